@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// --- Annotations ---
+
+// Annotation — надпись из -annotations, которая появляется у точки трека на Duration
+type Annotation struct {
+	Index    int // индекс в SmoothedPoints
+	Duration time.Duration
+	Text     string
+	Color    color.Color // nil — цвет показателей
+}
+
+// annotationSpec — строка файла надписей до привязки к точкам трека
+type annotationSpec struct {
+	Line      int
+	PointSpec string
+	Duration  time.Duration
+	Text      string
+	Color     color.Color
+}
+
+const (
+	annotationDuration = 5 * time.Second
+	annotationFade     = 500 * time.Millisecond
+)
+
+// parseAnnotationFile читает строки вида "12.5km duration=8s color=#FFD700 Начало грунтовки":
+// точка в синтаксисе файла корректировок, необязательные duration= и color=, дальше текст
+func parseAnnotationFile(filePath string) ([]annotationSpec, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read annotation file: %w", err)
+	}
+
+	var specs []annotationSpec
+	for i, line := range strings.Split(string(content), "\n") {
+		parts := strings.Fields(maskCommentRe.ReplaceAllString(line, "")) // комментарии как в файле масок: # после пробела
+		if len(parts) == 0 {
+			continue
+		}
+		spec := annotationSpec{Line: i + 1, PointSpec: parts[0], Duration: annotationDuration}
+		rest := parts[1:]
+		for len(rest) > 0 {
+			if v, ok := strings.CutPrefix(rest[0], "duration="); ok {
+				d, err := time.ParseDuration(v)
+				if err != nil || d <= 0 {
+					return nil, fmt.Errorf("invalid duration on line %d: %s", i+1, rest[0])
+				}
+				spec.Duration = d
+			} else if v, ok := strings.CutPrefix(rest[0], "color="); ok {
+				c, err := parseHexColor(v)
+				if err != nil {
+					return nil, fmt.Errorf("invalid color on line %d: %s", i+1, rest[0])
+				}
+				spec.Color = c
+			} else {
+				break
+			}
+			rest = rest[1:]
+		}
+		if len(rest) == 0 {
+			return nil, fmt.Errorf("missing annotation text on line %d", i+1)
+		}
+		spec.Text = strings.Join(rest, " ")
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// resolveAnnotations привязывает надписи к точкам; не найденные на треке пропускаются с предупреждением
+func resolveAnnotations(points []Point, specs []annotationSpec) ([]Annotation, error) {
+	resolver := newPointSpecResolver(points)
+	var annotations []Annotation
+	for _, spec := range specs {
+		idx, err := resolver.resolve(spec.PointSpec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", spec.Line, err)
+		}
+		if idx < 0 {
+			log.Printf("Warning: could not find point for annotation '%s' on line %d", spec.PointSpec, spec.Line)
+			continue
+		}
+		annotations = append(annotations, Annotation{Index: idx, Duration: spec.Duration, Text: spec.Text, Color: spec.Color})
+	}
+	sort.SliceStable(annotations, func(i, j int) bool { return annotations[i].Index < annotations[j].Index })
+	return annotations, nil
+}
+
+// drawAnnotation показывает последнюю начавшуюся надпись, пока не выйдет её время;
+// длинный текст уменьшается до ширины maxWidth
+func drawAnnotation(dc *gg.Context, track *Track, currentPoint Point, centerX, y, maxWidth float64, ttf *truetype.Font, args *Arguments) {
+	for k := len(track.Annotations) - 1; k >= 0; k-- {
+		a := track.Annotations[k]
+		since := currentPoint.Timestamp.Sub(track.SmoothedPoints[a.Index].Timestamp)
+		if since < 0 {
+			continue
+		}
+		if since > a.Duration {
+			return
+		}
+
+		alpha := math.Min(1, math.Min(float64(since), float64(a.Duration-since))/float64(annotationFade))
+		fontSize := maxWidth / 14
+		pad := fontSize / 2
+		dc.Push()
+		dc.SetFontFace(newFontFace(ttf, &truetype.Options{Size: fontSize}))
+		w, _ := dc.MeasureString(a.Text)
+		if w > maxWidth-2*pad {
+			fontSize *= (maxWidth - 2*pad) / w
+			pad = fontSize / 2
+			dc.SetFontFace(newFontFace(ttf, &truetype.Options{Size: fontSize}))
+			w, _ = dc.MeasureString(a.Text)
+		}
+		textColor := args.IndicatorColor
+		if a.Color != nil {
+			textColor = a.Color
+		}
+		dc.SetColor(color.RGBA{0, 0, 0, uint8(170 * alpha)})
+		dc.DrawRoundedRectangle(centerX-w/2-pad, y, w+2*pad, fontSize+2*pad, pad)
+		dc.Fill()
+		dc.SetColor(withAlpha(textColor, uint8(255*alpha)))
+		dc.DrawStringAnchored(a.Text, centerX, y+pad+fontSize/2, 0.5, 0.35)
+		dc.Pop()
+		return
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"image/color"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// --- Attribution ---
+
+// attributionBox — место подписи источников карты по умолчанию: полоска шириной с карту сразу под её рамкой
+func attributionBox(mapX, mapY, w float64) widgetBox {
+	return widgetBox{X: mapX, Y: mapY + w*1.02, W: w, H: w * 0.0375, Scale: 1}
+}
+
+// mapAttribution — подпись источников тайлов -style и -overlay-style; части через запятую не повторяются,
+// "© OpenStreetMap contributors" обычно есть у обоих
+func mapAttribution(args *Arguments) string {
+	var parts []string
+	seen := make(map[string]bool)
+	for _, name := range []string{args.MapStyle, args.OverlayStyle} {
+		for _, p := range strings.Split(mapStyles[name].Attribution, ",") {
+			if p = strings.TrimSpace(p); p != "" && !seen[p] {
+				seen[p] = true
+				parts = append(parts, p)
+			}
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// drawAttribution пишет подпись мелким шрифтом на полупрозрачной плашке по центру полосы шириной w от (x, y).
+// Не влезающая в ширину подпись уменьшается
+func drawAttribution(dc *gg.Context, x, y, w float64, ttf *truetype.Font, args *Arguments) {
+	text := mapAttribution(args)
+	if text == "" {
+		return
+	}
+	size := w / 40
+	dc.SetFontFace(newFontFace(ttf, &truetype.Options{Size: size}))
+	tw, _ := dc.MeasureString(text)
+	if maxWidth := w * 0.96; tw > maxWidth {
+		size *= maxWidth / tw
+		dc.SetFontFace(newFontFace(ttf, &truetype.Options{Size: size}))
+		tw, _ = dc.MeasureString(text)
+	}
+	pad := size * 0.4
+	dc.SetColor(color.NRGBA{A: 110})
+	dc.DrawRoundedRectangle(x+(w-tw)/2-pad, y, tw+2*pad, size*1.5, size*0.4)
+	dc.Fill()
+	dc.SetColor(color.NRGBA{R: 255, G: 255, B: 255, A: 220})
+	dc.DrawStringAnchored(text, x+w/2, y+size*0.75, 0.5, 0.35)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"math"
+	"os"
+)
+
+// --- MJPEG AVI ---
+
+// aviWriter пишет кадры JPEG в AVI (MJPEG) без ffmpeg. Заголовок сначала пишется с нулями
+// и переписывается в finish, когда известны размер кадра и их число. Это AVI 1.0 с индексом
+// idx1, поэтому файл ограничен 4 ГБ — для предпросмотра достаточно.
+type aviWriter struct {
+	file          *os.File
+	framerate     float64
+	width, height int
+	moviSize      uint32 // байт после fourcc "movi"
+	maxFrameSize  uint32
+	index         bytes.Buffer // записи idx1
+	frames        int
+	err           error
+}
+
+const aviHeaderSize = 224 // RIFF + hdrl + заголовок LIST movi
+
+func newAviWriter(outputFile string, framerate float64) (*aviWriter, error) {
+	f, err := os.Create(outputFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", outputFile, err)
+	}
+	w := &aviWriter{file: f, framerate: framerate, moviSize: 4}
+	if _, err := f.Write(w.header()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *aviWriter) writeFrame(frameNum int, data []byte) {
+	if w.err != nil {
+		return
+	}
+	if w.frames == 0 {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			w.err = fmt.Errorf("failed to read frame size: %w", err)
+			return
+		}
+		w.width, w.height = cfg.Width, cfg.Height
+	}
+
+	size := uint32(len(data))
+	chunk := new(bytes.Buffer)
+	chunk.WriteString("00dc")
+	binary.Write(chunk, binary.LittleEndian, size)
+	chunk.Write(data)
+	if size%2 == 1 {
+		chunk.WriteByte(0) // чанки RIFF выравниваются на чётную границу
+	}
+	if _, err := w.file.Write(chunk.Bytes()); err != nil {
+		w.err = fmt.Errorf("failed to write frame %d: %w", frameNum, err)
+		return
+	}
+
+	w.index.WriteString("00dc")
+	binary.Write(&w.index, binary.LittleEndian, []uint32{0x10, w.moviSize, size}) // AVIIF_KEYFRAME, смещение от "movi"
+	w.moviSize += uint32(chunk.Len())
+	w.maxFrameSize = max(w.maxFrameSize, size)
+	w.frames++
+}
+
+func (w *aviWriter) finish() error {
+	defer w.file.Close()
+	if w.err != nil {
+		return w.err
+	}
+	idx := new(bytes.Buffer)
+	idx.WriteString("idx1")
+	binary.Write(idx, binary.LittleEndian, uint32(w.index.Len()))
+	idx.Write(w.index.Bytes())
+	if _, err := w.file.Write(idx.Bytes()); err != nil {
+		return err
+	}
+	if _, err := w.file.WriteAt(w.header(), 0); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+// header собирает RIFF-заголовок длиной aviHeaderSize по текущим значениям
+func (w *aviWriter) header() []byte {
+	le := binary.LittleEndian
+	rate, scale := uint32(math.Round(w.framerate*1000)), uint32(1000)
+	microSecPerFrame := uint32(math.Round(1e6 / w.framerate))
+	width, height := uint32(w.width), uint32(w.height)
+	riffSize := aviHeaderSize - 8 + w.moviSize - 4 + 8 + uint32(w.index.Len())
+
+	h := new(bytes.Buffer)
+	h.WriteString("RIFF")
+	binary.Write(h, le, riffSize)
+	h.WriteString("AVI ")
+
+	h.WriteString("LIST")
+	binary.Write(h, le, uint32(192))
+	h.WriteString("hdrl")
+	h.WriteString("avih")
+	binary.Write(h, le, uint32(56))
+	binary.Write(h, le, []uint32{
+		microSecPerFrame,
+		uint32(float64(w.maxFrameSize) * w.framerate), // dwMaxBytesPerSec
+		0,    // dwPaddingGranularity
+		0x10, // AVIF_HASINDEX
+		uint32(w.frames),
+		0, // dwInitialFrames
+		1, // dwStreams
+		w.maxFrameSize,
+		width, height,
+		0, 0, 0, 0,
+	})
+
+	h.WriteString("LIST")
+	binary.Write(h, le, uint32(116))
+	h.WriteString("strl")
+	h.WriteString("strh")
+	binary.Write(h, le, uint32(56))
+	h.WriteString("vids")
+	h.WriteString("MJPG")
+	binary.Write(h, le, []uint32{0, 0, 0, scale, rate, 0, uint32(w.frames), w.maxFrameSize, math.MaxUint32, 0}) // флаги, приоритет+язык, ..., качество -1
+	binary.Write(h, le, []uint16{0, 0, uint16(width), uint16(height)})
+	h.WriteString("strf")
+	binary.Write(h, le, uint32(40))
+	binary.Write(h, le, []uint32{40, width, height})
+	binary.Write(h, le, []uint16{1, 24})
+	h.WriteString("MJPG")
+	binary.Write(h, le, []uint32{width * height * 3, 0, 0, 0, 0})
+
+	h.WriteString("LIST")
+	binary.Write(h, le, w.moviSize)
+	h.WriteString("movi")
+	return h.Bytes()
+}
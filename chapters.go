@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- Structs ---
+
+// Chapter — глава видео, начинающаяся с точки трека
+type Chapter struct {
+	Index int // индекс в SmoothedPoints
+	Title string
+}
+
+// chapterMark — глава, переведённая во время конкретного выходного файла
+type chapterMark struct {
+	Start float64 // с от начала файла
+	Title string
+}
+
+const (
+	lapRadius      = 30.0 // м: возврат к старту ближе этого засчитывается как новый круг
+	lapMinDistance = 0.5  // км: короче круг не бывает — отсекаем топтание у старта
+)
+
+// --- Chapters ---
+
+// detectLaps возвращает индексы начала кругов, кроме первого. В FIT и TCX круги берутся из
+// записанных кругов, иначе круг засчитывается при возвращении к стартовой точке.
+func detectLaps(points []Point) []int {
+	var laps []int
+	if points[len(points)-1].Lap > 1 {
+		for i := 1; i < len(points); i++ {
+			if points[i].Lap > points[i-1].Lap {
+				laps = append(laps, i)
+			}
+		}
+		return laps
+	}
+
+	// возвращение в конце трека — финиш, а не новый круг
+	start, finish := points[0], points[len(points)-1].Distance
+	lapStart, away := 0, false
+	for i, p := range points {
+		d := haversine(p, start) * 1000
+		if d > lapRadius*waypointBannerHysteresis {
+			away = true
+		} else if away && d <= lapRadius && p.Distance-points[lapStart].Distance >= lapMinDistance && finish-p.Distance >= lapMinDistance {
+			laps = append(laps, i)
+			lapStart, away = i, false
+		}
+	}
+	return laps
+}
+
+// trackChapters собирает главы: старт, круги, подъёмы и заданные пользователем точки
+// ("Summit@12.5km,Cafe@1h20m" — после @ всё, что понимает -from)
+func trackChapters(track *Track, spec string, loc *time.Location) ([]Chapter, error) {
+	points := track.SmoothedPoints
+	chapters := []Chapter{{Index: 0, Title: "Start"}}
+	for n, i := range detectLaps(points) {
+		chapters = append(chapters, Chapter{Index: i, Title: fmt.Sprintf("Lap %d", n+2)})
+	}
+
+	climbs := track.Climbs
+	if climbs == nil {
+		climbs = detectClimbs(points)
+	}
+	for _, c := range climbs {
+		chapters = append(chapters, Chapter{Index: c.StartIndex, Title: fmt.Sprintf("%s climb: %.1f km at %.1f%%", c.Category, c.Length, c.AvgGradient)})
+	}
+
+	if spec != "" {
+		for _, item := range strings.Split(spec, ",") {
+			name, boundary, ok := strings.Cut(strings.TrimSpace(item), "@")
+			if !ok || name == "" {
+				return nil, fmt.Errorf("chapter must look like name@point: %s", item)
+			}
+			i := parseCutBoundary(boundary, points, track.Waypoints, 0, false, loc)
+			chapters = append(chapters, Chapter{Index: min(i, len(points)-1), Title: name})
+		}
+	}
+
+	sort.SliceStable(chapters, func(i, j int) bool { return chapters[i].Index < chapters[j].Index })
+	return chapters, nil
+}
+
+// chapterMarks переводит главы во время выходного файла из сегментов segments (с их стоп-кадрами).
+// Глава, попавшая перед началом файла, сдвигается на его начало, чтобы у каждого файла была глава с 0:00.
+func chapterMarks(chapters []Chapter, track *Track, segments []videoSegment, framerate float64) []chapterMark {
+	var marks []chapterMark
+	trackStart := track.SmoothedPoints[0].Timestamp
+	base := 0.0
+	for _, seg := range segments {
+		segVideoStart := track.Timeline.videoOffset(seg.StartTime.Sub(trackStart).Seconds())
+		segLength := float64(seg.Frames) / framerate
+		intro := float64(seg.IntroFrames) / framerate
+		for _, c := range chapters {
+			offset := track.Timeline.videoOffset(track.SmoothedPoints[c.Index].Timestamp.Sub(trackStart).Seconds()) - segVideoStart
+			if offset >= segLength {
+				break
+			}
+			start := base
+			if offset > 0 {
+				start += intro + offset
+			}
+			if len(marks) > 0 && start-marks[len(marks)-1].Start < 1 {
+				marks[len(marks)-1] = chapterMark{Start: marks[len(marks)-1].Start, Title: c.Title}
+				continue
+			}
+			marks = append(marks, chapterMark{Start: start, Title: c.Title})
+		}
+		base += float64(seg.totalFrames()) / framerate
+	}
+	return marks
+}
+
+// writeChapterMetadata пишет главы в формате FFMETADATA1 для -map_chapters
+func writeChapterMetadata(filePath string, marks []chapterMark, duration float64) error {
+	escape := strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", `\`+"\n")
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for i, m := range marks {
+		end := duration
+		if i+1 < len(marks) {
+			end = marks[i+1].Start
+		}
+		fmt.Fprintf(&b, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n", int64(m.Start*1000), int64(end*1000), escape.Replace(m.Title))
+	}
+	return os.WriteFile(filePath, []byte(b.String()), 0644)
+}
+
+// logChapterList печатает главы в виде, который YouTube понимает в описании ролика
+func logChapterList(outputFile string, marks []chapterMark) {
+	var b strings.Builder
+	for _, m := range marks {
+		fmt.Fprintf(&b, "\n%s %s", formatChapterTime(m.Start), m.Title)
+	}
+	log.Printf("Chapters for %s:%s", outputFile, b.String())
+}
+
+func formatChapterTime(seconds float64) string {
+	s := int(seconds)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
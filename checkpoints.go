@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// --- Structs ---
+
+// Checkpoint — ориентир на треке (пункт питания, вершина), отмечаемый на полосе дистанции
+type Checkpoint struct {
+	Name     string
+	Distance float64 // км от начала трека
+}
+
+// WaypointPass — момент, когда трек подходит к путевой точке ближе -waypoint-banners метров
+type WaypointPass struct {
+	Index int // индекс в SmoothedPoints
+	Label string
+}
+
+const (
+	checkpointMaxOffset      = 200.0 // м: путевые точки дальше от трека на полосу не попадают
+	waypointBannerDuration   = 5 * time.Second
+	waypointBannerFade       = 500 * time.Millisecond
+	waypointBannerHysteresis = 1.5 // повторный проход засчитывается после удаления на radius*1.5
+)
+
+// --- Checkpoints ---
+
+// parseCheckpoints разбирает список -checkpoints вида "Feed@42.5,Summit@61" (дистанция в км)
+func parseCheckpoints(spec string) ([]Checkpoint, error) {
+	var checkpoints []Checkpoint
+	if spec == "" {
+		return checkpoints, nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		name, km, ok := strings.Cut(strings.TrimSpace(item), "@")
+		if !ok {
+			return nil, fmt.Errorf("checkpoint must look like name@km: %s", item)
+		}
+		dist, err := strconv.ParseFloat(strings.TrimSuffix(km, "km"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid checkpoint distance: %s", item)
+		}
+		checkpoints = append(checkpoints, Checkpoint{Name: name, Distance: dist})
+	}
+	return checkpoints, nil
+}
+
+// waypointCheckpoints привязывает путевые точки GPX к ближайшей точке трека
+func waypointCheckpoints(points []Point, waypoints []Waypoint) []Checkpoint {
+	var checkpoints []Checkpoint
+	for _, w := range waypoints {
+		i := nearestPointIndex(points, w.Lat, w.Lon, false)
+		if i < 0 || haversine(points[i], Point{Lat: w.Lat, Lon: w.Lon})*1000 > checkpointMaxOffset {
+			continue
+		}
+		checkpoints = append(checkpoints, Checkpoint{Name: w.Name, Distance: points[i].Distance})
+	}
+	return checkpoints
+}
+
+// drawCheckpointTicks рисует засечки ориентиров на полосе дистанции и подписи под ней.
+// У ближайшего впереди ориентира подписано, сколько до него осталось.
+func drawCheckpointTicks(dc *gg.Context, checkpoints []Checkpoint, currentDistance, totalDistance, x, y, width, height float64, ttf *truetype.Font, args *Arguments) {
+	fontSize := height * 0.6
+	dc.Push()
+	dc.SetFontFace(newFontFace(ttf, &truetype.Options{Size: fontSize}))
+	labelRight := x - 1
+	nextFound := false
+	for _, c := range checkpoints {
+		if c.Distance < 0 || c.Distance > totalDistance {
+			continue
+		}
+		tx := x + width*c.Distance/totalDistance
+		dc.SetColor(color.White)
+		dc.SetLineWidth(2 * args.UIScale)
+		dc.DrawLine(tx, y, tx, y+height+fontSize/3)
+		dc.Stroke()
+
+		label := c.Name
+		if !nextFound && c.Distance > currentDistance {
+			label = fmt.Sprintf("%s %.1f km", c.Name, c.Distance-currentDistance)
+			nextFound = true
+		}
+		w, _ := dc.MeasureString(label)
+		lx := min(max(tx-w/2, x), x+width-w)
+		if lx <= labelRight {
+			continue // не налезаем на предыдущую подпись
+		}
+		dc.SetColor(args.IndicatorColor)
+		dc.DrawString(label, lx, y+height+fontSize*1.2)
+		labelRight = lx + w + fontSize/2
+	}
+	dc.Pop()
+}
+
+// --- Waypoint Banners ---
+
+// detectWaypointPasses находит моменты входа трека в радиус radius (м) вокруг именованных путевых точек
+func detectWaypointPasses(points []Point, waypoints []Waypoint, radius float64) []WaypointPass {
+	var passes []WaypointPass
+	for _, w := range waypoints {
+		if w.Name == "" {
+			continue
+		}
+		label := w.Name
+		if w.Ele != 0 {
+			label = fmt.Sprintf("%s %.0f m", w.Name, w.Ele)
+		}
+		target := Point{Lat: w.Lat, Lon: w.Lon}
+		inside := false
+		for i, p := range points {
+			d := haversine(p, target) * 1000
+			if !inside && d <= radius {
+				passes = append(passes, WaypointPass{Index: i, Label: label})
+				inside = true
+			} else if inside && d > radius*waypointBannerHysteresis {
+				inside = false
+			}
+		}
+	}
+	sort.SliceStable(passes, func(i, j int) bool { return passes[i].Index < passes[j].Index })
+	return passes
+}
+
+// drawWaypointBanner показывает название путевой точки в течение waypointBannerDuration после подхода к ней
+func drawWaypointBanner(dc *gg.Context, track *Track, currentPoint Point, centerX, y, maxWidth float64, ttf *truetype.Font, args *Arguments) {
+	for k := len(track.WaypointPasses) - 1; k >= 0; k-- {
+		pass := track.WaypointPasses[k]
+		since := currentPoint.Timestamp.Sub(track.SmoothedPoints[pass.Index].Timestamp)
+		if since < 0 {
+			continue
+		}
+		if since > waypointBannerDuration {
+			return
+		}
+
+		alpha := math.Min(1, math.Min(float64(since), float64(waypointBannerDuration-since))/float64(waypointBannerFade))
+		fontSize := maxWidth / 12
+		pad := fontSize / 2
+		dc.Push()
+		dc.SetFontFace(newFontFace(ttf, &truetype.Options{Size: fontSize}))
+		w, _ := dc.MeasureString(pass.Label)
+		dc.SetColor(color.RGBA{0, 0, 0, uint8(170 * alpha)})
+		dc.DrawRoundedRectangle(centerX-w/2-pad, y, w+2*pad, fontSize+2*pad, pad)
+		dc.Fill()
+		dc.SetColor(withAlpha(color.White, uint8(255*alpha)))
+		dc.DrawStringAnchored(pass.Label, centerX, y+pad+fontSize/2, 0.5, 0.35)
+		dc.Pop()
+		return
+	}
+}
+
+// --- Waypoint Pins ---
+
+// drawWaypointPins отмечает на карте именованные путевые точки точкой с подписью.
+// Вызывается в повёрнутой вместе с картой системе координат; подписи разворачиваем обратно, чтобы читались
+func drawWaypointPins(dc *gg.Context, waypoints []Waypoint, currentPoint Point, viewX, viewY float64, zoom int, residualMapScale, centerX, centerY float64, ttf *truetype.Font, args *Arguments) {
+	radius := float64(args.WidgetSize) / 90
+	fontSize := float64(args.WidgetSize) / 30
+	pad := fontSize / 3
+	dc.SetFontFace(newFontFace(ttf, &truetype.Options{Size: fontSize}))
+	for _, w := range waypoints {
+		if w.Name == "" {
+			continue
+		}
+		px, py := deg2num(w.Lat, w.Lon, zoom)
+		x := centerX + (px*float64(args.TileSize)-viewX)/residualMapScale
+		y := centerY + (py*float64(args.TileSize)-viewY)/residualMapScale
+		if math.Hypot(x-centerX, y-centerY) > float64(args.WidgetSize) {
+			continue
+		}
+		dc.SetColor(color.Black)
+		dc.DrawCircle(x, y, radius)
+		dc.FillPreserve()
+		dc.SetColor(color.White)
+		dc.SetLineWidth(radius / 2)
+		dc.Stroke()
+
+		dc.Push()
+		dc.RotateAbout(-currentPoint.MapRotation, x, y)
+		tw, _ := dc.MeasureString(w.Name)
+		lx, ly := x+radius*2, y-fontSize/2-pad
+		// в правой половине виджета (с учётом поворота карты) подпись ставим слева от точки, чтобы не обрезалась краем
+		if (x-centerX)*math.Cos(currentPoint.MapRotation)-(y-centerY)*math.Sin(currentPoint.MapRotation) > 0 {
+			lx = x - radius*2 - tw - 2*pad
+		}
+		dc.SetColor(color.RGBA{0, 0, 0, 150})
+		dc.DrawRoundedRectangle(lx, ly, tw+2*pad, fontSize+2*pad, pad)
+		dc.Fill()
+		dc.SetColor(color.White)
+		dc.DrawStringAnchored(w.Name, lx+pad, y, 0, 0.35)
+		dc.Pop()
+	}
+}
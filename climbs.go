@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// --- Structs ---
+
+type Climb struct {
+	StartIndex, EndIndex int
+	Length               float64 // km
+	Gain                 float64 // m
+	AvgGradient          float64 // %
+	MaxGradient          float64 // %
+	Category             string
+}
+
+const (
+	climbDropTolerance  = 10.0 // м: на сколько можно спуститься от вершины, не завершая подъём
+	climbMinLength      = 0.5  // км
+	climbMinAvgGradient = 3.0  // %
+	climbBannerFade     = 1500 * time.Millisecond
+)
+
+// --- Climb Detection ---
+
+// climbCategory категоризирует подъём по произведению длины (м) на средний уклон (%)
+func climbCategory(lengthKm, avgGradient float64) string {
+	score := lengthKm * 1000 * avgGradient
+	switch {
+	case score >= 80000:
+		return "HC"
+	case score >= 64000:
+		return "Cat 1"
+	case score >= 32000:
+		return "Cat 2"
+	case score >= 16000:
+		return "Cat 3"
+	case score >= 8000:
+		return "Cat 4"
+	}
+	return ""
+}
+
+// detectClimbs ищет категорийные подъёмы: участок от локального минимума до вершины,
+// на котором высота не опускается ниже максимума более чем на climbDropTolerance.
+func detectClimbs(points []Point) []Climb {
+	var climbs []Climb
+	i := 0
+	for i < len(points)-1 {
+		start, peak := i, i
+		j := i + 1
+		for ; j < len(points); j++ {
+			if points[j].Ele > points[peak].Ele {
+				peak = j
+			}
+			if points[peak].Ele-points[j].Ele > climbDropTolerance {
+				break
+			}
+			if points[j].Ele <= points[start].Ele {
+				start, peak = j, j
+			}
+		}
+
+		length := points[peak].Distance - points[start].Distance
+		gain := points[peak].Ele - points[start].Ele
+		if length >= climbMinLength {
+			avg := gain / (length * 1000) * 100
+			if category := climbCategory(length, avg); category != "" && avg >= climbMinAvgGradient {
+				maxGradient := 0.0
+				for k := start; k <= peak; k++ {
+					maxGradient = math.Max(maxGradient, points[k].Slope)
+				}
+				climbs = append(climbs, Climb{
+					StartIndex:  start,
+					EndIndex:    peak,
+					Length:      length,
+					Gain:        gain,
+					AvgGradient: avg,
+					MaxGradient: maxGradient,
+					Category:    category,
+				})
+			}
+		}
+
+		if j <= i {
+			j = i + 1
+		}
+		i = j
+	}
+	return climbs
+}
+
+// --- Climb Banner ---
+
+// drawClimbBanner рисует плашку с оставшейся длиной подъёма, уклонами и профилем до вершины.
+// Плашка появляется в начале подъёма и плавно исчезает после вершины.
+func drawClimbBanner(dc *gg.Context, track *Track, currentPoint Point, x, y, width float64, ttf *truetype.Font, args *Arguments) {
+	points := track.SmoothedPoints
+	for _, climb := range track.Climbs {
+		start := points[climb.StartIndex]
+		summit := points[climb.EndIndex]
+		sinceStart := currentPoint.Timestamp.Sub(start.Timestamp)
+		sinceSummit := currentPoint.Timestamp.Sub(summit.Timestamp)
+		if sinceStart < 0 || sinceSummit > climbBannerFade {
+			continue
+		}
+
+		alpha := math.Min(1, float64(sinceStart)/float64(climbBannerFade))
+		if sinceSummit > 0 {
+			alpha = 1 - float64(sinceSummit)/float64(climbBannerFade)
+		}
+		drawClimbBannerPanel(dc, points, climb, currentPoint, x, y, width, alpha, ttf, args)
+		return
+	}
+}
+
+func drawClimbBannerPanel(dc *gg.Context, points []Point, climb Climb, currentPoint Point, x, y, width, alpha float64, ttf *truetype.Font, args *Arguments) {
+	height := width / 4
+	pad := height / 10
+	titleSize := height / 4.5
+	textSize := height / 6
+
+	// въезд сверху при появлении
+	y -= (1 - alpha) * height / 3
+
+	a := func(base float64) uint8 { return uint8(base * alpha) }
+
+	dc.Push()
+	dc.SetColor(color.RGBA{0, 0, 0, a(160)})
+	dc.DrawRoundedRectangle(x, y, width, height, pad)
+	dc.Fill()
+
+	summit := points[climb.EndIndex]
+	remaining := math.Max(0, summit.Distance-currentPoint.Distance)
+	remainingGradient := 0.0
+	if remaining > 0.01 {
+		remainingGradient = (summit.Ele - currentPoint.Ele) / (remaining * 1000) * 100
+	}
+
+	dc.SetColor(withAlpha(args.IndicatorColor, a(255)))
+	dc.SetFontFace(newFontFace(ttf, &truetype.Options{Size: titleSize}))
+	dc.DrawStringAnchored(fmt.Sprintf("%s · %.1f km to go", climb.Category, remaining), x+pad, y+pad, 0, 1)
+	dc.SetFontFace(newFontFace(ttf, &truetype.Options{Size: textSize}))
+	dc.DrawStringAnchored(fmt.Sprintf("avg %.1f%%  max %.1f%%  rest %.1f%%", climb.AvgGradient, climb.MaxGradient, remainingGradient), x+pad, y+pad+titleSize*1.3, 0, 1)
+
+	// профиль оставшейся части: ширина пропорциональна оставшейся длине, так что он «съёживается»
+	profileTop := y + pad + titleSize*1.3 + textSize*1.4
+	profileBottom := y + height - pad
+	profileLeft := x + pad
+	profileWidth := width - 2*pad
+	// по всему подъёму, а не по его концам: провалы и пики внутри не должны вылезать за плашку
+	minEle, maxEle := math.Inf(1), math.Inf(-1)
+	for _, p := range points[climb.StartIndex : climb.EndIndex+1] {
+		minEle = math.Min(minEle, p.Ele)
+		maxEle = math.Max(maxEle, p.Ele)
+	}
+	kx := profileWidth / climb.Length
+	ky := 0.0
+	if maxEle > minEle {
+		ky = (profileBottom - profileTop) / (maxEle - minEle)
+	}
+
+	for i := climb.StartIndex + 1; i <= climb.EndIndex; i++ {
+		p0, p1 := points[i-1], points[i]
+		if p1.Distance <= currentPoint.Distance {
+			continue
+		}
+		d0 := math.Max(p0.Distance, currentPoint.Distance) - currentPoint.Distance
+		d1 := p1.Distance - currentPoint.Distance
+		x0, x1 := profileLeft+d0*kx, profileLeft+d1*kx
+		y0 := profileBottom - (p0.Ele-minEle)*ky
+		y1 := profileBottom - (p1.Ele-minEle)*ky
+		dc.MoveTo(x0, profileBottom)
+		dc.LineTo(x0, y0)
+		dc.LineTo(x1, y1)
+		dc.LineTo(x1, profileBottom)
+		dc.ClosePath()
+		dc.SetColor(withAlpha(gradientColor(p1.Slope), a(220)))
+		dc.Fill()
+	}
+	dc.Pop()
+}
+
+// gradientColor — цвет участка профиля по крутизне
+func gradientColor(slope float64) color.Color {
+	switch {
+	case slope >= 12:
+		return color.RGBA{150, 0, 0, 255}
+	case slope >= 9:
+		return color.RGBA{230, 30, 30, 255}
+	case slope >= 6:
+		return color.RGBA{255, 140, 0, 255}
+	case slope >= 3:
+		return color.RGBA{255, 215, 0, 255}
+	}
+	return color.RGBA{80, 200, 80, 255}
+}
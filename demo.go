@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// --- Demo Track ---
+
+const (
+	demoTrackFile  = "demo.gpx"
+	demoOutputFile = "demo.mp4"
+	demoDuration   = "60s"
+	demoCenterLat  = 43.7384 // холмы над Ниццей
+	demoCenterLon  = 7.4246
+	demoRadius     = 2.0  // км
+	demoStopAt     = 0.55 // доля круга, на которой стоим demoStopTime
+	demoStopTime   = 30 * time.Second
+	demoRiderMass  = 80.0 // кг вместе с велосипедом, для расчёта мощности
+)
+
+// demoElevation — высота на угле theta круга: один длинный подъём и пара коротких бугров
+func demoElevation(theta float64) float64 {
+	return 180 + 90*math.Sin(theta) + 20*math.Sin(3*theta+1)
+}
+
+// demoPosition — точка петли: круг с тремя "лепестками", чтобы трек не был идеальной окружностью
+func demoPosition(theta float64) (lat, lon float64) {
+	r := demoRadius * (1 + 0.2*math.Sin(3*theta))
+	lat = demoCenterLat + r*math.Sin(theta)/111.32
+	lon = demoCenterLon + r*math.Cos(theta)/(111.32*math.Cos(demoCenterLat*math.Pi/180))
+	return lat, lon
+}
+
+// writeDemoTrack генерирует правдоподобную петлю с подъёмами, разной скоростью, остановкой,
+// пульсом и мощностью и пишет её в GPX, чтобы прогнать весь конвейер без своих данных
+func writeDemoTrack(filePath string) error {
+	summitTheta := math.Pi / 2
+	for theta := 0.0; theta < 2*math.Pi; theta += 0.001 {
+		if demoElevation(theta) > demoElevation(summitTheta) {
+			summitTheta = theta
+		}
+	}
+	lat, lon := demoPosition(summitTheta)
+	waypoints := []Waypoint{{Name: "Summit", Lat: lat, Lon: lon, Ele: demoElevation(summitTheta)}}
+
+	var points []Point
+	t := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	theta, hr, stopped := 0.0, 100.0, false
+	const dTheta = 1e-4
+	for theta < 2*math.Pi {
+		lat, lon := demoPosition(theta)
+		lat2, lon2 := demoPosition(theta + dTheta)
+		step := haversine(Point{Lat: lat, Lon: lon}, Point{Lat: lat2, Lon: lon2}) * 1000 // м на dTheta
+		slope := (demoElevation(theta+dTheta) - demoElevation(theta)) / step
+
+		// на подъёмах медленнее, на спусках быстрее, плюс медленная "волна" усталости
+		speed := 28 - 250*slope + 3*math.Sin(theta*7)
+		speed = math.Max(8, math.Min(55, speed)) / 3.6 // м/с
+		power := math.Max(0, demoRiderMass*9.81*(slope+0.005)*speed+0.5*1.2*0.35*speed*speed*speed)
+		if !stopped && theta >= demoStopAt*2*math.Pi {
+			stopped = true
+			for s := time.Duration(0); s < demoStopTime; s += time.Second {
+				hr += (95 - hr) * 0.05
+				points = append(points, Point{Lat: lat, Lon: lon, Ele: demoElevation(theta), Timestamp: t, HeartRate: math.Round(hr)})
+				t = t.Add(time.Second)
+			}
+		}
+		hr += (100 + power*0.25 - hr) * 0.05 // пульс догоняет нагрузку с задержкой
+		points = append(points, Point{Lat: lat, Lon: lon, Ele: demoElevation(theta), Timestamp: t, HeartRate: math.Round(hr), Power: math.Round(power)})
+
+		theta += speed / step * dTheta
+		t = t.Add(time.Second)
+	}
+	return writeGpx(filePath, "Demo loop", waypoints, points)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// --- Structs ---
+
+// endCardLine — строка итоговой карточки "подпись — значение"
+type endCardLine struct {
+	Label, Value string
+}
+
+// RideSplit — средние значения на отрезке поездки
+type RideSplit struct {
+	FromKm, ToKm              float64
+	AvgSpeed, AvgHR, AvgPower float64
+}
+
+// RideSummary — итоги для карточки, считаются заранее по рендерящемуся диапазону
+type RideSummary struct {
+	Splits     []RideSplit
+	HRDrift    float64 // рост среднего пульса во второй половине по времени, %
+	Decoupling float64 // падение отношения мощность/пульс во второй половине, %
+	HasHR      bool
+	HasPower   bool
+}
+
+// --- Ride Summary ---
+
+// computeRideSummary делит поездку на отрезки по splitKm и сравнивает первую и вторую половины по времени
+func computeRideSummary(points []Point, splitKm float64) RideSummary {
+	var s RideSummary
+	if len(points) < 2 {
+		return s
+	}
+	for _, p := range points {
+		s.HasHR = s.HasHR || p.HeartRate > 0
+		s.HasPower = s.HasPower || p.Power > 0
+	}
+
+	if splitKm > 0 {
+		start := 0
+		for i := 1; i < len(points); i++ {
+			if points[i].Distance-points[start].Distance >= splitKm || i == len(points)-1 {
+				s.Splits = append(s.Splits, rideSplit(points[start:i+1]))
+				start = i
+			}
+		}
+	}
+
+	midTime := points[0].Timestamp.Add(points[len(points)-1].Timestamp.Sub(points[0].Timestamp) / 2)
+	mid := indexAtTime(points, midTime)
+	first, second := rideSplit(points[:mid+1]), rideSplit(points[mid:])
+	if s.HasHR && first.AvgHR > 0 {
+		s.HRDrift = (second.AvgHR - first.AvgHR) / first.AvgHR * 100
+		if s.HasPower && second.AvgHR > 0 && first.AvgPower > 0 {
+			ratio1, ratio2 := first.AvgPower/first.AvgHR, second.AvgPower/second.AvgHR
+			s.Decoupling = (ratio1 - ratio2) / ratio1 * 100
+		}
+	}
+	return s
+}
+
+// formatKm округляет до 0.1 км и отбрасывает ".0"
+func formatKm(km float64) string {
+	return strconv.FormatFloat(math.Round(km*10)/10, 'f', -1, 64)
+}
+
+// rideSplit усредняет скорость, пульс и мощность по времени
+func rideSplit(points []Point) RideSplit {
+	split := RideSplit{FromKm: points[0].Distance, ToKm: points[len(points)-1].Distance}
+	var total, hr, power float64
+	for i := 1; i < len(points); i++ {
+		dt := points[i].Timestamp.Sub(points[i-1].Timestamp).Seconds()
+		total += dt
+		hr += points[i].HeartRate * dt
+		power += points[i].Power * dt
+	}
+	if total > 0 {
+		split.AvgSpeed = (split.ToKm - split.FromKm) / (total / 3600)
+		split.AvgHR = hr / total
+		split.AvgPower = power / total
+	}
+	return split
+}
+
+// --- End Card ---
+
+// endCardLines собирает итоги поездки до последней рендерящейся точки
+func endCardLines(track *Track, args *Arguments) []endCardLine {
+	first := track.SmoothedPoints[track.RenderFromIndex]
+	last := track.SmoothedPoints[track.RenderToIndex-1]
+	elapsed := last.Timestamp.Sub(first.Timestamp)
+	distance := last.Distance - first.Distance
+
+	lines := []endCardLine{
+		{"Distance", fmt.Sprintf("%.2f km", distance)},
+		{"Time", formatDuration(elapsed)},
+	}
+	if elapsed > 0 {
+		lines = append(lines, endCardLine{"Avg speed", fmt.Sprintf("%.1f km/h", distance/elapsed.Hours())})
+	}
+	if args.ShowPower || args.FTP > 0 {
+		lines = append(lines, endCardLine{"NP", fmt.Sprintf("%.0f W", last.NormalizedPower)})
+	}
+	if args.FTP > 0 {
+		lines = append(lines,
+			endCardLine{"IF", fmt.Sprintf("%.2f", last.IntensityFactor)},
+			endCardLine{"TSS", fmt.Sprintf("%.0f", last.TSS)},
+		)
+	}
+
+	summary := track.Summary
+	if summary.HasHR {
+		lines = append(lines, endCardLine{"HR drift", fmt.Sprintf("%+.1f%%", summary.HRDrift)})
+		if summary.HasPower {
+			lines = append(lines, endCardLine{"Pw:HR decoupling", fmt.Sprintf("%.1f%%", summary.Decoupling)})
+		}
+	}
+	for _, split := range summary.Splits {
+		value := fmt.Sprintf("%.1f km/h", split.AvgSpeed)
+		if summary.HasHR {
+			value += fmt.Sprintf("  %.0f bpm", split.AvgHR)
+		}
+		if summary.HasPower {
+			value += fmt.Sprintf("  %.0f W", split.AvgPower)
+		}
+		lines = append(lines, endCardLine{fmt.Sprintf("%s–%s km", formatKm(split.FromKm-first.Distance), formatKm(split.ToKm-first.Distance)), value})
+	}
+	return lines
+}
+
+// drawEndCard выводит поверх последнего кадра карточку с итогами, проявляющуюся за introTitleFade
+func drawEndCard(img image.Image, lines []endCardLine, elapsed float64, font *truetype.Font, args *Arguments) {
+	rgba, ok := img.(*image.RGBA)
+	if !ok || len(lines) == 0 {
+		return
+	}
+	alpha := math.Min(1, elapsed/introTitleFade.Seconds())
+	dc := gg.NewContextForRGBA(rgba)
+	widgetWidth := float64(args.WidgetSize)
+	// длинная карточка с отрезками ужимается, чтобы поместиться в виджет
+	fontSize := math.Min(widgetWidth/18, widgetWidth*0.9/(1.5*float64(len(lines))+1))
+	lineHeight := fontSize * 1.5
+	dc.SetFontFace(newFontFace(font, &truetype.Options{Size: fontSize}))
+
+	width := widgetWidth * 0.8
+	height := lineHeight*float64(len(lines)) + fontSize
+	x := args.Layout.MapX + (widgetWidth-width)/2
+	y := args.Layout.MapY + (widgetWidth-height)/2
+	dc.SetColor(color.RGBA{0, 0, 0, uint8(190 * alpha)})
+	dc.DrawRoundedRectangle(x, y, width, height, fontSize/2)
+	dc.Fill()
+
+	for i, line := range lines {
+		rowY := y + fontSize/2 + lineHeight*(float64(i)+0.5)
+		dc.SetColor(withAlpha(color.RGBA{200, 200, 200, 255}, uint8(255*alpha)))
+		dc.DrawStringAnchored(line.Label, x+fontSize, rowY, 0, 0.35)
+		dc.SetColor(withAlpha(color.White, uint8(255*alpha)))
+		dc.DrawStringAnchored(line.Value, x+width-fontSize, rowY, 1, 0.35)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// --- Structs ---
+
+// TrackEvent — заметное событие на треке: остановка, резкое торможение, максимальная скорость
+type TrackEvent struct {
+	Type  string
+	Index int // индекс в SmoothedPoints
+	Label string
+}
+
+const (
+	eventStop     = "stop"
+	eventBrake    = "brake"
+	eventMaxSpeed = "max-speed"
+
+	eventStopSpeedKmh    = 2.0
+	eventStopMinDuration = 60 * time.Second
+	eventBrakeDecel      = 2.0 // м/с², по сглаженной скорости
+	eventBrakeSpan       = 2 * time.Second
+	eventBrakeMinGap     = 10 * time.Second
+	eventCalloutDuration = 4 * time.Second
+)
+
+// parseEventTypes разбирает список -events
+func parseEventTypes(spec string) (map[string]bool, error) {
+	types := make(map[string]bool)
+	if spec == "" {
+		return types, nil
+	}
+	for _, t := range strings.Split(spec, ",") {
+		t = strings.TrimSpace(t)
+		switch t {
+		case eventStop, eventBrake, eventMaxSpeed:
+			types[t] = true
+		default:
+			return nil, fmt.Errorf("unknown event type: %s", t)
+		}
+	}
+	return types, nil
+}
+
+// --- Event Detection ---
+
+// detectEvents ищет события включённых типов; результат упорядочен по времени
+func detectEvents(points []Point, types map[string]bool) []TrackEvent {
+	var events []TrackEvent
+
+	if types[eventStop] {
+		for i := 0; i < len(points); i++ {
+			if points[i].Speed >= eventStopSpeedKmh {
+				continue
+			}
+			j := i
+			for j+1 < len(points) && points[j+1].Speed < eventStopSpeedKmh {
+				j++
+			}
+			// пауза в записи (автопауза) тоже остановка
+			end := points[min(j+1, len(points)-1)].Timestamp
+			if d := end.Sub(points[i].Timestamp); d >= eventStopMinDuration {
+				events = append(events, TrackEvent{Type: eventStop, Index: i, Label: "Stop " + formatDuration(d)})
+			}
+			i = j
+		}
+		for i := 1; i < len(points); i++ {
+			if d := points[i].Timestamp.Sub(points[i-1].Timestamp); d >= eventStopMinDuration && points[i-1].Speed >= eventStopSpeedKmh {
+				events = append(events, TrackEvent{Type: eventStop, Index: i - 1, Label: "Stop " + formatDuration(d)})
+			}
+		}
+	}
+
+	if types[eventBrake] {
+		var lastBrake time.Time
+		j := 0
+		for i := range points {
+			for j < len(points)-1 && points[j].Timestamp.Sub(points[i].Timestamp) < eventBrakeSpan {
+				j++
+			}
+			dt := points[j].Timestamp.Sub(points[i].Timestamp).Seconds()
+			if dt <= 0 {
+				continue
+			}
+			drop := points[i].Speed - points[j].Speed
+			if drop/3.6/dt >= eventBrakeDecel && points[i].Timestamp.Sub(lastBrake) >= eventBrakeMinGap {
+				events = append(events, TrackEvent{Type: eventBrake, Index: i, Label: fmt.Sprintf("Hard braking −%.0f km/h", drop)})
+				lastBrake = points[i].Timestamp
+			}
+		}
+	}
+
+	if types[eventMaxSpeed] && len(points) > 0 {
+		best := 0
+		for i, p := range points {
+			if p.Speed > points[best].Speed {
+				best = i
+			}
+		}
+		events = append(events, TrackEvent{Type: eventMaxSpeed, Index: best, Label: fmt.Sprintf("Max %.1f km/h", points[best].Speed)})
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Index < events[j].Index })
+	return events
+}
+
+func formatDuration(d time.Duration) string {
+	s := int(d.Round(time.Second).Seconds())
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+func eventColor(t string) color.Color {
+	switch t {
+	case eventStop:
+		return color.RGBA{40, 120, 255, 255}
+	case eventBrake:
+		return color.RGBA{255, 140, 0, 255}
+	}
+	return color.RGBA{230, 30, 30, 255}
+}
+
+// --- Event Rendering ---
+
+// drawEventCallout показывает подпись последнего события в течение eventCalloutDuration
+func drawEventCallout(dc *gg.Context, track *Track, currentPoint Point, centerX, y, maxWidth float64, ttf *truetype.Font, args *Arguments) {
+	for k := len(track.Events) - 1; k >= 0; k-- {
+		e := track.Events[k]
+		since := currentPoint.Timestamp.Sub(track.SmoothedPoints[e.Index].Timestamp)
+		if since < 0 {
+			continue
+		}
+		if since > eventCalloutDuration {
+			return
+		}
+
+		fontSize := maxWidth / 14
+		pad := fontSize / 2
+		dc.Push()
+		dc.SetFontFace(newFontFace(ttf, &truetype.Options{Size: fontSize}))
+		w, _ := dc.MeasureString(e.Label)
+		dc.SetColor(color.RGBA{0, 0, 0, 160})
+		dc.DrawRoundedRectangle(centerX-w/2-pad-fontSize, y, w+2*pad+fontSize, fontSize+2*pad, pad)
+		dc.Fill()
+		dc.SetColor(eventColor(e.Type))
+		dc.DrawCircle(centerX-w/2-fontSize/2, y+pad+fontSize/2, fontSize/3)
+		dc.Fill()
+		dc.SetColor(args.IndicatorColor)
+		dc.DrawStringAnchored(e.Label, centerX-w/2+fontSize/4, y+pad+fontSize/2, 0, 0.35)
+		dc.Pop()
+		return
+	}
+}
+
+// drawEventMarkers ставит на карте метки уже случившихся событий.
+// (viewX, viewY) — мировые пиксельные координаты центра виджета.
+func drawEventMarkers(dc *gg.Context, track *Track, currentPoint Point, viewX, viewY float64, zoom int, residualMapScale, centerX, centerY float64, args *Arguments) {
+	radius := float64(args.WidgetSize) / 60
+	for _, e := range track.Events {
+		p := track.SmoothedPoints[e.Index]
+		if p.Timestamp.After(currentPoint.Timestamp) {
+			break
+		}
+		px, py := deg2num(p.Lat, p.Lon, zoom)
+		x := centerX + (px*float64(args.TileSize)-viewX)/residualMapScale
+		y := centerY + (py*float64(args.TileSize)-viewY)/residualMapScale
+		if math.Hypot(x-centerX, y-centerY) > float64(args.WidgetSize) {
+			continue
+		}
+		dc.SetColor(eventColor(e.Type))
+		dc.DrawCircle(x, y, radius)
+		dc.FillPreserve()
+		dc.SetColor(color.White)
+		dc.SetLineWidth(radius / 3)
+		dc.Stroke()
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- GPX Export ---
+
+// writeGpx пишет точки одним треком GPX 1.1; пульс и мощность — в <extensions>,
+// в том виде, в каком их читает readGpxSensorExtensions
+func writeGpx(filePath, name string, waypoints []Waypoint, points []Point) error {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<gpx version="1.1" creator="gps_overlay_video" xmlns="http://www.topografix.com/GPX/1/1">` + "\n")
+	for _, w := range waypoints {
+		fmt.Fprintf(&b, "  <wpt lat=\"%.7f\" lon=\"%.7f\">", w.Lat, w.Lon)
+		if w.Ele != 0 {
+			fmt.Fprintf(&b, "<ele>%.1f</ele>", w.Ele)
+		}
+		fmt.Fprintf(&b, "<name>%s</name></wpt>\n", xmlEscape(w.Name))
+	}
+
+	fmt.Fprintf(&b, "  <trk><name>%s</name><trkseg>\n", xmlEscape(name))
+	for _, p := range points {
+		fmt.Fprintf(&b, "    <trkpt lat=\"%.7f\" lon=\"%.7f\"><ele>%.1f</ele><time>%s</time>", p.Lat, p.Lon, p.Ele, p.Timestamp.UTC().Format(time.RFC3339Nano))
+		if p.HeartRate > 0 || p.Power > 0 {
+			b.WriteString("<extensions>")
+			if p.Power > 0 {
+				fmt.Fprintf(&b, "<power>%.0f</power>", p.Power)
+			}
+			if p.HeartRate > 0 {
+				fmt.Fprintf(&b, "<hr>%.0f</hr>", p.HeartRate)
+			}
+			b.WriteString("</extensions>")
+		}
+		b.WriteString("</trkpt>\n")
+	}
+	b.WriteString("  </trkseg></trk>\n</gpx>\n")
+	return os.WriteFile(filePath, []byte(b.String()), 0644)
+}
+
+func xmlEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(s)
+}
+
+// resampleTrack интерполирует точки с постоянным шагом interval; 0 — точки остаются как есть
+func resampleTrack(points []Point, interval time.Duration) []Point {
+	if interval <= 0 || len(points) < 2 {
+		return points
+	}
+	start := points[0].Timestamp
+	duration := points[len(points)-1].Timestamp.Sub(start)
+	resampled := make([]Point, 0, int(duration/interval)+1)
+	for t := time.Duration(0); t <= duration; t += interval {
+		resampled = append(resampled, findPointForTime(t.Seconds(), start, points))
+	}
+	return resampled
+}
+
+// --- Point Metrics Export ---
+
+// pointRecord — строка выгрузки -export-points, те же величины, что печатает -debug
+type pointRecord struct {
+	Index            int       `json:"index"`
+	Time             time.Time `json:"time"`
+	Elapsed          float64   `json:"elapsed_s"`
+	Moving           float64   `json:"moving_s"`
+	Lat              float64   `json:"lat"`
+	Lon              float64   `json:"lon"`
+	Ele              float64   `json:"ele_m"`
+	Distance         float64   `json:"distance_km"`
+	Speed            float64   `json:"speed_kmh"`
+	AvgSpeed         float64   `json:"avg_speed_kmh"`
+	Slope            float64   `json:"slope_pct"`
+	SmoothedSlope    float64   `json:"smoothed_slope_pct"`
+	MapScale         float64   `json:"map_scale"`
+	TileZoom         int       `json:"tile_zoom"`
+	ResidualMapScale float64   `json:"residual_map_scale"`
+	Bearing          float64   `json:"bearing_deg"`
+	HeartRate        float64   `json:"heart_rate"`
+	Power            float64   `json:"power_w"`
+}
+
+var pointRecordColumns = []string{"index", "time", "elapsed_s", "moving_s", "lat", "lon", "ele_m", "distance_km", "speed_kmh", "avg_speed_kmh",
+	"slope_pct", "smoothed_slope_pct", "map_scale", "tile_zoom", "residual_map_scale", "bearing_deg", "heart_rate", "power_w"}
+
+func (r pointRecord) csvRow() []string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	return []string{strconv.Itoa(r.Index), r.Time.Format(time.RFC3339Nano), f(r.Elapsed), f(r.Moving), f(r.Lat), f(r.Lon), f(r.Ele), f(r.Distance), f(r.Speed), f(r.AvgSpeed),
+		f(r.Slope), f(r.SmoothedSlope), f(r.MapScale), strconv.Itoa(r.TileZoom), f(r.ResidualMapScale), f(r.Bearing), f(r.HeartRate), f(r.Power)}
+}
+
+// exportPoints выгружает SmoothedPoints диапазона рендера в CSV или JSON (по расширению файла)
+func exportPoints(filePath string, track *Track) error {
+	t0 := track.SmoothedPoints[0].Timestamp
+	var records []pointRecord
+	for i := track.RenderFromIndex; i < track.RenderToIndex; i++ {
+		p := track.SmoothedPoints[i]
+		records = append(records, pointRecord{
+			Index: i, Time: p.Timestamp, Elapsed: p.Timestamp.Sub(t0).Seconds(), Moving: p.MovingTime,
+			Lat: p.Lat, Lon: p.Lon, Ele: p.Ele, Distance: p.Distance,
+			Speed: p.Speed, AvgSpeed: p.AvgSpeed, Slope: p.Slope, SmoothedSlope: p.SmoothedSlope,
+			MapScale: p.MapScale, TileZoom: p.TileZoom, ResidualMapScale: p.ResidualMapScale, Bearing: p.Bearing * 180 / math.Pi,
+			HeartRate: p.HeartRate, Power: p.Power,
+		})
+	}
+
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".json":
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", " ")
+		if err := enc.Encode(records); err != nil {
+			return err
+		}
+	case ".csv":
+		w := csv.NewWriter(f)
+		w.Write(pointRecordColumns)
+		for _, r := range records {
+			w.Write(r.csvRow())
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown export format %q, use .csv or .json", filepath.Ext(filePath))
+	}
+	return f.Close()
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// --- Structs ---
+
+type fitFieldDef struct {
+	Num, Size, BaseType byte
+}
+
+type fitDefinition struct {
+	GlobalNum    uint16
+	ByteOrder    binary.ByteOrder
+	Fields       []fitFieldDef
+	DevFieldSize int
+}
+
+// fitMessage — значения полей одного сообщения (сырые, без масштабирования), ключ — номер поля.
+// Поля-массивы и поля с плавающей точкой лежат в Arrays
+type fitMessage struct {
+	GlobalNum uint16
+	Fields    map[byte]uint64
+	Arrays    map[byte][]float64
+}
+
+const (
+	fitMsgLap    = 19
+	fitMsgRecord = 20
+	fitMsgEvent  = 21
+
+	fitMsgGyroscopeData     = 164
+	fitMsgAccelerometerData = 165
+	fitMsgSensorCalibration = 167 // three_d_sensor_calibration
+
+	fitFieldTimestamp = 253
+
+	fitEventFrontGearChange = 42
+	fitEventRearGearChange  = 43
+)
+
+// FIT считает время от 1989-12-31 00:00:00 UTC
+var fitEpoch = time.Date(1989, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// --- FIT Parsing ---
+
+// readFitMessages декодирует поток сообщений FIT-файла. Поддерживаются обычные и
+// compressed-timestamp заголовки; поля разработчика пропускаются.
+func readFitMessages(r io.Reader, handle func(msg fitMessage)) error {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return fmt.Errorf("failed to read FIT header: %w", err)
+	}
+	headerSize := int(header[0])
+	if headerSize < 12 || string(header[8:12]) != ".FIT" {
+		return fmt.Errorf("not a FIT file")
+	}
+	if _, err := br.Discard(headerSize - 12); err != nil {
+		return err
+	}
+	dataSize := int64(binary.LittleEndian.Uint32(header[4:8]))
+
+	lr := &io.LimitedReader{R: br, N: dataSize}
+	defs := make(map[byte]*fitDefinition)
+	var lastTimestamp uint32
+
+	for lr.N > 0 {
+		recHeader, err := readByte(lr)
+		if err != nil {
+			return err
+		}
+
+		var localType byte
+		compressedTs := recHeader&0x80 != 0
+		if compressedTs {
+			localType = (recHeader >> 5) & 0x03
+		} else {
+			localType = recHeader & 0x0F
+		}
+
+		if !compressedTs && recHeader&0x40 != 0 {
+			def, err := readFitDefinition(lr, recHeader&0x20 != 0)
+			if err != nil {
+				return err
+			}
+			defs[localType] = def
+			continue
+		}
+
+		def, ok := defs[localType]
+		if !ok {
+			return fmt.Errorf("FIT data message for undefined local type %d", localType)
+		}
+
+		msg := fitMessage{GlobalNum: def.GlobalNum, Fields: make(map[byte]uint64, len(def.Fields))}
+		for _, f := range def.Fields {
+			buf := make([]byte, f.Size)
+			if _, err := io.ReadFull(lr, buf); err != nil {
+				return err
+			}
+			if v, ok := decodeFitValue(buf, f.BaseType, def.ByteOrder); ok {
+				msg.Fields[f.Num] = v
+			} else if vs := decodeFitArray(buf, f.BaseType, def.ByteOrder); vs != nil {
+				if msg.Arrays == nil {
+					msg.Arrays = make(map[byte][]float64)
+				}
+				msg.Arrays[f.Num] = vs
+			}
+		}
+		if def.DevFieldSize > 0 {
+			if _, err := io.CopyN(io.Discard, lr, int64(def.DevFieldSize)); err != nil {
+				return err
+			}
+		}
+
+		if compressedTs {
+			offset := uint32(recHeader & 0x1F)
+			ts := (lastTimestamp &^ 0x1F) + offset
+			if offset < lastTimestamp&0x1F {
+				ts += 0x20
+			}
+			msg.Fields[fitFieldTimestamp] = uint64(ts)
+			lastTimestamp = ts
+		} else if ts, ok := msg.Fields[fitFieldTimestamp]; ok {
+			lastTimestamp = uint32(ts)
+		}
+
+		handle(msg)
+	}
+	return nil
+}
+
+func readByte(r io.Reader) (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r, b[:])
+	return b[0], err
+}
+
+func readFitDefinition(r io.Reader, hasDevFields bool) (*fitDefinition, error) {
+	fixed := make([]byte, 5)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	def := &fitDefinition{ByteOrder: binary.LittleEndian}
+	if fixed[1] == 1 {
+		def.ByteOrder = binary.BigEndian
+	}
+	def.GlobalNum = def.ByteOrder.Uint16(fixed[2:4])
+
+	fields := make([]byte, int(fixed[4])*3)
+	if _, err := io.ReadFull(r, fields); err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(fields); i += 3 {
+		def.Fields = append(def.Fields, fitFieldDef{Num: fields[i], Size: fields[i+1], BaseType: fields[i+2]})
+	}
+
+	if hasDevFields {
+		n, err := readByte(r)
+		if err != nil {
+			return nil, err
+		}
+		devFields := make([]byte, int(n)*3)
+		if _, err := io.ReadFull(r, devFields); err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(devFields); i += 3 {
+			def.DevFieldSize += int(devFields[i+1])
+		}
+	}
+	return def, nil
+}
+
+// decodeFitValue читает скалярное значение поля; для невалидных (заполнитель) значений ok=false.
+// Массивы и строки не декодируются.
+func decodeFitValue(buf []byte, baseType byte, order binary.ByteOrder) (uint64, bool) {
+	switch baseType & 0x1F {
+	case 0x00, 0x02, 0x0A, 0x0D: // enum, uint8, uint8z, byte
+		if len(buf) != 1 {
+			return 0, false
+		}
+		v := buf[0]
+		return uint64(v), v != 0xFF && !(baseType&0x1F == 0x0A && v == 0)
+	case 0x01: // sint8
+		if len(buf) != 1 {
+			return 0, false
+		}
+		return uint64(int64(int8(buf[0]))), buf[0] != 0x7F
+	case 0x04, 0x0B: // uint16, uint16z
+		if len(buf) != 2 {
+			return 0, false
+		}
+		v := order.Uint16(buf)
+		return uint64(v), v != 0xFFFF && !(baseType&0x1F == 0x0B && v == 0)
+	case 0x03: // sint16
+		if len(buf) != 2 {
+			return 0, false
+		}
+		v := order.Uint16(buf)
+		return uint64(int64(int16(v))), v != 0x7FFF
+	case 0x06, 0x0C: // uint32, uint32z
+		if len(buf) != 4 {
+			return 0, false
+		}
+		v := order.Uint32(buf)
+		return uint64(v), v != 0xFFFFFFFF && !(baseType&0x1F == 0x0C && v == 0)
+	case 0x05: // sint32
+		if len(buf) != 4 {
+			return 0, false
+		}
+		v := order.Uint32(buf)
+		return uint64(int64(int32(v))), v != 0x7FFFFFFF
+	}
+	return 0, false
+}
+
+// decodeFitArray читает числовой массив (или одно число с плавающей точкой); невалидные элементы — NaN.
+// Для строк и поля не той длины — nil
+func decodeFitArray(buf []byte, baseType byte, order binary.ByteOrder) []float64 {
+	var size int
+	switch baseType & 0x1F {
+	case 0x00, 0x01, 0x02, 0x0A, 0x0D:
+		size = 1
+	case 0x03, 0x04, 0x0B:
+		size = 2
+	case 0x05, 0x06, 0x08, 0x0C:
+		size = 4
+	case 0x09:
+		size = 8
+	default:
+		return nil
+	}
+	if len(buf) == 0 || len(buf)%size != 0 {
+		return nil
+	}
+	vs := make([]float64, len(buf)/size)
+	for i := range vs {
+		b := buf[i*size : (i+1)*size]
+		switch baseType & 0x1F {
+		case 0x08: // float32
+			vs[i] = float64(math.Float32frombits(order.Uint32(b)))
+			if order.Uint32(b) == 0xFFFFFFFF {
+				vs[i] = math.NaN()
+			}
+		case 0x09: // float64
+			vs[i] = math.Float64frombits(order.Uint64(b))
+			if order.Uint64(b) == 0xFFFFFFFFFFFFFFFF {
+				vs[i] = math.NaN()
+			}
+		default:
+			// знаковые decodeFitValue возвращает как int64, беззнаковые не длиннее 32 бит
+			if v, ok := decodeFitValue(b, baseType, order); ok {
+				vs[i] = float64(int64(v))
+			} else {
+				vs[i] = math.NaN()
+			}
+		}
+	}
+	return vs
+}
+
+func fitSemicirclesToDegrees(v uint64) float64 {
+	return float64(int32(v)) * (180.0 / math.Pow(2, 31))
+}
+
+// parseFit читает точки из record-сообщений FIT-файла, дополняя их состоянием
+// электронного переключения из событий смены передачи.
+func parseFit(filePath string) ([]Point, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open FIT file: %w", err)
+	}
+	defer file.Close()
+
+	var points []Point
+	var frontGear, rearGear, frontGearNum, rearGearNum int
+	lap := 1
+
+	err = readFitMessages(file, func(msg fitMessage) {
+		switch msg.GlobalNum {
+		case fitMsgLap:
+			// lap-сообщение пишется в конце круга: следующие записи относятся к новому
+			lap++
+		case fitMsgEvent:
+			event, ok := msg.Fields[0]
+			if !ok || (event != fitEventFrontGearChange && event != fitEventRearGearChange) {
+				return
+			}
+			// поле data упаковывает rear_gear_num, rear_gear, front_gear_num, front_gear по байту
+			data, ok := msg.Fields[3]
+			if !ok {
+				return
+			}
+			rearGearNum = int(data & 0xFF)
+			rearGear = int((data >> 8) & 0xFF)
+			frontGearNum = int((data >> 16) & 0xFF)
+			frontGear = int((data >> 24) & 0xFF)
+		case fitMsgRecord:
+			ts, okTs := msg.Fields[fitFieldTimestamp]
+			lat, okLat := msg.Fields[0]
+			lon, okLon := msg.Fields[1]
+			if !okTs {
+				return
+			}
+			p := Point{
+				Lat:          fitSemicirclesToDegrees(lat),
+				Lon:          fitSemicirclesToDegrees(lon),
+				NoFix:        !okLat || !okLon, // тренажёр: позицию даст -virtual-route
+				Timestamp:    fitEpoch.Add(time.Duration(ts) * time.Second),
+				FrontGear:    frontGear,
+				RearGear:     rearGear,
+				FrontGearNum: frontGearNum,
+				RearGearNum:  rearGearNum,
+				Lap:          lap,
+			}
+			if v, ok := msg.Fields[3]; ok { // heart_rate
+				p.HeartRate = float64(v)
+			}
+			if v, ok := msg.Fields[7]; ok { // power
+				p.Power = float64(v)
+			}
+			if v, ok := msg.Fields[5]; ok { // distance, см
+				p.RecordedDistance = float64(v) / 100 / 1000
+			}
+			if v, ok := msg.Fields[73]; ok { // enhanced_speed, мм/с
+				p.RecordedSpeed = float64(v) / 1000 * 3.6
+			} else if v, ok := msg.Fields[6]; ok { // speed, мм/с
+				p.RecordedSpeed = float64(v) / 1000 * 3.6
+			}
+			if v, ok := msg.Fields[31]; ok { // gps_accuracy, м
+				p.GPSAccuracy = float64(v)
+			}
+			if v, ok := msg.Fields[4]; ok { // cadence; в плавании — темп гребков
+				p.Cadence = float64(v)
+			}
+			// беговая динамика
+			if v, ok := msg.Fields[85]; ok { // step_length, мм*10
+				p.StepLength = float64(v) / 10 / 1000
+			}
+			if v, ok := msg.Fields[41]; ok { // stance_time, мс*10
+				p.GroundContactTime = float64(v) / 10
+			}
+			if v, ok := msg.Fields[39]; ok { // vertical_oscillation, мм*10
+				p.VerticalOscillation = float64(v) / 10 / 10
+			}
+			if alt, ok := msg.Fields[78]; ok { // enhanced_altitude
+				p.Ele = float64(alt)/5 - 500
+			} else if alt, ok := msg.Fields[2]; ok {
+				p.Ele = float64(alt)/5 - 500
+			}
+			points = append(points, p)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse FIT file: %w", err)
+	}
+	return points, nil
+}
+
+// parseFitIMU читает из FIT-файла сообщения accelerometer_data и gyroscope_data. Берутся калиброванные
+// значения, а если их нет — сырые отсчёты, пересчитанные по three_d_sensor_calibration.
+// Ускорение — в g, вращение — в рад/с, оба в осях датчика
+func parseFitIMU(filePath string) (imuData, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return imuData{}, fmt.Errorf("failed to open FIT file: %w", err)
+	}
+	defer file.Close()
+
+	var data imuData
+	calibrations := make(map[uint64]fitMessage) // по sensor_type: 0 — акселерометр, 1 — гироскоп
+	err = readFitMessages(file, func(msg fitMessage) {
+		switch msg.GlobalNum {
+		case fitMsgSensorCalibration:
+			if sensor, ok := msg.Fields[0]; ok {
+				calibrations[sensor] = msg
+			}
+		case fitMsgAccelerometerData:
+			if _, calibrated := msg.Arrays[5]; !calibrated && len(msg.Arrays[8]) > 0 { // compressed_calibrated_accel, mG
+				data.Accel = append(data.Accel, fitIMUSamples(msg, [3]byte{8, 9, 10}, 0.001, [3]byte{}, fitMessage{})...)
+			} else {
+				data.Accel = append(data.Accel, fitIMUSamples(msg, [3]byte{5, 6, 7}, 1, [3]byte{2, 3, 4}, calibrations[0])...)
+			}
+		case fitMsgGyroscopeData:
+			data.Gyro = append(data.Gyro, fitIMUSamples(msg, [3]byte{5, 6, 7}, math.Pi/180, [3]byte{2, 3, 4}, calibrations[1])...)
+		}
+	})
+	if err != nil {
+		return imuData{}, fmt.Errorf("failed to parse FIT file: %w", err)
+	}
+	return data, nil
+}
+
+// fitIMUSamples разворачивает пачку измерений одного сообщения: время каждого — timestamp, timestamp_ms
+// и сдвиг из sample_time_offset. Калиброванные поля cal умножаются на scale; без них сырые поля raw
+// пересчитываются по калибровке cal (в g или °/с, тоже со scale)
+func fitIMUSamples(msg fitMessage, calFields [3]byte, scale float64, rawFields [3]byte, cal fitMessage) []imuSample {
+	ts, ok := msg.Fields[fitFieldTimestamp]
+	if !ok {
+		return nil
+	}
+	start := fitEpoch.Add(time.Duration(ts) * time.Second)
+	if ms, ok := msg.Fields[0]; ok {
+		start = start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	offsets := msg.Arrays[1]
+	if v, ok := msg.Fields[1]; ok { // одно измерение — не массив
+		offsets = []float64{float64(v)}
+	}
+
+	var axes [3][]float64
+	factor, offset := [3]float64{scale, scale, scale}, [3]float64{}
+	for i := range axes {
+		axes[i] = fitFieldValues(msg, calFields[i])
+	}
+	if len(axes[0]) == 0 && rawFields[0] != 0 {
+		f, okF := cal.Fields[1]
+		d, okD := cal.Fields[2]
+		if !okF || !okD || d == 0 {
+			return nil
+		}
+		levelShift := float64(int64(cal.Fields[3]))
+		offsetCal := cal.Arrays[4]
+		for i := range axes {
+			axes[i] = fitFieldValues(msg, rawFields[i])
+			factor[i] = scale * float64(f) / float64(d)
+			offset[i] = levelShift
+			if i < len(offsetCal) && !math.IsNaN(offsetCal[i]) {
+				offset[i] += offsetCal[i]
+			}
+		}
+	}
+
+	n := min(len(axes[0]), len(axes[1]), len(axes[2]), len(offsets))
+	samples := make([]imuSample, 0, n)
+	for k := 0; k < n; k++ {
+		s := imuSample{Time: start.Add(time.Duration(offsets[k]) * time.Millisecond)}
+		for i := range axes {
+			s.V[i] = (axes[i][k] - offset[i]) * factor[i]
+		}
+		if !math.IsNaN(s.V[0]+s.V[1]+s.V[2]) && !math.IsNaN(offsets[k]) {
+			samples = append(samples, s)
+		}
+	}
+	return samples
+}
+
+// fitFieldValues — значения поля-массива или одиночного целого поля
+func fitFieldValues(msg fitMessage, num byte) []float64 {
+	if vs, ok := msg.Arrays[num]; ok {
+		return vs
+	}
+	if v, ok := msg.Fields[num]; ok {
+		return []float64{float64(int64(v))}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/math/fixed"
+)
+
+// --- Font Loading ---
+
+var (
+	loadedFontsMu sync.Mutex
+	loadedFonts   = make(map[string]*truetype.Font) // путь -> шрифт; "" — встроенный goregular
+)
+
+// loadFont читает шрифт -font или шрифт виджета из -layout-file; пустой путь — встроенный goregular.
+// Если файл не читается или это OpenType с CFF-контурами, которые freetype не понимает, пишем
+// предупреждение и возвращаем nil: вызывающий рисует общим шрифтом, а не прерывает рендер
+func loadFont(path string) *truetype.Font {
+	loadedFontsMu.Lock()
+	defer loadedFontsMu.Unlock()
+	if f, ok := loadedFonts[path]; ok {
+		return f
+	}
+	data := goregular.TTF
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			log.Printf("Font %s: %v; using the default font", path, err)
+			loadedFonts[path] = nil
+			return nil
+		}
+	}
+	f, err := truetype.Parse(data)
+	if err != nil {
+		log.Printf("Font %s: %v (only TrueType outlines are supported); using the default font", path, err)
+	}
+	loadedFonts[path] = f
+	return f
+}
+
+// --- Font Fallback ---
+
+// fallbackFonts — шрифты из -fallback-fonts, которыми рисуются символы, отсутствующие
+// во встроенном goregular (иероглифы, арабское письмо и т.п.)
+var fallbackFonts []*truetype.Font
+
+// loadFallbackFonts читает TrueType-шрифты из списка через запятую. OpenType с CFF-контурами
+// и коллекции .ttc библиотека freetype не понимает — нужен обычный .ttf.
+func loadFallbackFonts(spec string) ([]*truetype.Font, error) {
+	var fonts []*truetype.Font
+	for _, path := range strings.Split(spec, ",") {
+		path = strings.TrimSpace(path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		f, err := truetype.Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse font %s (only TrueType .ttf is supported): %w", path, err)
+		}
+		fonts = append(fonts, f)
+	}
+	return fonts, nil
+}
+
+// newFontFace заменяет truetype.NewFace: если заданы запасные шрифты, каждый символ
+// берётся из первого шрифта, в котором он есть. Начертания кэшируются по шрифту и размеру —
+// truetype.NewFace на каждый кадр выделяет кэш глифов заново
+func newFontFace(ttf *truetype.Font, opts *truetype.Options) font.Face {
+	key := faceKey{ttf, *opts}
+	faceCacheMu.Lock()
+	defer faceCacheMu.Unlock()
+	if f, ok := faceCache[key]; ok {
+		return f
+	}
+	if len(faceCache) >= faceCacheLimit {
+		// размеры подписей, ужимаемых под ширину, бывают любыми — не даём кэшу расти без конца
+		faceCache = make(map[faceKey]*sharedFace)
+	}
+	var face font.Face
+	if len(fallbackFonts) == 0 {
+		face = truetype.NewFace(ttf, opts)
+	} else {
+		f := &fallbackFace{fonts: append([]*truetype.Font{ttf}, fallbackFonts...)}
+		for _, t := range f.fonts {
+			f.faces = append(f.faces, truetype.NewFace(t, opts))
+		}
+		face = f
+	}
+	shared := &sharedFace{face: face}
+	faceCache[key] = shared
+	return shared
+}
+
+// --- Face Cache ---
+
+const faceCacheLimit = 256
+
+type faceKey struct {
+	ttf  *truetype.Font
+	opts truetype.Options
+}
+
+var (
+	faceCacheMu sync.Mutex
+	faceCache   = make(map[faceKey]*sharedFace)
+)
+
+// sharedFace — начертание из кэша, общее для параллельно рисующих кадры воркеров. truetype.Face
+// не потокобезопасен и отдаёт маску глифа из своего буфера, поэтому вызовы идут под мьютексом,
+// а маска копируется. Close ничего не делает: начертание живёт в кэше
+type sharedFace struct {
+	mu   sync.Mutex
+	face font.Face
+}
+
+func (f *sharedFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	dr, mask, maskp, advance, ok := f.face.Glyph(dot, r)
+	if !ok || mask == nil {
+		return dr, mask, maskp, advance, ok
+	}
+	copied := image.NewAlpha(image.Rect(0, 0, dr.Dx(), dr.Dy()))
+	draw.Draw(copied, copied.Bounds(), mask, maskp, draw.Src)
+	return dr, copied, image.Point{}, advance, ok
+}
+
+func (f *sharedFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.face.GlyphBounds(r)
+}
+
+func (f *sharedFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.face.GlyphAdvance(r)
+}
+
+func (f *sharedFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.face.Kern(r0, r1)
+}
+
+func (f *sharedFace) Metrics() font.Metrics {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.face.Metrics()
+}
+
+func (f *sharedFace) Close() error {
+	return nil
+}
+
+// fallbackFace — font.Face поверх нескольких шрифтов; метрики строки берутся у основного
+type fallbackFace struct {
+	fonts []*truetype.Font
+	faces []font.Face
+}
+
+func (f *fallbackFace) pick(r rune) int {
+	for i, t := range f.fonts {
+		if t.Index(r) != 0 {
+			return i
+		}
+	}
+	return 0 // ни в одном нет — пусть основной шрифт нарисует свой «нет символа»
+}
+
+func (f *fallbackFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	return f.faces[f.pick(r)].Glyph(dot, r)
+}
+
+func (f *fallbackFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	return f.faces[f.pick(r)].GlyphBounds(r)
+}
+
+func (f *fallbackFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	return f.faces[f.pick(r)].GlyphAdvance(r)
+}
+
+// Kern применим только к паре символов из одного шрифта
+func (f *fallbackFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	if i := f.pick(r0); i == f.pick(r1) {
+		return f.faces[i].Kern(r0, r1)
+	}
+	return 0
+}
+
+func (f *fallbackFace) Metrics() font.Metrics {
+	return f.faces[0].Metrics()
+}
+
+func (f *fallbackFace) Close() error {
+	for _, face := range f.faces {
+		face.Close()
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// --- Structs ---
+
+// Place — название дороги или населённого пункта, действующее с дистанции Distance
+type Place struct {
+	Distance float64 // км
+	Name     string
+}
+
+type nominatimResponse struct {
+	Name    string            `json:"name"`
+	Address map[string]string `json:"address"`
+}
+
+const (
+	geocodeNominatim     = "nominatim"
+	geocodeSpacing       = 0.25 // км между запросами вдоль трека
+	geocodeRateLimit     = time.Second
+	geocodeOfflineRadius = 1.0 // км: дальше ближайший объект выгрузки не считается текущим местом
+)
+
+// --- Reverse Geocoding ---
+
+// geocodeTrack определяет названия мест вдоль трека раз в geocodeSpacing.
+// source — "nominatim" или путь к офлайн-выгрузке (строки "lat,lon,name"); offline — Nominatim только из кэша.
+func geocodeTrack(points []Point, source string, offline bool) ([]Place, error) {
+	lookup := func(lat, lon float64) (string, error) {
+		return lookupNominatim(lat, lon, offline)
+	}
+	if source != geocodeNominatim {
+		extract, err := loadPlaceExtract(source)
+		if err != nil {
+			return nil, err
+		}
+		lookup = func(lat, lon float64) (string, error) {
+			return nearestPlace(extract, lat, lon), nil
+		}
+	}
+
+	var places []Place
+	nextDistance := 0.0
+	for _, p := range points {
+		if p.Distance < nextDistance {
+			continue
+		}
+		nextDistance = p.Distance + geocodeSpacing
+		name, err := lookup(p.Lat, p.Lon)
+		if err != nil {
+			return nil, err
+		}
+		if len(places) > 0 && places[len(places)-1].Name == name {
+			continue
+		}
+		places = append(places, Place{Distance: p.Distance, Name: name})
+	}
+	return places, nil
+}
+
+var lastNominatimRequest time.Time
+
+func lookupNominatim(lat, lon float64, offline bool) (string, error) {
+	cachePath := filepath.Join(geocodeCacheDir, fmt.Sprintf("%.4f_%.4f.json", lat, lon))
+	body, err := os.ReadFile(cachePath)
+	if err != nil && offline {
+		return "", fmt.Errorf("place name for %.4f,%.4f is not in %s and -offline forbids asking Nominatim", lat, lon, geocodeCacheDir)
+	}
+	if err != nil {
+		// правила Nominatim: не больше одного запроса в секунду
+		if wait := geocodeRateLimit - time.Since(lastNominatimRequest); wait > 0 {
+			time.Sleep(wait)
+		}
+		lastNominatimRequest = time.Now()
+
+		url := fmt.Sprintf("https://nominatim.openstreetmap.org/reverse?format=jsonv2&lat=%.4f&lon=%.4f&zoom=17", lat, lon)
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("User-Agent", "GpsOverlayVideoGo/0.1")
+
+		client := &http.Client{
+			Timeout: 10 * time.Second,
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("failed to reverse geocode %s: %w", url, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("failed to reverse geocode %s: status %d", url, resp.StatusCode)
+		}
+
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return "", err
+		}
+		os.MkdirAll(geocodeCacheDir, 0755)
+		os.WriteFile(cachePath, body, 0644)
+	}
+
+	var data nominatimResponse
+	if err := json.Unmarshal(body, &data); err != nil {
+		return "", fmt.Errorf("failed to decode geocoding response: %w", err)
+	}
+	road := data.Address["road"]
+	if road == "" {
+		road = data.Name
+	}
+	var locality string
+	for _, key := range []string{"village", "town", "city", "hamlet", "suburb"} {
+		if locality = data.Address[key]; locality != "" {
+			break
+		}
+	}
+	if road != "" && locality != "" && road != locality {
+		return road + ", " + locality, nil
+	}
+	return road + locality, nil
+}
+
+// loadPlaceExtract читает офлайн-выгрузку: по строке "lat,lon,name", # — комментарий
+func loadPlaceExtract(filePath string) ([]Waypoint, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read place extract: %w", err)
+	}
+	var places []Waypoint
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ",", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid format on line %d: %s", i+1, line)
+		}
+		lat, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		lon, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid coordinates on line %d: %s", i+1, line)
+		}
+		places = append(places, Waypoint{Name: strings.TrimSpace(parts[2]), Lat: lat, Lon: lon})
+	}
+	log.Printf("Loaded %d places from %s", len(places), filePath)
+	return places, nil
+}
+
+func nearestPlace(places []Waypoint, lat, lon float64) string {
+	best, bestDist := "", geocodeOfflineRadius
+	for _, p := range places {
+		if d := haversine(Point{Lat: lat, Lon: lon}, Point{Lat: p.Lat, Lon: p.Lon}); d < bestDist {
+			best, bestDist = p.Name, d
+		}
+	}
+	return best
+}
+
+// --- Place Caption ---
+
+// drawPlaceCaption показывает название текущего места небольшой подписью по центру
+func drawPlaceCaption(dc *gg.Context, places []Place, distance, centerX, y, maxWidth float64, ttf *truetype.Font, args *Arguments) {
+	i := sort.Search(len(places), func(i int) bool { return places[i].Distance > distance }) - 1
+	if i < 0 || places[i].Name == "" {
+		return
+	}
+	fontSize := maxWidth / 20
+	pad := fontSize / 2
+	dc.Push()
+	dc.SetFontFace(newFontFace(ttf, &truetype.Options{Size: fontSize}))
+	name := places[i].Name
+	w, _ := dc.MeasureString(name)
+	for w > maxWidth-2*pad && len([]rune(name)) > 1 {
+		r := []rune(strings.TrimSuffix(name, "…"))
+		name = string(r[:len(r)-1]) + "…"
+		w, _ = dc.MeasureString(name)
+	}
+	dc.SetColor(color.RGBA{0, 0, 0, 140})
+	dc.DrawRoundedRectangle(centerX-w/2-pad, y, w+2*pad, fontSize+pad, pad)
+	dc.Fill()
+	dc.SetColor(color.White)
+	dc.DrawStringAnchored(name, centerX, y+(fontSize+pad)/2, 0.5, 0.35)
+	dc.Pop()
+}
@@ -0,0 +1,628 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// --- GPMF Telemetry ---
+
+// Телеметрия пишется так же, как её пишут камеры GoPro: отдельная дорожка с обработчиком "meta"
+// и описанием "gpmd", по сэмплу GPMF на секунду видео. Внутри каждого сэмпла — поток GPS5
+// (широта, долгота, высота, 2D- и 3D-скорость) с частотой gpmfRate, так что её читают
+// gopro-telemetry, GPMF-parser и другие оверлей-программы.
+
+const (
+	gpmfRate      = 10   // измерений GPS5 на секунду видео, если кадров не меньше
+	gpmfTimescale = 1000 // единица времени дорожки — мс
+	gpmfDeviceID  = 1    // DVID; у камер GoPro это тоже 1
+	gpmfFix3D     = 3    // GPSF: трёхмерная фиксация
+	gpmfPrecision = 100  // GPSP: DOP x100, у записанного трека точность неизвестна — пишем «хорошую»
+	gpmfGPSUTime  = "060102150405.000"
+)
+
+// gpmfSamples строит по сэмплу GPMF на каждую секунду видео; время берётся у кадров,
+// так что стоп-кадры и ускорения попадают в телеметрию так же, как в картинку
+func gpmfSamples(track *Track, args *Arguments, segments []videoSegment, totalFrames int) [][]byte {
+	duration := float64(totalFrames) / args.Framerate
+	rate := max(1, min(gpmfRate, int(args.Framerate))) // чаще кадров измерения только повторялись бы
+	var samples [][]byte
+	for second := 0.0; second < duration; second++ {
+		var gps5 []int32
+		var first time.Time
+		for k := 0; k < rate; k++ {
+			frameNum := min(int((second+float64(k)/float64(rate))*args.Framerate), totalFrames-1)
+			seg, offset := frameTrackOffset(track, args, segments, frameNum)
+			p := findPointForTime(offset, seg.StartTime, track.SmoothedPoints)
+			if k == 0 {
+				first = p.Timestamp
+			}
+			speed := p.Speed / 3.6
+			gps5 = append(gps5, int32(math.Round(p.Lat*1e7)), int32(math.Round(p.Lon*1e7)), int32(math.Round(p.Ele*1000)),
+				int32(math.Round(speed*1000)), int32(math.Round(speed*100)))
+		}
+
+		stream := new(bytes.Buffer)
+		gpmfKLV(stream, "STNM", 'c', 1, []byte("GPS (Lat., Long., Alt., 2D speed, 3D speed)"))
+		gpmfKLV(stream, "GPSF", 'L', 4, []uint32{gpmfFix3D})
+		gpmfKLV(stream, "GPSU", 'U', 16, []byte(first.UTC().Format(gpmfGPSUTime)))
+		gpmfKLV(stream, "GPSP", 'S', 2, []uint16{gpmfPrecision})
+		gpmfKLV(stream, "UNIT", 'c', 3, []byte("degdegm  m/sm/s"))
+		gpmfKLV(stream, "SCAL", 'l', 4, []int32{1e7, 1e7, 1000, 1000, 100})
+		gpmfKLV(stream, "GPS5", 'l', 20, gps5)
+
+		device := new(bytes.Buffer)
+		gpmfKLV(device, "DVID", 'L', 4, []uint32{gpmfDeviceID})
+		gpmfKLV(device, "DVNM", 'c', 1, []byte("gps_overlay_video"))
+		gpmfKLV(device, "STRM", 0, 1, stream.Bytes())
+
+		sample := new(bytes.Buffer)
+		gpmfKLV(sample, "DEVC", 0, 1, device.Bytes())
+		samples = append(samples, sample.Bytes())
+	}
+	return samples
+}
+
+// gpmfKLV пишет запись GPMF: ключ, тип, размер структуры, число повторов и данные,
+// выровненные на 4 байта. Тип 0 — вложенные записи, их размер структуры равен 1.
+func gpmfKLV(w *bytes.Buffer, key string, typ byte, structSize int, data any) {
+	payload := new(bytes.Buffer)
+	binary.Write(payload, binary.BigEndian, data)
+	w.WriteString(key)
+	w.WriteByte(typ)
+	w.WriteByte(byte(structSize))
+	binary.Write(w, binary.BigEndian, uint16(payload.Len()/structSize))
+	w.Write(payload.Bytes())
+	for w.Len()%4 != 0 {
+		w.WriteByte(0)
+	}
+}
+
+// --- MP4 Muxing ---
+
+// embedGPMF добавляет дорожку телеметрии в готовый MP4: сэмплы дописываются новым mdat
+// на место moov, а moov с дополнительным trak — после него. Смещения видео при этом
+// не меняются, поэтому moov должен быть последним боксом (так пишет ffmpeg без +faststart).
+func embedGPMF(filePath string, samples [][]byte, duration float64) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(filePath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var moovOffset, moovSize int64 = -1, 0
+	for pos := int64(0); pos < info.Size(); {
+		size, typ, err := readBoxHeader(f, pos, info.Size())
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case "moov":
+			moovOffset, moovSize = pos, size
+		case "moof":
+			return errors.New("fragmented MP4 is not supported")
+		case "mdat":
+			if moovOffset >= 0 {
+				return errors.New("moov must follow the media data (file written with +faststart?)")
+			}
+		}
+		pos += size
+	}
+	if moovOffset < 0 {
+		return errors.New("no moov box found")
+	}
+	moov := make([]byte, moovSize)
+	if _, err := f.ReadAt(moov, moovOffset); err != nil {
+		return err
+	}
+	if string(moov[4:8]) != "moov" || binary.BigEndian.Uint32(moov) == 1 {
+		return errors.New("unsupported moov box header")
+	}
+
+	// новый mdat встаёт на место старого moov
+	mdat := new(bytes.Buffer)
+	var dataSize int64
+	for _, s := range samples {
+		dataSize += int64(len(s))
+	}
+	mdat.Write(uint32Bytes(1))
+	mdat.WriteString("mdat")
+	binary.Write(mdat, binary.BigEndian, uint64(16+dataSize))
+	offsets := make([]uint64, len(samples))
+	sizes := make([]uint32, len(samples))
+	for i, s := range samples {
+		offsets[i] = uint64(moovOffset) + uint64(mdat.Len())
+		sizes[i] = uint32(len(s))
+		mdat.Write(s)
+	}
+
+	newMoov, err := addGPMFTrack(moov, offsets, sizes, duration)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(mdat.Bytes(), moovOffset); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(newMoov, moovOffset+int64(mdat.Len())); err != nil {
+		return err
+	}
+	if err := f.Truncate(moovOffset + int64(mdat.Len()) + int64(len(newMoov))); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// readBoxHeader читает размер и тип бокса верхнего уровня по смещению pos
+func readBoxHeader(f *os.File, pos, fileSize int64) (size int64, typ string, err error) {
+	var buf [16]byte
+	if _, err := f.ReadAt(buf[:8], pos); err != nil {
+		return 0, "", fmt.Errorf("failed to read MP4 box at %d: %w", pos, err)
+	}
+	size, typ, header := int64(binary.BigEndian.Uint32(buf[:4])), string(buf[4:8]), int64(8)
+	switch size {
+	case 0: // до конца файла
+		size = fileSize - pos
+	case 1:
+		if _, err := f.ReadAt(buf[8:16], pos+8); err != nil && err != io.EOF {
+			return 0, "", err
+		}
+		size, header = int64(binary.BigEndian.Uint64(buf[8:16])), 16
+	}
+	if size < header || pos+size > fileSize {
+		return 0, "", fmt.Errorf("invalid MP4 box %q at %d", typ, pos)
+	}
+	return size, typ, nil
+}
+
+// addGPMFTrack возвращает moov с новым trak телеметрии и увеличенным next_track_ID в mvhd
+func addGPMFTrack(moov []byte, offsets []uint64, sizes []uint32, duration float64) ([]byte, error) {
+	var mvhd []byte
+	mvhdAt := 8
+	for mvhdAt+8 <= len(moov) {
+		size := int(binary.BigEndian.Uint32(moov[mvhdAt:]))
+		if size < 8 || mvhdAt+size > len(moov) {
+			return nil, errors.New("invalid box inside moov")
+		}
+		if string(moov[mvhdAt+4:mvhdAt+8]) == "mvhd" {
+			mvhd = moov[mvhdAt : mvhdAt+size]
+			break
+		}
+		mvhdAt += size
+	}
+	if mvhd == nil {
+		return nil, errors.New("no mvhd box found")
+	}
+	// после версии и флагов: времена создания/изменения (4 или 8 байт), timescale, длительность (4 или 8)
+	timescaleAt, nextIDAt := 20, 104
+	if mvhd[8] == 1 {
+		timescaleAt, nextIDAt = 28, 116
+	}
+	if len(mvhd) < nextIDAt+4 {
+		return nil, errors.New("truncated mvhd box")
+	}
+	movieTimescale := binary.BigEndian.Uint32(mvhd[timescaleAt:])
+	trackID := binary.BigEndian.Uint32(mvhd[nextIDAt:])
+
+	out := append([]byte(nil), moov...)
+	binary.BigEndian.PutUint32(out[mvhdAt+nextIDAt:], trackID+1)
+	out = append(out, gpmfTrak(trackID, movieTimescale, offsets, sizes, duration)...)
+	binary.BigEndian.PutUint32(out, uint32(len(out)))
+	return out, nil
+}
+
+// gpmfTrak собирает trak дорожки gpmd: по сэмплу на секунду, каждый в своём чанке
+func gpmfTrak(trackID, movieTimescale uint32, offsets []uint64, sizes []uint32, duration float64) []byte {
+	n := len(sizes)
+	mediaDuration := uint32(math.Round(duration * gpmfTimescale))
+
+	// все сэмплы по секунде, последний — сколько осталось от видео
+	stts := []uint32{0, 0}
+	if n > 1 {
+		stts = append(stts, uint32(n-1), gpmfTimescale)
+	}
+	if n > 0 { // у пустого видео нет и сэмплов
+		stts = append(stts, 1, mediaDuration-min(mediaDuration, uint32(n-1)*gpmfTimescale))
+	}
+	stts[1] = uint32(len(stts)-2) / 2
+
+	stsz := []uint32{0, 0, uint32(n)}
+	stsz = append(stsz, sizes...)
+	co64 := new(bytes.Buffer)
+	binary.Write(co64, binary.BigEndian, []uint32{0, uint32(n)})
+	binary.Write(co64, binary.BigEndian, offsets)
+
+	stsd := new(bytes.Buffer)
+	binary.Write(stsd, binary.BigEndian, []uint32{0, 1})
+	stsd.Write(mp4Box("gpmd", []byte{0, 0, 0, 0, 0, 0, 0, 1})) // 6 зарезервированных байт и data_reference_index
+
+	stbl := mp4Box("stbl",
+		mp4Box("stsd", stsd.Bytes()),
+		mp4Box("stts", uint32sBytes(stts)),
+		mp4Box("stsc", uint32sBytes([]uint32{0, 1, 1, 1, 1})),
+		mp4Box("stsz", uint32sBytes(stsz)),
+		mp4Box("co64", co64.Bytes()),
+	)
+	dref := mp4Box("dref", uint32sBytes([]uint32{0, 1}), mp4Box("url ", uint32Bytes(1))) // флаг 1: данные в этом же файле
+	minf := mp4Box("minf", mp4Box("nmhd", uint32Bytes(0)), mp4Box("dinf", dref), stbl)
+
+	hdlr := new(bytes.Buffer)
+	binary.Write(hdlr, binary.BigEndian, []uint32{0, 0})
+	hdlr.WriteString("meta")
+	hdlr.Write(make([]byte, 12))
+	hdlr.WriteString("GoPro MET\x00")
+
+	mdhd := new(bytes.Buffer)
+	binary.Write(mdhd, binary.BigEndian, []uint32{0, 0, 0, gpmfTimescale, mediaDuration})
+	binary.Write(mdhd, binary.BigEndian, []uint16{0x55c4, 0}) // язык "und"
+
+	// tkhd: дорожка выключена (флаги 0), чтобы плееры не пытались её показывать
+	tkhd := new(bytes.Buffer)
+	binary.Write(tkhd, binary.BigEndian, []uint32{0, 0, 0, trackID, 0, uint32(math.Round(duration * float64(movieTimescale))), 0, 0})
+	binary.Write(tkhd, binary.BigEndian, []uint16{0, 0, 0, 0})
+	binary.Write(tkhd, binary.BigEndian, []uint32{0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000, 0, 0}) // единичная матрица, ширина и высота 0
+
+	return mp4Box("trak", mp4Box("tkhd", tkhd.Bytes()), mp4Box("mdia", mp4Box("mdhd", mdhd.Bytes()), mp4Box("hdlr", hdlr.Bytes()), minf))
+}
+
+// mp4Box собирает бокс из типа и содержимого (склеенного по порядку)
+func mp4Box(typ string, parts ...[]byte) []byte {
+	size := 8
+	for _, p := range parts {
+		size += len(p)
+	}
+	b := make([]byte, 0, size)
+	b = append(b, uint32Bytes(uint32(size))...)
+	b = append(b, typ...)
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func uint32Bytes(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func uint32sBytes(vs []uint32) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+// --- GPMF Reading ---
+
+// readGPMF читает потоки ACCL (м/с²) и GYRO (рад/с) из дорожки телеметрии MP4 камеры GoPro.
+// Время измерений берётся из GPSU — часов GPS в том же сэмпле, так что камера должна была поймать спутники
+func readGPMF(filePath string) (imuData, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return imuData{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return imuData{}, err
+	}
+
+	var moov []byte
+	for pos := int64(0); pos < info.Size(); {
+		size, typ, err := readBoxHeader(f, pos, info.Size())
+		if err != nil {
+			return imuData{}, err
+		}
+		if typ == "moov" {
+			moov = make([]byte, size)
+			if _, err := f.ReadAt(moov, pos); err != nil {
+				return imuData{}, err
+			}
+			break
+		}
+		pos += size
+	}
+	if moov == nil {
+		return imuData{}, errors.New("no moov box found")
+	}
+
+	var stbl []byte
+	var timescale uint32
+	for _, trak := range mp4Children(moov[8:], "trak") {
+		mdia := mp4Child(trak, "mdia")
+		hdlr, mdhd := mp4Child(mdia, "hdlr"), mp4Child(mdia, "mdhd")
+		s := mp4Child(mp4Child(mdia, "minf"), "stbl")
+		stsd := mp4Child(s, "stsd")
+		if len(hdlr) >= 12 && string(hdlr[8:12]) == "meta" && len(stsd) >= 16 && string(stsd[12:16]) == "gpmd" && len(mdhd) >= 24 {
+			stbl = s
+			timescale = binary.BigEndian.Uint32(mdhd[12:]) // версия 0: после времён создания и изменения
+			if mdhd[0] == 1 {
+				timescale = binary.BigEndian.Uint32(mdhd[20:])
+			}
+			break
+		}
+	}
+	if stbl == nil || timescale == 0 {
+		return imuData{}, errors.New("no GoPro telemetry (gpmd) track found")
+	}
+	offsets, sizes, starts, durations, err := mp4SampleTable(stbl, info.Size())
+	if err != nil {
+		return imuData{}, err
+	}
+
+	// часы GPS против времени дорожки: медиана по сэмплам, чтобы одна ошибка приёмника не сдвинула всё
+	var data imuData
+	var clockOffsets []float64
+	for i := range sizes {
+		payload := make([]byte, sizes[i])
+		if _, err := f.ReadAt(payload, int64(offsets[i])); err != nil {
+			return imuData{}, fmt.Errorf("failed to read telemetry sample %d: %w", i+1, err)
+		}
+		start := float64(starts[i]) / float64(timescale)
+		duration := float64(durations[i]) / float64(timescale)
+		streams := gpmfStreams(payload)
+		for _, st := range streams {
+			if st.GPSU != "" {
+				if t, err := time.Parse(gpmfGPSUTime, st.GPSU); err == nil {
+					clockOffsets = append(clockOffsets, float64(t.UnixNano())/1e9-start)
+				}
+			}
+			var target *[]imuSample
+			switch st.Key {
+			case "ACCL":
+				target = &data.Accel
+			case "GYRO":
+				target = &data.Gyro
+			default:
+				continue
+			}
+			for k, v := range st.Values {
+				*target = append(*target, imuSample{Time: time.Unix(0, int64((start+duration*float64(k)/float64(len(st.Values)))*1e9)), V: v})
+			}
+		}
+	}
+	if len(clockOffsets) == 0 {
+		return imuData{}, errors.New("telemetry has no GPS time (GPSU): the camera had no GPS fix")
+	}
+	sort.Float64s(clockOffsets)
+	clock := time.Duration(clockOffsets[len(clockOffsets)/2] * 1e9)
+	for _, samples := range [][]imuSample{data.Accel, data.Gyro} {
+		for i := range samples {
+			samples[i].Time = samples[i].Time.Add(clock).UTC()
+		}
+	}
+	return data, nil
+}
+
+// gpmfStream — поток STRM одного сэмпла: трёхосные измерения с применённым SCAL и время GPSU, если есть
+type gpmfStream struct {
+	Key    string
+	Values [][3]float64
+	GPSU   string
+}
+
+// gpmfStreams разбирает DEVC → STRM сэмпла телеметрии; нужны только ACCL, GYRO и GPSU
+func gpmfStreams(payload []byte) []gpmfStream {
+	var streams []gpmfStream
+	gpmfWalk(payload, func(key string, typ byte, structSize, repeat int, data []byte) {
+		if key != "DEVC" {
+			return
+		}
+		gpmfWalk(data, func(key string, typ byte, structSize, repeat int, data []byte) {
+			if key != "STRM" {
+				return
+			}
+			var st gpmfStream
+			scale := []float64{1}
+			gpmfWalk(data, func(key string, typ byte, structSize, repeat int, data []byte) {
+				switch key {
+				case "SCAL":
+					if vs := gpmfNumbers(typ, data); len(vs) > 0 {
+						scale = vs
+					}
+				case "GPSU":
+					st.GPSU = string(bytes.TrimRight(data[:min(len(data), 16)], "\x00"))
+				case "ACCL", "GYRO":
+					vs := gpmfNumbers(typ, data)
+					if repeat == 0 || len(vs) != 3*repeat {
+						return
+					}
+					st.Key = key
+					for i := 0; i < repeat; i++ {
+						var v [3]float64
+						for j := range v {
+							s := scale[min(j, len(scale)-1)]
+							if s == 0 {
+								s = 1
+							}
+							v[j] = vs[3*i+j] / s
+						}
+						st.Values = append(st.Values, v)
+					}
+				}
+			})
+			streams = append(streams, st)
+		})
+	})
+	return streams
+}
+
+// gpmfWalk обходит записи KLV одного уровня (обратная операция к gpmfKLV)
+func gpmfWalk(b []byte, visit func(key string, typ byte, structSize, repeat int, data []byte)) {
+	for len(b) >= 8 {
+		key, typ, structSize, repeat := string(b[:4]), b[4], int(b[5]), int(binary.BigEndian.Uint16(b[6:8]))
+		n := structSize * repeat
+		if 8+n > len(b) {
+			return
+		}
+		visit(key, typ, structSize, repeat, b[8:8+n])
+		b = b[min(len(b), 8+(n+3)&^3):]
+	}
+}
+
+// gpmfNumbers читает числа простого типа GPMF (big-endian); для остальных типов — nil
+func gpmfNumbers(typ byte, data []byte) []float64 {
+	var size int
+	switch typ {
+	case 'b', 'B':
+		size = 1
+	case 's', 'S':
+		size = 2
+	case 'l', 'L', 'f':
+		size = 4
+	case 'd', 'j', 'J':
+		size = 8
+	default:
+		return nil
+	}
+	vs := make([]float64, len(data)/size)
+	for i := range vs {
+		b := data[i*size:]
+		switch typ {
+		case 'b':
+			vs[i] = float64(int8(b[0]))
+		case 'B':
+			vs[i] = float64(b[0])
+		case 's':
+			vs[i] = float64(int16(binary.BigEndian.Uint16(b)))
+		case 'S':
+			vs[i] = float64(binary.BigEndian.Uint16(b))
+		case 'l':
+			vs[i] = float64(int32(binary.BigEndian.Uint32(b)))
+		case 'L':
+			vs[i] = float64(binary.BigEndian.Uint32(b))
+		case 'f':
+			vs[i] = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+		case 'd':
+			vs[i] = math.Float64frombits(binary.BigEndian.Uint64(b))
+		case 'j':
+			vs[i] = float64(int64(binary.BigEndian.Uint64(b)))
+		case 'J':
+			vs[i] = float64(binary.BigEndian.Uint64(b))
+		}
+	}
+	return vs
+}
+
+// mp4Children — содержимое (без заголовков) дочерних боксов типа typ среди боксов b
+func mp4Children(b []byte, typ string) [][]byte {
+	var out [][]byte
+	for len(b) >= 8 {
+		size, header := int(binary.BigEndian.Uint32(b)), 8
+		if size == 1 && len(b) >= 16 {
+			size, header = int(binary.BigEndian.Uint64(b[8:])), 16
+		} else if size == 0 {
+			size = len(b)
+		}
+		if size < header || size > len(b) {
+			break
+		}
+		if string(b[4:8]) == typ {
+			out = append(out, b[header:size])
+		}
+		b = b[size:]
+	}
+	return out
+}
+
+// mp4Child — содержимое первого дочернего бокса typ, nil — нет такого
+func mp4Child(b []byte, typ string) []byte {
+	if c := mp4Children(b, typ); len(c) > 0 {
+		return c[0]
+	}
+	return nil
+}
+
+// mp4SampleTable разворачивает stbl дорожки в смещения, размеры, начала и длительности сэмплов
+// (время — в единицах timescale дорожки). Сэмплы, выходящие за fileSize, — ошибка: таблицы из битого файла
+// не должны заставлять выделять гигабайты
+func mp4SampleTable(stbl []byte, fileSize int64) (offsets []uint64, sizes []uint32, starts, durations []uint64, err error) {
+	stsz, stsc, stts := mp4Child(stbl, "stsz"), mp4Child(stbl, "stsc"), mp4Child(stbl, "stts")
+	if len(stsz) < 12 || len(stsc) < 8 || len(stts) < 8 {
+		return nil, nil, nil, nil, errors.New("incomplete telemetry sample table")
+	}
+	n := int(binary.BigEndian.Uint32(stsz[8:]))
+	if fixed := binary.BigEndian.Uint32(stsz[4:]); fixed != 0 {
+		if uint64(n)*uint64(fixed) > uint64(fileSize) {
+			return nil, nil, nil, nil, errors.New("telemetry sample sizes exceed the file size")
+		}
+		for range n {
+			sizes = append(sizes, fixed)
+		}
+	} else {
+		for i := 0; i < n && 12+4*i+4 <= len(stsz); i++ {
+			size := binary.BigEndian.Uint32(stsz[12+4*i:])
+			if int64(size) > fileSize {
+				return nil, nil, nil, nil, errors.New("telemetry sample sizes exceed the file size")
+			}
+			sizes = append(sizes, size)
+		}
+	}
+
+	var chunks []uint64
+	if co := mp4Child(stbl, "stco"); len(co) >= 8 {
+		for i := 0; i < int(binary.BigEndian.Uint32(co[4:])) && 8+4*i+4 <= len(co); i++ {
+			chunks = append(chunks, uint64(binary.BigEndian.Uint32(co[8+4*i:])))
+		}
+	} else if co := mp4Child(stbl, "co64"); len(co) >= 8 {
+		for i := 0; i < int(binary.BigEndian.Uint32(co[4:])) && 8+8*i+8 <= len(co); i++ {
+			chunks = append(chunks, binary.BigEndian.Uint64(co[8+8*i:]))
+		}
+	}
+
+	// stsc: с чанка first (с 1) в каждом чанке по perChunk сэмплов, до следующей записи
+	entries := int(binary.BigEndian.Uint32(stsc[4:]))
+	sample := 0
+	for e := 0; e < entries && 8+12*e+12 <= len(stsc); e++ {
+		first := int(binary.BigEndian.Uint32(stsc[8+12*e:]))
+		perChunk := int(binary.BigEndian.Uint32(stsc[12+12*e:]))
+		if first < 1 {
+			return nil, nil, nil, nil, errors.New("invalid telemetry stsc entry: chunks are numbered from 1")
+		}
+		last := len(chunks)
+		if e+1 < entries && 8+12*(e+1)+4 <= len(stsc) {
+			last = int(binary.BigEndian.Uint32(stsc[8+12*(e+1):])) - 1
+		}
+		for c := first - 1; c < last && c < len(chunks); c++ {
+			pos := chunks[c]
+			for k := 0; k < perChunk && sample < len(sizes); k++ {
+				offsets = append(offsets, pos)
+				pos += uint64(sizes[sample])
+				sample++
+			}
+		}
+	}
+
+	var t uint64
+	for e := 0; e < int(binary.BigEndian.Uint32(stts[4:])) && 8+8*e+8 <= len(stts); e++ {
+		count, delta := int(binary.BigEndian.Uint32(stts[8+8*e:])), uint64(binary.BigEndian.Uint32(stts[12+8*e:]))
+		for k := 0; k < count && len(starts) < len(sizes); k++ {
+			starts = append(starts, t)
+			durations = append(durations, delta)
+			t += delta
+		}
+	}
+	n = min(len(offsets), len(sizes), len(starts))
+	if n == 0 {
+		return nil, nil, nil, nil, errors.New("telemetry track has no samples")
+	}
+	for i := range n {
+		if offsets[i]+uint64(sizes[i]) > uint64(fileSize) {
+			return nil, nil, nil, nil, fmt.Errorf("telemetry sample %d lies outside the file", i+1)
+		}
+	}
+	return offsets[:n], sizes[:n], starts[:n], durations[:n], nil
+}
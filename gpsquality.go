@@ -0,0 +1,91 @@
+package main
+
+import (
+	"image/color"
+
+	"github.com/fogleman/gg"
+)
+
+// --- GPS Quality ---
+
+const (
+	gpsQualityUnknown = iota // приёмник не пишет ни HDOP, ни спутники, ни точность
+	gpsQualityPoor
+	gpsQualityFair
+	gpsQualityGood
+)
+
+// пороги качества: значение не хуже первого — хорошо, не хуже второго — терпимо
+const (
+	gpsGoodHDOP       = 2.0
+	gpsFairHDOP       = 5.0
+	gpsGoodSatellites = 7
+	gpsFairSatellites = 5
+	gpsGoodAccuracy   = 5.0  // м
+	gpsFairAccuracy   = 15.0 // м
+)
+
+// gpsQuality оценивает точность по худшему из известных показателей точки
+func gpsQuality(p Point) int {
+	quality := gpsQualityUnknown
+	rate := func(q int) {
+		if quality == gpsQualityUnknown || q < quality {
+			quality = q
+		}
+	}
+	if p.HDOP > 0 {
+		rate(rateLower(p.HDOP, gpsGoodHDOP, gpsFairHDOP))
+	}
+	if p.Satellites > 0 {
+		rate(rateLower(float64(-p.Satellites), -gpsGoodSatellites, -gpsFairSatellites))
+	}
+	if p.GPSAccuracy > 0 {
+		rate(rateLower(p.GPSAccuracy, gpsGoodAccuracy, gpsFairAccuracy))
+	}
+	return quality
+}
+
+// rateLower — оценка величины, у которой меньше значит лучше
+func rateLower(v, good, fair float64) int {
+	switch {
+	case v <= good:
+		return gpsQualityGood
+	case v <= fair:
+		return gpsQualityFair
+	}
+	return gpsQualityPoor
+}
+
+// hasGPSQuality — есть ли в треке хоть какие-то данные о точности
+func hasGPSQuality(points []Point) bool {
+	for _, p := range points {
+		if gpsQuality(p) != gpsQualityUnknown {
+			return true
+		}
+	}
+	return false
+}
+
+// drawGPSQualityIcon рисует три столбика уровня сигнала: закрашены по качеству,
+// цвет от зелёного к красному; при неизвестном качестве не рисует ничего
+func drawGPSQualityIcon(dc *gg.Context, x, y, size float64, quality int) {
+	if quality == gpsQualityUnknown {
+		return
+	}
+	fill := map[int]color.Color{
+		gpsQualityPoor: color.RGBA{220, 40, 30, 255},
+		gpsQualityFair: color.RGBA{230, 170, 0, 255},
+		gpsQualityGood: color.RGBA{40, 180, 60, 255},
+	}[quality]
+	barWidth := size / 4
+	for i := 0; i < 3; i++ {
+		h := size * float64(i+1) / 3
+		dc.DrawRoundedRectangle(x+float64(i)*barWidth*4/3, y+size/2-h, barWidth, h, barWidth/4)
+		if i < quality {
+			dc.SetColor(fill)
+		} else {
+			dc.SetColor(color.RGBA{80, 80, 80, 160})
+		}
+		dc.Fill()
+	}
+}
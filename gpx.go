@@ -1,38 +1,120 @@
 package main
 
 import (
+	"bufio"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/tkrajina/gpxgo/gpx"
 )
 
 // --- Structs ---
 
 type Point struct {
 	Lat, Lon, Ele, Speed, Slope, Distance, SmoothedSlope, AvgSpeed, MapScale, ResidualMapScale, Bearing float64
+
+	RouteDeviation                        float64 // расстояние до запланированного маршрута, м
+	Temperature, WindSpeed, WindDirection float64 // погода; WindDirection — откуда дует, радианы
+
+	FrontGear, RearGear       int // число зубьев, 0 — нет данных
+	FrontGearNum, RearGearNum int // номер передачи
+
+	RunNumber          int     // горные лыжи: номер текущего (или последнего) спуска
+	OnLift             bool    // горные лыжи: подъём на подъёмнике
+	RunDrop, TotalDrop float64 // горные лыжи: перепад текущего спуска и суммарный, м
+
+	Ascent, Descent float64 // набор и сброс высоты с начала трека, м
+	MovingTime      float64 // время в движении с начала трека (без стоянок), с
+	VAM             float64 // скорость набора высоты за последнюю минуту, м/ч
+	Calories        float64 // оценка затраченной с начала трека энергии, ккал
+
+	MaxSpeed, SessionAvgSpeed float64 // км/ч с начала показываемого фрагмента: максимум и средняя в движении
+
+	PanEast, PanNorth float64 // временный сдвиг центра карты из файла корректировок, м
+	MapRotation       float64 // поворот карты по часовой стрелке, радианы
+
+	HeartRate, Power       float64 // уд/мин и Вт с датчиков, 0 — нет данных
+	AvgHeartRate, AvgPower float64 // скользящие средние за -hr-window и -power-window
+	NormalizedPower        float64 // NP с начала показываемого фрагмента, Вт
+	IntensityFactor, TSS   float64 // по -ftp, накопленные с начала показываемого фрагмента
+	WPrimeBalance          float64 // остаток анаэробного запаса W′bal, Дж
+
+	Cadence             float64 // об/мин, шаг/мин или гребков/мин в зависимости от активности
+	StepLength          float64 // м
+	GroundContactTime   float64 // мс
+	VerticalOscillation float64 // см
+
+	Lap int // номер круга по lap-сообщениям FIT или <Lap> в TCX, с 1; 0 — нет данных
+
+	HDOP        float64 // горизонтальный геометрический фактор из GPX, 0 — нет данных
+	Satellites  int     // число спутников из GPX
+	GPSAccuracy float64 // оценка точности из FIT, м
+
+	ProjectedFinish float64 // прогноз времени финиша (-eta), Unix-время в секундах; 0 — прогноза ещё нет
+
+	NoFix            bool    // в записи нет координат (тренажёр); такие точки нужны только -virtual-route
+	RecordedDistance float64 // км по датчику скорости/колеса, 0 — нет данных
+	RecordedSpeed    float64 // км/ч по датчику
+	FileStart        bool    // первая точка следующего файла из -gpx: окно скорости не переходит через стык
+
 	Timestamp      time.Time
 	TileZoom       int
 }
 
 type Track struct {
-	Points         []Point
+	PathPoints     []int // индексы SmoothedPoints прореженной геометрии для отрисовки пройденного пути
+	PathProjection *pathProjection // PathPoints в координатах тайлов по зумам
 	SmoothedPoints []Point
+	Route          []Point // запланированный маршрут (<rte>), может быть пустым
+	Climbs         []Climb
+	Motion         *motionSeries // крен и перегрузки для -motorsport
+	Timeline       Timeline // hold/skip/speedup из файла корректировок
+	TileScales     map[float64]struct{} // масштабы scale из файла корректировок, под них готовятся тайлы
+	Waypoints      []Waypoint
+	MapMasks       []MapMask // области карты под размытием или заливкой
+	Events         []TrackEvent
+	SpeedReadout   *Readout // nil — скорость показывается без задержки
+	SlopeReadout   *Readout
+	HRReadout      *Readout // средний пульс -heart-rate
+	Checkpoints    []Checkpoint // по возрастанию дистанции
+	Places         []Place      // названия мест вдоль трека (-geocode)
+	WaypointPasses []WaypointPass
+	Annotations    []Annotation // надписи -annotations по порядку появления
+	ElevationProfile []Point // профиль всего трека для -elevation-profile, только Distance и Ele
+	Photos         []Photo // снимки -photos по времени съёмки
+	Chapters       []Chapter // главы MP4 (-chapters)
+	Summary        RideSummary // итоги для карточки -end-card
+	Splits         []Split     // отрезки -splits показываемого фрагмента
 	TotalDistance  float64
 	RenderFromIndex int
 	RenderToIndex   int
 }
 
+type Waypoint struct {
+	Name     string
+	Lat, Lon float64
+	Ele      float64 // м, 0 — высота не указана
+}
+
 type TrackAdjustmentSpec struct {
 	Line      int
 	PointSpec string
-	Scale     float64
+	EndSpec   string  // конец диапазона для skip и speedup ("10km..12km")
+	Scale     float64 // 0 — строка не меняет масштаб
 	Duration  *time.Duration
+	Hold      time.Duration
+	Skip      bool
+	Speedup   float64
+	Pan       *[2]float64 // сдвиг карты на восток и на север, м
+	Rotate    *float64    // поворот карты, градусы
+	Overview  bool        // обзорный отъезд камеры, показывающий весь трек
 }
 
 type ScaleChange struct {
@@ -61,24 +143,253 @@ func smoothGpxPoints(points []Point) {
 	}
 }
 
+// decimatePath прореживает геометрию для отрисовки пройденного пути: точки ближе
+// pathDecimationSpacing к предыдущей оставленной отбрасываются. На записях 10 Гц
+// это в разы сокращает число отрезков без видимой разницы на карте. Возвращает индексы оставленных
+// точек: копии Point на многодневных треках занимали бы столько же памяти, сколько сам трек
+func decimatePath(points []Point) []int {
+	out := []int{0}
+	for i := 1; i < len(points)-1; i++ {
+		if haversine(points[out[len(out)-1]], points[i])*1000 >= pathDecimationSpacing {
+			out = append(out, i)
+		}
+	}
+	if len(points) > 1 {
+		out = append(out, len(points)-1)
+	}
+	return out
+}
+
+// --- GPX Reading ---
+
+// gpxPoint — элемент <trkpt>, <wpt> или <rtept>. Числа и время разбираются вручную, как это
+// делал gpxgo: нечитаемое значение считается отсутствующим, а не ошибкой файла
+type gpxPoint struct {
+	Lat        float64 `xml:"lat,attr"`
+	Lon        float64 `xml:"lon,attr"`
+	Ele        string  `xml:"ele"`
+	Time       string  `xml:"time"`
+	Name       string  `xml:"name"`
+	HDOP       string  `xml:"hdop"`
+	Satellites string  `xml:"sat"`
+	Extensions struct {
+		Nodes []gpxExtensionNode `xml:",any"`
+	} `xml:"extensions"`
+}
+
+type gpxExtensionNode struct {
+	XMLName xml.Name
+	Data    string             `xml:",chardata"`
+	Nodes   []gpxExtensionNode `xml:",any"`
+}
+
+// gpxTimeLayouts — форматы <time>, которые понимал gpxgo; доли секунды отбрасываются до разбора
+var gpxTimeLayouts = []string{
+	"2006-01-02T15:04:05.000Z",
+	"2006-01-02T15:04:05Z",
+	"2006-01-02T15:04:05+00:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z",
+	"2006-01-02 15:04:05",
+}
+
+func parseGpxTime(s string) (time.Time, bool) {
+	s, _, _ = strings.Cut(s, ".")
+	s = strings.TrimSpace(s)
+	for _, layout := range gpxTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseGpxFloat(s string) (float64, bool) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return v, err == nil
+}
+
+// scanGpx читает GPX потоком и отдаёт visit каждую точку с именем её элемента (trkpt, wpt, rtept)
+// в порядке файла. Документ целиком в памяти не строится: многодневный трек занимает только
+// собранные из него точки
+func scanGpx(filePath string, visit func(element string, p gpxPoint)) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	decoder := xml.NewDecoder(bufio.NewReader(f))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "trkpt", "wpt", "rtept":
+			var p gpxPoint
+			if err := decoder.DecodeElement(&p, &start); err != nil {
+				return err
+			}
+			visit(start.Name.Local, p)
+		}
+	}
+}
+
 func parseGpx(filePath string) ([]Point, error) {
-	gpxFile, err := gpx.ParseFile(filePath)
+	var points []Point
+	err := scanGpx(filePath, func(element string, p gpxPoint) {
+		if element != "trkpt" {
+			return
+		}
+		point := Point{Lat: p.Lat, Lon: p.Lon}
+		point.Ele, _ = parseGpxFloat(p.Ele)
+		point.Timestamp, _ = parseGpxTime(p.Time)
+		point.HDOP, _ = parseGpxFloat(p.HDOP)
+		if sats, ok := parseGpxFloat(p.Satellites); ok {
+			point.Satellites = int(sats)
+		}
+		readGpxSensorExtensions(&point, p.Extensions.Nodes)
+		points = append(points, point)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse GPX file: %w", err)
 	}
+	return points, nil
+}
 
-	var points []Point
-	for _, track := range gpxFile.Tracks {
-		for _, segment := range track.Segments {
-			for _, p := range segment.Points {
-				var ele float64
-				if p.Elevation.NotNull() {
-					ele = p.Elevation.Value()
-				}
-				points = append(points, Point{Lat: p.Latitude, Lon: p.Longitude, Ele: ele, Timestamp: p.Timestamp})
+// readGpxSensorExtensions достаёт пульс, мощность, скорость и дистанцию из расширений точки
+// (gpxtpx:TrackPointExtension/gpxtpx:hr, <power> и их варианты от разных устройств)
+func readGpxSensorExtensions(p *Point, nodes []gpxExtensionNode) {
+	for _, n := range nodes {
+		v, err := strconv.ParseFloat(strings.TrimSpace(n.Data), 64)
+		switch {
+		case len(n.Nodes) > 0:
+			readGpxSensorExtensions(p, n.Nodes)
+		case err != nil:
+		case strings.EqualFold(n.XMLName.Local, "hr") || strings.EqualFold(n.XMLName.Local, "heartrate"):
+			p.HeartRate = v
+		case strings.EqualFold(n.XMLName.Local, "power") || strings.EqualFold(n.XMLName.Local, "watts"):
+			p.Power = v
+		case strings.EqualFold(n.XMLName.Local, "speed"): // м/с
+			p.RecordedSpeed = v * 3.6
+		case strings.EqualFold(n.XMLName.Local, "distance"): // м
+			p.RecordedDistance = v / 1000
+		}
+	}
+}
+
+// trackFilePaths раскрывает -gpx: один файл или список через запятую, элементы которого
+// могут быть шаблонами ("ride_*.gpx")
+func trackFilePaths(spec string) ([]string, error) {
+	var files []string
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if !strings.ContainsAny(item, "*?[") {
+			files = append(files, item)
+			continue
+		}
+		matches, err := filepath.Glob(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", item, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %s", item)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// readTrackFile выбирает парсер по расширению файла
+func readTrackFile(filePath string) ([]Point, error) {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".fit":
+		return parseFit(filePath)
+	case ".tcx":
+		return parseTcx(filePath)
+	case ".kml", ".kmz":
+		return parseKml(filePath)
+	}
+	return parseGpx(filePath)
+}
+
+// parseTrackFiles читает файлы трека, склеивает их и доводит точки до общего вида.
+// С virtualRoute точки расставляются по нему на записанной дистанции (тренажёрные поездки).
+func parseTrackFiles(files []string, virtualRoute []Point) ([]Point, error) {
+	parts := make([][]Point, 0, len(files))
+	for _, f := range files {
+		points, err := readTrackFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		if len(points) > 0 {
+			parts = append(parts, points)
+		}
+	}
+	points := mergeTrackParts(parts)
+	if virtualRoute != nil {
+		if err := replayOnRoute(points, virtualRoute); err != nil {
+			return nil, err
+		}
+	} else {
+		points = dropNoFix(points)
+	}
+
+	fillMissingElevation(points)
+	smoothGpxPoints(points)
+
+	return points, nil
+}
+
+// mergeTrackParts склеивает треки из нескольких файлов одной поездки по времени их начала.
+// Точки, которые повторяют уже записанное время (файлы перекрываются), отбрасываются;
+// дистанция датчика и номера кругов продолжаются, если в следующем файле начинаются заново.
+func mergeTrackParts(parts [][]Point) []Point {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	sort.SliceStable(parts, func(i, j int) bool { return parts[i][0].Timestamp.Before(parts[j][0].Timestamp) })
+	var merged []Point
+	for _, part := range parts {
+		var distOffset float64
+		lapOffset := 0
+		if n := len(merged); n > 0 {
+			last := merged[n-1]
+			if part[0].RecordedDistance < last.RecordedDistance {
+				distOffset = last.RecordedDistance
+			}
+			if part[0].Lap > 0 && part[0].Lap <= last.Lap {
+				lapOffset = last.Lap
 			}
 		}
+		fileStart := len(merged) > 0
+		for _, p := range part {
+			if n := len(merged); n > 0 && !p.Timestamp.After(merged[n-1].Timestamp) {
+				continue
+			}
+			if p.RecordedDistance > 0 {
+				p.RecordedDistance += distOffset
+			}
+			if p.Lap > 0 {
+				p.Lap += lapOffset
+			}
+			p.FileStart = fileStart
+			fileStart = false
+			merged = append(merged, p)
+		}
 	}
+	return merged
+}
+
+// fillMissingElevation заполняет нулевые высоты ближайшим известным значением
+func fillMissingElevation(points []Point) {
 	var firstEle float64
 	firstEleIdx := -1
 	for i, p := range points {
@@ -106,12 +417,137 @@ func parseGpx(filePath string) ([]Point, error) {
 			points[i].Ele = lastEle
 		}
 	}
+}
 
-	smoothGpxPoints(points)
+// parseGpxWaypoints читает именованные точки (<wpt>) GPX-файла
+func parseGpxWaypoints(filePath string) ([]Waypoint, error) {
+	var waypoints []Waypoint
+	err := scanGpx(filePath, func(element string, p gpxPoint) {
+		if element == "wpt" {
+			ele, _ := parseGpxFloat(p.Ele)
+			waypoints = append(waypoints, Waypoint{Name: p.Name, Lat: p.Lat, Lon: p.Lon, Ele: ele})
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GPX file: %w", err)
+	}
+	return waypoints, nil
+}
 
+// parseGpxRoute читает запланированный маршрут из элементов <rte> GPX-файла
+func parseGpxRoute(filePath string) ([]Point, error) {
+	var points []Point
+	err := scanGpx(filePath, func(element string, p gpxPoint) {
+		if element == "rtept" {
+			ele, _ := parseGpxFloat(p.Ele)
+			points = append(points, Point{Lat: p.Lat, Lon: p.Lon, Ele: ele})
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse route GPX file: %w", err)
+	}
+	if len(points) < 2 {
+		return nil, fmt.Errorf("no route (<rte>) with at least 2 points found in %s", filePath)
+	}
 	return points, nil
 }
 
+// computeRouteDeviations проставляет каждой точке трека расстояние (в метрах) до ближайшего отрезка маршрута
+func computeRouteDeviations(points []Point, route []Point) {
+	if len(route) < 2 {
+		return
+	}
+	for i := range points {
+		points[i].RouteDeviation = distanceToPolyline(points[i], route)
+	}
+}
+
+// distanceToPolyline returns the distance in meters from p to the closest segment of line,
+// using a local equirectangular projection centered on p.
+func distanceToPolyline(p Point, line []Point) float64 {
+	const R = 6371000.0
+	kx := math.Cos(p.Lat*math.Pi/180) * R * math.Pi / 180
+	ky := R * math.Pi / 180
+
+	best := math.Inf(1)
+	for i := 1; i < len(line); i++ {
+		ax := (line[i-1].Lon - p.Lon) * kx
+		ay := (line[i-1].Lat - p.Lat) * ky
+		bx := (line[i].Lon - p.Lon) * kx
+		by := (line[i].Lat - p.Lat) * ky
+
+		dx, dy := bx-ax, by-ay
+		t := 0.0
+		if segLen2 := dx*dx + dy*dy; segLen2 > 0 {
+			t = -(ax*dx + ay*dy) / segLen2
+			t = math.Max(0, math.Min(1, t))
+		}
+		cx, cy := ax+t*dx, ay+t*dy
+		if d := math.Hypot(cx, cy); d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// smoothSwimPositions выкидывает точки с нереальной для пловца скоростью и сглаживает
+// координаты скользящим средним по времени: GPS на запястье в воде очень шумный
+func smoothSwimPositions(points []Point) []Point {
+	if len(points) < 3 {
+		return points
+	}
+
+	filtered := []Point{points[0]}
+	for _, p := range points[1:] {
+		prev := filtered[len(filtered)-1]
+		dt := p.Timestamp.Sub(prev.Timestamp).Hours()
+		if dt <= 0 || haversine(prev, p)/dt > swimMaxSpeedKmh {
+			continue
+		}
+		filtered = append(filtered, p)
+	}
+
+	smoothed := make([]Point, len(filtered))
+	copy(smoothed, filtered)
+	left, right := 0, 0
+	var sumLat, sumLon float64
+	for i := range filtered {
+		for right < len(filtered) && !filtered[right].Timestamp.After(filtered[i].Timestamp.Add(swimSmoothingWindow)) {
+			sumLat += filtered[right].Lat
+			sumLon += filtered[right].Lon
+			right++
+		}
+		for filtered[left].Timestamp.Before(filtered[i].Timestamp.Add(-swimSmoothingWindow)) {
+			sumLat -= filtered[left].Lat
+			sumLon -= filtered[left].Lon
+			left++
+		}
+		n := float64(right - left)
+		smoothed[i].Lat = sumLat / n
+		smoothed[i].Lon = sumLon / n
+	}
+	return smoothed
+}
+
+// smoothedElevation возвращает высоты, усреднённые по окну ±window вокруг каждой точки
+func smoothedElevation(points []Point, window time.Duration) []float64 {
+	result := make([]float64, len(points))
+	left, right := 0, 0
+	var sum float64
+	for i := range points {
+		for right < len(points) && !points[right].Timestamp.After(points[i].Timestamp.Add(window)) {
+			sum += points[right].Ele
+			right++
+		}
+		for points[left].Timestamp.Before(points[i].Timestamp.Add(-window)) {
+			sum -= points[left].Ele
+			left++
+		}
+		result[i] = sum / float64(right-left)
+	}
+	return result
+}
+
 func parseTrackAdjustmentFile(filePath string) ([]TrackAdjustmentSpec, error) {
 	if filePath == "" {
 		return nil, nil
@@ -141,10 +577,41 @@ func parseTrackAdjustmentFile(filePath string) ([]TrackAdjustmentSpec, error) {
 		}
 
 		spec := TrackAdjustmentSpec{Line: i + 1, PointSpec: parts[0]}
+		if from, to, ok := strings.Cut(parts[0], ".."); ok {
+			spec.PointSpec, spec.EndSpec = from, to
+		}
 		var scaleFound bool
 
 		for _, part := range parts[1:] {
-			if strings.HasPrefix(part, "scale=") {
+			if part == "overview" {
+				spec.Overview = true
+			} else if part == "skip" {
+				spec.Skip = true
+			} else if strings.HasPrefix(part, "speedup=") {
+				speedup, err := strconv.ParseFloat(strings.TrimPrefix(part, "speedup="), 64)
+				if err != nil || speedup <= 0 {
+					return nil, fmt.Errorf("invalid speedup value on line %d: %s", i+1, line)
+				}
+				spec.Speedup = speedup
+			} else if strings.HasPrefix(part, "hold=") {
+				hold, err := time.ParseDuration(strings.TrimPrefix(part, "hold="))
+				if err != nil || hold <= 0 {
+					return nil, fmt.Errorf("invalid hold value on line %d: %s", i+1, line)
+				}
+				spec.Hold = hold
+			} else if strings.HasPrefix(part, "pan=") {
+				var pan [2]float64
+				if _, err := fmt.Sscanf(strings.TrimPrefix(part, "pan="), "%g,%g", &pan[0], &pan[1]); err != nil {
+					return nil, fmt.Errorf("invalid pan value on line %d: %s", i+1, line)
+				}
+				spec.Pan = &pan
+			} else if strings.HasPrefix(part, "rotate=") {
+				rotate, err := strconv.ParseFloat(strings.TrimPrefix(part, "rotate="), 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid rotate value on line %d: %s", i+1, line)
+				}
+				spec.Rotate = &rotate
+			} else if strings.HasPrefix(part, "scale=") {
 				scaleStr := strings.TrimPrefix(part, "scale=")
 				scale, err := strconv.ParseFloat(scaleStr, 64)
 				if err != nil {
@@ -165,8 +632,20 @@ func parseTrackAdjustmentFile(filePath string) ([]TrackAdjustmentSpec, error) {
 			}
 		}
 
-		if !scaleFound {
-			return nil, fmt.Errorf("scale parameter not found on line %d: %s", i+1, line)
+		isRange := spec.EndSpec != ""
+		timing := spec.Skip || spec.Speedup != 0
+		view := spec.Pan != nil || spec.Rotate != nil
+		if (timing || view) && !isRange {
+			return nil, fmt.Errorf("skip, speedup, pan and rotate need a point range (from..to) on line %d: %s", i+1, line)
+		}
+		if isRange && (scaleFound || spec.Hold != 0 || spec.Overview || (spec.Skip && spec.Speedup != 0) || !(timing || view)) {
+			return nil, fmt.Errorf("a point range takes skip or speedup and/or pan, rotate on line %d: %s", i+1, line)
+		}
+		if scaleFound && spec.Overview {
+			return nil, fmt.Errorf("overview sets its own scale on line %d: %s", i+1, line)
+		}
+		if !scaleFound && spec.Hold == 0 && !spec.Overview && !isRange {
+			return nil, fmt.Errorf("scale, hold, overview, skip or speedup parameter not found on line %d: %s", i+1, line)
 		}
 
 		specs = append(specs, spec)
@@ -181,72 +660,164 @@ func parseTrackAdjustmentFile(filePath string) ([]TrackAdjustmentSpec, error) {
 	return specs, nil
 }
 
-func applyTrackAdjustments(points []Point, specs []TrackAdjustmentSpec) ([]float64, error) {
+// pointSpecResolver находит точку по спецификации из файла корректировок: "0", "17.5km", "500s",
+// а также "+2km" и "+30s" относительно предыдущей найденной точки.
+type pointSpecResolver struct {
+	points       []Point
+	lastDistance float64
+	lastIndex    int
+}
+
+func newPointSpecResolver(points []Point) *pointSpecResolver {
+	return &pointSpecResolver{points: points}
+}
+
+// resolve возвращает индекс точки или -1, если трек кончился раньше
+func (r *pointSpecResolver) resolve(spec string) (int, error) {
+	points := r.points
+	pointIndex := -1
+
+	if spec == "0" {
+		pointIndex = 0
+	} else if strings.HasSuffix(spec, "km") {
+		valStr := strings.TrimSuffix(strings.TrimPrefix(spec, "+"), "km")
+		dist, err := strconv.ParseFloat(valStr, 64)
+		if err != nil {
+			return -1, fmt.Errorf("invalid distance spec: %s", spec)
+		}
+
+		if strings.HasPrefix(spec, "+") {
+			dist += r.lastDistance
+		}
+		r.lastDistance = dist
+
+		for i, p := range points {
+			if p.Distance >= dist {
+				pointIndex = i
+				break
+			}
+		}
+	} else if strings.HasSuffix(spec, "s") {
+		valStr := strings.TrimSuffix(strings.TrimPrefix(spec, "+"), "s")
+		timeOffset, err := strconv.ParseFloat(valStr, 64)
+		if err != nil {
+			return -1, fmt.Errorf("invalid time spec: %s", spec)
+		}
+
+		var targetTime time.Time
+
+		if strings.HasPrefix(spec, "+") {
+			// время указано относительно предыдущей метки
+			prevPt := points[r.lastIndex]
+			targetTime = prevPt.Timestamp.Add(time.Duration(timeOffset * float64(time.Second)))
+		} else {
+			targetTime = points[0].Timestamp.Add(time.Duration(timeOffset * float64(time.Second)))
+		}
+
+		for i, p := range points {
+			if !p.Timestamp.Before(targetTime) {
+				pointIndex = i
+				break
+			}
+		}
+	}
+
+	if pointIndex != -1 {
+		r.lastIndex = pointIndex
+	}
+	return pointIndex, nil
+}
+
+// addOverviewScaleChanges вставляет для каждой точки из at обзорный отъезд: за overviewTransition
+// карта отдаляется так, чтобы был виден весь трек, держится overviewHold и возвращается
+// к масштабу, действовавшему до отъезда. Отъезды, пересекающиеся с ручными сменами масштаба, пропускаются.
+func addOverviewScaleChanges(scaleChanges []ScaleChange, at []int, points []Point, args *Arguments) []ScaleChange {
+	if len(at) == 0 {
+		return scaleChanges
+	}
+	sort.SliceStable(scaleChanges, func(i, j int) bool { return scaleChanges[i].PointIndex < scaleChanges[j].PointIndex })
+	manual := scaleChanges
+	result := append([]ScaleChange(nil), scaleChanges...)
+	widgetRadiusPx := float64(args.WidgetSize) / args.UIScale / 2 // в пикселях карты
+
+	for _, idx := range at {
+		endIdx := indexAtTime(points, points[idx].Timestamp.Add(overviewTransition+overviewHold))
+		if endIdx >= len(points) {
+			continue
+		}
+		prevScale := 1.0
+		overlaps := false
+		for _, c := range manual {
+			if c.PointIndex <= idx {
+				prevScale = c.TargetScale
+			} else if c.PointIndex <= endIdx {
+				overlaps = true
+			}
+		}
+		if overlaps {
+			continue
+		}
+
+		// масштаб, при котором самая дальняя точка трека попадает в виджет
+		cx, cy := deg2num(points[idx].Lat, points[idx].Lon, args.MapZoom)
+		maxDistPx := 0.0
+		for _, p := range points {
+			x, y := deg2num(p.Lat, p.Lon, args.MapZoom)
+			maxDistPx = math.Max(maxDistPx, math.Hypot(x-cx, y-cy)*float64(args.TileSize))
+		}
+		overviewScale := maxDistPx / (widgetRadiusPx * 0.9) / points[idx].MapScale
+		if overviewScale <= prevScale {
+			continue
+		}
+
+		result = append(result,
+			ScaleChange{PointIndex: idx, TargetScale: overviewScale, TransitionDuration: overviewTransition},
+			ScaleChange{PointIndex: endIdx, TargetScale: prevScale, TransitionDuration: overviewTransition},
+		)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].PointIndex < result[j].PointIndex })
+	if len(result) > 0 && result[0].PointIndex != 0 {
+		result = append([]ScaleChange{{PointIndex: 0, TargetScale: 1.0}}, result...)
+	}
+	return result
+}
+
+func applyTrackAdjustments(points []Point, specs []TrackAdjustmentSpec, args *Arguments) ([]float64, error) {
 	scaleMultipliers := make([]float64, len(points))
 	for i := range scaleMultipliers {
 		scaleMultipliers[i] = 1.0
 	}
 
-	if len(specs) == 0 {
+	if len(specs) == 0 && args.OverviewEvery == 0 {
 		return scaleMultipliers, nil
 	}
 
 	// --- Resolve specs to point indices ---
 	scaleChanges := make([]ScaleChange, 0)
-	lastDistance := 0.0
-	startTime := points[0].Timestamp
+	var overviewIndices []int
+	resolver := newPointSpecResolver(points)
 
 	for _, spec := range specs {
-		var pointIndex int = -1
 		transitionDuration := 20 * time.Second
 		if spec.Duration != nil {
 			transitionDuration = *spec.Duration
 		}
 
-		if spec.PointSpec == "0" {
-			pointIndex = 0
-		} else if strings.HasSuffix(spec.PointSpec, "km") {
-			valStr := strings.TrimSuffix(strings.TrimPrefix(spec.PointSpec, "+"), "km")
-			dist, err := strconv.ParseFloat(valStr, 64)
-			if err != nil {
-				return nil, fmt.Errorf("invalid distance spec: %s", spec.PointSpec)
-			}
-
-			if strings.HasPrefix(spec.PointSpec, "+") {
-				dist += lastDistance
-			}
-			lastDistance = dist
-
-			for i, p := range points {
-				if p.Distance >= dist {
-					pointIndex = i
-					break
-				}
-			}
-		} else if strings.HasSuffix(spec.PointSpec, "s") {
-			valStr := strings.TrimSuffix(strings.TrimPrefix(spec.PointSpec, "+"), "s")
-			timeOffset, err := strconv.ParseFloat(valStr, 64)
-			if err != nil {
-				return nil, fmt.Errorf("invalid time spec: %s", spec.PointSpec)
-			}
-
-			var targetTime time.Time
-
-			if strings.HasPrefix(spec.PointSpec, "+") {
-				// время указано относительно предыдущей метки
-				prevPt := points[scaleChanges[len(scaleChanges)-1].PointIndex]
-				targetTime = prevPt.Timestamp.Add(time.Duration(timeOffset * float64(time.Second)))
-			} else {
-				targetTime = startTime.Add(time.Duration(timeOffset * float64(time.Second)))
-			}
-
-			for i, p := range points {
-				if !p.Timestamp.Before(targetTime) {
-					pointIndex = i
-					break
-				}
+		pointIndex, err := resolver.resolve(spec.PointSpec)
+		if err != nil {
+			return nil, err
+		}
+		if spec.EndSpec != "" {
+			if _, err := resolver.resolve(spec.EndSpec); err != nil {
+				return nil, err
 			}
 		}
+		if spec.Overview && pointIndex != -1 {
+			overviewIndices = append(overviewIndices, pointIndex)
+		}
+		if spec.Scale == 0 {
+			continue
+		}
 
 		if pointIndex != -1 {
 			scaleChanges = append(scaleChanges, ScaleChange{Line: spec.Line, PointIndex: pointIndex, TargetScale: spec.Scale, TransitionDuration: transitionDuration})
@@ -255,6 +826,14 @@ func applyTrackAdjustments(points []Point, specs []TrackAdjustmentSpec) ([]float
 		}
 	}
 
+	if args.OverviewEvery > 0 {
+		every := time.Duration(args.OverviewEvery * float64(time.Minute))
+		for t := points[0].Timestamp.Add(every); t.Before(points[len(points)-1].Timestamp); t = t.Add(every) {
+			overviewIndices = append(overviewIndices, indexAtTime(points, t))
+		}
+	}
+	scaleChanges = addOverviewScaleChanges(scaleChanges, overviewIndices, points, args)
+
 	// --- Apply scale changes to the multiplier slice ---
 	currentScale := 1.0
 	changeIdx := 0
@@ -311,16 +890,118 @@ func applyTrackAdjustments(points []Point, specs []TrackAdjustmentSpec) ([]float
 	return scaleMultipliers, nil
 }
 
+// applyViewAdjustments задаёт временные сдвиг и поворот карты для диапазонов точек.
+// Как и для масштаба, переход к целевому значению и обратно занимает duration (по умолчанию 20 с).
+func applyViewAdjustments(points []Point, specs []TrackAdjustmentSpec) error {
+	resolver := newPointSpecResolver(points)
+	for _, spec := range specs {
+		from, err := resolver.resolve(spec.PointSpec)
+		if err != nil {
+			return err
+		}
+		if spec.EndSpec == "" {
+			continue
+		}
+		to, err := resolver.resolve(spec.EndSpec)
+		if err != nil {
+			return err
+		}
+		if spec.Pan == nil && spec.Rotate == nil {
+			continue
+		}
+		if from == -1 {
+			log.Printf("Warning: could not find point for spec '%s'", spec.PointSpec)
+			continue
+		}
+		if to == -1 {
+			to = len(points) - 1
+		}
+
+		transitionDuration := 20 * time.Second
+		if spec.Duration != nil {
+			transitionDuration = *spec.Duration
+		}
+		var panEast, panNorth, rotation float64
+		if spec.Pan != nil {
+			panEast, panNorth = spec.Pan[0], spec.Pan[1]
+		}
+		if spec.Rotate != nil {
+			rotation = *spec.Rotate * math.Pi / 180
+		}
+
+		fromTime, toTime := points[from].Timestamp, points[to].Timestamp
+		// возврат начинается с того значения, до которого успели дойти на диапазоне
+		reached := math.Min(1, float64(toTime.Sub(fromTime))/float64(transitionDuration))
+		for j := from; j < len(points); j++ {
+			var progress float64
+			if j < to {
+				progress = math.Min(1, float64(points[j].Timestamp.Sub(fromTime))/float64(transitionDuration))
+			} else {
+				progress = reached * (1 - float64(points[j].Timestamp.Sub(toTime))/float64(transitionDuration))
+				if progress <= 0 {
+					break
+				}
+			}
+			points[j].PanEast += panEast * progress
+			points[j].PanNorth += panNorth * progress
+			points[j].MapRotation += rotation * progress
+		}
+	}
+	return nil
+}
+
+// lookAheadZoomMultipliers приближает карту перед крутыми поворотами и серпантинами:
+// чем сильнее суммарный поворот трека в ближайшие window, тем меньше множитель масштаба
+// (вплоть до lookAheadMinScale при развороте на 180° и больше).
+func lookAheadZoomMultipliers(points []Point, window time.Duration) []float64 {
+	// направления отрезков; слишком короткие отрезки (стоянка, шум) пропускаем
+	turns := make([]float64, len(points)) // поворот в точке i, радианы
+	lastBearing, lastIdx := math.NaN(), 0
+	for i := 1; i < len(points); i++ {
+		if haversine(points[lastIdx], points[i])*1000 < lookAheadMinSegment {
+			continue
+		}
+		b := bearing(points[lastIdx], points[i])
+		if !math.IsNaN(lastBearing) {
+			turns[i] = angleBetweenBearings(lastBearing, b)
+		}
+		lastBearing, lastIdx = b, i
+	}
+
+	raw := make([]float64, len(points))
+	j := 0
+	var turnSum float64
+	for i := range points {
+		for j < len(points) && points[j].Timestamp.Sub(points[i].Timestamp) <= window {
+			turnSum += turns[j]
+			j++
+		}
+		score := math.Min(1, turnSum/math.Pi)
+		raw[i] = 1 - score*(1-lookAheadMinScale)
+		turnSum -= turns[i]
+	}
+
+	// сглаживаем, чтобы зум менялся плавно
+	return smoothSeries(raw, smoothingWindow, samplesInDuration(points, window/2))
+}
+
+// preprocessGpxPoints считает производные метрики прямо в points, без копии трека: сырые точки
+// после этого не нужны, а на многодневных записях вторая копия удваивала бы память
 func preprocessGpxPoints(points []Point, args *Arguments) []Point {
 	if len(points) < 2 {
 		return points
 	}
-	smoothed := make([]Point, len(points))
-	copy(smoothed, points)
+	smoothed := points
 
-	for i := 1; i < len(smoothed); i++ {
-		if math.Abs(smoothed[i].Ele-smoothed[i-1].Ele) > slopeMaxEleChange {
-			smoothed[i].Ele = smoothed[i-1].Ele
+	filterElevationJumps(smoothed, args)
+	if args.EleSmoothing != smoothingWindow {
+		ele := make([]float64, len(smoothed))
+		for i := range smoothed {
+			ele[i] = smoothed[i].Ele
+		}
+		ele = smoothSeries(ele, args.EleSmoothing, samplesInDuration(smoothed, eleSmoothingSpan))
+		for i := range smoothed {
+			smoothed[i].Ele = ele[i]
 		}
 	}
 
@@ -336,6 +1017,18 @@ func preprocessGpxPoints(points []Point, args *Arguments) []Point {
 		if windowEnd >= len(smoothed) {
 			windowEnd = len(smoothed) - 1
 		}
+		// не смешиваем точки из разных файлов: между ними может быть пауза записи
+		for j := windowStart + 1; j <= i; j++ {
+			if smoothed[j].FileStart {
+				windowStart = j
+			}
+		}
+		for j := i + 1; j <= windowEnd; j++ {
+			if smoothed[j].FileStart {
+				windowEnd = j - 1
+				break
+			}
+		}
 
 		var totalDist float64
 		var totalTime float64
@@ -357,9 +1050,36 @@ func preprocessGpxPoints(points []Point, args *Arguments) []Point {
 		}
 	}
 
+	// не-window методы сглаживают и показываемую скорость: по скоростям между соседними точками
+	// вместо среднего по 5 точкам, выбросы гасит сам фильтр
+	if args.SpeedSmoothing != smoothingWindow && len(smoothed) > 1 {
+		speeds := make([]float64, len(smoothed))
+		for i := 1; i < len(smoothed); i++ {
+			dt := smoothed[i].Timestamp.Sub(smoothed[i-1].Timestamp).Seconds()
+			if dt > 0 && !smoothed[i].FileStart {
+				speeds[i] = haversine(smoothed[i-1], smoothed[i]) * 3600 / dt
+			} else {
+				speeds[i] = speeds[i-1]
+			}
+		}
+		speeds[0] = speeds[1]
+		speeds = smoothSeries(speeds, args.SpeedSmoothing, samplesInDuration(smoothed, speedSmoothingSpan))
+		for i := range smoothed {
+			smoothed[i].Speed = math.Max(0, speeds[i])
+		}
+	}
 
 	// --- Moving Average Speed Calculation (30s window) ---
-	if len(smoothed) > 0 {
+	if args.SpeedSmoothing != smoothingWindow {
+		speeds := make([]float64, len(smoothed))
+		for i := range smoothed {
+			speeds[i] = smoothed[i].Speed
+		}
+		speeds = smoothSeries(speeds, args.SpeedSmoothing, samplesInDuration(smoothed, avgSpeedWindow))
+		for i := range smoothed {
+			smoothed[i].AvgSpeed = math.Max(0, speeds[i])
+		}
+	} else if len(smoothed) > 0 {
 		left, right := 0, 0
 		var speedSum float64
 		var speedCount int
@@ -393,6 +1113,8 @@ func preprocessGpxPoints(points []Point, args *Arguments) []Point {
 		}
 	}
 
+	computeSensorAverages(smoothed, args)
+
 	// --- Dynamic Map Scale Calculation ---
 	for i := range smoothed {
 		speedMapScale := 1.0
@@ -408,6 +1130,12 @@ func preprocessGpxPoints(points []Point, args *Arguments) []Point {
 		}
 		smoothed[i].MapScale = speedMapScale
 	}
+	if args.LookAheadZoom > 0 {
+		multipliers := lookAheadZoomMultipliers(smoothed, time.Duration(args.LookAheadZoom*float64(time.Second)))
+		for i := range smoothed {
+			smoothed[i].MapScale *= multipliers[i]
+		}
+	}
 
 	for i := 0; i < len(smoothed)-1; i++ {
 		smoothed[i].Bearing = bearing(smoothed[i], smoothed[i+1])
@@ -437,32 +1165,31 @@ func preprocessGpxPoints(points []Point, args *Arguments) []Point {
 	if err != nil {
 		log.Fatalf("Error processing track adjustment file: %v", err)
 	}
-	scaleMultipliers, err := applyTrackAdjustments(smoothed, adjSpecs)
+	scaleMultipliers, err := applyTrackAdjustments(smoothed, adjSpecs, args)
 	if err != nil {
 		log.Fatalf("Error applying track adjustments: %v", err)
 	}
 	for i := range smoothed {
 		smoothed[i].MapScale *= scaleMultipliers[i]
 	}
+	if err := applyViewAdjustments(smoothed, adjSpecs); err != nil {
+		log.Fatalf("Error applying track adjustments: %v", err)
+	}
 
 	// --- Slope Calculation (centered 50m distance) ---
+	// Distance не убывает, поэтому границы окна ищем бинарным поиском
 	for i := range smoothed {
 		// Find the start point for our -25m slope calculation window
-		p_start_idx := -1
-		for j := i; j >= 0; j-- {
-			if math.Abs(smoothed[i].Distance-smoothed[j].Distance)*1000 >= 25 {
-				p_start_idx = j
-				break
-			}
-		}
+		p_start_idx := sort.Search(i+1, func(j int) bool {
+			return (smoothed[i].Distance-smoothed[j].Distance)*1000 < 25
+		}) - 1
 
 		// Find the end point for our +25m slope calculation window
-		p_end_idx := -1
-		for j := i; j < len(smoothed); j++ {
-			if math.Abs(smoothed[j].Distance-smoothed[i].Distance)*1000 >= 25 {
-				p_end_idx = j
-				break
-			}
+		p_end_idx := i + sort.Search(len(smoothed)-i, func(k int) bool {
+			return (smoothed[i+k].Distance-smoothed[i].Distance)*1000 >= 25
+		})
+		if p_end_idx == len(smoothed) {
+			p_end_idx = -1
 		}
 
 		if p_start_idx != -1 && p_end_idx != -1 {
@@ -486,37 +1213,104 @@ func preprocessGpxPoints(points []Point, args *Arguments) []Point {
 	}
 
 	// --- Smoothed Slope Calculation (5-second moving average) ---
-	for i := 0; i < len(smoothed); i++ {
-		start := i - 4
-		if start < 0 {
-			start = 0
+	if args.SlopeSmoothing != smoothingWindow {
+		slopes := make([]float64, len(smoothed))
+		for i := range smoothed {
+			slopes[i] = smoothed[i].Slope
+		}
+		slopes = smoothSeries(slopes, args.SlopeSmoothing, slopeSmoothingN)
+		for i := range smoothed {
+			smoothed[i].SmoothedSlope = slopes[i]
 		}
+	} else {
+		for i := 0; i < len(smoothed); i++ {
+			start := i - 4
+			if start < 0 {
+				start = 0
+			}
+
+			var totalSlope float64
+			count := 0
+			for j := start; j <= i; j++ {
+				totalSlope += smoothed[j].Slope
+				count++
+			}
 
-		var totalSlope float64
-		count := 0
-		for j := start; j <= i; j++ {
-			totalSlope += smoothed[j].Slope
-			count++
+			if count > 0 {
+				smoothed[i].SmoothedSlope = totalSlope / float64(count)
+			} else if i > 0 {
+				smoothed[i].SmoothedSlope = smoothed[i-1].SmoothedSlope
+			} else {
+				smoothed[i].SmoothedSlope = 0
+			}
 		}
+	}
 
-		if count > 0 {
-			smoothed[i].SmoothedSlope = totalSlope / float64(count)
-		} else if i > 0 {
-			smoothed[i].SmoothedSlope = smoothed[i-1].SmoothedSlope
-		} else {
-			smoothed[i].SmoothedSlope = 0
+	computeVAM(smoothed)
+
+	// --- Ascent / Descent Calculation ---
+	// высота копится только после изменения на ascentThreshold от последней опорной точки,
+	// иначе шум барометра и GPS на ровном месте даёт лишние сотни метров
+	if len(smoothed) > 0 {
+		refEle := smoothed[0].Ele
+		var ascent, descent float64
+		for i := range smoothed {
+			if delta := smoothed[i].Ele - refEle; delta >= ascentThreshold {
+				ascent += delta
+				refEle = smoothed[i].Ele
+			} else if delta <= -ascentThreshold {
+				descent -= delta
+				refEle = smoothed[i].Ele
+			}
+			smoothed[i].Ascent = ascent
+			smoothed[i].Descent = descent
+		}
+	}
+
+	// --- Moving Time Calculation ---
+	// стоянка — скорость ниже -stop-speed дольше -stop-seconds или пауза в записи такой же длины;
+	// короткие притормаживания (светофор на ходу, поворот) остаются временем в движении
+	minStop := time.Duration(args.StopSeconds * float64(time.Second))
+	stopped := make([]bool, len(smoothed)) // stopped[i] — отрезок от i-1 до i
+	for i := 0; i < len(smoothed); i++ {
+		if smoothed[i].Speed >= args.StopSpeed {
+			continue
 		}
+		j := i
+		for j+1 < len(smoothed) && smoothed[j+1].Speed < args.StopSpeed {
+			j++
+		}
+		end := min(j+1, len(smoothed)-1)
+		if smoothed[end].Timestamp.Sub(smoothed[i].Timestamp) >= minStop {
+			for k := i + 1; k <= end; k++ {
+				stopped[k] = true
+			}
+		}
+		i = j
+	}
+	var moving time.Duration
+	for i := 1; i < len(smoothed); i++ {
+		if dt := smoothed[i].Timestamp.Sub(smoothed[i-1].Timestamp); !stopped[i] && dt < minStop {
+			moving += dt
+		}
+		smoothed[i].MovingTime = moving.Seconds()
+	}
+
+	if args.RiderWeight > 0 {
+		computeEnergy(smoothed, args)
 	}
 
 	// --- Pre-calculate Zoom and Scale ---
 	for i := range smoothed {
 		p := &smoothed[i]
+		// при -ui-scale на пиксель экрана приходится меньше пикселей карты: тайлы берутся крупнее
+		scale := p.MapScale / args.UIScale
 		zoomOutLevels := 0.0
-		if p.MapScale > 1.0 {
-			zoomOutLevels = math.Floor(math.Log2(p.MapScale))
-		} else if p.MapScale < 1.0 {
+		if scale > 1.0 {
+			zoomOutLevels = math.Floor(math.Log2(scale))
+		} else if scale < 1.0 {
 			zoomOutLevels = -1
-			if p.MapScale < 0.5 {
+			if scale < 0.5 {
 				zoomOutLevels = -2
 			}
 		}
@@ -524,7 +1318,7 @@ func preprocessGpxPoints(points []Point, args *Arguments) []Point {
 		if p.TileZoom < 0 {
 			p.TileZoom = 0
 		}
-		p.ResidualMapScale = p.MapScale / math.Pow(2, zoomOutLevels)
+		p.ResidualMapScale = scale / math.Pow(2, zoomOutLevels)
 	}
 
 	return smoothed
@@ -568,15 +1362,24 @@ func angleBetweenBearings(bearing1, bearing2 float64) float64 {
 	return math.Abs(diff)
 }
 
-func parseCutBoundary(boundary string, points []Point) int {
+// parseCutBoundary находит индекс точки для -from/-to. Координаты и имена путевых точек
+// ищутся среди точек начиная с after; для -to (isEnd) берётся последний проход рядом с ними,
+// чтобы кольцевой трек можно было обрезать «от парковки до парковки». Время на часах
+// считается в поясе loc (-timezone).
+func parseCutBoundary(boundary string, points []Point, waypoints []Waypoint, after int, isEnd bool, loc *time.Location) int {
 	if len(points) == 0 {
 		return 0
 	}
-	if strings.HasSuffix(boundary, "s") {
-		seconds, err := strconv.ParseFloat(strings.TrimSuffix(boundary, "s"), 64)
-		if err != nil {
-			return 0
+	// имена путевых точек проверяются первыми: "Les Arcs" или "12:30 cafe" не должны уйти в разбор времени
+	for _, w := range waypoints {
+		if strings.EqualFold(w.Name, boundary) {
+			return nearestPointIndex(points[after:], w.Lat, w.Lon, isEnd) + after
 		}
+	}
+	if d, err := time.ParseDuration(boundary); err == nil { // 500s, 20m, 1h05m
+		return indexAtTime(points, points[0].Timestamp.Add(d))
+	}
+	if seconds, err := strconv.ParseFloat(strings.TrimSuffix(boundary, "s"), 64); err == nil && strings.HasSuffix(boundary, "s") {
 		startTime := points[0].Timestamp
 		for i, p := range points {
 			if p.Timestamp.Sub(startTime).Seconds() >= seconds {
@@ -584,11 +1387,8 @@ func parseCutBoundary(boundary string, points []Point) int {
 			}
 		}
 		return len(points)
-	} else if strings.HasSuffix(boundary, "km") {
-		km, err := strconv.ParseFloat(strings.TrimSuffix(boundary, "km"), 64)
-		if err != nil {
-			return 0
-		}
+	}
+	if km, err := strconv.ParseFloat(strings.TrimSuffix(boundary, "km"), 64); err == nil && strings.HasSuffix(boundary, "km") {
 		for i, p := range points {
 			if p.Distance >= km {
 				return i
@@ -596,15 +1396,99 @@ func parseCutBoundary(boundary string, points []Point) int {
 		}
 		return len(points)
 	}
+	if t, err := time.Parse(time.RFC3339, boundary); err == nil {
+		return indexAtTime(points, t)
+	}
+	// время на часах в поясе loc, в день начала трека
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		clock, err := time.Parse(layout, boundary)
+		if err != nil {
+			continue
+		}
+		start := points[0].Timestamp.In(loc)
+		t := time.Date(start.Year(), start.Month(), start.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, loc)
+		if t.Before(start) && start.Sub(t) > 12*time.Hour {
+			t = t.AddDate(0, 0, 1) // трек начался накануне и перевалил за полночь
+		}
+		return indexAtTime(points, t)
+	}
+	var lat, lon float64
+	if _, err := fmt.Sscanf(boundary, "%g,%g", &lat, &lon); err == nil {
+		return nearestPointIndex(points[after:], lat, lon, isEnd) + after
+	}
+	log.Fatalf("Cut boundary '%s' is not a duration, distance, clock time, timestamp, lat,lon or GPX waypoint name", boundary)
 	return 0
 }
 
-func cutTrack(track *Track, from, to string) {
-	track.RenderFromIndex = parseCutBoundary(from, track.SmoothedPoints)
-	track.RenderToIndex = parseCutBoundary(to, track.SmoothedPoints)
+// nearestPointIndex возвращает индекс ближайшей к координатам точки. Если трек проходит
+// рядом несколько раз, берётся первый (или последний, если last) проход в пределах
+// nearestPointTolerance от минимума.
+func nearestPointIndex(points []Point, lat, lon float64, last bool) int {
+	target := Point{Lat: lat, Lon: lon}
+	dists := make([]float64, len(points))
+	minDist := math.Inf(1)
+	for i, p := range points {
+		dists[i] = haversine(p, target) * 1000
+		minDist = math.Min(minDist, dists[i])
+	}
+	best := -1
+	for i, d := range dists {
+		if d > minDist+nearestPointTolerance {
+			continue
+		}
+		if !last {
+			return i
+		}
+		// внутри одного прохода берём ближайшую точку, а не последнюю
+		if best == -1 || i > best+1 || d < dists[best] {
+			best = i
+		}
+	}
+	return max(best, 0)
+}
+
+// indexAtTime возвращает индекс первой точки не раньше t
+func indexAtTime(points []Point, t time.Time) int {
+	for i, p := range points {
+		if !p.Timestamp.Before(t) {
+			return i
+		}
+	}
+	return len(points)
+}
+
+// parseSegments разбирает список диапазонов -segments: "0s-20m,1h05m-1h30m".
+// Границы диапазона разделяются "-" или ".." (последнее — если в границах есть дефисы, как в RFC3339).
+func parseSegments(spec string) ([][2]string, error) {
+	var segments [][2]string
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		from, to, ok := strings.Cut(part, "..")
+		if !ok {
+			from, to, ok = strings.Cut(part, "-")
+		}
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid segment '%s', expected from-to", part)
+		}
+		segments = append(segments, [2]string{from, to})
+	}
+	return segments, nil
+}
+
+func cutTrack(track *Track, from, to string, loc *time.Location) {
+	track.RenderFromIndex, track.RenderToIndex = resolveCut(track, from, to, loc)
+	if track.RenderToIndex == 0 {
+		log.Fatalf("Track fragment %s-%s is empty", from, to)
+	}
+}
+
+// resolveCut возвращает диапазон индексов [fromIdx, toIdx); 0, 0 — пустой диапазон
+func resolveCut(track *Track, from, to string, loc *time.Location) (int, int) {
+	fromIdx := parseCutBoundary(from, track.SmoothedPoints, track.Waypoints, 0, false, loc)
+	toIdx := parseCutBoundary(to, track.SmoothedPoints, track.Waypoints, fromIdx, true, loc)
 
-	if track.RenderFromIndex >= track.RenderToIndex {
-		track.RenderFromIndex = 0
-		track.RenderToIndex = 0
+	if fromIdx >= toIdx {
+		return 0, 0
 	}
+	return fromIdx, toIdx
 }
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- KML/KMZ ---
+
+// kmlPlacemark — линия трека в KML. Locus и Google Earth пишут gx:Track с временем каждой точки,
+// Google My Maps — LineString, у которого время есть только у всей линии (TimeSpan или TimeStamp).
+type kmlPlacemark struct {
+	When        string     `xml:"TimeStamp>when"`
+	Begin       string     `xml:"TimeSpan>begin"`
+	End         string     `xml:"TimeSpan>end"`
+	Tracks      []kmlTrack `xml:"Track"`
+	MultiTracks []kmlTrack `xml:"MultiTrack>Track"`
+	Lines       []string   `xml:"LineString>coordinates"`
+	MultiLines  []string   `xml:"MultiGeometry>LineString>coordinates"`
+}
+
+type kmlTrack struct {
+	When   []string `xml:"when"`
+	Coords []string `xml:"coord"` // "lon lat alt"
+}
+
+// kmlLine — LineString с известным началом и, возможно, концом
+type kmlLine struct {
+	Points     []Point
+	Start, End time.Time
+}
+
+// parseKml читает трек из KML или KMZ (zip с .kml внутри). Точки LineString получают время,
+// равномерно распределённое по длине между началом линии и её концом — концом TimeSpan или
+// началом следующей линии.
+func parseKml(filePath string) ([]Point, error) {
+	r, closer, err := openKml(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	var points []Point
+	var lines []kmlLine
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse KML file: %w", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "Placemark" {
+			continue
+		}
+		var pm kmlPlacemark
+		if err := dec.DecodeElement(&pm, &start); err != nil {
+			return nil, fmt.Errorf("failed to parse KML placemark: %w", err)
+		}
+		for _, t := range append(pm.Tracks, pm.MultiTracks...) {
+			trackPoints, err := kmlTrackPoints(t)
+			if err != nil {
+				return nil, err
+			}
+			points = append(points, trackPoints...)
+		}
+		for _, coords := range append(pm.Lines, pm.MultiLines...) {
+			line := kmlLine{Points: kmlCoordinates(coords)}
+			line.Start, _ = parseKmlTime(pm.Begin)
+			if line.Start.IsZero() {
+				line.Start, _ = parseKmlTime(pm.When)
+			}
+			line.End, _ = parseKmlTime(pm.End)
+			if len(line.Points) > 0 {
+				lines = append(lines, line)
+			}
+		}
+	}
+
+	for i, line := range lines {
+		end := line.End
+		if end.IsZero() && i+1 < len(lines) {
+			end = lines[i+1].Start
+		}
+		if line.Start.IsZero() || !end.After(line.Start) {
+			return nil, errors.New("KML LineString has no usable time: it needs a TimeSpan, or a TimeStamp followed by another timed line")
+		}
+		points = append(points, spreadLineTimes(line.Points, line.Start, end)...)
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("no gx:Track or LineString found in %s", filePath)
+	}
+	// треки и линии из разных Placemark идут в порядке файла, а не времени
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Timestamp.Before(points[j].Timestamp) })
+	return points, nil
+}
+
+// openKml открывает .kml как есть, а из .kmz — первый .kml в архиве (обычно doc.kml)
+func openKml(filePath string) (io.Reader, io.Closer, error) {
+	if strings.ToLower(filepath.Ext(filePath)) != ".kmz" {
+		f, err := os.Open(filePath)
+		return f, f, err
+	}
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open KMZ file: %w", err)
+	}
+	for _, f := range zr.File {
+		if strings.ToLower(filepath.Ext(f.Name)) == ".kml" {
+			rc, err := f.Open()
+			if err != nil {
+				zr.Close()
+				return nil, nil, err
+			}
+			return rc, multiCloser{rc, zr}, nil
+		}
+	}
+	zr.Close()
+	return nil, nil, fmt.Errorf("no .kml file inside %s", filePath)
+}
+
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	for _, c := range m {
+		c.Close()
+	}
+	return nil
+}
+
+func kmlTrackPoints(t kmlTrack) ([]Point, error) {
+	if len(t.When) != len(t.Coords) {
+		return nil, fmt.Errorf("gx:Track has %d <when> for %d <gx:coord>", len(t.When), len(t.Coords))
+	}
+	points := make([]Point, 0, len(t.Coords))
+	for i, c := range t.Coords {
+		ts, err := parseKmlTime(t.When[i])
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(c)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid gx:coord: %s", c)
+		}
+		p := Point{Timestamp: ts}
+		p.Lon, _ = strconv.ParseFloat(fields[0], 64)
+		p.Lat, _ = strconv.ParseFloat(fields[1], 64)
+		if len(fields) > 2 {
+			p.Ele, _ = strconv.ParseFloat(fields[2], 64)
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+// kmlCoordinates разбирает <coordinates>: кортежи "lon,lat[,alt]" через пробелы
+func kmlCoordinates(s string) []Point {
+	var points []Point
+	for _, tuple := range strings.Fields(s) {
+		fields := strings.Split(tuple, ",")
+		if len(fields) < 2 {
+			continue
+		}
+		var p Point
+		p.Lon, _ = strconv.ParseFloat(fields[0], 64)
+		p.Lat, _ = strconv.ParseFloat(fields[1], 64)
+		if len(fields) > 2 {
+			p.Ele, _ = strconv.ParseFloat(fields[2], 64)
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+// spreadLineTimes раздаёт точкам линии время пропорционально пройденному расстоянию
+func spreadLineTimes(points []Point, start, end time.Time) []Point {
+	dist := make([]float64, len(points))
+	for i := 1; i < len(points); i++ {
+		dist[i] = dist[i-1] + haversine(points[i-1], points[i])
+	}
+	total := dist[len(dist)-1]
+	for i := range points {
+		k := 0.0
+		if total > 0 {
+			k = dist[i] / total
+		} else if len(points) > 1 {
+			k = float64(i) / float64(len(points)-1)
+		}
+		points[i].Timestamp = start.Add(time.Duration(k * float64(end.Sub(start))))
+	}
+	return points
+}
+
+// parseKmlTime понимает dateTime из KML: с часовым поясом или без (тогда UTC)
+func parseKmlTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, errors.New("empty KML time")
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid KML time: %s", s)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"sync"
+
+	"github.com/fogleman/gg"
+)
+
+// --- Static Layers ---
+
+// staticLayer — часть оверлея, одинаковая во всех кадрах (рамка карты, подложка и иконки показателей). Рисуется
+// один раз при первом кадре, обрезается по непрозрачным пикселям и дальше только накладывается
+type staticLayer struct {
+	once sync.Once
+	img  *image.RGBA
+}
+
+var (
+	panelLayer  staticLayer
+	borderLayer staticLayer
+	iconLayer   staticLayer // иконки и шкалы показателей, drawIndicatorIcons
+)
+
+// draw накладывает слой на кадр dc; render рисует слой, если его ещё нет. Кадр к этому моменту
+// не должен быть сдвинут или повёрнут: слой копируется в пиксели как есть
+func (l *staticLayer) draw(dc *gg.Context, args *Arguments, render func(dc *gg.Context)) {
+	l.once.Do(func() {
+		layer := gg.NewContext(args.VideoWidth, args.VideoHeight)
+		render(layer)
+		rgba := layer.Image().(*image.RGBA)
+		l.img = rgba.SubImage(opaqueBounds(rgba)).(*image.RGBA)
+	})
+	if l.img.Bounds().Empty() {
+		return
+	}
+	draw.Draw(dc.Image().(*image.RGBA), l.img.Bounds(), l.img, l.img.Bounds().Min, draw.Over)
+}
+
+// opaqueBounds — наименьший прямоугольник, вне которого все пиксели прозрачны
+func opaqueBounds(img *image.RGBA) image.Rectangle {
+	b := img.Bounds()
+	out := image.Rectangle{}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+		for x := 0; x < b.Dx(); x++ {
+			if row[x*4+3] != 0 {
+				out = out.Union(image.Rect(b.Min.X+x, y, b.Min.X+x+1, y+1))
+			}
+		}
+	}
+	return out
+}
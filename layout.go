@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+)
+
+// --- Layout ---
+
+const (
+	aspectPortrait = "9:16" // Reels, Shorts, TikTok
+	aspectSquare   = "1:1"
+
+	layoutMargin       = 20.0  // px от края кадра до виджета
+	panelBaseHeight    = 180.0 // показатели без дополнительных строк
+	portraitSafeBottom = 0.2   // доля вертикального кадра снизу, которую в приложениях закрывают подписи и кнопки
+)
+
+// frameLayout — где в кадре стоят карта и блок показателей (блок шириной с карту).
+// Widgets — итоговые места всех виджетов, с учётом -layout-file
+type frameLayout struct {
+	MapX, MapY     float64
+	PanelX, PanelY float64
+	Widgets        map[string]widgetBox
+}
+
+// имена виджетов в -layout-file
+const (
+	widgetMap         = "map"
+	widgetSpeed       = "speed"
+	widgetAltitude    = "altitude"
+	widgetSlope       = "slope"
+	widgetDistance    = "distance"
+	widgetIndicators  = "indicators"
+	widgetProfile     = "elevation_profile"
+	widgetAttribution = "attribution"
+)
+
+// widgetBox — прямоугольник виджета в кадре при масштабе 1; Scale увеличивает его относительно левого верхнего угла.
+// Font — шрифт виджета из -layout-file, nil — общий
+type widgetBox struct {
+	X, Y, W, H float64
+	Scale      float64
+	Hidden     bool
+	Font       *truetype.Font
+}
+
+// fontOr — шрифт виджета или def, если свой не задан
+func (b widgetBox) fontOr(def *truetype.Font) *truetype.Font {
+	if b.Font != nil {
+		return b.Font
+	}
+	return def
+}
+
+// widgetFaces — начертания значения и единицы измерения шрифтом виджета
+func widgetFaces(box widgetBox, def *truetype.Font, valueSize, unitSize float64) (value, unit font.Face) {
+	ttf := box.fontOr(def)
+	return newFontFace(ttf, &truetype.Options{Size: valueSize}), newFontFace(ttf, &truetype.Options{Size: unitSize})
+}
+
+// computeLayout задаёт размер кадра и раскладку по -aspect. По умолчанию кадр ровно под
+// виджет: карта, под ней показатели. В квадрате показатели встают справа от карты,
+// в вертикальном кадре — под ней, а весь блок поднят над нижней полосой интерфейса.
+func computeLayout(args *Arguments) error {
+	w := float64(args.WidgetSize)
+	margin := layoutMargin * args.UIScale
+	panelHeight := panelBaseHeight * args.UIScale
+	if rows := extraIndicatorRows(args); rows > 0 {
+		panelHeight += math.Ceil(float64(rows) * extraRowHeight(w))
+	}
+	if args.ElevationProfile {
+		panelHeight = math.Max(panelHeight, math.Ceil(elevationProfileOffset(args)+elevationProfileHeight(args)))
+	}
+
+	var width, height float64
+	switch args.Aspect {
+	case "":
+		width, height = w+2*margin, margin+w+panelHeight
+		args.Layout = frameLayout{MapX: margin, MapY: margin, PanelX: margin, PanelY: margin + w}
+	case aspectSquare:
+		blockHeight := math.Max(w, panelHeight)
+		width = 2*w + 3*margin
+		height = math.Max(width, blockHeight+2*margin)
+		// карта внизу слева, показатели по центру её высоты справа
+		top := height - margin - blockHeight
+		args.Layout = frameLayout{
+			MapX: margin, MapY: top + blockHeight - w,
+			PanelX: 2*margin + w, PanelY: top + (blockHeight-panelHeight)/2,
+		}
+	case aspectPortrait:
+		blockHeight := w + panelHeight
+		width = w + 2*margin
+		height = math.Ceil(width * 16 / 9)
+		if minHeight := math.Ceil((margin + blockHeight) / (1 - portraitSafeBottom)); height < minHeight {
+			height = minHeight // много строк показателей: кадр вытягивается, а не обрезает их
+		}
+		mapY := height*(1-portraitSafeBottom) - blockHeight
+		args.Layout = frameLayout{MapX: margin, MapY: mapY, PanelX: margin, PanelY: mapY + w}
+	default:
+		return fmt.Errorf("unknown aspect %q (supported: %s, %s)", args.Aspect, aspectPortrait, aspectSquare)
+	}
+	args.Layout.Widgets = defaultWidgetBoxes(args)
+	if args.LayoutFile != "" {
+		cfg, err := parseLayoutFile(args.LayoutFile)
+		if err != nil {
+			return err
+		}
+		if cfg.Width > 0 && cfg.Height > 0 {
+			width, height = cfg.Width, cfg.Height
+		}
+		if err := applyLayoutFile(args.Layout.Widgets, cfg, width, height); err != nil {
+			return fmt.Errorf("%s: %w", args.LayoutFile, err)
+		}
+		m := args.Layout.Widgets[widgetMap]
+		args.Layout.MapX, args.Layout.MapY = m.X, m.Y
+		if _, placed := cfg.Widgets[widgetAttribution]; !placed {
+			// подпись источников карты без своего места следует за картой
+			a := attributionBox(m.X, m.Y, w)
+			a.Hidden, a.Font = args.Layout.Widgets[widgetAttribution].Hidden, args.Layout.Widgets[widgetAttribution].Font
+			args.Layout.Widgets[widgetAttribution] = a
+		}
+	}
+	// libx264 требует чётные размеры
+	args.VideoWidth = int(math.Ceil(width))
+	args.VideoWidth += args.VideoWidth % 2
+	args.VideoHeight = int(math.Ceil(height))
+	args.VideoHeight += args.VideoHeight % 2
+	return nil
+}
+
+// defaultWidgetBoxes раскладывает виджеты по карте и блоку показателей так, как рисует renderFrame без -layout-file
+func defaultWidgetBoxes(args *Arguments) map[string]widgetBox {
+	w := float64(args.WidgetSize)
+	valueFontSize := w / 8
+	rowHeight := valueFontSize*1.2 + valueFontSize/2*1.2 // строка скорости и уклона до полосы дистанции
+	barHeight := 20 * args.UIScale
+	speedWidth := w / 3
+	if args.Activity == "swimming" {
+		speedWidth = w / 2 // темп шире скорости
+	}
+	x, y := args.Layout.PanelX, args.Layout.PanelY
+	iconTop := y - panelIconOverhang(w)
+	return map[string]widgetBox{
+		widgetMap:         {X: args.Layout.MapX, Y: args.Layout.MapY, W: w, H: w, Scale: 1},
+		widgetSpeed:       {X: x, Y: iconTop, W: speedWidth, H: y + rowHeight - iconTop, Scale: 1},
+		widgetAltitude:    {X: x + w/3, Y: iconTop, W: w / 3, H: y + rowHeight - iconTop, Scale: 1},
+		widgetSlope:       {X: x + w*2/3, Y: iconTop, W: w / 3, H: y + rowHeight - iconTop, Scale: 1},
+		widgetDistance:    {X: x, Y: y + rowHeight, W: w, H: barHeight, Scale: 1},
+		widgetIndicators:  {X: x, Y: y + rowHeight + barHeight, W: w, H: float64(extraIndicatorRows(args)) * extraRowHeight(w), Scale: 1},
+		widgetProfile:     {X: x, Y: y + elevationProfileOffset(args), W: w, H: w * elevationProfileRatio, Scale: 1},
+		widgetAttribution: attributionBox(args.Layout.MapX, args.Layout.MapY, w),
+	}
+}
+
+// panelIconOverhang — насколько иконки скорости и уклона выступают над блоком показателей (в зазор под картой);
+// их виджеты начинаются выше на эту величину, чтобы при переносе иконки не обрезались
+func panelIconOverhang(w float64) float64 {
+	return w * 0.055
+}
+
+// indicatorBaseline — базовая линия строки скорости, высоты и уклона в виджете с верхним краем top
+func indicatorBaseline(top, w float64) float64 {
+	return top + panelIconOverhang(w) + w/8*1.2
+}
+
+// drawInWidgetBox рисует виджет в его прямоугольнике: draw получает левый верхний угол при масштабе 1,
+// а масштаб и цвет после отрисовки восстанавливаются. Скрытые виджеты не рисуются
+func drawInWidgetBox(dc *gg.Context, box widgetBox, draw func(x, y float64)) {
+	if box.Hidden {
+		return
+	}
+	dc.Push()
+	dc.ScaleAbout(box.Scale, box.Scale, box.X, box.Y)
+	draw(box.X, box.Y)
+	dc.Pop()
+}
+
+// --- Layout File ---
+
+// layoutFile — содержимое -layout-file (JSON). Width и Height, если заданы, меняют размер кадра
+type layoutFile struct {
+	Width   float64                     `json:"width"`
+	Height  float64                     `json:"height"`
+	Widgets map[string]layoutWidgetSpec `json:"widgets"`
+}
+
+// layoutWidgetSpec — место виджета: угол или сторона кадра (anchor), к которой он прижат тем же углом,
+// сдвиг offset в пикселях, ширина size (показатели масштабируются пропорционально), видимость и файл шрифта
+type layoutWidgetSpec struct {
+	Anchor  string     `json:"anchor"`
+	Offset  [2]float64 `json:"offset"`
+	Size    float64    `json:"size"`
+	Visible *bool      `json:"visible"`
+	Font    string     `json:"font"`
+}
+
+// layoutAnchors — доли ширины и высоты кадра (и виджета) для каждой привязки
+var layoutAnchors = map[string][2]float64{
+	"top-left": {0, 0}, "top": {0.5, 0}, "top-right": {1, 0},
+	"left": {0, 0.5}, "center": {0.5, 0.5}, "right": {1, 0.5},
+	"bottom-left": {0, 1}, "bottom": {0.5, 1}, "bottom-right": {1, 1},
+}
+
+func parseLayoutFile(filePath string) (*layoutFile, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var cfg layoutFile
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	if (cfg.Width > 0) != (cfg.Height > 0) {
+		return nil, fmt.Errorf("%s: width and height must be given together", filePath)
+	}
+	return &cfg, nil
+}
+
+// applyLayoutFile переставляет виджеты из boxes по cfg в кадре width x height;
+// не упомянутые в файле виджеты остаются на местах по умолчанию
+func applyLayoutFile(boxes map[string]widgetBox, cfg *layoutFile, width, height float64) error {
+	for name, spec := range cfg.Widgets {
+		box, ok := boxes[name]
+		if !ok {
+			names := make([]string, 0, len(boxes))
+			for n := range boxes {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown widget %q (supported: %s)", name, strings.Join(names, ", "))
+		}
+		if spec.Visible != nil {
+			box.Hidden = !*spec.Visible
+		}
+		if spec.Font != "" {
+			if name == widgetMap || name == widgetProfile {
+				return fmt.Errorf("widget %s has no text to set a font for", name)
+			}
+			box.Font = loadFont(spec.Font)
+		}
+		if spec.Size < 0 {
+			return fmt.Errorf("widget %s: size must be positive", name)
+		}
+		if spec.Size > 0 {
+			if name == widgetMap {
+				return fmt.Errorf("widget %s: the map size is set with -widget-size", name)
+			}
+			box.Scale = spec.Size / box.W
+		}
+		if spec.Anchor != "" || spec.Offset != [2]float64{} {
+			anchor := spec.Anchor
+			if anchor == "" {
+				anchor = "top-left"
+			}
+			a, ok := layoutAnchors[anchor]
+			if !ok {
+				return fmt.Errorf("widget %s: unknown anchor %q", name, anchor)
+			}
+			box.X = a[0]*(width-box.W*box.Scale) + spec.Offset[0]
+			box.Y = a[1]*(height-box.H*box.Scale) + spec.Offset[1]
+		}
+		boxes[name] = box
+	}
+	return nil
+}
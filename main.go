@@ -4,47 +4,266 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/fogleman/gg"
-	"github.com/golang/freetype/truetype"
-	"golang.org/x/image/font/gofont/goregular"
 )
 
 const (
 	tileCacheDir           = "tiles"
+	weatherCacheDir        = "weather"
+	geocodeCacheDir        = "geocode"
 	tileFetchConcurrency   = 8
-	slopeMaxEleChange      = 3.0
+	osmMaxConnections      = 2   // правила tile.openstreetmap.org: без массовых параллельных загрузок
+	slopeMaxEleChange      = 3.0 // для -elevation-filter=clamp
+	slewEleAllowance       = 1.0 // м: допуск сверх уклона, чтобы шум на стоянке не замораживал высоту
+	ascentThreshold        = 3.0 // м: меньшие колебания высоты в набор и сброс не идут
 	avgSpeedWindow         = 15 * time.Second
 	dynMapScaleMinSpeedKmh = 17.0
 	dynMapScaleMaxSpeedKmh = 26.0
+	swimMaxSpeedKmh        = 8.0
+	swimSmoothingWindow    = 10 * time.Second
+	skiEleSmoothingWindow  = 15 * time.Second
+	skiReversalThreshold   = 20.0
+	nearestPointTolerance  = 25.0 // м
+	segmentTransition      = 500 * time.Millisecond
+	introTitleFade         = time.Second
+	pathDecimationSpacing  = 2.0 // м
+	tilePrefetchStep       = 32  // px: точки ближе к предыдущей при сборе тайлов пропускаются
+	autoTuneDuration       = 3 * time.Second // видео на каждый вариант -workers при -auto-tune
+	lookAheadMinScale      = 0.5
+	lookAheadMinSegment    = 5.0 // м
+	overviewTransition     = 3 * time.Second
+	overviewHold           = 5 * time.Second
 )
 
 // --- Main Logic ---
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "tiles" {
+		runTilesCommand(os.Args[2:])
+		return
+	}
 	args := parseArguments()
+	if args.Watch {
+		watchAndRender(args)
+		return
+	}
+	defer startProfiling(args)()
+
+	if args.Demo {
+		if err := writeDemoTrack(args.GpxFile); err != nil {
+			log.Fatalf("Error writing demo track: %v", err)
+		}
+		log.Printf("Wrote synthetic track to %s", args.GpxFile)
+	}
 
-	points, err := parseGpx(args.GpxFile)
+	trackFiles, err := trackFilePaths(args.GpxFile)
 	if err != nil {
-		log.Fatalf("Error parsing GPX: %v", err)
+		log.Fatalf("Error parsing track: %v", err)
+	}
+	var virtualRoute []Point
+	if args.VirtualRoute != "" {
+		if virtualRoute, err = loadVirtualRoute(args.VirtualRoute); err != nil {
+			log.Fatalf("Error parsing virtual route: %v", err)
+		}
+	}
+	points, err := parseTrackFiles(trackFiles, virtualRoute)
+	if err != nil {
+		log.Fatalf("Error parsing track: %v", err)
 	}
 	if len(points) < 2 {
 		log.Fatal("Not enough points in GPX file.")
 	}
 
-	track := &Track{Points: points}
-	track.SmoothedPoints = preprocessGpxPoints(track.Points, args)
+	if args.ShowGear && points[len(points)-1].RearGear == 0 {
+		log.Printf("Warning: no gear change events found in %s", args.GpxFile)
+	}
+
+	if (args.GPSQuality || args.GPSQualityPath) && !hasGPSQuality(points) {
+		log.Printf("Warning: no HDOP, satellite count or GPS accuracy found in %s", args.GpxFile)
+	}
+
+	if args.Activity == "swimming" {
+		points = smoothSwimPositions(points)
+	}
+
+	track := &Track{}
+	if args.RouteFile != "" {
+		route, err := parseGpxRoute(args.RouteFile)
+		if err != nil {
+			log.Fatalf("Error parsing route: %v", err)
+		}
+		track.Route = route
+		computeRouteDeviations(points, track.Route)
+	}
+	if args.Activity == "skiing" {
+		runs := detectSkiRuns(points)
+		log.Printf("Detected %d ski runs", runs)
+	}
+	track.SmoothedPoints = preprocessGpxPoints(points, args)
+	track.PathPoints = decimatePath(track.SmoothedPoints)
+	track.PathProjection = newPathProjection(track.SmoothedPoints, track.PathPoints)
 	track.RenderToIndex = len(track.SmoothedPoints)
 
-	for i := 1; i < len(track.Points); i++ {
-		track.TotalDistance += haversine(track.Points[i-1], track.Points[i])
+	for i := 1; i < len(track.SmoothedPoints); i++ {
+		track.TotalDistance += haversine(track.SmoothedPoints[i-1], track.SmoothedPoints[i])
+	}
+
+	for _, f := range trackFiles {
+		switch strings.ToLower(filepath.Ext(f)) {
+		case ".fit", ".tcx", ".kml", ".kmz": // путевые точки читаем только из GPX
+		default:
+			waypoints, err := parseGpxWaypoints(f)
+			if err != nil {
+				log.Fatalf("Error parsing waypoints: %v", err)
+			}
+			track.Waypoints = append(track.Waypoints, waypoints...)
+		}
+	}
+	cutTrack(track, args.From, args.To, args.Location)
+
+	if args.CheckpointTicks {
+		track.Checkpoints = waypointCheckpoints(track.SmoothedPoints, track.Waypoints)
+	}
+	checkpoints, err := parseCheckpoints(args.Checkpoints)
+	if err != nil {
+		log.Fatalf("Error parsing checkpoints: %v", err)
+	}
+	track.Checkpoints = append(track.Checkpoints, checkpoints...)
+	sort.SliceStable(track.Checkpoints, func(i, j int) bool { return track.Checkpoints[i].Distance < track.Checkpoints[j].Distance })
+
+	setGaugeRanges(track, args)
+	preparePathColors(track, args)
+	computeSessionSpeeds(track.SmoothedPoints[track.RenderFromIndex:])
+	computeTrainingLoad(track.SmoothedPoints[track.RenderFromIndex:track.RenderToIndex], args.FTP)
+	if args.Splits != "" {
+		track.Splits = detectSplits(track.SmoothedPoints[track.RenderFromIndex:track.RenderToIndex], splitLength(args.Splits))
+	}
+	if args.ElevationProfile {
+		track.ElevationProfile = sampleElevationProfile(track.SmoothedPoints, args.WidgetSize)
+	}
+	if args.ETA {
+		finish := track.SmoothedPoints[track.RenderToIndex-1].Distance
+		computeFinishProjection(track.SmoothedPoints, finish, time.Duration(args.ETAWindow*float64(time.Minute)))
+	}
+
+	if args.EndCard > 0 {
+		track.Summary = computeRideSummary(track.SmoothedPoints[track.RenderFromIndex:track.RenderToIndex], args.SplitKm)
+	}
+
+	readoutStart := track.SmoothedPoints[track.RenderFromIndex].Timestamp
+	track.SlopeReadout = buildReadout(track.SmoothedPoints, readoutStart, time.Duration(args.SlopeInterval*float64(time.Second)), args.SlopeHysteresis,
+		func(p Point) float64 { return p.SmoothedSlope })
+	track.SpeedReadout = buildReadout(track.SmoothedPoints, readoutStart, time.Duration(args.SpeedInterval*float64(time.Second)), args.SpeedHysteresis,
+		func(p Point) float64 { return p.Speed })
+	track.HRReadout = buildReadout(track.SmoothedPoints, readoutStart, time.Duration(args.HRInterval*float64(time.Second)), args.HRHysteresis,
+		func(p Point) float64 { return p.AvgHeartRate })
+
+	if args.ClimbBanner {
+		track.Climbs = detectClimbs(track.SmoothedPoints)
+		for _, c := range track.Climbs {
+			log.Printf("Climb %s at %.2f km: %.2f km, +%.0f m, avg %.1f%%, max %.1f%%", c.Category, track.SmoothedPoints[c.StartIndex].Distance, c.Length, c.Gain, c.AvgGradient, c.MaxGradient)
+		}
 	}
 
-	cutTrack(track, args.From, args.To)
+	if args.Weather {
+		samples, err := fetchTrackWeather(track.SmoothedPoints, args.Offline)
+		if err != nil {
+			log.Fatalf("Error fetching weather: %v", err)
+		}
+		applyWeather(track.SmoothedPoints, samples)
+	}
+
+	if args.WaypointBanners > 0 {
+		track.WaypointPasses = detectWaypointPasses(track.SmoothedPoints, track.Waypoints, args.WaypointBanners)
+	}
+
+	if args.AnnotationFile != "" {
+		specs, err := parseAnnotationFile(args.AnnotationFile)
+		if err != nil {
+			log.Fatalf("Error parsing annotation file: %v", err)
+		}
+		if track.Annotations, err = resolveAnnotations(track.SmoothedPoints, specs); err != nil {
+			log.Fatalf("Error parsing annotation file: %v", err)
+		}
+	}
+
+	if args.Geocode != "" {
+		// только показываемый фрагмент: Nominatim отвечает не чаще раза в секунду.
+		// Отрезки -segments режутся позже и могут лежать где угодно, для них — весь трек
+		geocoded := track.SmoothedPoints[track.RenderFromIndex:track.RenderToIndex]
+		if args.Segments != "" {
+			geocoded = track.SmoothedPoints
+		}
+		track.Places, err = geocodeTrack(geocoded, args.Geocode, args.Offline)
+		if err != nil {
+			log.Fatalf("Error reverse geocoding track: %v", err)
+		}
+		log.Printf("Found %d place name changes along the track", len(track.Places))
+	}
+
+	if args.PhotoDir != "" {
+		offset := time.Duration(args.PhotoClockOffset * float64(time.Second))
+		track.Photos, err = loadPhotos(args.PhotoDir, track.SmoothedPoints, offset, args.Location, args.WidgetSize)
+		if err != nil {
+			log.Fatalf("Error loading photos: %v", err)
+		}
+		log.Printf("Matched %d photos to the track", len(track.Photos))
+	}
+
+	if args.Motorsport {
+		var imu imuData
+		if args.IMUFile != "" {
+			if imu, err = loadIMU(args.IMUFile); err != nil {
+				log.Fatalf("Error reading IMU data from %s: %v", args.IMUFile, err)
+			}
+			log.Printf("Read %d accelerometer and %d gyroscope samples from %s", len(imu.Accel), len(imu.Gyro), args.IMUFile)
+		}
+		track.Motion = buildMotionSeries(track.SmoothedPoints, imu)
+	}
+
+	if args.Events != "" {
+		types, err := parseEventTypes(args.Events)
+		if err != nil {
+			log.Fatalf("Error parsing events: %v", err)
+		}
+		track.Events = detectEvents(track.SmoothedPoints, types)
+		for _, e := range track.Events {
+			log.Printf("Event %s at %.2f km: %s", e.Type, track.SmoothedPoints[e.Index].Distance, e.Label)
+		}
+	}
+
+	if args.ExportGpx != "" {
+		points := track.SmoothedPoints[track.RenderFromIndex:track.RenderToIndex]
+		points = resampleTrack(points, time.Duration(args.ExportGpxInterval*float64(time.Second)))
+		name := strings.TrimSuffix(filepath.Base(trackFiles[0]), filepath.Ext(trackFiles[0]))
+		if err := writeGpx(args.ExportGpx, name, track.Waypoints, points); err != nil {
+			log.Fatalf("Error exporting track: %v", err)
+		}
+		log.Printf("Exported %d points to %s", len(points), args.ExportGpx)
+	}
+
+	if args.ExportPoints != "" {
+		if err := exportPoints(args.ExportPoints, track); err != nil {
+			log.Fatalf("Error exporting points: %v", err)
+		}
+		log.Printf("Exported point metrics to %s", args.ExportPoints)
+	}
+
+	if args.Chapters {
+		track.Chapters, err = trackChapters(track, args.ChapterPoints, args.Location)
+		if err != nil {
+			log.Fatalf("Error parsing chapters: %v", err)
+		}
+	}
 
 	if args.Debug {
-		t0 := track.Points[0].Timestamp
+		t0 := track.SmoothedPoints[0].Timestamp
 		for i := track.RenderFromIndex; i < track.RenderToIndex; i++ {
 			p := track.SmoothedPoints[i]
 			ddist := 0.0
@@ -57,14 +276,32 @@ func main() {
 		return
 	}
 
-	font, err := truetype.Parse(goregular.TTF)
-	if err != nil {
-		log.Fatal(err)
+	font := loadFont(args.FontFile)
+	if font == nil {
+		font = loadFont("")
+	}
+	if args.FallbackFonts != "" {
+		fallbackFonts, err = loadFallbackFonts(args.FallbackFonts)
+		if err != nil {
+			log.Fatalf("Error loading fallback fonts: %v", err)
+		}
 	}
 
 	// --- Prefetch & Cache Tiles ---
-	allTilesForTrack := getAllTilesForTrack(track, args)
-	prefetchTiles(allTilesForTrack, args)
+	// при -chunk-minutes тайлы подгружаются по ходу рендера, окнами на кусок видео,
+	// а для листов сравнения — только вокруг их кадров
+	tileCache.setBudget(int64(args.TileCacheMem) << 20)
+	defer reportUnavailableTiles(args)
+	var allTilesForTrack map[Tile]struct{}
+	if args.ChunkMinutes == 0 && !args.NoVideo && args.CompareStyles == "" && args.Thumbnails == 0 {
+		allTilesForTrack = getTilesForPoints(track.SmoothedPoints, args)
+		prefetchTiles(allTilesForTrack, args)
+	}
+
+	track.MapMasks, err = parseMapMaskFile(args.MapMaskFile)
+	if err != nil {
+		log.Fatalf("Error parsing map mask file: %v", err)
+	}
 
 	adjSpecs, err := parseTrackAdjustmentFile(args.TrackAdjustmentFile)
 	if err != nil {
@@ -73,20 +310,72 @@ func main() {
 	if adjSpecs != nil {
 		uniqueScales := make(map[float64]struct{})
 		for _, spec := range adjSpecs {
-			uniqueScales[spec.Scale] = struct{}{}
+			if spec.Scale != 0 {
+				uniqueScales[spec.Scale/args.UIScale] = struct{}{} // как ResidualMapScale точек, с учётом -ui-scale
+			}
+		}
+		track.TileScales = uniqueScales
+		if allTilesForTrack != nil {
+			cacheScaledTiles(uniqueScales, allTilesForTrack, args)
+		}
+
+		track.Timeline, err = buildTimeline(track.SmoothedPoints, adjSpecs)
+		if err != nil {
+			log.Fatalf("Error building frame timeline: %v", err)
 		}
-		cacheScaledTiles(uniqueScales, allTilesForTrack, args)
 	}
 
 	if args.RenderFirstFrame {
 		log.Println("Rendering first frame only...")
 		img := renderFrame(22000, 1, track, args, font, track.SmoothedPoints[0].Timestamp)
+		if args.Intro > 0 {
+			drawIntroTitle(img, args.IntroTitle, args.Intro, font, args)
+		}
+		if args.EndCard > 0 {
+			drawEndCard(img, endCardLines(track, args), args.EndCard, font, args)
+		}
 		gg.SavePNG("first_frame.png", img)
 		log.Println("Saved first_frame.png")
 		return
 	}
 
-	runVideoPipeline(track, args, font)
+	if args.CompareStyles != "" {
+		renderStyleSheet(track, args, font)
+		return
+	}
+	if args.Thumbnails > 0 {
+		renderThumbnailSheet(track, args, font)
+		return
+	}
+
+	if args.AutoTune && !args.NoVideo {
+		args.Workers = autoTuneWorkers(track, args, font)
+	}
+
+	if args.Segments == "" {
+		segment := newVideoSegment(track, track.RenderFromIndex, track.RenderToIndex, args)
+		runVideoPipeline(track, args, font, []videoSegment{segment}, args.OutputFile)
+		return
+	}
 
-	fmt.Printf("\nVideo saved to %s\n", args.OutputFile)
+	ranges, err := parseSegments(args.Segments)
+	if err != nil {
+		log.Fatalf("Error parsing segments: %v", err)
+	}
+	var segments []videoSegment
+	for _, r := range ranges {
+		fromIdx, toIdx := resolveCut(track, r[0], r[1], args.Location)
+		if toIdx == 0 {
+			log.Fatalf("Segment %s-%s is empty", r[0], r[1])
+		}
+		segments = append(segments, newVideoSegment(track, fromIdx, toIdx, args))
+	}
+	if args.StitchSegments {
+		runVideoPipeline(track, args, font, segments, args.OutputFile)
+		return
+	}
+	for i, segment := range segments {
+		outputFile := segmentOutputFile(args.OutputFile, i+1)
+		runVideoPipeline(track, args, font, []videoSegment{segment}, outputFile)
+	}
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// --- Structs ---
+
+// MapMask — область карты, которую нужно размыть или закрасить (частная территория и т.п.)
+type MapMask struct {
+	Circle     bool
+	Lat1, Lon1 float64     // угол прямоугольника или центр круга
+	Lat2, Lon2 float64     // противоположный угол прямоугольника
+	Radius     float64     // радиус круга, м
+	Color      color.Color // nil — размытие
+}
+
+const mapMaskBlurRadius = 12 // px
+
+var maskCommentRe = regexp.MustCompile(`(^|\s)#.*$`)
+
+// --- Map Masks ---
+
+// parseMapMaskFile читает файл с областями, по одной на строку:
+//
+//	rect 55.750,37.600 55.760,37.620 blur
+//	circle 55.755,37.610 200 color=#808080
+func parseMapMaskFile(filePath string) ([]MapMask, error) {
+	if filePath == "" {
+		return nil, nil
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read map mask file: %w", err)
+	}
+
+	var masks []MapMask
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(maskCommentRe.ReplaceAllString(line, ""))
+		if line == "" {
+			continue
+		}
+
+		parts := strings.Fields(line)
+		var mask MapMask
+		var effect string
+		switch {
+		case parts[0] == "rect" && len(parts) == 4:
+			_, err1 := fmt.Sscanf(parts[1], "%g,%g", &mask.Lat1, &mask.Lon1)
+			_, err2 := fmt.Sscanf(parts[2], "%g,%g", &mask.Lat2, &mask.Lon2)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid rect corners on line %d: %s", i+1, line)
+			}
+			effect = parts[3]
+		case parts[0] == "circle" && len(parts) == 4:
+			mask.Circle = true
+			_, err1 := fmt.Sscanf(parts[1], "%g,%g", &mask.Lat1, &mask.Lon1)
+			radius, err2 := strconv.ParseFloat(parts[2], 64)
+			if err1 != nil || err2 != nil || radius <= 0 {
+				return nil, fmt.Errorf("invalid circle on line %d: %s", i+1, line)
+			}
+			mask.Radius = radius
+			effect = parts[3]
+		default:
+			return nil, fmt.Errorf("invalid format on line %d: %s", i+1, line)
+		}
+
+		if strings.HasPrefix(effect, "color=") {
+			c, err := parseHexColor(strings.TrimPrefix(effect, "color="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid color on line %d: %s", i+1, line)
+			}
+			mask.Color = c
+		} else if effect != "blur" {
+			return nil, fmt.Errorf("unknown effect on line %d: %s", i+1, effect)
+		}
+		masks = append(masks, mask)
+	}
+	return masks, nil
+}
+
+// applyMapMasks закрашивает или размывает области на собранной из тайлов карте.
+// project переводит координаты в пиксели mapImage.
+func applyMapMasks(mapImage *image.RGBA, masks []MapMask, project func(lat, lon float64) (float64, float64)) {
+	for _, mask := range masks {
+		var inside func(x, y int) bool
+		var bounds image.Rectangle
+		if mask.Circle {
+			cx, cy := project(mask.Lat1, mask.Lon1)
+			// радиус в пикселях: проецируем точку на mask.Radius метров севернее
+			_, ny := project(mask.Lat1+mask.Radius/111320, mask.Lon1)
+			r := math.Abs(cy - ny)
+			bounds = image.Rect(int(cx-r), int(cy-r), int(cx+r)+1, int(cy+r)+1)
+			inside = func(x, y int) bool {
+				dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+				return dx*dx+dy*dy <= r*r
+			}
+		} else {
+			x1, y1 := project(mask.Lat1, mask.Lon1)
+			x2, y2 := project(mask.Lat2, mask.Lon2)
+			bounds = image.Rect(int(x1), int(y1), int(x2), int(y2)) // Rect сам упорядочивает углы
+			inside = func(x, y int) bool { return true }
+		}
+		bounds = bounds.Intersect(mapImage.Bounds())
+		if bounds.Empty() {
+			continue
+		}
+
+		var src *image.RGBA
+		if mask.Color == nil {
+			src = boxBlur(mapImage, bounds.Inset(-mapMaskBlurRadius).Intersect(mapImage.Bounds()), mapMaskBlurRadius)
+		}
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if !inside(x, y) {
+					continue
+				}
+				if src != nil {
+					mapImage.SetRGBA(x, y, src.RGBAAt(x, y))
+				} else {
+					mapImage.Set(x, y, mask.Color)
+				}
+			}
+		}
+	}
+}
+
+// boxBlur возвращает размытую копию области r (три прохода бокс-фильтра ≈ гауссово размытие)
+func boxBlur(img *image.RGBA, r image.Rectangle, radius int) *image.RGBA {
+	out := image.NewRGBA(r)
+	draw.Draw(out, r, img, r.Min, draw.Src)
+	tmp := image.NewRGBA(r)
+	for pass := 0; pass < 3; pass++ {
+		boxBlurLine(out, tmp, r, radius/3+1, true)
+		boxBlurLine(tmp, out, r, radius/3+1, false)
+	}
+	return out
+}
+
+func boxBlurLine(src, dst *image.RGBA, r image.Rectangle, radius int, horizontal bool) {
+	outer, inner := r.Dy(), r.Dx()
+	if !horizontal {
+		outer, inner = inner, outer
+	}
+	at := func(o, i int) (int, int) {
+		if horizontal {
+			return r.Min.X + i, r.Min.Y + o
+		}
+		return r.Min.X + o, r.Min.Y + i
+	}
+	for o := 0; o < outer; o++ {
+		for i := 0; i < inner; i++ {
+			var sum [4]int
+			n := 0
+			for k := max(0, i-radius); k <= min(inner-1, i+radius); k++ {
+				c := src.RGBAAt(at(o, k))
+				sum[0] += int(c.R)
+				sum[1] += int(c.G)
+				sum[2] += int(c.B)
+				sum[3] += int(c.A)
+				n++
+			}
+			x, y := at(o, i)
+			dst.SetRGBA(x, y, color.RGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), uint8(sum[3] / n)})
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fogleman/gg"
+)
+
+// --- Structs ---
+
+// imuSample — одно измерение трёхосного датчика в его собственных осях
+type imuSample struct {
+	Time time.Time
+	V    [3]float64
+}
+
+// imuData — акселерометр и гироскоп из -imu, по времени
+type imuData struct {
+	Accel []imuSample // единицы источника (м/с² у GoPro, g у FIT): масштаб выводится из силы тяжести
+	Gyro  []imuSample // рад/с
+}
+
+// motionSeries — крен и перегрузки с шагом 1/motionRate с от Start
+type motionSeries struct {
+	Start            time.Time
+	Lean, LatG, LonG []float64 // крен в градусах и перегрузки в g; крен и боковая — вправо положительные, продольная — разгон
+}
+
+const (
+	motionRate      = 10      // Гц, шаг motionSeries
+	imuWindow       = 0.5     // с, окно усреднения датчиков: гасит вибрацию мотора и дороги
+	imuMinSpeed     = 3.0     // м/с: медленнее GPS-курс и ускорение слишком шумные для калибровки
+	imuSteadyAccel  = 0.3     // м/с²: ускорения по GPS меньше — ровная езда, датчик видит только силу тяжести
+	standardGravity = 9.80665 // м/с²
+	gForceGaugeMax  = 1.5     // g, край шкалы иконок перегрузки
+	leanGaugeMax    = 60.0    // градусы, край шкалы иконки крена
+)
+
+// --- IMU Loading ---
+
+// loadIMU читает акселерометр и гироскоп из MP4 камеры GoPro (GPMF) или из FIT-файла
+func loadIMU(filePath string) (imuData, error) {
+	var data imuData
+	var err error
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".mp4", ".mov", ".360":
+		data, err = readGPMF(filePath)
+	case ".fit":
+		data, err = parseFitIMU(filePath)
+	default:
+		return imuData{}, fmt.Errorf("unsupported IMU file %s: expected a GoPro MP4 or a FIT file", filePath)
+	}
+	if err != nil {
+		return imuData{}, err
+	}
+	if len(data.Accel) == 0 && len(data.Gyro) == 0 {
+		return imuData{}, fmt.Errorf("no accelerometer or gyroscope data in %s", filePath)
+	}
+	for _, samples := range [][]imuSample{data.Accel, data.Gyro} {
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	}
+	return data, nil
+}
+
+// --- Motion Series ---
+
+// buildMotionSeries считает крен и перегрузки вдоль трека. Без датчиков всё выводится из GPS:
+// боковая перегрузка — скорость на угловую скорость курса, крен — угол, при котором она уравновешена
+// силой тяжести, продольная — изменение скорости. Ориентацию камеры или устройства знать не нужно:
+// «вверх» — среднее направление ускорения (сила тяжести), «вперёд» — направление в плоскости,
+// перпендикулярной ему, лучше всего повторяющее разгоны и торможения по GPS. Знак гироскопа сверяется
+// с поворотами по GPS. Там, где данных датчика нет (вне видео с камеры), остаются значения по GPS
+func buildMotionSeries(points []Point, imu imuData) *motionSeries {
+	if len(points) < 2 {
+		return &motionSeries{}
+	}
+	start := points[0].Timestamp
+	n := int(points[len(points)-1].Timestamp.Sub(start).Seconds()*motionRate) + 1
+	m := &motionSeries{Start: start, Lean: make([]float64, n), LatG: make([]float64, n), LonG: make([]float64, n)}
+
+	speed, turnRate, accel := gpsMotion(points, start, n)
+
+	accelMean, accelOK := imuWindowMeans(imu.Accel, start, n)
+	gyroMean, gyroOK := imuWindowMeans(imu.Gyro, start, n)
+
+	// ось «вверх» и модуль силы тяжести в единицах датчика — по ровной езде без разгонов и поворотов,
+	// иначе торможения и наклоны в поворотах её перекашивают; если такой езды нет, по всем измерениям
+	var up [3]float64
+	gravity, count := 0.0, 0
+	for _, steadyOnly := range []bool{true, false} {
+		for k := range accelMean {
+			steady := math.Abs(accel[k]) < imuSteadyAccel && math.Abs(speed[k]*turnRate[k]) < imuSteadyAccel
+			if accelOK[k] && (steady || !steadyOnly) {
+				up = vecAdd(up, accelMean[k])
+				count++
+			}
+		}
+		if count >= 10*motionRate {
+			break
+		}
+	}
+	if count > 0 {
+		up = vecScale(up, 1/float64(count))
+		gravity = vecLen(up)
+	}
+	if gravity == 0 {
+		if len(imu.Accel)+len(imu.Gyro) > 0 {
+			log.Println("Warning: no accelerometer data overlaps the track; lean and g-force come from GPS")
+		}
+		gyroOK = nil
+	} else {
+		up = vecScale(up, 1/gravity)
+	}
+
+	forward, hasForward := imuForwardAxis(accelMean, accelOK, up, gravity, speed, accel)
+	if gravity > 0 && !hasForward {
+		log.Println("Warning: could not find the forward axis of the accelerometer (too little braking and acceleration); longitudinal g comes from GPS")
+	}
+	gyroSign := imuGyroSign(gyroMean, gyroOK, up, speed, turnRate)
+
+	for k := 0; k < n; k++ {
+		m.LonG[k] = accel[k] / standardGravity
+		if hasForward && accelOK[k] {
+			m.LonG[k] = vecDot(accelMean[k], forward) / gravity
+		}
+		lateral := speed[k] * turnRate[k] / standardGravity
+		lean := math.Atan(lateral)
+		if k < len(gyroOK) && gyroOK[k] {
+			// датчик на мотоцикле наклоняется вместе с ним и видит рыскание, уменьшенное в cos(крена) раз:
+			// из tan(крен) = v·ω/g и ω_датчика = ω·cos(крен) выходит sin(крен) = v·ω_датчика/g
+			s := speed[k] * gyroSign * vecDot(gyroMean[k], up) / standardGravity
+			lean = math.Asin(math.Max(-0.99, math.Min(0.99, s)))
+			lateral = math.Tan(lean)
+		}
+		m.Lean[k] = lean * 180 / math.Pi
+		m.LatG[k] = lateral
+	}
+	return m
+}
+
+// gpsMotion — скорость (м/с), угловая скорость курса (рад/с, вправо положительная) и ускорение (м/с²)
+// в узлах ряда: центральные разности по точкам трека, линейно интерполированные
+func gpsMotion(points []Point, start time.Time, n int) (speed, turnRate, accel []float64) {
+	pointTurn := make([]float64, len(points))
+	pointAccel := make([]float64, len(points))
+	for i := range points {
+		a, b := max(0, i-1), min(len(points)-1, i+1)
+		dt := points[b].Timestamp.Sub(points[a].Timestamp).Seconds()
+		if dt <= 0 {
+			continue
+		}
+		turn := points[b].Bearing - points[a].Bearing
+		turn = math.Mod(turn+3*math.Pi, 2*math.Pi) - math.Pi
+		pointTurn[i] = turn / dt
+		pointAccel[i] = (points[b].Speed - points[a].Speed) / 3.6 / dt
+	}
+
+	speed, turnRate, accel = make([]float64, n), make([]float64, n), make([]float64, n)
+	i := 0
+	for k := 0; k < n; k++ {
+		t := start.Add(time.Duration(float64(k) / motionRate * float64(time.Second)))
+		for i < len(points)-2 && points[i+1].Timestamp.Before(t) {
+			i++
+		}
+		ratio := 0.0
+		if dt := points[i+1].Timestamp.Sub(points[i].Timestamp).Seconds(); dt > 0 {
+			ratio = math.Max(0, math.Min(1, t.Sub(points[i].Timestamp).Seconds()/dt))
+		}
+		speed[k] = (points[i].Speed + (points[i+1].Speed-points[i].Speed)*ratio) / 3.6
+		turnRate[k] = pointTurn[i] + (pointTurn[i+1]-pointTurn[i])*ratio
+		accel[k] = pointAccel[i] + (pointAccel[i+1]-pointAccel[i])*ratio
+	}
+	return speed, turnRate, accel
+}
+
+// imuWindowMeans — средние измерений в окне imuWindow вокруг каждого узла ряда; ok — в окне что-то было
+func imuWindowMeans(samples []imuSample, start time.Time, n int) (means [][3]float64, ok []bool) {
+	if len(samples) == 0 {
+		return nil, nil
+	}
+	means, ok = make([][3]float64, n), make([]bool, n)
+	// префиксные суммы: среднее любого окна за O(1)
+	prefix := make([][3]float64, len(samples)+1)
+	for i, s := range samples {
+		prefix[i+1] = vecAdd(prefix[i], s.V)
+	}
+	half := time.Duration(imuWindow / 2 * float64(time.Second))
+	lo, hi := 0, 0
+	for k := 0; k < n; k++ {
+		t := start.Add(time.Duration(float64(k) / motionRate * float64(time.Second)))
+		for lo < len(samples) && samples[lo].Time.Before(t.Add(-half)) {
+			lo++
+		}
+		for hi < len(samples) && !samples[hi].Time.After(t.Add(half)) {
+			hi++
+		}
+		if hi > lo {
+			means[k] = vecScale(vecAdd(prefix[hi], vecScale(prefix[lo], -1)), 1/float64(hi-lo))
+			ok[k] = true
+		}
+	}
+	return means, ok
+}
+
+// imuForwardAxis находит «вперёд» датчика: в плоскости, перпендикулярной up, методом наименьших квадратов
+// подбирается направление, проекция ускорения на которое лучше всего совпадает с ускорением по GPS
+func imuForwardAxis(accelMean [][3]float64, accelOK []bool, up [3]float64, gravity float64, speed, accel []float64) ([3]float64, bool) {
+	if gravity == 0 {
+		return [3]float64{}, false
+	}
+	// базис плоскости: любой вектор, не параллельный up, и их векторное произведение
+	e1 := vecCross(up, [3]float64{1, 0, 0})
+	if vecLen(e1) < 0.5 {
+		e1 = vecCross(up, [3]float64{0, 1, 0})
+	}
+	e1 = vecScale(e1, 1/vecLen(e1))
+	e2 := vecCross(up, e1)
+
+	var sxx, sxy, syy, sx, sy float64
+	used := 0
+	for k := range accelMean {
+		if !accelOK[k] || speed[k] < imuMinSpeed {
+			continue
+		}
+		x := vecDot(accelMean[k], e1) / gravity * standardGravity
+		y := vecDot(accelMean[k], e2) / gravity * standardGravity
+		sxx, sxy, syy = sxx+x*x, sxy+x*y, syy+y*y
+		sx, sy = sx+x*accel[k], sy+y*accel[k]
+		used++
+	}
+	// на мотоцикле ускорения почти не выходят из плоскости «вперёд — вверх», и система вырождена:
+	// небольшая регуляризация оставляет решение в пределах того, что датчик видел
+	ridge := (sxx + syy) * 1e-3
+	sxx, syy = sxx+ridge, syy+ridge
+	det := sxx*syy - sxy*sxy
+	if used < 10*motionRate || det <= 0 {
+		return [3]float64{}, false
+	}
+	c1, c2 := (sx*syy-sy*sxy)/det, (sy*sxx-sx*sxy)/det
+	f := vecAdd(vecScale(e1, c1), vecScale(e2, c2))
+	if vecLen(f) == 0 {
+		return [3]float64{}, false
+	}
+	return vecScale(f, 1/vecLen(f)), true
+}
+
+// imuGyroSign — +1 или -1: с каким знаком вращение вокруг up совпадает с поворотами направо по GPS
+// (порядок и направление осей у камер и устройств разные)
+func imuGyroSign(gyroMean [][3]float64, gyroOK []bool, up [3]float64, speed, turnRate []float64) float64 {
+	corr := 0.0
+	for k := range gyroOK {
+		if gyroOK[k] && speed[k] >= imuMinSpeed {
+			corr += vecDot(gyroMean[k], up) * turnRate[k]
+		}
+	}
+	if corr < 0 {
+		return -1
+	}
+	return 1
+}
+
+// at — крен (градусы) и перегрузки (g) на момент t, линейно между узлами ряда
+func (m *motionSeries) at(t time.Time) (lean, latG, lonG float64) {
+	if m == nil || len(m.Lean) == 0 {
+		return 0, 0, 0
+	}
+	pos := math.Max(0, math.Min(float64(len(m.Lean)-1), t.Sub(m.Start).Seconds()*motionRate))
+	i := min(int(pos), len(m.Lean)-2)
+	if i < 0 {
+		return m.Lean[0], m.LatG[0], m.LonG[0]
+	}
+	r := pos - float64(i)
+	lerp := func(s []float64) float64 { return s[i] + (s[i+1]-s[i])*r }
+	return lerp(m.Lean), lerp(m.LatG), lerp(m.LonG)
+}
+
+func vecAdd(a, b [3]float64) [3]float64 { return [3]float64{a[0] + b[0], a[1] + b[1], a[2] + b[2]} }
+
+func vecScale(a [3]float64, k float64) [3]float64 { return [3]float64{a[0] * k, a[1] * k, a[2] * k} }
+
+func vecDot(a, b [3]float64) float64 { return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] }
+
+func vecLen(a [3]float64) float64 { return math.Sqrt(vecDot(a, a)) }
+
+func vecCross(a, b [3]float64) [3]float64 {
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+// --- Indicators ---
+
+// motionIndicator — крен со стороной наклона, боковая перегрузка со стороной поворота и продольная:
+// точка в круге перегрузок ходит только по своей оси
+func motionIndicator(name string, m *motionSeries, t time.Time) indicator {
+	lean, latG, lonG := m.at(t)
+	side := func(v, threshold float64) string {
+		switch {
+		case v >= threshold:
+			return " R"
+		case v <= -threshold:
+			return " L"
+		}
+		return ""
+	}
+	switch name {
+	case "lean_angle":
+		return indicator{
+			LiveIcon: func(dc *gg.Context, x, y, size, lineWidth float64) {
+				drawLeanIcon(dc, x, y, size, lineWidth, lean)
+			},
+			Value: fmt.Sprintf("%.0f°", math.Abs(lean)),
+			Unit:  side(math.Round(lean), 1),
+		}
+	case "lateral_g":
+		return indicator{
+			LiveIcon: func(dc *gg.Context, x, y, size, lineWidth float64) {
+				drawGForceIcon(dc, x, y, size, lineWidth, latG, 0)
+			},
+			Value: fmt.Sprintf("%.2f", math.Abs(latG)),
+			Unit:  " g" + side(latG, 0.005),
+		}
+	}
+	unit := " g"
+	if lonG <= -0.005 {
+		unit = " g brake"
+	}
+	return indicator{
+		LiveIcon: func(dc *gg.Context, x, y, size, lineWidth float64) {
+			drawGForceIcon(dc, x, y, size, lineWidth, 0, lonG)
+		},
+		Value: fmt.Sprintf("%.2f", math.Abs(lonG)),
+		Unit:  unit,
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// --- Passed Path ---
+
+// pathProjection — PathPoints в координатах тайлов (deg2num), посчитанные один раз на каждый зум:
+// без кэша каждый кадр заново проецирует весь пройденный путь
+type pathProjection struct {
+	points []Point
+	index  []int // PathPoints
+	mu     sync.Mutex
+	byZoom map[int][][2]float64
+}
+
+func newPathProjection(points []Point, index []int) *pathProjection {
+	return &pathProjection{points: points, index: index, byZoom: make(map[int][][2]float64)}
+}
+
+func (p *pathProjection) at(zoom int) [][2]float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if tiles, ok := p.byZoom[zoom]; ok {
+		return tiles
+	}
+	tiles := make([][2]float64, len(p.index))
+	for i, j := range p.index {
+		tiles[i][0], tiles[i][1] = deg2num(p.points[j].Lat, p.points[j].Lon, zoom)
+	}
+	p.byZoom[zoom] = tiles
+	return tiles
+}
+
+// passedPath — пройденный к кадру путь: точки points по индексам index[from:to] с шагом step и текущая
+// точка последней. Срез трека не копируется, а отрезки вне карты виджета отбрасываются, так что кадр стоит
+// столько, сколько отрезков видно, а не сколько пройдено
+type passedPath struct {
+	points             []Point
+	index              []int        // PathPoints
+	tiles              [][2]float64 // index в координатах тайлов зума кадра
+	from, to, step     int
+	current            Point
+	currentX, currentY float64
+	viewX, viewY       float64 // центр карты виджета в координатах тайлов
+	viewR              float64 // половина стороны видимого квадрата с запасом на толщину линии
+}
+
+func (p *passedPath) len() int {
+	return (max(0, p.to-p.from)+p.step-1)/p.step + 1
+}
+
+func (p *passedPath) at(i int) (Point, float64, float64) {
+	if i == p.len()-1 {
+		return p.current, p.currentX, p.currentY
+	}
+	j := p.from + i*p.step
+	return p.points[p.index[j]], p.tiles[j][0], p.tiles[j][1]
+}
+
+// segment — отрезок от точки i-1 до точки i в координатах тайлов и его конечная точка;
+// ok=false, если отрезок целиком вне видимого квадрата
+func (p *passedPath) segment(i int) (pt Point, x1, y1, x2, y2 float64, ok bool) {
+	_, x1, y1 = p.at(i - 1)
+	pt, x2, y2 = p.at(i)
+	ok = math.Max(x1, x2) >= p.viewX-p.viewR && math.Min(x1, x2) <= p.viewX+p.viewR &&
+		math.Max(y1, y2) >= p.viewY-p.viewR && math.Min(y1, y2) <= p.viewY+p.viewR
+	return pt, x1, y1, x2, y2, ok
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// --- Path Color Ramp ---
+
+const (
+	pathColorBySpeed = "speed"
+	pathColorBySlope = "slope"
+	pathColorByHR    = "hr"
+)
+
+// parseColorRamp разбирает список цветов через запятую, от минимума метрики к максимуму
+func parseColorRamp(s string) ([]color.Color, error) {
+	var ramp []color.Color
+	for _, part := range strings.Split(s, ",") {
+		c, err := parseHexColor(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("bad color %q in ramp: %w", part, err)
+		}
+		ramp = append(ramp, c)
+	}
+	if len(ramp) < 2 {
+		return nil, fmt.Errorf("color ramp needs at least two colors, got %q", s)
+	}
+	return ramp, nil
+}
+
+// pathMetric — значение метрики, по которой красится путь; false, если в точке её нет (пульс без датчика)
+func pathMetric(p Point, by string) (float64, bool) {
+	switch by {
+	case pathColorBySpeed:
+		return p.Speed, true
+	case pathColorBySlope:
+		return p.SmoothedSlope, true
+	case pathColorByHR:
+		return p.AvgHeartRate, p.AvgHeartRate > 0
+	}
+	return 0, false
+}
+
+// preparePathColors подбирает границы шкалы под показываемый диапазон трека, если они не заданы флагами.
+// Скорость считаем от нуля, чтобы стоянки всегда были началом шкалы
+func preparePathColors(track *Track, args *Arguments) {
+	if args.PathColorBy == "" {
+		return
+	}
+	if args.PathColorMin != 0 || args.PathColorMax != 0 {
+		return
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, p := range track.SmoothedPoints[track.RenderFromIndex:track.RenderToIndex] {
+		if v, ok := pathMetric(p, args.PathColorBy); ok {
+			lo = math.Min(lo, v)
+			hi = math.Max(hi, v)
+		}
+	}
+	if math.IsInf(lo, 1) {
+		return
+	}
+	if args.PathColorBy == pathColorBySpeed {
+		lo = 0
+	}
+	args.PathColorMin, args.PathColorMax = lo, hi
+}
+
+// rampColor — цвет шкалы ramp для значения v в диапазоне [lo, hi], с линейной интерполяцией между соседними цветами
+func rampColor(ramp []color.Color, v, lo, hi float64) color.Color {
+	if hi <= lo {
+		return ramp[0]
+	}
+	t := math.Max(0, math.Min(1, (v-lo)/(hi-lo))) * float64(len(ramp)-1)
+	i := min(int(t), len(ramp)-2)
+	f := t - float64(i)
+	r1, g1, b1, _ := ramp[i].RGBA()
+	r2, g2, b2, _ := ramp[i+1].RGBA()
+	mix := func(a, b uint32) uint8 {
+		return uint8((float64(a)*(1-f) + float64(b)*f) / 257)
+	}
+	return color.RGBA{mix(r1, r2), mix(g1, g2), mix(b1, b2), 255}
+}
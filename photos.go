@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/draw"
+)
+
+// --- Structs ---
+
+// Photo — снимок из -photos, привязанный по времени съёмки к точке трека
+type Photo struct {
+	Path  string
+	Time  time.Time
+	Index int         // ближайшая по времени точка в SmoothedPoints
+	Image image.Image // уменьшенная копия для кадра
+}
+
+// exifInfo — то немногое из EXIF, что нужно для привязки снимка
+type exifInfo struct {
+	DateTime    string // "2006:01:02 15:04:05"
+	Offset      string // OffsetTimeOriginal, "+03:00"; пусто, если камера его не пишет
+	Orientation int
+}
+
+const (
+	exifTimeLayout = "2006:01:02 15:04:05"
+	exifReadLimit  = 256 << 10 // APP1 с EXIF лежит в начале файла и не длиннее 64 КБ
+	photoMaxWidth  = 0.6       // размеры снимка в кадре относительно ширины виджета:
+	photoMaxHeight = 0.36      // так он помещается над центром карты, не закрывая маркер
+)
+
+// --- Loading ---
+
+// loadPhotos читает JPEG из каталога и привязывает их к треку по времени съёмки из EXIF.
+// Время без часового пояса считается временем пояса loc (-timezone); clockOffset поправляет часы камеры.
+// Снимки без EXIF или снятые вне трека пропускаются.
+func loadPhotos(dir string, points []Point, clockOffset time.Duration, loc *time.Location, widgetSize int) ([]Photo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read photo directory: %w", err)
+	}
+	maxWidth, maxHeight := float64(widgetSize)*photoMaxWidth, float64(widgetSize)*photoMaxHeight
+	first, last := points[0].Timestamp, points[len(points)-1].Timestamp
+
+	var photos []Photo
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.IsDir() || (ext != ".jpg" && ext != ".jpeg") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		info, err := readExif(path)
+		if err != nil {
+			log.Printf("Skipping photo %s: %v", path, err)
+			continue
+		}
+		t, err := info.time(loc)
+		if err != nil {
+			log.Printf("Skipping photo %s: %v", path, err)
+			continue
+		}
+		t = t.Add(clockOffset)
+		if t.Before(first) || t.After(last) {
+			log.Printf("Skipping photo %s: taken at %s, outside the track", path, t.Format(time.RFC3339))
+			continue
+		}
+
+		img, err := loadPhotoThumbnail(path, maxWidth, maxHeight, info.Orientation)
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, Photo{Path: path, Time: t, Index: min(indexAtTime(points, t), len(points)-1), Image: img})
+	}
+	sort.Slice(photos, func(i, j int) bool { return photos[i].Time.Before(photos[j].Time) })
+	return photos, nil
+}
+
+// loadPhotoThumbnail вписывает снимок в maxWidth x maxHeight и поворачивает по EXIF Orientation
+func loadPhotoThumbnail(path string, maxWidth, maxHeight float64, orientation int) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode photo %s: %w", path, err)
+	}
+
+	b := src.Bounds()
+	shownWidth, shownHeight := float64(b.Dx()), float64(b.Dy())
+	if orientation == 6 || orientation == 8 {
+		shownWidth, shownHeight = shownHeight, shownWidth
+	}
+	k := math.Min(1, math.Min(maxWidth/shownWidth, maxHeight/shownHeight))
+	dst := image.NewRGBA(image.Rect(0, 0, max(1, int(float64(b.Dx())*k)), max(1, int(float64(b.Dy())*k))))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	return orientImage(dst, orientation), nil
+}
+
+// orientImage поворачивает снимок так, как его показал бы фотоаппарат (значения 3, 6, 8 EXIF Orientation;
+// зеркальные варианты встречаются редко и не поддерживаются)
+func orientImage(img *image.RGBA, orientation int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	var out *image.RGBA
+	switch orientation {
+	case 3:
+		out = image.NewRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				out.SetRGBA(w-1-x, h-1-y, img.RGBAAt(x, y))
+			}
+		}
+	case 6: // повернуть на 90° по часовой
+		out = image.NewRGBA(image.Rect(0, 0, h, w))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				out.SetRGBA(h-1-y, x, img.RGBAAt(x, y))
+			}
+		}
+	case 8: // на 90° против часовой
+		out = image.NewRGBA(image.Rect(0, 0, h, w))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				out.SetRGBA(y, w-1-x, img.RGBAAt(x, y))
+			}
+		}
+	default:
+		return img
+	}
+	return out
+}
+
+// --- EXIF ---
+
+func (e exifInfo) time(loc *time.Location) (time.Time, error) {
+	if e.DateTime == "" {
+		return time.Time{}, errors.New("no EXIF capture time")
+	}
+	if e.Offset != "" {
+		return time.Parse(exifTimeLayout+"-07:00", e.DateTime+e.Offset)
+	}
+	return time.ParseInLocation(exifTimeLayout, e.DateTime, loc)
+}
+
+// readExif разбирает APP1-сегмент JPEG: IFD0 (DateTime, Orientation) и Exif IFD
+// (DateTimeOriginal, OffsetTimeOriginal)
+func readExif(path string) (exifInfo, error) {
+	var info exifInfo
+	f, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+	data := make([]byte, exifReadLimit)
+	n, err := io.ReadFull(f, data)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return info, err
+	}
+	data = data[:n]
+
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return info, errors.New("not a JPEG file")
+	}
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			break
+		}
+		marker := data[pos+1]
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if marker == 0xDA || length < 2 || pos+2+length > len(data) { // дальше сжатые данные или битый сегмент
+			break
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return parseTiff(segment[6:])
+		}
+		pos += 2 + length
+	}
+	return info, errors.New("no EXIF data")
+}
+
+func parseTiff(tiff []byte) (exifInfo, error) {
+	var info exifInfo
+	if len(tiff) < 8 {
+		return info, errors.New("truncated EXIF")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return info, errors.New("invalid EXIF byte order")
+	}
+
+	var dateTime, dateTimeOriginal string
+	var walk func(offset uint32, root bool)
+	walk = func(offset uint32, root bool) {
+		if int(offset)+2 > len(tiff) {
+			return
+		}
+		count := int(order.Uint16(tiff[offset:]))
+		for i := 0; i < count; i++ {
+			entry := int(offset) + 2 + i*12
+			if entry+12 > len(tiff) {
+				return
+			}
+			tag := order.Uint16(tiff[entry:])
+			switch tag {
+			case 0x0112:
+				info.Orientation = int(order.Uint16(tiff[entry+8:]))
+			case 0x0132:
+				dateTime = tiffString(tiff, entry, order)
+			case 0x9003:
+				dateTimeOriginal = tiffString(tiff, entry, order)
+			case 0x9011:
+				info.Offset = tiffString(tiff, entry, order)
+			case 0x8769: // указатель на Exif IFD, бывает только в IFD0
+				if root {
+					walk(order.Uint32(tiff[entry+8:]), false)
+				}
+			}
+		}
+	}
+	walk(order.Uint32(tiff[4:]), true)
+
+	info.DateTime = dateTimeOriginal
+	if info.DateTime == "" {
+		info.DateTime = dateTime
+	}
+	return info, nil
+}
+
+// tiffString читает ASCII-значение записи IFD: до 4 байт хранятся прямо в записи, длиннее — по смещению
+func tiffString(tiff []byte, entry int, order binary.ByteOrder) string {
+	count := int(order.Uint32(tiff[entry+4:]))
+	start := entry + 8
+	if count > 4 {
+		start = int(order.Uint32(tiff[entry+8:]))
+	}
+	if start < 0 || start+count > len(tiff) {
+		return ""
+	}
+	return strings.TrimRight(string(tiff[start:start+count]), "\x00 ")
+}
+
+// --- Rendering ---
+
+// drawPhotoPins отмечает на карте места уже показанных снимков
+func drawPhotoPins(dc *gg.Context, track *Track, currentPoint Point, viewX, viewY float64, zoom int, residualMapScale, centerX, centerY float64, args *Arguments) {
+	radius := float64(args.WidgetSize) / 70
+	for _, photo := range track.Photos {
+		if photo.Time.After(currentPoint.Timestamp) {
+			break
+		}
+		p := track.SmoothedPoints[photo.Index]
+		px, py := deg2num(p.Lat, p.Lon, zoom)
+		x := centerX + (px*float64(args.TileSize)-viewX)/residualMapScale
+		y := centerY + (py*float64(args.TileSize)-viewY)/residualMapScale
+		if math.Hypot(x-centerX, y-centerY) > float64(args.WidgetSize) {
+			continue
+		}
+		// булавка: кружок над точкой съёмки и ножка к ней
+		dc.SetColor(color.RGBA{230, 120, 20, 255})
+		dc.MoveTo(x, y)
+		dc.LineTo(x-radius*0.7, y-radius*1.6)
+		dc.LineTo(x+radius*0.7, y-radius*1.6)
+		dc.ClosePath()
+		dc.Fill()
+		dc.DrawCircle(x, y-radius*2, radius)
+		dc.FillPreserve()
+		dc.SetColor(color.White)
+		dc.SetLineWidth(radius / 3)
+		dc.Stroke()
+	}
+}
+
+// drawPhoto показывает снимок в рамке в течение duration секунд после момента съёмки,
+// проявляя и гася его за transition секунд
+func drawPhoto(dc *gg.Context, photos []Photo, currentPoint Point, centerX, centerY float64, args *Arguments) {
+	for k := len(photos) - 1; k >= 0; k-- {
+		photo := photos[k]
+		since := currentPoint.Timestamp.Sub(photo.Time).Seconds()
+		if since < 0 {
+			continue
+		}
+		if since > args.PhotoDuration {
+			return
+		}
+
+		alpha := 1.0
+		if args.PhotoTransition > 0 {
+			alpha = math.Min(1, math.Min(since, args.PhotoDuration-since)/args.PhotoTransition)
+		}
+		b := photo.Image.Bounds()
+		frame := float64(args.WidgetSize) / 100
+		x := centerX - float64(b.Dx())/2
+		y := centerY - float64(b.Dy())/2
+
+		card := gg.NewContext(b.Dx()+int(2*frame), b.Dy()+int(2*frame))
+		card.SetColor(color.White)
+		card.DrawRoundedRectangle(0, 0, float64(card.Width()), float64(card.Height()), frame)
+		card.Fill()
+		card.DrawImage(photo.Image, int(frame), int(frame))
+		cardImg := card.Image().(*image.RGBA)
+		if alpha < 1 {
+			fadeImage(cardImg, alpha)
+		}
+		dc.DrawImage(cardImg, int(x-frame), int(y-frame))
+		return
+	}
+}
@@ -2,30 +2,52 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	"image/png"
 	"log"
 	"math"
+	"math/rand"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fogleman/gg"
-	"github.com/schollz/progressbar/v3"
 )
 
 // --- Structs ---
 
+// MapStyle — источник тайлов XYZ: встроенный, из -styles-file или -tile-url
 type MapStyle struct {
-	Name    string
-	URL     string
-	Headers map[string]string
+	Name        string            `yaml:"name"`
+	URL         string            `yaml:"url"`        // шаблон с {z}, {x}, {y} и необязательными {r}, {s}, {apikey}
+	Subdomains  []string          `yaml:"subdomains"` // поддомены для {s}; по умолчанию a, b, c
+	Headers     map[string]string `yaml:"headers"`
+	MinZoom     int               `yaml:"min_zoom"`  // мельче — пустой тайл
+	MaxZoom     int               `yaml:"max_zoom"`  // 0 — без ограничения; глубже — увеличенный тайл max_zoom
+	TileSize    int               `yaml:"tile_size"` // px тайлов сервера; 0 — 256, с -2x 512
+	Attribution string            `yaml:"attribution"`
+	APIKey      string            `yaml:"api_key"`
+	APIKeyEnv   string            `yaml:"api_key_env"` // переменная окружения с ключом, важнее api_key
+	// MaxConnections ограничивает параллельные загрузки при prefetch (0 — tileFetchConcurrency
+	// на каждый поддомен); серверы вроде tile.openstreetmap.org запрещают массовое скачивание
+	MaxConnections int `yaml:"max_connections"`
+
+	// источники WMS и WMTS (type: wms или wmts); url у них — адрес сервиса
+	Type             string `yaml:"type"`   // xyz (по умолчанию), wms или wmts
+	Layers           string `yaml:"layers"` // LAYERS для WMS, LAYER для WMTS
+	LayerStyle       string `yaml:"layer_style"`
+	Format           string `yaml:"format"` // по умолчанию image/png
+	Version          string `yaml:"version"`
+	TileMatrixSet    string `yaml:"tile_matrix_set"`    // WMTS, по умолчанию GoogleMapsCompatible
+	TileMatrixPrefix string `yaml:"tile_matrix_prefix"` // WMTS: идентификатор матрицы — префикс и зум, например "EPSG:3857:"
 }
 
 type Tile struct {
@@ -33,20 +55,26 @@ type Tile struct {
 }
 
 var mapStyles = map[string]MapStyle{
-	"default":       {Name: "default", URL: "https://tile.openstreetmap.org/{z}/{x}/{y}.png"},
-	"cyclosm":       {Name: "cyclosm", URL: "https://c.tile-cyclosm.openstreetmap.fr/cyclosm/{z}/{x}/{y}.png"},
-	"toner":         {Name: "toner", URL: "https://tiles.stadiamaps.com/tiles/stamen_toner/{z}/{x}/{y}.png", Headers: map[string]string{"Referer": "https://mc.bbbike.org/"}},
-	"clockwork":     {Name: "clockwork", URL: "https://maps.clockworkmicro.com/streets/v1/raster/{z}/{x}/{y}?x-api-key=2d33HqvhuU3z6lPsPOqQR6Zwl2LQ2pmo9NnWbboL"},
-	"thunderforest": {Name: "thunderforest", URL: "https://tile.thunderforest.com/outdoors/{z}/{x}/{y}.png?apikey=6170aad10dfd42a38d4d8c709a536f38"},
-	"positron":      {Name: "positron", URL: "https://d.basemaps.cartocdn.com/light_all/{z}/{x}/{y}.png"},
-	"outdoor":       {Name: "outdoor", URL: "https://api.maptiler.com/maps/outdoor-v2/256/{z}/{x}/{y}.png?key=jsK0th32A1xWq2x6QeVu"},
+	"default":       {Name: "default", URL: "https://tile.openstreetmap.org/{z}/{x}/{y}.png", MaxZoom: 19, MaxConnections: osmMaxConnections, Attribution: "© OpenStreetMap contributors"},
+	"cyclosm":       {Name: "cyclosm", URL: "https://{s}.tile-cyclosm.openstreetmap.fr/cyclosm/{z}/{x}/{y}.png", Attribution: "© CyclOSM, © OpenStreetMap contributors"},
+	"toner":         {Name: "toner", URL: "https://tiles.stadiamaps.com/tiles/stamen_toner/{z}/{x}/{y}.png", Headers: map[string]string{"Referer": "https://mc.bbbike.org/"}, Attribution: "© Stadia Maps, © Stamen Design, © OpenStreetMap contributors"},
+	"clockwork":     {Name: "clockwork", URL: "https://maps.clockworkmicro.com/streets/v1/raster/{z}/{x}/{y}?x-api-key={apikey}", APIKeyEnv: "CLOCKWORK_API_KEY", Attribution: "© Clockwork Micro, © OpenStreetMap contributors"},
+	"thunderforest": {Name: "thunderforest", URL: "https://tile.thunderforest.com/outdoors/{z}/{x}/{y}.png?apikey={apikey}", APIKeyEnv: "THUNDERFOREST_API_KEY", Attribution: "© Thunderforest, © OpenStreetMap contributors"},
+	"positron":      {Name: "positron", URL: "https://{s}.basemaps.cartocdn.com/light_all/{z}/{x}/{y}.png", Subdomains: []string{"a", "b", "c", "d"}, Attribution: "© CARTO, © OpenStreetMap contributors"},
+	"outdoor":       {Name: "outdoor", URL: "https://api.maptiler.com/maps/outdoor-v2/256/{z}/{x}/{y}.png?key={apikey}", APIKeyEnv: "MAPTILER_API_KEY", Attribution: "© MapTiler, © OpenStreetMap contributors"},
 }
 
 var (
-	tileCache       sync.Map // Concurrent map for caching original tiles
-	scaledTileCache = make(map[string]map[Tile]image.Image)
+	tileCache        = newTileLRU()             // исходные и предмасштабированные тайлы, -tile-cache-mem
+	scaledTileScales = make(map[string]float64) // остаточные масштабы с предмасштабированными тайлами, по ключу "%.4f"
+	unavailableTiles sync.Map                   // пути тайлов, не скачавшихся после всех попыток или, с -offline, не найденных в кэше
+	tileDownloads    atomic.Int64               // тайлы, которые пришлось скачивать
+	tileFailures     atomic.Int64               // из них так и не скачавшиеся
 )
 
+// errNo2x — сервер стиля не отдаёт тайлы @2x. Это не сбой отдельного тайла: пустой была бы вся карта
+var errNo2x = errors.New("does not support 2x")
+
 // --- Tile Downloading & Caching ---
 
 func getTileImage(style string, z, x, y int, args *Arguments) (image.Image, error) {
@@ -59,10 +87,20 @@ func getTileImage(style string, z, x, y int, args *Arguments) (image.Image, erro
 	if args.Is2x {
 		tileName = fmt.Sprintf("%d@2x.png", y)
 	}
-	tilePath := filepath.Join(tileCacheDir, styleInfo.Name, strconv.Itoa(z), strconv.Itoa(x), tileName)
+	tilePath := filepath.Join(args.TileCacheDir, styleInfo.Name, strconv.Itoa(z), strconv.Itoa(x), tileName)
 
-	if img, ok := tileCache.Load(tilePath); ok {
-		return img.(image.Image), nil
+	if img, ok := tileCache.load(tilePath); ok {
+		return img, nil
+	}
+	if _, ok := unavailableTiles.Load(tilePath); ok || z < styleInfo.MinZoom {
+		return placeholderTile(args), nil
+	}
+	if styleInfo.MaxZoom > 0 && z > styleInfo.MaxZoom {
+		img, err := overzoomTile(style, z, x, y, styleInfo.MaxZoom, args)
+		if err == nil {
+			tileCache.store(tilePath, img)
+		}
+		return img, err
 	}
 
 	if _, err := os.Stat(tilePath); err == nil {
@@ -75,31 +113,89 @@ func getTileImage(style string, z, x, y int, args *Arguments) (image.Image, erro
 		if err != nil {
 			return nil, err
 		}
-		if args.Is2x && (img.Bounds().Dx() != 512 || img.Bounds().Dy() != 512) {
-			return nil, fmt.Errorf("style %s does not support 2x: tile is %dx%d", style, img.Bounds().Dx(), img.Bounds().Dy())
+		if err := styleInfo.checkTileSize(img, args); err != nil {
+			return nil, err
 		}
+		img = styleInfo.fitTileSize(img, args)
 		if args.MapBrightness != 0 || args.MapContrast != 1 {
 			img = adjustBrightnessContrast(img, args.MapBrightness, args.MapContrast)
 		}
-		tileCache.Store(tilePath, img)
+		img = toRGBA(img)
+		tileCache.store(tilePath, img)
 		return img, nil
 	}
 
+	if args.Offline {
+		// без сети недостающий тайл сразу пустой; сколько их было, пишет reportUnavailableTiles
+		unavailableTiles.Store(tilePath, struct{}{})
+		return placeholderTile(args), nil
+	}
+
 	// Download
-	url := strings.Replace(styleInfo.URL, "{z}", strconv.Itoa(z), 1)
-	url = strings.Replace(url, "{x}", strconv.Itoa(x), 1)
-	url = strings.Replace(url, "{y}", strconv.Itoa(y), 1)
-	if args.Is2x {
-		if strings.Contains(url, "outdoor-v2/256") {
-			url = strings.Replace(url, "outdoor-v2/256", "outdoor-v2", 1)
-		} else {
-			url = strings.Replace(url, ".png", "@2x.png", 1)
+	tileDownloads.Add(1)
+	img, err := fetchTile(styleInfo, z, x, y, args)
+	if errors.Is(err, errNo2x) {
+		log.Fatalf("%v; run with -2x=false", err)
+	}
+	if err != nil {
+		// один недоступный тайл не должен обрывать рендер: на его месте будет пустой тайл
+		log.Printf("Warning: %v; using a blank tile", err)
+		tileFailures.Add(1)
+		unavailableTiles.Store(tilePath, struct{}{})
+		return placeholderTile(args), nil
+	}
+
+	if err := styleInfo.checkTileSize(img, args); err != nil {
+		return nil, err
+	}
+
+	os.MkdirAll(filepath.Dir(tilePath), 0755)
+	out, err := os.Create(tilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	// Re-encode to PNG to save
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+	out.Write(buf.Bytes())
+
+	img = styleInfo.fitTileSize(img, args)
+	if args.MapBrightness != 0 || args.MapContrast != 1 {
+		img = adjustBrightnessContrast(img, args.MapBrightness, args.MapContrast)
+	}
+	img = toRGBA(img)
+
+	tileCache.store(tilePath, img)
+	return img, nil
+}
+
+// fetchTile скачивает тайл, повторяя временные ошибки (таймаут, сеть, 429, 5xx) до -tile-retries раз.
+// Пауза удваивается с каждой попыткой и случайно растягивается или сжимается вдвое, чтобы параллельные
+// загрузки не возвращались к серверу одновременно. URL строится заново на каждую попытку, так что
+// у стилей с {s} повтор уходит на следующий поддомен
+func fetchTile(s MapStyle, z, x, y int, args *Arguments) (image.Image, error) {
+	delay := time.Duration(args.TileRetryDelay * float64(time.Second))
+	for attempt := 0; ; attempt++ {
+		img, retry, err := downloadTile(s.tileURL(z, x, y, args), s, args)
+		err = s.redact(err)
+		if err == nil || !retry || attempt >= args.TileRetries {
+			return img, err
 		}
+		wait := time.Duration(float64(delay<<attempt) * (0.5 + rand.Float64()))
+		log.Printf("%v; retrying in %s (%d/%d)", err, wait.Round(time.Millisecond), attempt+1, args.TileRetries)
+		time.Sleep(wait)
 	}
+}
 
+// downloadTile — одна попытка загрузки; retry — ошибка временная и запрос стоит повторить
+func downloadTile(url string, s MapStyle, args *Arguments) (img image.Image, retry bool, err error) {
 	req, _ := http.NewRequest("GET", url, nil)
 	req.Header.Set("User-Agent", "GpsOverlayVideoGo/0.1")
-	for k, v := range styleInfo.Headers {
+	for k, v := range s.Headers {
 		req.Header.Set(k, v)
 	}
 
@@ -108,49 +204,67 @@ func getTileImage(style string, z, x, y int, args *Arguments) (image.Image, erro
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		if os.IsTimeout(err) {
-			log.Fatalf("Tile download timed out after 3 seconds for %s: %v", url, err)
-		}
-		return nil, fmt.Errorf("failed to download tile %s: %w", url, err)
+		return nil, true, fmt.Errorf("failed to download tile %s: %w", url, err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound && args.Is2x {
-		return nil, fmt.Errorf("style %s does not support 2x (got 404 for tile: %s)", style, url)
+	// 404 на адрес, куда @2x дописали мы сами, значит, что сервер не знает @2x; в остальных случаях
+	// (tile_size, {r}, WMS/WMTS, слой вне своего покрытия) пропал только этот тайл
+	if resp.StatusCode == http.StatusNotFound && s.appends2x(args) {
+		return nil, false, fmt.Errorf("style %s %w (got 404 for tile: %s)", s.Name, errNo2x, url)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to download tile %s: status %d", url, resp.StatusCode)
+		retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return nil, retry, fmt.Errorf("failed to download tile %s: status %d", url, resp.StatusCode)
 	}
 
-	img, _, err := image.Decode(resp.Body)
+	img, _, err = image.Decode(resp.Body)
 	if err != nil {
-		return nil, err
+		// обрыв соединения посреди ответа тоже временный
+		return nil, true, fmt.Errorf("failed to read tile %s: %w", url, err)
 	}
+	return img, false, nil
+}
 
-	if args.Is2x && (img.Bounds().Dx() != 512 || img.Bounds().Dy() != 512) {
-		return nil, fmt.Errorf("style %s does not support 2x: downloaded tile is %dx%d", style, img.Bounds().Dx(), img.Bounds().Dy())
-	}
+// placeholderTile — ровный серый тайл на месте недоступного, один на весь рендер
+func placeholderTile(args *Arguments) image.Image {
+	placeholderOnce.Do(func() {
+		placeholder = image.NewRGBA(image.Rect(0, 0, args.TileSize, args.TileSize))
+		draw.Draw(placeholder, placeholder.Bounds(), image.NewUniform(color.RGBA{R: 0xDD, G: 0xDD, B: 0xDD, A: 0xFF}), image.Point{}, draw.Src)
+	})
+	return placeholder
+}
 
-	os.MkdirAll(filepath.Dir(tilePath), 0755)
-	out, err := os.Create(tilePath)
-	if err != nil {
-		return nil, err
+// reportUnavailableTiles в конце работы сообщает, сколько тайлов нарисовано пустыми
+func reportUnavailableTiles(args *Arguments) {
+	n := 0
+	unavailableTiles.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	switch {
+	case n == 0:
+	case args.Offline:
+		log.Printf("Warning: %d map tiles were not in %s and were drawn blank; run once without -offline to download them", n, args.TileCacheDir)
+	default:
+		log.Printf("Warning: %d map tiles could not be downloaded and were drawn blank", n)
 	}
-	defer out.Close()
+}
 
-	// Re-encode to PNG to save
-	buf := new(bytes.Buffer)
-	if err := png.Encode(buf, img); err != nil {
-		return nil, err
-	}
-	out.Write(buf.Bytes())
+var (
+	placeholderOnce sync.Once
+	placeholder     *image.RGBA
+)
 
-	if args.MapBrightness != 0 || args.MapContrast != 1 {
-		img = adjustBrightnessContrast(img, args.MapBrightness, args.MapContrast)
+// toRGBA один раз переводит тайл в *image.RGBA с предумноженной альфой: PNG декодируется
+// в NRGBA или палитру, и DrawImage с масштабированием на таких типах идут медленным общим путём
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
 	}
-
-	tileCache.Store(tilePath, img)
-	return img, nil
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
 }
 
 func adjustBrightnessContrast(img image.Image, brightness, contrast float64) image.Image {
@@ -182,20 +296,31 @@ func adjustBrightnessContrast(img image.Image, brightness, contrast float64) ima
 	return newImg
 }
 
-func getAllTilesForTrack(track *Track, args *Arguments) map[Tile]struct{} {
+// getTilesForPoints собирает тайлы, попадающие в виджет вокруг каждой из точек
+func getTilesForPoints(points []Point, args *Arguments) map[Tile]struct{} {
 	tileCoords := make(map[Tile]struct{})
 
-	for _, p := range track.SmoothedPoints {
-		widgetRadiusPx := float64(args.WidgetSize) / 2.0
+	// на частых записях соседние точки дают одни и те же тайлы: пропускаем точки, сдвинувшиеся
+	// меньше чем на tilePrefetchStep, а радиус расширяем на этот шаг, чтобы ничего не потерять
+	lastZoom, lastScale, lastPx, lastPy := -1, 0.0, 0.0, 0.0
+	for _, p := range points {
+		widgetRadiusPx := mapCoverageRadius(args)
 
 		adjustedMapZoom := p.TileZoom
 		residualMapScale := p.ResidualMapScale
-		effectiveWidgetRadiusPx := widgetRadiusPx * residualMapScale
+		effectiveWidgetRadiusPx := widgetRadiusPx*residualMapScale + tilePrefetchStep
 
-		worldPx, worldPy := deg2num(p.Lat, p.Lon, adjustedMapZoom)
+		viewLat, viewLon := mapViewCenter(p)
+		worldPx, worldPy := deg2num(viewLat, viewLon, adjustedMapZoom)
 		worldPx *= float64(args.TileSize)
 		worldPy *= float64(args.TileSize)
 
+		if adjustedMapZoom == lastZoom && math.Abs(residualMapScale-lastScale) < 0.01 &&
+			math.Hypot(worldPx-lastPx, worldPy-lastPy) < tilePrefetchStep/2 {
+			continue
+		}
+		lastZoom, lastScale, lastPx, lastPy = adjustedMapZoom, residualMapScale, worldPx, worldPy
+
 		px_min := worldPx - effectiveWidgetRadiusPx
 		py_min := worldPy - effectiveWidgetRadiusPx
 		px_max := worldPx + effectiveWidgetRadiusPx
@@ -217,24 +342,38 @@ func getAllTilesForTrack(track *Track, args *Arguments) map[Tile]struct{} {
 
 func prefetchTiles(allTiles map[Tile]struct{}, args *Arguments) {
 	log.Println("Prefetching map tiles...")
-	bar := progressbar.Default(int64(len(allTiles)), "Downloading Tiles")
+	bar := newProgressBar(len(allTiles), "Downloading Tiles")
 	var wg sync.WaitGroup
-	limit := make(chan struct{}, tileFetchConcurrency)
+	// загрузки по кругу расходятся по поддоменам {s}, и каждый шард сервера получает свои tileFetchConcurrency потоков;
+	// с -overlay-style каждая загрузка идёт на оба сервера, поэтому действует более строгий предел
+	connections := mapStyles[args.MapStyle].connections()
+	if args.OverlayStyle != "" {
+		connections = min(connections, mapStyles[args.OverlayStyle].connections())
+	}
+	limit := make(chan struct{}, connections)
 
 	for tile := range allTiles {
 		wg.Add(1)
 		limit <- struct{}{}
 		go func(t Tile) {
 			defer wg.Done()
-			getTileImage(args.MapStyle, t.Z, t.X, t.Y, args)
+			getMapTile(t.Z, t.X, t.Y, args)
 			bar.Add(1)
 			<-limit
 			time.Sleep(time.Second / 20) // Rate limit to 20 tiles per second
 		}(tile)
 	}
 	wg.Wait()
+
+	// отдельные пустые тайлы терпимы, а карта, где пустых большинство, значит, что сервер недоступен
+	if downloads, failures := tileDownloads.Load(), tileFailures.Load(); downloads >= minTilesForFailureCheck && failures*2 > downloads {
+		log.Fatalf("%d of %d map tiles could not be downloaded; check the network and -style, or use -offline to render with what is cached", failures, downloads)
+	}
 }
 
+// minTilesForFailureCheck — меньше скачанных тайлов слишком мало, чтобы судить о доступности сервера
+const minTilesForFailureCheck = 10
+
 func cacheScaledTiles(uniqueScales map[float64]struct{}, allTiles map[Tile]struct{}, args *Arguments) {
 	if len(uniqueScales) == 0 {
 		return
@@ -254,7 +393,7 @@ func cacheScaledTiles(uniqueScales map[float64]struct{}, allTiles map[Tile]struc
 		residualMapScale := scale / math.Pow(2, zoomOutLevels)
 		scaleKey := fmt.Sprintf("%.4f", residualMapScale)
 
-		if _, exists := scaledTileCache[scaleKey]; exists {
+		if _, exists := scaledTileScales[scaleKey]; exists {
 			continue
 		}
 
@@ -264,30 +403,53 @@ func cacheScaledTiles(uniqueScales map[float64]struct{}, allTiles map[Tile]struc
 		}
 
 		log.Printf("Pre-scaling tiles for residual scale %.4f (%.2fx)...", residualMapScale, scalingFactor)
-		scaledTileCache[scaleKey] = make(map[Tile]image.Image)
-		bar := progressbar.Default(int64(len(allTiles)))
+		scaledTileScales[scaleKey] = residualMapScale
+		bar := newProgressBar(len(allTiles), "")
 
 		for tile := range allTiles {
 			bar.Add(1)
-			originalImg, err := getTileImage(args.MapStyle, tile.Z, tile.X, tile.Y, args)
-			if err != nil {
-				log.Printf("could not get tile for scaling %v", err)
-				continue
-			}
-
-			scaledWidth := int(float64(originalImg.Bounds().Dx()) * scalingFactor)
-			scaledHeight := int(float64(originalImg.Bounds().Dy()) * scalingFactor)
+			getScaledTile(scaleKey, tile, args)
+		}
+	}
+}
 
-			if scaledWidth == 0 || scaledHeight == 0 {
-				continue
-			}
+// getScaledTile — тайл, предмасштабированный под остаточный масштаб scaleKey из scaledTileScales;
+// выброшенный из памяти масштабируется заново. nil — исходного тайла нет
+func getScaledTile(scaleKey string, tile Tile, args *Arguments) image.Image {
+	key := scaledTileKey{scale: scaleKey, tile: tile}
+	if img, ok := tileCache.load(key); ok {
+		return img
+	}
+	originalImg, err := getMapTile(tile.Z, tile.X, tile.Y, args)
+	if err != nil {
+		log.Printf("could not get tile for scaling %v", err)
+		return nil
+	}
 
-			dc := gg.NewContext(scaledWidth, scaledHeight)
-			dc.Scale(scalingFactor, scalingFactor)
-			dc.DrawImage(originalImg, 0, 0)
-			scaledImg := dc.Image()
+	scalingFactor := 1.0 / scaledTileScales[scaleKey]
+	scaledWidth := int(float64(originalImg.Bounds().Dx()) * scalingFactor)
+	scaledHeight := int(float64(originalImg.Bounds().Dy()) * scalingFactor)
 
-			scaledTileCache[scaleKey][tile] = scaledImg
-		}
+	if scaledWidth == 0 || scaledHeight == 0 {
+		return nil
 	}
+
+	dc := gg.NewContext(scaledWidth, scaledHeight)
+	dc.Scale(scalingFactor, scalingFactor)
+	dc.DrawImage(originalImg, 0, 0)
+	scaledImg := dc.Image()
+
+	tileCache.store(key, scaledImg)
+	return scaledImg
+}
+
+// loadTileWindow заменяет содержимое кэшей тайлами для points: при -chunk-minutes
+// в памяти держатся только тайлы текущего куска видео
+func loadTileWindow(points []Point, scales map[float64]struct{}, args *Arguments) {
+	tileCache.reset()
+	scaledTileScales = make(map[string]float64)
+
+	tiles := getTilesForPoints(points, args)
+	prefetchTiles(tiles, args)
+	cacheScaledTiles(scales, tiles, args)
 }
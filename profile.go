@@ -0,0 +1,107 @@
+package main
+
+import (
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// --- Elevation Profile ---
+
+const elevationProfileRatio = 0.2 // высота профиля относительно ширины виджета
+
+// elevationProfileOffset — верх профиля относительно верха блока показателей: под полосой
+// дистанции и дополнительными строками
+func elevationProfileOffset(args *Arguments) float64 {
+	w := float64(args.WidgetSize)
+	valueFontSize := w / 8
+	rowHeight := extraRowHeight(w)
+	return valueFontSize*1.2 + valueFontSize/2*1.2 + 20*args.UIScale + float64(extraIndicatorRows(args))*rowHeight + rowHeight*0.4
+}
+
+// elevationProfileHeight — место под профиль в блоке показателей, с отступом снизу
+func elevationProfileHeight(args *Arguments) float64 {
+	return float64(args.WidgetSize) * elevationProfileRatio * 1.15
+}
+
+// sampleElevationProfile прореживает трек до n точек, равномерных по дистанции, — по одной на столбец пикселей
+func sampleElevationProfile(points []Point, n int) []Point {
+	total := points[len(points)-1].Distance
+	if n < 2 || total <= 0 {
+		return points
+	}
+	profile := make([]Point, 0, n)
+	j := 0
+	for i := 0; i < n; i++ {
+		d := total * float64(i) / float64(n-1)
+		for j < len(points)-2 && points[j+1].Distance < d {
+			j++
+		}
+		p1, p2 := points[j], points[j+1]
+		ratio := 0.0
+		if seg := p2.Distance - p1.Distance; seg > 0 {
+			ratio = math.Max(0, math.Min(1, (d-p1.Distance)/seg))
+		}
+		profile = append(profile, Point{Distance: d, Ele: p1.Ele + (p2.Ele-p1.Ele)*ratio})
+	}
+	return profile
+}
+
+// drawElevationProfile рисует профиль всего трека: пройденная часть залита цветом полосы дистанции,
+// текущее положение — точка цвета маркера
+func drawElevationProfile(dc *gg.Context, profile []Point, current Point, x, y, width, height float64, args *Arguments) {
+	minEle, maxEle := math.Inf(1), math.Inf(-1)
+	for _, p := range profile {
+		minEle = math.Min(minEle, p.Ele)
+		maxEle = math.Max(maxEle, p.Ele)
+	}
+	total := profile[len(profile)-1].Distance
+	if total <= 0 {
+		return
+	}
+	// плоский трек не растягиваем на всю высоту: шкала не мельче 20 м
+	span := math.Max(maxEle-minEle, 20)
+	px := func(d float64) float64 { return x + d/total*width }
+	py := func(ele float64) float64 { return y + height - (ele-minEle)/span*height }
+
+	area := func() {
+		dc.MoveTo(x, y+height)
+		for _, p := range profile {
+			dc.LineTo(px(p.Distance), py(p.Ele))
+		}
+		dc.LineTo(x+width, y+height)
+		dc.ClosePath()
+	}
+
+	dc.Push()
+	area()
+	dc.SetColor(withAlpha(args.BarTrackColor, 160))
+	dc.Fill()
+
+	currentX := px(math.Min(current.Distance, total))
+	dc.DrawRectangle(x, y, currentX-x, height)
+	dc.Clip()
+	area()
+	dc.SetColor(withAlpha(args.BarColor, 220))
+	dc.Fill()
+	dc.ResetClip()
+
+	for i, p := range profile {
+		if i == 0 {
+			dc.MoveTo(px(p.Distance), py(p.Ele))
+		} else {
+			dc.LineTo(px(p.Distance), py(p.Ele))
+		}
+	}
+	dc.SetColor(args.IndicatorColor)
+	dc.SetLineWidth(width / 300)
+	dc.Stroke()
+
+	dc.DrawCircle(currentX, py(current.Ele), height/12)
+	dc.SetColor(args.MarkerColor)
+	dc.FillPreserve()
+	dc.SetColor(args.MarkerOutlineColor)
+	dc.SetLineWidth(args.MarkerOutlineWidth)
+	dc.Stroke()
+	dc.Pop()
+}
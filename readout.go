@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// --- Structs ---
+
+// Readout — показание индикатора, которое обновляется не чаще раза в Interval,
+// чтобы цифры на видео успевали прочитать. Значения заранее посчитаны на сетке
+// с шагом Interval от Start, с учётом гистерезиса.
+type Readout struct {
+	Start    time.Time
+	Interval time.Duration
+	Values   []float64
+}
+
+// --- Readout Cadence ---
+
+// buildReadout снимает value на границах интервалов. Показание меняется, только если
+// новое значение отличается от показанного хотя бы на hysteresis. interval == 0 — живое значение (nil).
+func buildReadout(points []Point, start time.Time, interval time.Duration, hysteresis float64, value func(Point) float64) *Readout {
+	if interval <= 0 || len(points) == 0 {
+		return nil
+	}
+	r := &Readout{Start: start, Interval: interval}
+	end := points[len(points)-1].Timestamp
+	lo := 0
+	for t := start; !t.After(end); t = t.Add(interval) {
+		for lo+2 < len(points) && !points[lo+1].Timestamp.After(t) {
+			lo++
+		}
+		v := value(findPointForTime(0, t, points[lo:]))
+		if n := len(r.Values); n > 0 && math.Abs(v-r.Values[n-1]) < hysteresis {
+			v = r.Values[n-1]
+		}
+		r.Values = append(r.Values, v)
+	}
+	return r
+}
+
+// at возвращает показание на момент t; для nil — живое значение live
+func (r *Readout) at(t time.Time, live float64) float64 {
+	if r == nil || len(r.Values) == 0 {
+		return live
+	}
+	k := int(math.Floor(float64(t.Sub(r.Start)) / float64(r.Interval)))
+	return r.Values[max(0, min(k, len(r.Values)-1))]
+}
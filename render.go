@@ -4,26 +4,67 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	"log"
 	"math"
-	"strconv"
+	"sort"
 	"time"
 
 	"github.com/fogleman/gg"
 	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
 )
 
-func drawSpeedIcon(dc *gg.Context, x, y, size, lineWidth float64) {
+// дуга шкалы спидометра и мощности
+var (
+	gaugeStartAngle = gg.Radians(165)
+	gaugeEndAngle   = gg.Radians(375)
+)
+
+func gaugeAngle(v, scaleMax float64) float64 {
+	return gaugeStartAngle + (gaugeEndAngle-gaugeStartAngle)*math.Max(0, math.Min(1, v/scaleMax))
+}
+
+// drawGaugeDial рисует шкалу спидометра при полной шкале scaleMax: дугу, деления
+// и, если заданы, цветные участки zones. Стрелку добавляет drawGaugeNeedle
+func drawGaugeDial(dc *gg.Context, x, y, size, lineWidth, scaleMax float64, zones []gaugeZone) {
 	dc.Push()
 	dc.Translate(x, y)
 	dc.SetLineWidth(lineWidth)
 
-	startAngle := gg.Radians(165)
-	endAngle := gg.Radians(375)
-	dc.DrawArc(0, 0, size/2, startAngle, endAngle)
+	// цветные участки — внутри дуги, от предыдущей границы до своей
+	from := 0.0
+	for _, z := range zones {
+		if z.Max > from {
+			dc.Push()
+			dc.SetColor(z.Color)
+			dc.SetLineWidth(lineWidth * 1.5)
+			dc.DrawArc(0, 0, size/2-lineWidth*1.25, gaugeAngle(from, scaleMax), gaugeAngle(z.Max, scaleMax))
+			dc.Stroke()
+			dc.Pop()
+			from = z.Max
+		}
+	}
+
+	dc.DrawArc(0, 0, size/2, gaugeStartAngle, gaugeEndAngle)
+	dc.Stroke()
+
+	dc.SetLineWidth(lineWidth / 2)
+	for i := 0; i <= speedGaugeTicks; i++ {
+		a := gaugeStartAngle + (gaugeEndAngle-gaugeStartAngle)*float64(i)/speedGaugeTicks
+		dc.MoveTo(math.Cos(a)*size/2, math.Sin(a)*size/2)
+		dc.LineTo(math.Cos(a)*size/2.6, math.Sin(a)*size/2.6)
+	}
 	dc.Stroke()
+	dc.Pop()
+}
 
-	needleAngle := gg.Radians(210) // Example angle
+// drawGaugeNeedle рисует стрелку шкалы drawGaugeDial на value
+func drawGaugeNeedle(dc *gg.Context, x, y, size, lineWidth, value, scaleMax float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.SetLineWidth(lineWidth)
+	needleAngle := gaugeAngle(value, scaleMax)
 	dc.MoveTo(0, 0)
 	dc.LineTo(math.Cos(needleAngle)*size/2.2, math.Sin(needleAngle)*size/2.2)
 	dc.Stroke()
@@ -44,51 +85,266 @@ func drawSlopeIcon(dc *gg.Context, x, y, size, lineWidth float64) {
 	dc.Pop()
 }
 
+// drawIndicatorIcons рисует для iconLayer то, что в показателях не меняется от кадра к кадру:
+// шкалу спидометра, иконки уклона и высоты и неизменные иконки дополнительных строк.
+// Места те же, что у соответствующих показателей в renderFrame
+func drawIndicatorIcons(dc *gg.Context, indicators []indicator, ttf *truetype.Font, args *Arguments) {
+	boxes := args.Layout.Widgets
+	widgetWidth := float64(args.WidgetSize)
+	valueFontSize := widgetWidth / 8.0
+	iconSize := widgetWidth / 9.0
+	iconLineWidth := widgetWidth / 150.0
+	dc.SetColor(args.IndicatorColor)
+
+	drawInWidgetBox(dc, boxes[widgetSpeed], func(x, top float64) {
+		drawGaugeDial(dc, x+iconSize/2, indicatorBaseline(top, widgetWidth)-1.15*valueFontSize, iconSize, iconLineWidth, args.SpeedGaugeMax, args.SpeedGaugeZones)
+	})
+	if !args.SlopeColors {
+		drawInWidgetBox(dc, boxes[widgetSlope], func(x, top float64) {
+			drawSlopeIcon(dc, x+2*iconSize, indicatorBaseline(top, widgetWidth)-1.35*valueFontSize, iconSize, iconLineWidth)
+		})
+	}
+	if args.ShowAltitude && args.Activity != "swimming" {
+		drawInWidgetBox(dc, boxes[widgetAltitude], func(x, top float64) {
+			valueFace, unitFace := widgetFaces(boxes[widgetAltitude], ttf, valueFontSize, valueFontSize/2)
+			l := newAltitudeLayout(dc, valueFace, unitFace, x, top, args)
+			l.scale(dc)
+			drawAltitudeIcon(dc, l.startX+iconSize/2, l.row1Y-valueFontSize*0.35, iconSize, iconLineWidth)
+		})
+	}
+	if len(indicators) > 0 {
+		drawInWidgetBox(dc, boxes[widgetIndicators], func(x, top float64) {
+			drawExtraIndicatorIcons(dc, indicators, x, top+extraRowHeight(widgetWidth), widgetWidth, args)
+		})
+	}
+}
+
+// altitudeLayout — раскладка показателя высоты. Она считается по самой длинной записи высоты
+// фрагмента (args.AltitudeWidest), а не по текущей, чтобы иконка не сдвигалась при смене числа цифр
+// и могла рисоваться один раз в iconLayer; значение прижато к единице справа
+type altitudeLayout struct {
+	row1Y      float64
+	startX     float64 // левый край иконки
+	valueRight float64 // правый край значения
+	centerX    float64
+	k          float64 // < 1 — блок ужат, чтобы влезть в треть ширины
+}
+
+func newAltitudeLayout(dc *gg.Context, valueFace, unitFace font.Face, x, top float64, args *Arguments) altitudeLayout {
+	widgetWidth := float64(args.WidgetSize)
+	blockWidth := widgetWidth / 3.0
+	iconSpace := widgetWidth / 9.0 * 1.2
+	valueText, unitText := altitudeText(args.AltitudeWidest, args)
+	dc.SetFontFace(valueFace)
+	valueWidth, _ := dc.MeasureString(valueText)
+	dc.SetFontFace(unitFace)
+	unitWidth, _ := dc.MeasureString(unitText)
+
+	l := altitudeLayout{row1Y: indicatorBaseline(top, widgetWidth), centerX: x + blockWidth/2, k: 1}
+	total := iconSpace + valueWidth + unitWidth
+	// четыре-пять цифр с единицей могут не влезть в треть ширины — ужимаем, как в дополнительных строках
+	if available := blockWidth * 0.8; total > available { // с зазором до скорости и уклона
+		l.k = available / total
+	}
+	l.startX = x + (blockWidth-total)/2
+	l.valueRight = l.startX + iconSpace + valueWidth
+	return l
+}
+
+// scale ужимает dc вокруг середины блока, если запись не влезает
+func (l altitudeLayout) scale(dc *gg.Context) {
+	if l.k < 1 {
+		dc.ScaleAbout(l.k, l.k, l.centerX, l.row1Y)
+	}
+}
+
+// altitudeText — значение и единица показателя высоты в -altitude-units
+func altitudeText(ele float64, args *Arguments) (string, string) {
+	if args.AltitudeUnits == "ft" {
+		return fmt.Sprintf("%.0f", ele/0.3048), " ft"
+	}
+	return fmt.Sprintf("%.0f", ele), " m"
+}
+
+// drawAltitudeIcon рисует две вершины: большую и меньшую за ней; (x, y) — центр основания по высоте
+func drawAltitudeIcon(dc *gg.Context, x, y, size, lineWidth float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.SetLineWidth(lineWidth)
+	dc.MoveTo(-size/2, size/3)
+	dc.LineTo(-size/8, -size/3)
+	dc.LineTo(size/8, size/20)
+	dc.LineTo(size/4, -size/8)
+	dc.LineTo(size/2, size/3)
+	dc.ClosePath()
+	dc.Stroke()
+	dc.Pop()
+}
+
+const (
+	border3D       = "3d"
+	borderFlat     = "flat"
+	borderGradient = "gradient"
+	borderProgress = "progress"
+	borderNone     = "none"
+)
+
+const (
+	shapeCircle  = "circle"
+	shapeSquare  = "square"
+	shapeRounded = "rounded"
+)
+
+// drawWidgetShape добавляет в путь контур карты по -widget-shape: круг радиуса radius или квадрат со стороной 2*radius.
+// Скругление углов уменьшается вместе с radius, чтобы внутренние контуры (кайма, обрезка пути) шли параллельно рамке
+func drawWidgetShape(dc *gg.Context, cx, cy, radius float64, args *Arguments) {
+	switch args.WidgetShape {
+	case shapeSquare:
+		dc.DrawRectangle(cx-radius, cy-radius, 2*radius, 2*radius)
+	case shapeRounded:
+		corner := args.WidgetCornerRadius*float64(args.WidgetSize) - (float64(args.WidgetSize)/2 - radius)
+		dc.DrawRoundedRectangle(cx-radius, cy-radius, 2*radius, 2*radius, math.Max(0, corner))
+	default:
+		dc.DrawCircle(cx, cy, radius)
+	}
+}
+
+// mapCoverageRadius — радиус вокруг центра карты в пикселях виджета, который должны покрывать тайлы.
+// Углы квадрата при повороте карты уходят на radius*√2
+func mapCoverageRadius(args *Arguments) float64 {
+	radius := float64(args.WidgetSize) / 2
+	if args.WidgetShape != shapeCircle {
+		radius *= math.Sqrt2
+	}
+	return radius
+}
+
+// drawWidgetBorder рисует рамку вокруг карты в стиле -border-style.
+// progress (0..1) нужен только для кольца прогресса, которое заполняется по часовой стрелке от верха.
+func drawWidgetBorder(dc *gg.Context, cx, cy, radius, width, progress float64, args *Arguments) {
+	switch args.BorderStyle {
+	case borderNone:
+		return
+	case border3D:
+		shadowAlpha := 120
+		dc.SetColor(color.RGBA{R: 0, G: 0, B: 0, A: uint8(shadowAlpha)})
+		dc.SetLineWidth(width * 0.75)
+		if args.WidgetShape == shapeCircle {
+			dc.DrawArc(cx+width/2, cy+width/2, radius, gg.Radians(-45), gg.Radians(135))
+			dc.Stroke()
+			// ...top left:
+			//dc.SetColor(color.RGBA{R: 255, G: 255, B: 255, A: uint8(shadowAlpha)})
+			dc.DrawArc(cx+width/2, cy+width/2, radius, gg.Radians(135), gg.Radians(315))
+		} else {
+			drawWidgetShape(dc, cx+width/2, cy+width/2, radius, args)
+		}
+		dc.Stroke()
+		dc.SetColor(args.BorderColor)
+	case borderFlat:
+		dc.SetColor(args.BorderColor)
+	case borderGradient:
+		// светлее сверху слева, темнее снизу справа
+		r, g, b, _ := args.BorderColor.RGBA()
+		shade := func(k float64) color.Color {
+			f := func(v uint32) uint8 { return uint8(math.Min(255, float64(v>>8)*k)) }
+			return color.RGBA{f(r), f(g), f(b), 255}
+		}
+		grad := gg.NewLinearGradient(cx-radius, cy-radius, cx+radius, cy+radius)
+		grad.AddColorStop(0, shade(1.4))
+		grad.AddColorStop(1, shade(0.6))
+		dc.SetStrokeStyle(grad)
+	case borderProgress:
+		dc.SetColor(withAlpha(args.BorderColor, 70))
+		dc.SetLineWidth(width)
+		drawWidgetShape(dc, cx, cy, radius, args)
+		dc.Stroke()
+		if progress > 0 {
+			dc.SetColor(args.BorderColor)
+			dc.SetLineCap(gg.LineCapButt)
+			end := -math.Pi/2 + 2*math.Pi*math.Min(1, progress)
+			if args.WidgetShape == shapeCircle {
+				dc.DrawArc(cx, cy, radius, -math.Pi/2, end)
+				dc.Stroke()
+			} else {
+				// у квадрата заполненную часть рамки вырезаем сектором из центра
+				dc.Push()
+				dc.MoveTo(cx, cy)
+				dc.DrawArc(cx, cy, radius*2, -math.Pi/2, end)
+				dc.ClosePath()
+				dc.Clip()
+				drawWidgetShape(dc, cx, cy, radius, args)
+				dc.Stroke()
+				dc.Pop()
+				dc.ResetClip()
+			}
+			dc.SetLineCap(gg.LineCapRound)
+		}
+	}
+	if args.BorderStyle != borderProgress {
+		dc.SetLineWidth(width)
+		drawWidgetShape(dc, cx, cy, radius, args)
+		dc.Stroke()
+	}
+
+	// тёмная кайма внутри границы
+	dc.SetLineWidth(4 * args.UIScale)
+	dc.SetColor(color.RGBA{R: 0, G: 0, B: 0, A: 80})
+	drawWidgetShape(dc, cx, cy, radius-width/2, args)
+	dc.Stroke()
+}
+
+// frameTimeOffset — сколько секунд трека от начала сегмента показывает кадр frameNum с учётом Timeline
+func frameTimeOffset(frameNum int, track *Track, args *Arguments, segmentStartTime time.Time) float64 {
+	timeOffset := float64(frameNum)/args.Framerate + args.SyncOffset
+	if track.Timeline != nil {
+		segmentOffset := segmentStartTime.Sub(track.SmoothedPoints[0].Timestamp).Seconds()
+		timeOffset = track.Timeline.trackOffset(track.Timeline.videoOffset(segmentOffset)+timeOffset) - segmentOffset
+	}
+	return timeOffset
+}
+
 func renderFrame(frameNum, totalFrames int, track *Track, args *Arguments, font *truetype.Font, segmentStartTime time.Time) image.Image {
-	timeOffset := float64(frameNum) / args.Framerate
+	timeOffset := frameTimeOffset(frameNum, track, args, segmentStartTime)
 	currentPoint := findPointForTime(timeOffset, segmentStartTime, track.SmoothedPoints)
-	fiveSecondIntervalStartOffset := math.Floor(timeOffset/5.0) * 5.0
-	slopeDisplayPoint := findPointForTime(fiveSecondIntervalStartOffset, segmentStartTime, track.SmoothedPoints)
+	frameTime := segmentStartTime.Add(time.Duration(timeOffset * float64(time.Second)))
 
 	// --- Calculations ---
-	pathSoFar := []Point{}
 	// Calculate the timestamp after which the path should be drawn
 	skipUntilTimestamp := track.SmoothedPoints[0].Timestamp.Add(time.Duration(args.SkipPathSeconds * float64(time.Second)))
 
-	for i := 0; i < len(track.Points) && track.Points[i].Timestamp.Before(currentPoint.Timestamp); i++ {
-		// Only add points to pathSoFar if their timestamp is after the skipUntilTimestamp
-		if track.Points[i].Timestamp.After(skipUntilTimestamp) {
-			pathSoFar = append(pathSoFar, track.Points[i])
-		}
-	}
-	// Always add the current point, regardless of skip time, as it represents the current position
-	pathSoFar = append(pathSoFar, currentPoint)
-
+	// пройденный путь — точки прореженной геометрии после skipUntilTimestamp и до текущего момента;
+	// границы ищем бинарным поиском, чтобы кадр не стоил O(n) на треках 10 Гц
+	points, path := track.SmoothedPoints, track.PathPoints
+	from := sort.Search(len(path), func(i int) bool { return points[path[i]].Timestamp.After(skipUntilTimestamp) })
+	to := sort.Search(len(path), func(i int) bool { return !points[path[i]].Timestamp.Before(currentPoint.Timestamp) })
+	// на крупных масштабах путь прореживается ещё сильнее
+	pathStep := 1
 	if currentPoint.MapScale > 16 {
-		sparsePathSoFar := make([]Point, 0, len(pathSoFar))
-		for i := 0; i < len(pathSoFar); i += 15 {
-			sparsePathSoFar = append(sparsePathSoFar, pathSoFar[i])
-		}
-		pathSoFar = sparsePathSoFar
+		pathStep = 15
 	}
 
-	speed := currentPoint.Speed
-	slope := slopeDisplayPoint.SmoothedSlope
+	speed := track.SpeedReadout.at(frameTime, currentPoint.Speed)
+	slope := track.SlopeReadout.at(frameTime, currentPoint.SmoothedSlope)
 	currentDistance := currentPoint.Distance
 
 	// --- Map Rendering Setup ---
 	adjustedMapZoom := currentPoint.TileZoom
 	residualMapScale := currentPoint.ResidualMapScale
 	widgetRadiusPx := float64(args.WidgetSize) / 2.0
+	coverageRadiusPx := mapCoverageRadius(args)
 
+	// ближайший предмасштабированный набор тайлов; при равенстве — меньший ключ,
+	// чтобы выбор не зависел от порядка обхода map и рендер был воспроизводимым
 	var targetCachedResidualScale float64 = -1.0
 	var scaleKey string
-	for keyStr := range scaledTileCache {
-		keyFloat, _ := strconv.ParseFloat(keyStr, 64)
-		if math.Abs(residualMapScale-keyFloat) < 0.01 {
+	for keyStr, keyFloat := range scaledTileScales {
+		diff := math.Abs(residualMapScale - keyFloat)
+		if diff >= 0.01 {
+			continue
+		}
+		if bestDiff := math.Abs(residualMapScale - targetCachedResidualScale); scaleKey == "" || diff < bestDiff || (diff == bestDiff && keyStr < scaleKey) {
 			targetCachedResidualScale = keyFloat
 			scaleKey = keyStr
-			break
 		}
 	}
 
@@ -96,15 +352,32 @@ func renderFrame(frameNum, totalFrames int, track *Track, args *Arguments, font
 	var mapDC *gg.Context
 	var centerPxOnMap, centerPyOnMap float64
 
-	worldPx, worldPy := deg2num(currentPoint.Lat, currentPoint.Lon, adjustedMapZoom)
+	viewLat, viewLon := mapViewCenter(currentPoint)
+	worldPx, worldPy := deg2num(viewLat, viewLon, adjustedMapZoom)
 	worldPx *= float64(args.TileSize)
 	worldPy *= float64(args.TileSize)
 
+	// смещение текущей точки от центра виджета (ненулевое при сдвиге карты), в пикселях экрана
+	currentPx, currentPy := deg2num(currentPoint.Lat, currentPoint.Lon, adjustedMapZoom)
+	markerDX := (currentPx*float64(args.TileSize) - worldPx) / residualMapScale
+	markerDY := (currentPy*float64(args.TileSize) - worldPy) / residualMapScale
+	mapPxPerScreenPx := 1.0
+
+	// Always add the current point, regardless of skip time, as it represents the current position.
+	// Запас видимого квадрата — на толщину линии и на отличие масштаба готовых тайлов от точного
+	passed := passedPath{
+		points: points, index: path, tiles: track.PathProjection.at(adjustedMapZoom),
+		from: from, to: to, step: pathStep,
+		current: currentPoint, currentX: currentPx, currentY: currentPy,
+		viewX: worldPx / float64(args.TileSize), viewY: worldPy / float64(args.TileSize),
+		viewR: (coverageRadiusPx*1.02 + 2*args.PathWidth) * residualMapScale / float64(args.TileSize),
+	}
+
 	if targetCachedResidualScale > 0 {
 		// --- Cached Render Path ---
 		scalingFactor := 1.0 / targetCachedResidualScale
 		scaledTileSize := int(float64(args.TileSize) * scalingFactor)
-		effectiveWidgetRadiusPx := widgetRadiusPx / scalingFactor
+		effectiveWidgetRadiusPx := coverageRadiusPx / scalingFactor
 
 		px_min := worldPx - effectiveWidgetRadiusPx
 		py_min := worldPy - effectiveWidgetRadiusPx
@@ -123,27 +396,36 @@ func renderFrame(frameNum, totalFrames int, track *Track, args *Arguments, font
 
 		for x := int(tx_min); x <= int(tx_max); x++ {
 			for y := int(ty_min); y <= int(ty_max); y++ {
-				tile := Tile{X: x, Y: y, Z: adjustedMapZoom}
-				if scaledImg, ok := scaledTileCache[scaleKey][tile]; ok {
+				if scaledImg := getScaledTile(scaleKey, Tile{X: x, Y: y, Z: adjustedMapZoom}, args); scaledImg != nil {
 					mapDC.DrawImage(scaledImg, (x-int(tx_min))*scaledTileSize, (y-int(ty_min))*scaledTileSize)
 				}
 			}
 		}
 
+		if len(track.MapMasks) > 0 {
+			applyMapMasks(mapImage, track.MapMasks, func(lat, lon float64) (float64, float64) {
+				x, y := deg2num(lat, lon, adjustedMapZoom)
+				return (x - tx_min) * float64(args.TileSize) * scalingFactor, (y - ty_min) * float64(args.TileSize) * scalingFactor
+			})
+		}
+
 		centerPxOnMap = (worldPx - (tx_min * float64(args.TileSize))) * scalingFactor
 		centerPyOnMap = (worldPy - (ty_min * float64(args.TileSize))) * scalingFactor
 
 		// Path
-		if len(pathSoFar) > 1 {
-			mapDC.SetColor(args.PathColor)
+		if passed.len() > 1 {
 			mapDC.SetLineWidth(args.PathWidth)
 
 			prevX := math.NaN()
 			prevY := math.NaN()
 
-			for i := 1; i < len(pathSoFar); i++ {
-				p1x, p1y := deg2num(pathSoFar[i-1].Lat, pathSoFar[i-1].Lon, adjustedMapZoom)
-				p2x, p2y := deg2num(pathSoFar[i].Lat, pathSoFar[i].Lon, adjustedMapZoom)
+			for i := 1; i < passed.len(); i++ {
+				pt, p1x, p1y, p2x, p2y, ok := passed.segment(i)
+				if !ok {
+					prevX = math.NaN()
+					prevY = math.NaN()
+					continue
+				}
 				sp1x := (p1x*float64(args.TileSize) - tx_min*float64(args.TileSize)) * scalingFactor
 				sp1y := (p1y*float64(args.TileSize) - ty_min*float64(args.TileSize)) * scalingFactor
 				sp2x := (p2x*float64(args.TileSize) - tx_min*float64(args.TileSize)) * scalingFactor
@@ -162,13 +444,14 @@ func renderFrame(frameNum, totalFrames int, track *Track, args *Arguments, font
 					prevY = sp1y
 					continue
 				}
+				mapDC.SetColor(pathSegmentColor(pt, track, args))
 				mapDC.DrawLine(sp1x, sp1y, sp2x, sp2y)
 				mapDC.Stroke()
 			}
 		}
 	} else {
 		// --- Dynamic Scale Render Path ---
-		effectiveWidgetRadiusPx := widgetRadiusPx * residualMapScale
+		effectiveWidgetRadiusPx := coverageRadiusPx * residualMapScale
 
 		px_min := worldPx - effectiveWidgetRadiusPx
 		py_min := worldPy - effectiveWidgetRadiusPx
@@ -187,7 +470,7 @@ func renderFrame(frameNum, totalFrames int, track *Track, args *Arguments, font
 
 		for x := int(tx_min); x <= int(tx_max); x++ {
 			for y := int(ty_min); y <= int(ty_max); y++ {
-				tileImg, err := getTileImage(args.MapStyle, adjustedMapZoom, x, y, args)
+				tileImg, err := getMapTile(adjustedMapZoom, x, y, args)
 				if err != nil {
 					log.Printf("could not get tile image: %v", err)
 				}
@@ -197,16 +480,26 @@ func renderFrame(frameNum, totalFrames int, track *Track, args *Arguments, font
 			}
 		}
 
+		if len(track.MapMasks) > 0 {
+			applyMapMasks(mapImage, track.MapMasks, func(lat, lon float64) (float64, float64) {
+				x, y := deg2num(lat, lon, adjustedMapZoom)
+				return (x - tx_min) * float64(args.TileSize), (y - ty_min) * float64(args.TileSize)
+			})
+		}
+
 		centerPxOnMap = worldPx - (tx_min * float64(args.TileSize))
 		centerPyOnMap = worldPy - (ty_min * float64(args.TileSize))
+		mapPxPerScreenPx = residualMapScale
 
 		// Path
-		if len(pathSoFar) > 1 {
-			mapDC.SetColor(args.PathColor)
+		if passed.len() > 1 {
 			mapDC.SetLineWidth(args.PathWidth)
-			for i := 1; i < len(pathSoFar); i++ {
-				p1x, p1y := deg2num(pathSoFar[i-1].Lat, pathSoFar[i-1].Lon, adjustedMapZoom)
-				p2x, p2y := deg2num(pathSoFar[i].Lat, pathSoFar[i].Lon, adjustedMapZoom)
+			for i := 1; i < passed.len(); i++ {
+				pt, p1x, p1y, p2x, p2y, ok := passed.segment(i)
+				if !ok {
+					continue
+				}
+				mapDC.SetColor(pathSegmentColor(pt, track, args))
 				mapDC.DrawLine((p1x-tx_min)*float64(args.TileSize), (p1y-ty_min)*float64(args.TileSize), (p2x-tx_min)*float64(args.TileSize), (p2y-ty_min)*float64(args.TileSize))
 				mapDC.Stroke()
 			}
@@ -214,20 +507,25 @@ func renderFrame(frameNum, totalFrames int, track *Track, args *Arguments, font
 	}
 
 	// --- Draw Marker & Compose ---
-	mapDC.SetColor(color.RGBA{0, 0, 255, 255})
-	mapDC.DrawPoint(centerPxOnMap, centerPyOnMap, 8)
+	markerPxOnMap := centerPxOnMap + markerDX*mapPxPerScreenPx
+	markerPyOnMap := centerPyOnMap + markerDY*mapPxPerScreenPx
+	mapDC.SetColor(args.MarkerColor)
+	mapDC.DrawPoint(markerPxOnMap, markerPyOnMap, args.MarkerRadius)
 	mapDC.Fill()
-	mapDC.SetColor(color.White)
-	mapDC.SetLineWidth(2)
-	mapDC.DrawPoint(centerPxOnMap, centerPyOnMap, 8)
+	mapDC.SetColor(args.MarkerOutlineColor)
+	mapDC.SetLineWidth(args.MarkerOutlineWidth)
+	mapDC.DrawPoint(markerPxOnMap, markerPyOnMap, args.MarkerRadius)
 	mapDC.Stroke()
 
-	// Crop circular widget
+	// Crop widget shape
 	mask := gg.NewContext(args.WidgetSize, args.WidgetSize)
-	mask.DrawCircle(widgetRadiusPx, widgetRadiusPx, widgetRadiusPx)
+	drawWidgetShape(mask, widgetRadiusPx, widgetRadiusPx, widgetRadiusPx, args)
 	mask.Clip()
+	if currentPoint.MapRotation != 0 {
+		mask.RotateAbout(currentPoint.MapRotation, widgetRadiusPx, widgetRadiusPx)
+	}
 
-	if targetCachedResidualScale <= 0 && currentPoint.MapScale != 1.0 {
+	if targetCachedResidualScale <= 0 && residualMapScale != 1.0 {
 		// Apply dynamic scaling only if not using a cached version
 		mask.Translate(widgetRadiusPx, widgetRadiusPx)
 		if math.Abs(residualMapScale-1.0) > 0.01 {
@@ -240,50 +538,55 @@ func renderFrame(frameNum, totalFrames int, track *Track, args *Arguments, font
 
 	// --- Final Frame Composition ---
 	frameDC := gg.NewContext(args.VideoWidth, args.VideoHeight)
-	mapPosX := float64(20)
-	mapPosY := float64(20)
+	mapPosX := args.Layout.MapX
+	mapPosY := args.Layout.MapY
+	panelLayer.draw(frameDC, args, func(dc *gg.Context) { drawPanelBackground(dc, args) })
 	frameDC.DrawImage(mask.Image(), int(mapPosX), int(mapPosY))
 
-	// 3D Border
-	shadowAlpha := 120
 	borderWidth := float64(args.WidgetSize) * 0.04
-	frameDC.SetColor(color.RGBA{R: 0, G: 0, B: 0, A: uint8(shadowAlpha)})
-	frameDC.SetLineWidth(borderWidth * 0.75)
-	frameDC.DrawArc(mapPosX+widgetRadiusPx+borderWidth/2, mapPosY+widgetRadiusPx+borderWidth/2, widgetRadiusPx, gg.Radians(-45), gg.Radians(135))
-	frameDC.Stroke()
-	// ...top left:
-	//frameDC.SetColor(color.RGBA{R: 255, G: 255, B: 255, A: uint8(shadowAlpha)})
-	frameDC.DrawArc(mapPosX+widgetRadiusPx+borderWidth/2, mapPosY+widgetRadiusPx+borderWidth/2, widgetRadiusPx, gg.Radians(135), gg.Radians(315))
-	frameDC.Stroke()
-	frameDC.SetColor(args.BorderColor)
-	frameDC.SetLineWidth(borderWidth)
-	frameDC.DrawCircle(mapPosX+widgetRadiusPx, mapPosY+widgetRadiusPx, widgetRadiusPx)
-	frameDC.Stroke()
-
-	// --- Path and Marker (on top of map) ---
 	widgetCenterX := mapPosX + widgetRadiusPx
 	widgetCenterY := mapPosY + widgetRadiusPx
+	if args.BorderStyle == borderProgress { // кольцо прогресса меняется от кадра к кадру
+		drawWidgetBorder(frameDC, widgetCenterX, widgetCenterY, widgetRadiusPx, borderWidth, currentDistance/track.TotalDistance, args)
+	} else {
+		borderLayer.draw(frameDC, args, func(dc *gg.Context) {
+			drawWidgetBorder(dc, widgetCenterX, widgetCenterY, widgetRadiusPx, borderWidth, 0, args)
+		})
+	}
 
-	// тёмная кайма внутри границы
-	frameDC.SetLineWidth(4)
-	frameDC.SetColor(color.RGBA{R: 0, G: 0, B: 0, A: 80})
-	frameDC.DrawCircle(widgetCenterX, widgetCenterY, widgetRadiusPx - borderWidth/2)
-	frameDC.Stroke()
+	// --- Path and Marker (on top of map) ---
 
 	// Set clip for path
 	frameDC.Push()
-	frameDC.DrawCircle(widgetCenterX, widgetCenterY, widgetRadiusPx - borderWidth/2 - 1)
+	drawWidgetShape(frameDC, widgetCenterX, widgetCenterY, widgetRadiusPx-borderWidth/2-1, args)
 	frameDC.Clip()
+	frameDC.RotateAbout(currentPoint.MapRotation, widgetCenterX, widgetCenterY)
 
+	viewPoint := currentPoint
+	viewPoint.Lat, viewPoint.Lon = viewLat, viewLon
+	if len(track.Route) > 1 {
+		drawRoute(frameDC, track.Route, viewPoint, adjustedMapZoom, residualMapScale, widgetCenterX, widgetCenterY, widgetRadiusPx, args)
+	}
+	if args.PathAhead {
+		// оставшаяся часть трека — пунктиром под пройденным путём, до конца рендеримого фрагмента
+		renderEnd := track.SmoothedPoints[track.RenderToIndex-1].Timestamp
+		end := sort.Search(len(path), func(i int) bool { return points[path[i]].Timestamp.After(renderEnd) })
+		ahead := []Point{currentPoint}
+		for _, j := range path[to:max(to, end)] {
+			ahead = append(ahead, points[j])
+		}
+		if len(ahead) > 1 {
+			frameDC.SetColor(withAlpha(args.PathColor, 110))
+			frameDC.SetLineWidth(args.PathWidth * 0.6)
+			frameDC.SetDash(args.PathWidth*1.5, args.PathWidth)
+			strokePolyline(frameDC, ahead, viewPoint, adjustedMapZoom, residualMapScale, widgetCenterX, widgetCenterY, widgetRadiusPx, args)
+			frameDC.SetDash()
+		}
+	}
 
-	if len(pathSoFar) > 1 {
-		current_world_px, current_world_py := deg2num(currentPoint.Lat, currentPoint.Lon, adjustedMapZoom)
-		frameDC.SetColor(args.PathColor)
-		frameDC.SetLineWidth(args.PathWidth)
-		for i := 1; i < len(pathSoFar); i++ {
-			p1_world_px, p1_world_py := deg2num(pathSoFar[i-1].Lat, pathSoFar[i-1].Lon, adjustedMapZoom)
-			p2_world_px, p2_world_py := deg2num(pathSoFar[i].Lat, pathSoFar[i].Lon, adjustedMapZoom)
-
+	if passed.len() > 1 {
+		current_world_px, current_world_py := passed.viewX, passed.viewY
+		drawSegment := func(p1_world_px, p1_world_py, p2_world_px, p2_world_py float64) {
 			dx1 := (p1_world_px - current_world_px) * float64(args.TileSize)
 			dy1 := (p1_world_py - current_world_py) * float64(args.TileSize)
 			dx2 := (p2_world_px - current_world_px) * float64(args.TileSize)
@@ -295,18 +598,59 @@ func renderFrame(frameNum, totalFrames int, track *Track, args *Arguments, font
 			screen_dy2 := dy2 / residualMapScale
 
 			frameDC.DrawLine(widgetCenterX+screen_dx1, widgetCenterY+screen_dy1, widgetCenterX+screen_dx2, widgetCenterY+screen_dy2)
+		}
+		if args.GPSQualityPath {
+			// под участками с плохим GPS — широкая полупрозрачная полоса: положение там известно лишь примерно.
+			// Одним контуром, чтобы на стыках не было тёмных пятен
+			frameDC.SetColor(withAlpha(args.PathColor, 70))
+			frameDC.SetLineWidth(args.PathWidth * 3)
+			for i := 1; i < passed.len(); i++ {
+				if pt, x1, y1, x2, y2, ok := passed.segment(i); ok && gpsQuality(pt) == gpsQualityPoor {
+					drawSegment(x1, y1, x2, y2)
+				}
+			}
+			frameDC.Stroke()
+		}
+		frameDC.SetLineWidth(args.PathWidth)
+		for i := 1; i < passed.len(); i++ {
+			pt, x1, y1, x2, y2, ok := passed.segment(i)
+			if !ok {
+				continue
+			}
+			frameDC.SetColor(pathSegmentColor(pt, track, args))
+			drawSegment(x1, y1, x2, y2)
 			frameDC.Stroke()
 		}
 	}
+	if args.EventMarkers && len(track.Events) > 0 {
+		drawEventMarkers(frameDC, track, currentPoint, worldPx, worldPy, adjustedMapZoom, residualMapScale, widgetCenterX, widgetCenterY, args)
+	}
+	if args.WaypointPins && len(track.Waypoints) > 0 {
+		drawWaypointPins(frameDC, track.Waypoints, currentPoint, worldPx, worldPy, adjustedMapZoom, residualMapScale, widgetCenterX, widgetCenterY, font, args)
+	}
+	if len(track.Photos) > 0 {
+		drawPhotoPins(frameDC, track, currentPoint, worldPx, worldPy, adjustedMapZoom, residualMapScale, widgetCenterX, widgetCenterY, args)
+	}
 	frameDC.Pop() // Reset clip
 	frameDC.ResetClip()
 
 	// Current position marker
 	bearing := currentPoint.Bearing
-	radius := 8.0
+	radius := args.MarkerRadius
 
 	frameDC.Push()
-	frameDC.Translate(widgetCenterX, widgetCenterY)
+	frameDC.RotateAbout(currentPoint.MapRotation, widgetCenterX, widgetCenterY)
+	frameDC.Translate(widgetCenterX+markerDX, widgetCenterY+markerDY)
+
+	// Pulse: расходящееся гаснущее кольцо, один цикл за MarkerPulse секунд
+	if args.MarkerPulse > 0 {
+		phase := math.Mod(float64(frameNum)/args.Framerate, args.MarkerPulse) / args.MarkerPulse
+		frameDC.SetColor(withAlpha(args.MarkerColor, uint8(200*(1-phase))))
+		frameDC.SetLineWidth(args.MarkerOutlineWidth)
+		frameDC.DrawCircle(0, 0, radius*(1+2*phase))
+		frameDC.Stroke()
+	}
+
 	frameDC.Rotate(bearing - math.Pi/2.0)
 
 	// Drop path
@@ -316,13 +660,13 @@ func renderFrame(frameNum, totalFrames int, track *Track, args *Arguments, font
 	frameDC.DrawArc(0, 0, radius, gg.Radians(45), gg.Radians(315))
 	frameDC.ClosePath()
 
-	// White outline
-	frameDC.SetColor(color.White)
-	frameDC.SetLineWidth(4) // 2px inside, 2px outside
+	// Outline
+	frameDC.SetColor(args.MarkerOutlineColor)
+	frameDC.SetLineWidth(args.MarkerOutlineWidth * 2) // половина внутри, половина снаружи
 	frameDC.StrokePreserve()
 
-	// Blue fill
-	frameDC.SetColor(color.RGBA{0, 0, 255, 255})
+	// Fill
+	frameDC.SetColor(args.MarkerColor)
 	frameDC.Fill()
 
 	frameDC.Pop()
@@ -334,71 +678,254 @@ func renderFrame(frameNum, totalFrames int, track *Track, args *Arguments, font
 	iconSize := widgetWidth / 9.0
 	iconLineWidth := widgetWidth / 150.0
 
-	valueFace := truetype.NewFace(font, &truetype.Options{Size: valueFontSize})
-	unitFace := truetype.NewFace(font, &truetype.Options{Size: unitFontSize})
+	if args.Layout.Widgets[widgetMap].Hidden {
+		// карту всё равно собираем — по ней считается путь и маркер, — но в кадр она не идёт
+		bw := borderWidth * 2
+		clear := image.Rect(int(mapPosX-bw), int(mapPosY-bw), int(math.Ceil(mapPosX+widgetWidth+bw)), int(math.Ceil(mapPosY+widgetWidth+bw)))
+		draw.Draw(frameDC.Image().(*image.RGBA), clear, image.Transparent, image.Point{}, draw.Src)
+	} else if args.GPSQuality {
+		// в правом верхнем углу, вне круга карты
+		size := widgetWidth / 12
+		drawGPSQualityIcon(frameDC, mapPosX+widgetWidth-size, mapPosY+size/2, size, gpsQuality(currentPoint))
+	}
+	if args.Attribution && !args.Layout.Widgets[widgetMap].Hidden {
+		box := args.Layout.Widgets[widgetAttribution]
+		drawInWidgetBox(frameDC, box, func(x, y float64) { drawAttribution(frameDC, x, y, box.W, box.fontOr(font), args) })
+	}
 
-	row1Y := mapPosY + widgetWidth + valueFontSize*1.2
 
+	boxes := args.Layout.Widgets
+	var indicators []indicator
+	for _, name := range extraIndicatorNames(args) {
+		indicators = append(indicators, buildIndicator(name, currentPoint, track, args))
+	}
+	iconLayer.draw(frameDC, args, func(dc *gg.Context) { drawIndicatorIcons(dc, indicators, font, args) })
 	frameDC.SetColor(args.IndicatorColor)
 
 	// Speed Indicator
-	speedBlockX := mapPosX
-	speedBlockWidth := widgetWidth / 3.0
-	speedIconX := speedBlockX + iconSize/2
-	speedIconY := row1Y - 1.15*valueFontSize
-	drawSpeedIcon(frameDC, speedIconX, speedIconY, iconSize, iconLineWidth)
-	speedValueText := fmt.Sprintf("%.0f", math.Round(speed))
-	speedUnitText := " km/h"
-	frameDC.SetFontFace(valueFace)
-	valueWidth, _ := frameDC.MeasureString(speedValueText)
-	frameDC.SetFontFace(unitFace)
-	unitWidth, _ := frameDC.MeasureString(speedUnitText)
-	startX := speedBlockX + speedBlockWidth - (valueWidth + unitWidth)
-	frameDC.SetFontFace(valueFace)
-	frameDC.DrawString(speedValueText, startX, row1Y)
-	frameDC.SetFontFace(unitFace)
-	frameDC.DrawString(speedUnitText, startX+valueWidth, row1Y)
+	drawInWidgetBox(frameDC, boxes[widgetSpeed], func(speedBlockX, top float64) {
+		valueFace, unitFace := widgetFaces(boxes[widgetSpeed], font, valueFontSize, unitFontSize)
+		row1Y := indicatorBaseline(top, widgetWidth)
+		speedBlockWidth := widgetWidth / 3.0
+		drawGaugeNeedle(frameDC, speedBlockX+iconSize/2, row1Y-1.15*valueFontSize, iconSize, iconLineWidth, speed, args.SpeedGaugeMax)
+		speedValueText := fmt.Sprintf("%.0f", math.Round(speed))
+		speedUnitText := " km/h"
+		if args.Activity == "swimming" {
+			// в воде мгновенная скорость слишком шумная, темп считаем по средней
+			speedValueText = formatPace(currentPoint.AvgSpeed, 0.1)
+			speedUnitText = " /100m"
+			speedBlockWidth = widgetWidth / 2 // темп шире скорости, занимаем пустую середину
+		}
+		frameDC.SetFontFace(valueFace)
+		valueWidth, _ := frameDC.MeasureString(speedValueText)
+		frameDC.SetFontFace(unitFace)
+		unitWidth, _ := frameDC.MeasureString(speedUnitText)
+		startX := speedBlockX + speedBlockWidth - (valueWidth + unitWidth)
+		frameDC.SetFontFace(valueFace)
+		drawIndicatorText(frameDC, speedValueText, startX, row1Y, 0, 0, args.IndicatorColor, args)
+		frameDC.SetFontFace(unitFace)
+		drawIndicatorText(frameDC, speedUnitText, startX+valueWidth, row1Y, 0, 0, args.IndicatorColor, args)
+	})
 
 	// Slope Indicator
-	slopeBlockX := mapPosX + widgetWidth*2/3
-	slopeBlockWidth := widgetWidth / 3.0
-	slopeIconX := slopeBlockX + 2 * iconSize
-	slopeIconY := row1Y - 1.35*valueFontSize
-	drawSlopeIcon(frameDC, slopeIconX, slopeIconY, iconSize, iconLineWidth)
-	slopeValueText := fmt.Sprintf("%.1f", slope)
-	slopeUnitText := " %"
-	frameDC.SetFontFace(valueFace)
-	valueWidth, _ = frameDC.MeasureString(slopeValueText)
-	frameDC.SetFontFace(unitFace)
-	unitWidth, _ = frameDC.MeasureString(slopeUnitText)
-	startX = slopeBlockX + slopeBlockWidth - (valueWidth + unitWidth)
-	frameDC.SetFontFace(valueFace)
-	frameDC.DrawString(slopeValueText, startX, row1Y)
-	frameDC.SetFontFace(unitFace)
-	frameDC.DrawString(slopeUnitText, startX+valueWidth, row1Y)
+	drawInWidgetBox(frameDC, boxes[widgetSlope], func(slopeBlockX, top float64) {
+		valueFace, unitFace := widgetFaces(boxes[widgetSlope], font, valueFontSize, unitFontSize)
+		row1Y := indicatorBaseline(top, widgetWidth)
+		slopeBlockWidth := widgetWidth / 3.0
+		textColor := args.IndicatorColor
+		if args.SlopeColors { // иконка цвета уклона меняется от кадра к кадру, в iconLayer её нет
+			textColor = slopeColor(slope, args.SlopeThresholds)
+			frameDC.SetColor(textColor)
+			drawSlopeIcon(frameDC, slopeBlockX+2*iconSize, row1Y-1.35*valueFontSize, iconSize, iconLineWidth)
+		}
+		slopeValueText := fmt.Sprintf("%.1f", slope)
+		slopeUnitText := " %"
+		frameDC.SetFontFace(valueFace)
+		valueWidth, _ := frameDC.MeasureString(slopeValueText)
+		frameDC.SetFontFace(unitFace)
+		unitWidth, _ := frameDC.MeasureString(slopeUnitText)
+		startX := slopeBlockX + slopeBlockWidth - (valueWidth + unitWidth)
+		frameDC.SetFontFace(valueFace)
+		drawIndicatorText(frameDC, slopeValueText, startX, row1Y, 0, 0, textColor, args)
+		frameDC.SetFontFace(unitFace)
+		drawIndicatorText(frameDC, slopeUnitText, startX+valueWidth, row1Y, 0, 0, textColor, args)
+	})
+
+	// Altitude Indicator — в свободной середине ряда; в плавании её занимает темп.
+	// Иконка (в iconLayer) слева от значения: над серединой ряда низ карты
+	if args.ShowAltitude && args.Activity != "swimming" {
+		drawInWidgetBox(frameDC, boxes[widgetAltitude], func(altBlockX, top float64) {
+			valueFace, unitFace := widgetFaces(boxes[widgetAltitude], font, valueFontSize, unitFontSize)
+			l := newAltitudeLayout(frameDC, valueFace, unitFace, altBlockX, top, args)
+			altValueText, altUnitText := altitudeText(currentPoint.Ele, args)
+			l.scale(frameDC)
+			frameDC.SetFontFace(valueFace)
+			drawIndicatorText(frameDC, altValueText, l.valueRight, l.row1Y, 1, 0, args.IndicatorColor, args)
+			frameDC.SetFontFace(unitFace)
+			drawIndicatorText(frameDC, altUnitText, l.valueRight, l.row1Y, 0, 0, args.IndicatorColor, args)
+		})
+	}
 
 	// Distance Bar
-	row2Y := row1Y + unitFontSize*1.2
-	barWidth := widgetWidth
-	barHeight := 20.0
-	progress := currentDistance / track.TotalDistance
-	frameDC.SetColor(color.RGBA{80, 80, 80, 255})
-	frameDC.DrawRectangle(mapPosX, row2Y, barWidth, barHeight)
-	frameDC.Fill()
-	frameDC.SetColor(color.RGBA{100, 180, 255, 255})
-	frameDC.DrawRectangle(mapPosX, row2Y, barWidth*progress, barHeight)
-	frameDC.Fill()
-	distText := fmt.Sprintf("%.2f / %.2f km", currentDistance, track.TotalDistance)
-	frameDC.SetColor(args.IndicatorColor)
-	frameDC.SetFontFace(unitFace)
-	frameDC.DrawStringAnchored(distText, mapPosX+barWidth/2, row2Y+barHeight/2, 0.5, 0.5)
+	drawInWidgetBox(frameDC, boxes[widgetDistance], func(barX, row2Y float64) {
+		_, unitFace := widgetFaces(boxes[widgetDistance], font, valueFontSize, unitFontSize)
+		barWidth := widgetWidth
+		barHeight := 20 * args.UIScale
+		progress := currentDistance / track.TotalDistance
+		if args.BorderStyle != borderProgress { // кольцо прогресса заменяет полосу
+			frameDC.SetColor(args.BarTrackColor)
+			frameDC.DrawRectangle(barX, row2Y, barWidth, barHeight)
+			frameDC.Fill()
+			frameDC.SetColor(args.BarColor)
+			frameDC.DrawRectangle(barX, row2Y, barWidth*progress, barHeight)
+			frameDC.Fill()
+			if len(track.Checkpoints) > 0 {
+				drawCheckpointTicks(frameDC, track.Checkpoints, currentDistance, track.TotalDistance, barX, row2Y, barWidth, barHeight, font, args)
+			}
+		}
+		distText := fmt.Sprintf("%.2f / %.2f km", currentDistance, track.TotalDistance)
+		frameDC.SetFontFace(unitFace)
+		drawIndicatorText(frameDC, distText, barX+barWidth/2, row2Y+barHeight/2, 0.5, 0.5, args.IndicatorColor, args)
+	})
+
+	// Extra indicator rows
+	if len(indicators) > 0 {
+		drawInWidgetBox(frameDC, boxes[widgetIndicators], func(x, top float64) {
+			drawExtraIndicators(frameDC, indicators, x, top+extraRowHeight(widgetWidth), widgetWidth, boxes[widgetIndicators].fontOr(font), args)
+		})
+	}
+
+	if len(track.ElevationProfile) > 1 {
+		drawInWidgetBox(frameDC, boxes[widgetProfile], func(x, y float64) {
+			drawElevationProfile(frameDC, track.ElevationProfile, currentPoint, x, y, widgetWidth, widgetWidth*elevationProfileRatio, args)
+		})
+	}
+
+	if args.SyncStrip {
+		drawSyncStrip(frameDC, frameNum, frameTime, font, args)
+	}
+
+	if boxes[widgetMap].Hidden {
+		// подписи и плашки поверх карты прячутся вместе с ней
+		return frameDC.Image()
+	}
+	if len(track.Climbs) > 0 {
+		drawClimbBanner(frameDC, track, currentPoint, mapPosX+widgetWidth*0.1, mapPosY+widgetWidth*0.68, widgetWidth*0.8, font, args)
+	}
+	if len(track.WaypointPasses) > 0 {
+		drawWaypointBanner(frameDC, track, currentPoint, mapPosX+widgetWidth/2, mapPosY+widgetWidth*0.25, widgetWidth*0.8, font, args)
+	}
+	if len(track.Places) > 0 {
+		drawPlaceCaption(frameDC, track.Places, currentDistance, mapPosX+widgetWidth/2, mapPosY+widgetWidth*0.86, widgetWidth*0.7, font, args)
+	}
+	if len(track.Events) > 0 {
+		drawEventCallout(frameDC, track, currentPoint, mapPosX+widgetWidth/2, mapPosY+widgetWidth*0.12, widgetWidth*0.8, font, args)
+	}
+	if len(track.Splits) > 0 {
+		drawSplitCallout(frameDC, track.Splits, currentPoint, mapPosX+widgetWidth/2, mapPosY+widgetWidth*0.3, widgetWidth*0.8, font, args)
+	}
+	if len(track.Annotations) > 0 {
+		drawAnnotation(frameDC, track, currentPoint, mapPosX+widgetWidth/2, mapPosY+widgetWidth*0.56, widgetWidth*0.8, font, args)
+	}
+	if len(track.Photos) > 0 {
+		drawPhoto(frameDC, track.Photos, currentPoint, mapPosX+widgetWidth/2, mapPosY+widgetWidth*0.25, args)
+	}
 
 	return frameDC.Image()
 }
 
+// pathSegmentColor выбирает цвет отрезка пройденного пути, заканчивающегося в точке p
+func pathSegmentColor(p Point, track *Track, args *Arguments) color.Color {
+	if len(track.Route) > 1 && p.RouteDeviation > args.RouteDeviationMax {
+		// участки, где ушли с маршрута, подсвечиваем
+		return args.RouteDeviationColor
+	}
+	if args.Activity == "skiing" && p.OnLift {
+		return args.LiftColor
+	}
+	if v, ok := pathMetric(p, args.PathColorBy); ok {
+		return rampColor(args.PathColorRamp, v, args.PathColorMin, args.PathColorMax)
+	}
+	return args.PathColor
+}
+
+// drawRoute рисует запланированный маршрут полупрозрачной линией под треком
+func drawRoute(dc *gg.Context, route []Point, currentPoint Point, zoom int, residualMapScale, centerX, centerY, radius float64, args *Arguments) {
+	dc.SetColor(withAlpha(args.RouteColor, 140))
+	dc.SetLineWidth(args.PathWidth * 0.6)
+	strokePolyline(dc, route, currentPoint, zoom, residualMapScale, centerX, centerY, radius, args)
+}
+
+// strokePolyline обводит линию по точкам points вокруг текущей точки текущим цветом и толщиной
+func strokePolyline(dc *gg.Context, points []Point, currentPoint Point, zoom int, residualMapScale, centerX, centerY, radius float64, args *Arguments) {
+	curX, curY := deg2num(currentPoint.Lat, currentPoint.Lon, zoom)
+	scale := float64(args.TileSize) / residualMapScale
+
+	prevX, prevY := deg2num(points[0].Lat, points[0].Lon, zoom)
+	prevX, prevY = (prevX-curX)*scale, (prevY-curY)*scale
+	drawing := false
+	for i := 1; i < len(points); i++ {
+		x, y := deg2num(points[i].Lat, points[i].Lon, zoom)
+		x, y = (x-curX)*scale, (y-curY)*scale
+		// отрезки целиком за пределами виджета не рисуем
+		offscreen := (x > 2*radius && prevX > 2*radius) || (x < -2*radius && prevX < -2*radius) ||
+			(y > 2*radius && prevY > 2*radius) || (y < -2*radius && prevY < -2*radius)
+		if !offscreen {
+			// одним контуром, чтобы полупрозрачные стыки не давали точек
+			if !drawing {
+				dc.MoveTo(centerX+prevX, centerY+prevY)
+				drawing = true
+			}
+			dc.LineTo(centerX+x, centerY+y)
+		} else {
+			drawing = false
+		}
+		prevX, prevY = x, y
+	}
+	dc.Stroke()
+}
+
+// drawIntroTitle рисует заголовок вступительного стоп-кадра, проявляющийся за introTitleFade.
+// elapsed — время от начала стоп-кадра.
+func drawIntroTitle(img image.Image, title string, elapsed float64, font *truetype.Font, args *Arguments) {
+	rgba, ok := img.(*image.RGBA)
+	if !ok || title == "" {
+		return
+	}
+	alpha := math.Min(1, elapsed/introTitleFade.Seconds())
+	dc := gg.NewContextForRGBA(rgba)
+	widgetWidth := float64(args.WidgetSize)
+	fontSize := widgetWidth / 10
+	dc.SetFontFace(newFontFace(font, &truetype.Options{Size: fontSize}))
+	textWidth, _ := dc.MeasureString(title)
+	if maxWidth := widgetWidth * 0.9; textWidth > maxWidth {
+		fontSize *= maxWidth / textWidth
+		dc.SetFontFace(newFontFace(font, &truetype.Options{Size: fontSize}))
+		textWidth = maxWidth
+	}
+
+	centerX := args.Layout.MapX + widgetWidth/2
+	centerY := args.Layout.MapY + widgetWidth*0.75
+	pad := fontSize / 2
+	dc.SetColor(color.RGBA{0, 0, 0, uint8(160 * alpha)})
+	dc.DrawRoundedRectangle(centerX-textWidth/2-pad, centerY-fontSize/2-pad, textWidth+2*pad, fontSize+2*pad, pad)
+	dc.Fill()
+	dc.SetColor(withAlpha(args.IndicatorColor, uint8(255*alpha)))
+	dc.DrawStringAnchored(title, centerX, centerY, 0.5, 0.35)
+}
+
+// mapViewCenter возвращает центр карты с учётом временного сдвига из файла корректировок
+func mapViewCenter(p Point) (float64, float64) {
+	lat := p.Lat + p.PanNorth/111320
+	lon := p.Lon + p.PanEast/(111320*math.Cos(p.Lat*math.Pi/180))
+	return lat, lon
+}
+
 func findPointForTime(offset float64, startTime time.Time, points []Point) Point {
 	targetTime := startTime.Add(time.Duration(offset * float64(time.Second)))
-	for i := 0; i < len(points)-1; i++ {
+	// первая точка не раньше targetTime; искомый отрезок начинается перед ней
+	first := max(0, sort.Search(len(points), func(i int) bool { return !points[i].Timestamp.Before(targetTime) })-1)
+	for i := first; i < len(points)-1; i++ {
 		p1, p2 := points[i], points[i+1]
 		if (p1.Timestamp.Equal(targetTime) || p1.Timestamp.Before(targetTime)) && (p2.Timestamp.Equal(targetTime) || p2.Timestamp.After(targetTime)) {
 			timeDiff := p2.Timestamp.Sub(p1.Timestamp).Seconds()
@@ -415,19 +942,57 @@ func findPointForTime(offset float64, startTime time.Time, points []Point) Point
 				p2ResidualMapScale = p2.ResidualMapScale * math.Pow(2, float64(p1.TileZoom-p2.TileZoom))
 			}
 			return Point{
-				Lat:              p1.Lat + (p2.Lat-p1.Lat)*ratio,
-				Lon:              p1.Lon + (p2.Lon-p1.Lon)*ratio,
-				Ele:              p1.Ele + (p2.Ele-p1.Ele)*ratio,
-				Speed:            p1.Speed + (p2.Speed-p1.Speed)*derivedCalcRatio,
-				AvgSpeed:         p1.AvgSpeed + (p2.AvgSpeed-p1.AvgSpeed)*derivedCalcRatio,
-				Slope:            p1.Slope + (p2.Slope-p1.Slope)*derivedCalcRatio,
-				SmoothedSlope:    p1.SmoothedSlope + (p2.SmoothedSlope-p1.SmoothedSlope)*derivedCalcRatio,
-				Distance:         p1.Distance + (p2.Distance-p1.Distance)*derivedCalcRatio,
-				MapScale:         p1.MapScale + (p2.MapScale-p1.MapScale)*ratio,
-				Timestamp:        targetTime,
-				TileZoom:         p1.TileZoom,
-				ResidualMapScale: p1.ResidualMapScale + (p2ResidualMapScale-p1.ResidualMapScale)*ratio,
-				Bearing:          interpolateBearing(p1.Bearing, p2.Bearing, ratio),
+				Lat:                 p1.Lat + (p2.Lat-p1.Lat)*ratio,
+				Lon:                 p1.Lon + (p2.Lon-p1.Lon)*ratio,
+				Ele:                 p1.Ele + (p2.Ele-p1.Ele)*ratio,
+				Speed:               p1.Speed + (p2.Speed-p1.Speed)*derivedCalcRatio,
+				AvgSpeed:            p1.AvgSpeed + (p2.AvgSpeed-p1.AvgSpeed)*derivedCalcRatio,
+				Slope:               p1.Slope + (p2.Slope-p1.Slope)*derivedCalcRatio,
+				SmoothedSlope:       p1.SmoothedSlope + (p2.SmoothedSlope-p1.SmoothedSlope)*derivedCalcRatio,
+				Distance:            p1.Distance + (p2.Distance-p1.Distance)*derivedCalcRatio,
+				MapScale:            p1.MapScale + (p2.MapScale-p1.MapScale)*ratio,
+				Timestamp:           targetTime,
+				TileZoom:            p1.TileZoom,
+				ResidualMapScale:    p1.ResidualMapScale + (p2ResidualMapScale-p1.ResidualMapScale)*ratio,
+				Bearing:             interpolateBearing(p1.Bearing, p2.Bearing, ratio),
+				RouteDeviation:      p1.RouteDeviation + (p2.RouteDeviation-p1.RouteDeviation)*ratio,
+				Temperature:         p1.Temperature + (p2.Temperature-p1.Temperature)*ratio,
+				WindSpeed:           p1.WindSpeed + (p2.WindSpeed-p1.WindSpeed)*ratio,
+				WindDirection:       interpolateBearing(p1.WindDirection, p2.WindDirection, ratio),
+				HeartRate:           p1.HeartRate + (p2.HeartRate-p1.HeartRate)*derivedCalcRatio,
+				Power:               p1.Power + (p2.Power-p1.Power)*derivedCalcRatio,
+				AvgHeartRate:        p1.AvgHeartRate + (p2.AvgHeartRate-p1.AvgHeartRate)*derivedCalcRatio,
+				AvgPower:            p1.AvgPower + (p2.AvgPower-p1.AvgPower)*derivedCalcRatio,
+				IntensityFactor:     p1.IntensityFactor + (p2.IntensityFactor-p1.IntensityFactor)*derivedCalcRatio,
+				TSS:                 p1.TSS + (p2.TSS-p1.TSS)*derivedCalcRatio,
+				WPrimeBalance:       p1.WPrimeBalance + (p2.WPrimeBalance-p1.WPrimeBalance)*derivedCalcRatio,
+				NormalizedPower:     p1.NormalizedPower + (p2.NormalizedPower-p1.NormalizedPower)*derivedCalcRatio,
+				Cadence:             p1.Cadence + (p2.Cadence-p1.Cadence)*derivedCalcRatio,
+				StepLength:          p1.StepLength + (p2.StepLength-p1.StepLength)*derivedCalcRatio,
+				GroundContactTime:   p1.GroundContactTime + (p2.GroundContactTime-p1.GroundContactTime)*derivedCalcRatio,
+				VerticalOscillation: p1.VerticalOscillation + (p2.VerticalOscillation-p1.VerticalOscillation)*derivedCalcRatio,
+				RunNumber:           p1.RunNumber,
+				OnLift:              p1.OnLift,
+				RunDrop:             p1.RunDrop + (p2.RunDrop-p1.RunDrop)*ratio,
+				TotalDrop:           p1.TotalDrop + (p2.TotalDrop-p1.TotalDrop)*ratio,
+				Ascent:              p1.Ascent + (p2.Ascent-p1.Ascent)*ratio,
+				Descent:             p1.Descent + (p2.Descent-p1.Descent)*ratio,
+				MovingTime:          p1.MovingTime + (p2.MovingTime-p1.MovingTime)*ratio,
+				VAM:                 p1.VAM + (p2.VAM-p1.VAM)*derivedCalcRatio,
+				Calories:            p1.Calories + (p2.Calories-p1.Calories)*ratio,
+				MaxSpeed:            p1.MaxSpeed + (p2.MaxSpeed-p1.MaxSpeed)*ratio,
+				SessionAvgSpeed:     p1.SessionAvgSpeed + (p2.SessionAvgSpeed-p1.SessionAvgSpeed)*ratio,
+				PanEast:             p1.PanEast + (p2.PanEast-p1.PanEast)*ratio,
+				PanNorth:            p1.PanNorth + (p2.PanNorth-p1.PanNorth)*ratio,
+				MapRotation:         p1.MapRotation + (p2.MapRotation-p1.MapRotation)*ratio,
+				FrontGear:           p1.FrontGear,
+				RearGear:            p1.RearGear,
+				FrontGearNum:        p1.FrontGearNum,
+				RearGearNum:         p1.RearGearNum,
+				HDOP:                p1.HDOP,
+				Satellites:          p1.Satellites,
+				GPSAccuracy:         p1.GPSAccuracy,
+				ProjectedFinish:     interpolateProjectedFinish(p1.ProjectedFinish, p2.ProjectedFinish, ratio),
 			}
 		}
 	}
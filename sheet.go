@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// --- Contact Sheets ---
+
+const (
+	styleSheetFile  = "styles.png"
+	thumbnailsFile  = "thumbnails.png"
+	sheetGap        = 10.0 // px между кадрами листа
+	sheetLabelSize  = 16.0
+	sheetLabelSpace = 2.2 * sheetLabelSize // высота подписи под кадром
+)
+
+// compareStyleList разбирает -compare-styles: "all" — все известные стили, иначе список через запятую
+func compareStyleList(spec string) ([]string, error) {
+	if spec == "all" {
+		styles := make([]string, 0, len(mapStyles))
+		for name, style := range mapStyles {
+			if _, err := style.apiKey(); err != nil {
+				continue // стили без ключа в "all" не попадают
+			}
+			styles = append(styles, name)
+		}
+		sort.Strings(styles)
+		return styles, nil
+	}
+	var styles []string
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		style, ok := mapStyles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown map style: %s", name)
+		}
+		if _, err := style.apiKey(); err != nil {
+			return nil, err
+		}
+		styles = append(styles, name)
+	}
+	return styles, nil
+}
+
+// sheetFrames делит рендер (-from/-to) на n равных частей и возвращает кадры из их середин
+func sheetFrames(track *Track, args *Arguments, n int) (videoSegment, []int) {
+	segment := newVideoSegment(track, track.RenderFromIndex, track.RenderToIndex, args)
+	frames := make([]int, n)
+	for i := range frames {
+		frames[i] = min((2*i+1)*segment.Frames/(2*n), max(segment.Frames-1, 0))
+	}
+	return segment, frames
+}
+
+// renderSheetCell рисует один кадр листа; тайлы грузятся только для его окрестности,
+// а не для всего трека, поэтому лист строится за секунды
+func renderSheetCell(track *Track, args *Arguments, font *truetype.Font, segment videoSegment, frame int) image.Image {
+	loadTileWindow(chunkPoints(track, args, []videoSegment{segment}, frame, frame+1), track.TileScales, args)
+	return renderFrame(frame, segment.Frames, track, args, font, segment.StartTime)
+}
+
+// framePoint — точка трека, которую показывает кадр
+func framePoint(track *Track, args *Arguments, segment videoSegment, frame int) Point {
+	offset := frameTimeOffset(frame, track, args, segment.StartTime)
+	return findPointForTime(offset, segment.StartTime, track.SmoothedPoints)
+}
+
+// frameLabel — время видео и трека и дистанция кадра для подписи на листе
+func frameLabel(track *Track, args *Arguments, segment videoSegment, frame int) string {
+	p := framePoint(track, args, segment, frame)
+	return fmt.Sprintf("%s · %s · %.1f km", formatChapterTime(float64(frame)/args.Framerate), p.Timestamp.Local().Format("15:04:05"), p.Distance)
+}
+
+// drawContactSheet раскладывает кадры сеткой по columns в ряд с подписями под каждым
+func drawContactSheet(cells []image.Image, labels []string, columns int, font *truetype.Font) image.Image {
+	cellW, cellH := float64(cells[0].Bounds().Dx()), float64(cells[0].Bounds().Dy())
+	rows := (len(cells) + columns - 1) / columns
+	dc := gg.NewContext(int(sheetGap+float64(columns)*(cellW+sheetGap)), int(sheetGap+float64(rows)*(cellH+sheetLabelSpace+sheetGap)))
+	dc.SetColor(color.RGBA{48, 48, 48, 255}) // прозрачный фон кадров виден на тёмно-сером
+	dc.Clear()
+	dc.SetFontFace(newFontFace(font, &truetype.Options{Size: sheetLabelSize}))
+	for i, cell := range cells {
+		x := sheetGap + float64(i%columns)*(cellW+sheetGap)
+		y := sheetGap + float64(i/columns)*(cellH+sheetLabelSpace+sheetGap)
+		dc.DrawImage(cell, int(x), int(y))
+		dc.SetColor(color.White)
+		dc.DrawStringAnchored(labels[i], x+cellW/2, y+cellH+sheetLabelSpace/2, 0.5, 0.35)
+	}
+	return dc.Image()
+}
+
+// renderStyleSheet рендерит одни и те же кадры во всех стилях: строка — кадр, столбец — стиль
+func renderStyleSheet(track *Track, args *Arguments, font *truetype.Font) {
+	styles, _ := compareStyleList(args.CompareStyles)
+	segment, frames := sheetFrames(track, args, args.CompareFrames)
+	style := args.MapStyle
+	defer func() { args.MapStyle = style }()
+
+	cells := make([]image.Image, len(frames)*len(styles))
+	labels := make([]string, len(cells))
+	for j, s := range styles {
+		args.MapStyle = s
+		log.Printf("Rendering style %s...", s)
+		for i, frame := range frames {
+			cells[i*len(styles)+j] = renderSheetCell(track, args, font, segment, frame)
+			labels[i*len(styles)+j] = s + " · " + frameLabel(track, args, segment, frame)
+		}
+	}
+	if err := gg.SavePNG(styleSheetFile, drawContactSheet(cells, labels, len(styles), font)); err != nil {
+		log.Fatalf("Failed to save %s: %v", styleSheetFile, err)
+	}
+	log.Printf("Saved %s", styleSheetFile)
+}
+
+// renderThumbnailSheet рендерит n равномерно разнесённых кадров всего видео почти квадратной сеткой —
+// чтобы выбрать обложку и разом проверить масштабы и файл корректировок
+func renderThumbnailSheet(track *Track, args *Arguments, font *truetype.Font) {
+	segment, frames := sheetFrames(track, args, args.Thumbnails)
+	cells := make([]image.Image, len(frames))
+	labels := make([]string, len(frames))
+	bar := newProgressBar(len(frames), "Rendering thumbnails")
+	for i, frame := range frames {
+		cells[i] = renderSheetCell(track, args, font, segment, frame)
+		// дробный зум карты: по листу видно, как динамический масштаб меняется по ходу видео
+		p := framePoint(track, args, segment, frame)
+		labels[i] = fmt.Sprintf("%s · z%.1f", frameLabel(track, args, segment, frame), float64(p.TileZoom)-math.Log2(p.ResidualMapScale))
+		bar.Add(1)
+	}
+	columns := int(math.Ceil(math.Sqrt(float64(len(frames)))))
+	if err := gg.SavePNG(thumbnailsFile, drawContactSheet(cells, labels, columns, font)); err != nil {
+		log.Fatalf("Failed to save %s: %v", thumbnailsFile, err)
+	}
+	log.Printf("Saved %s", thumbnailsFile)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+)
+
+// --- Ski Runs ---
+
+// detectSkiRuns делит трек на подъёмы на подъёмнике и спуски по зигзагу сглаженной высоты:
+// направление меняется, когда высота отходит от последнего экстремума больше чем на skiReversalThreshold.
+// Размечает точки номером спуска, признаком подъёмника и перепадами; возвращает число спусков.
+func detectSkiRuns(points []Point) int {
+	if len(points) < 2 {
+		return 0
+	}
+	ele := smoothedElevation(points, skiEleSmoothingWindow)
+
+	// точки разворота: начало, экстремумы, конец
+	turns := []int{0}
+	dir := 0
+	lo, hi, ext := 0, 0, 0
+	for i := 1; i < len(points); i++ {
+		switch dir {
+		case 0:
+			if ele[i] < ele[lo] {
+				lo = i
+			}
+			if ele[i] > ele[hi] {
+				hi = i
+			}
+			if ele[i]-ele[lo] > skiReversalThreshold {
+				dir, ext = 1, i
+				turns[0] = lo
+			} else if ele[hi]-ele[i] > skiReversalThreshold {
+				dir, ext = -1, i
+				turns[0] = hi
+			}
+		case 1:
+			if ele[i] >= ele[ext] {
+				ext = i
+			} else if ele[ext]-ele[i] > skiReversalThreshold {
+				turns = append(turns, ext)
+				dir, ext = -1, i
+			}
+		case -1:
+			if ele[i] <= ele[ext] {
+				ext = i
+			} else if ele[i]-ele[ext] > skiReversalThreshold {
+				turns = append(turns, ext)
+				dir, ext = 1, i
+			}
+		}
+	}
+	turns = append(turns, len(points)-1)
+
+	runs := 0
+	totalDrop := 0.0
+	lastRunDrop := 0.0
+	for i := 0; i < turns[0]; i++ {
+		points[i].OnLift = true
+	}
+	for k := 0; k+1 < len(turns); k++ {
+		from, to := turns[k], turns[k+1]
+		descending := ele[to] < ele[from]
+		if descending {
+			runs++
+		}
+		for i := from; i <= to; i++ {
+			p := &points[i]
+			p.RunNumber = runs
+			p.OnLift = !descending
+			if descending {
+				p.RunDrop = math.Max(0, ele[from]-ele[i])
+				p.TotalDrop = totalDrop + p.RunDrop
+			} else {
+				p.RunDrop = lastRunDrop
+				p.TotalDrop = totalDrop
+			}
+		}
+		if descending {
+			lastRunDrop = math.Max(0, ele[from]-ele[to])
+			totalDrop += lastRunDrop
+		}
+	}
+	return runs
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// --- Smoothing Methods ---
+
+const (
+	smoothingWindow    = "window" // прежнее поведение: скользящее среднее (для высоты — без фильтра)
+	smoothingSavGol    = "savgol"
+	smoothingExp       = "exp"
+	smoothingMedian    = "median"
+	eleSmoothingSpan   = 10 * time.Second // полуширина окна сглаживания высоты
+	speedSmoothingSpan = 3 * time.Second  // полуширина окна сглаживания показываемой скорости
+	slopeSmoothingN    = 4                // полуширина окна сглаживания уклона, в точках
+)
+
+const (
+	eleFilterSlew  = "slew"
+	eleFilterClamp = "clamp"
+	eleFilterNone  = "none"
+)
+
+// filterElevationJumps убирает выбросы высоты. slew ограничивает изменение высоты между точками
+// уклоном -elevation-max-grade от пройденного расстояния, так что настоящие крутые участки
+// на редком треке сохраняются; clamp — старое правило, отбрасывающее любое изменение больше 3 м.
+func filterElevationJumps(points []Point, args *Arguments) {
+	switch args.EleFilter {
+	case eleFilterClamp:
+		for i := 1; i < len(points); i++ {
+			if math.Abs(points[i].Ele-points[i-1].Ele) > slopeMaxEleChange {
+				points[i].Ele = points[i-1].Ele
+			}
+		}
+	case eleFilterSlew:
+		for i := 1; i < len(points); i++ {
+			limit := haversine(points[i-1], points[i])*1000*args.EleMaxGrade/100 + slewEleAllowance
+			delta := points[i].Ele - points[i-1].Ele
+			points[i].Ele = points[i-1].Ele + math.Max(-limit, math.Min(limit, delta))
+		}
+	}
+}
+
+func isSmoothingMethod(s string) bool {
+	switch s {
+	case smoothingWindow, smoothingSavGol, smoothingExp, smoothingMedian:
+		return true
+	}
+	return false
+}
+
+// samplesInDuration переводит длительность в число точек по медианному интервалу записи
+func samplesInDuration(points []Point, d time.Duration) int {
+	if len(points) < 2 {
+		return 1
+	}
+	intervals := make([]float64, len(points)-1)
+	for i := 1; i < len(points); i++ {
+		intervals[i-1] = points[i].Timestamp.Sub(points[i-1].Timestamp).Seconds()
+	}
+	sort.Float64s(intervals)
+	median := intervals[len(intervals)/2]
+	if median <= 0 {
+		return 1
+	}
+	return max(1, int(math.Round(d.Seconds()/median)))
+}
+
+// smoothSeries сглаживает ряд выбранным методом с полушириной окна halfWidth точек.
+// У краёв окно симметрично укорачивается.
+func smoothSeries(values []float64, method string, halfWidth int) []float64 {
+	out := make([]float64, len(values))
+	n := len(values)
+	switch method {
+	case smoothingExp:
+		// прямой и обратный проход, чтобы не было запаздывания
+		alpha := 2.0 / float64(halfWidth+2)
+		forward := make([]float64, n)
+		for i, v := range values {
+			if i == 0 {
+				forward[i] = v
+			} else {
+				forward[i] = forward[i-1] + alpha*(v-forward[i-1])
+			}
+		}
+		for i := n - 1; i >= 0; i-- {
+			if i == n-1 {
+				out[i] = forward[i]
+			} else {
+				out[i] = out[i+1] + alpha*(forward[i]-out[i+1])
+			}
+		}
+	case smoothingSavGol:
+		// квадратичный фильтр Савицкого–Голея
+		for i := range values {
+			m := min(halfWidth, i, n-1-i)
+			norm := float64((4*m*m - 1) * (2*m + 3))
+			if m < 2 {
+				out[i] = values[i]
+				continue
+			}
+			var sum float64
+			for j := -m; j <= m; j++ {
+				c := 3 * float64(3*m*m+3*m-1-5*j*j)
+				sum += c * values[i+j]
+			}
+			out[i] = sum / norm
+		}
+	case smoothingMedian:
+		window := make([]float64, 0, 2*halfWidth+1)
+		for i := range values {
+			m := min(halfWidth, i, n-1-i)
+			window = append(window[:0], values[i-m:i+m+1]...)
+			sort.Float64s(window)
+			out[i] = window[len(window)/2]
+		}
+	default:
+		for i := range values {
+			m := min(halfWidth, i, n-1-i)
+			var sum float64
+			for j := i - m; j <= i+m; j++ {
+				sum += values[j]
+			}
+			out[i] = sum / float64(2*m+1)
+		}
+	}
+	return out
+}
+
+// --- Sensor Averages ---
+
+const normalizedPowerWindow = 30 * time.Second
+
+// computeSensorAverages считает скользящие средние пульса и мощности для стабильных показаний
+// и баланс W′ по -cp и -w-prime
+func computeSensorAverages(points []Point, args *Arguments) {
+	hr := trailingAverage(points, time.Duration(args.HRWindow*float64(time.Second)), func(p Point) float64 { return p.HeartRate })
+	power := trailingAverage(points, time.Duration(args.PowerWindow*float64(time.Second)), func(p Point) float64 { return p.Power })
+	for i := range points {
+		points[i].AvgHeartRate = hr[i]
+		points[i].AvgPower = power[i]
+	}
+	if args.CP > 0 && args.WPrime > 0 {
+		computeWPrimeBalance(points, args.CP, args.WPrime)
+	}
+}
+
+// computeTrainingLoad накапливает с начала показываемого фрагмента Normalized Power — корень четвёртой
+// степени из среднего по времени четвёртых степеней 30-секундной мощности, — а с ftp > 0 ещё
+// Intensity Factor и TSS; points — точки фрагмента
+func computeTrainingLoad(points []Point, ftp float64) {
+	power30 := trailingAverage(points, normalizedPowerWindow, func(p Point) float64 { return p.Power })
+	var sum4, seconds float64
+	for i := range points {
+		if i > 0 {
+			dt := points[i].Timestamp.Sub(points[i-1].Timestamp).Seconds()
+			sum4 += math.Pow(power30[i], 4) * dt
+			seconds += dt
+		}
+		if seconds > 0 {
+			points[i].NormalizedPower = math.Pow(sum4/seconds, 0.25)
+		} else {
+			points[i].NormalizedPower = power30[i]
+		}
+		if ftp > 0 {
+			// TSS = время(ч) * IF² * 100
+			points[i].IntensityFactor = points[i].NormalizedPower / ftp
+			points[i].TSS = seconds / 3600 * points[i].IntensityFactor * points[i].IntensityFactor * 100
+		}
+	}
+}
+
+// computeWPrimeBalance — дифференциальная модель W′bal: выше CP запас тратится на (P−CP)·dt,
+// ниже — восстанавливается со скоростью (CP−P), замедляющейся по мере заполнения.
+func computeWPrimeBalance(points []Point, cp, wPrime float64) {
+	balance := wPrime
+	for i := range points {
+		if i > 0 {
+			dt := points[i].Timestamp.Sub(points[i-1].Timestamp).Seconds()
+			if p := points[i].Power; p > cp {
+				balance -= (p - cp) * dt
+			} else {
+				balance += (cp - p) * dt * (wPrime - balance) / wPrime
+			}
+			balance = math.Max(0, math.Min(wPrime, balance))
+		}
+		points[i].WPrimeBalance = balance
+	}
+}
+
+// trailingAverage — среднее value по точкам за последние window; window == 0 — без сглаживания
+func trailingAverage(points []Point, window time.Duration, value func(Point) float64) []float64 {
+	out := make([]float64, len(points))
+	var sum float64
+	start := 0
+	for i, p := range points {
+		sum += value(p)
+		for start < i && p.Timestamp.Sub(points[start].Timestamp) >= window {
+			sum -= value(points[start])
+			start++
+		}
+		out[i] = sum / float64(i-start+1)
+	}
+	return out
+}
+
+// --- Vertical Ascent Speed ---
+
+const vamWindow = time.Minute
+
+// computeVAM считает скорость набора высоты (VAM, м/ч) по сглаженной высоте за последние vamWindow.
+// На спусках VAM отрицательная; показывается она только на подъёмах круче -vam-min-slope
+func computeVAM(points []Point) {
+	start := 0
+	for i := range points {
+		for start < i && points[i].Timestamp.Sub(points[start].Timestamp) > vamWindow {
+			start++
+		}
+		points[i].VAM = 0
+		if dt := points[i].Timestamp.Sub(points[start].Timestamp).Hours(); dt > 0 {
+			points[i].VAM = (points[i].Ele - points[start].Ele) / dt
+		}
+	}
+}
+
+// --- Energy ---
+
+const (
+	bikeMass          = 9.0   // кг, добавляется к -rider-weight
+	rollingResistance = 0.005 // Crr асфальта
+	dragArea          = 0.4   // CdA, м², посадка на тормозных ручках
+	airDensity        = 1.2   // кг/м³
+	grossEfficiency   = 0.24  // доля химической энергии, уходящая в педали
+	joulesPerKcal     = 4184.0
+	runKcalPerKgKm    = 1.0 // бег: около 1 ккал на кг веса на километр
+)
+
+// computeEnergy накапливает оценку затраченной энергии (ккал). В велосипеде работа берётся с датчика мощности,
+// а без него — из модели сопротивления качению, подъёма и воздуха; работа пересчитывается в калории через КПД.
+// В беге — по весу и дистанции. Паузы в записи длиннее -stop-seconds не считаются
+func computeEnergy(points []Point, args *Arguments) {
+	minStop := time.Duration(args.StopSeconds * float64(time.Second))
+	mass := args.RiderWeight + bikeMass
+	var kcal float64
+	for i := 1; i < len(points); i++ {
+		p1, p2 := points[i-1], points[i]
+		dt := p2.Timestamp.Sub(p1.Timestamp)
+		if dt > 0 && dt < minStop {
+			if args.Activity == "running" {
+				kcal += runKcalPerKgKm * args.RiderWeight * (p2.Distance - p1.Distance)
+			} else {
+				power := p2.Power
+				if power == 0 {
+					v := p2.Speed / 3.6
+					power = math.Max(0, (mass*9.81*(rollingResistance+p2.SmoothedSlope/100)+0.5*airDensity*dragArea*v*v)*v)
+				}
+				kcal += power * dt.Seconds() / grossEfficiency / joulesPerKcal
+			}
+		}
+		points[i].Calories = kcal
+	}
+}
+
+// --- Session Speeds ---
+
+// computeSessionSpeeds считает максимальную скорость и среднюю по времени в движении
+// с начала показываемого фрагмента; points начинаются с его первой точки
+func computeSessionSpeeds(points []Point) {
+	if len(points) == 0 {
+		return
+	}
+	first := points[0]
+	maxSpeed := 0.0
+	for i := range points {
+		p := &points[i]
+		maxSpeed = math.Max(maxSpeed, p.Speed)
+		p.MaxSpeed = maxSpeed
+		p.SessionAvgSpeed = 0
+		if moving := p.MovingTime - first.MovingTime; moving > 0 {
+			p.SessionAvgSpeed = (p.Distance - first.Distance) / moving * 3600
+		}
+	}
+}
+
+// --- Finish Projection ---
+
+const (
+	etaMinElapsed = 2 * time.Minute // раньше средняя скорость ничего не говорит
+	etaSmoothing  = time.Minute     // сглаживание самого прогноза, чтобы время финиша не дёргалось
+)
+
+// computeFinishProjection прогнозирует время финиша по оставшейся до finishDistance дистанции
+// и средней скорости за последние window, считая с остановками — как и время поездки
+func computeFinishProjection(points []Point, finishDistance float64, window time.Duration) {
+	start := 0
+	for i, p := range points {
+		for start < i && p.Timestamp.Sub(points[start].Timestamp) > window {
+			start++
+		}
+		elapsed := p.Timestamp.Sub(points[0].Timestamp)
+		dt := p.Timestamp.Sub(points[start].Timestamp).Hours()
+		remaining := math.Max(0, finishDistance-p.Distance)
+		points[i].ProjectedFinish = 0
+		if elapsed < etaMinElapsed || dt <= 0 {
+			continue
+		}
+		speed := (p.Distance - points[start].Distance) / dt
+		if remaining == 0 {
+			points[i].ProjectedFinish = float64(p.Timestamp.UnixMilli()) / 1000
+		} else if speed > 0 {
+			points[i].ProjectedFinish = float64(p.Timestamp.UnixMilli())/1000 + remaining/speed*3600
+		}
+	}
+
+	smoothed := trailingAverage(points, etaSmoothing, func(p Point) float64 { return p.ProjectedFinish })
+	for i := range points {
+		// пока в окне сглаживания есть точки без прогноза, среднее занижено
+		if points[i].ProjectedFinish > 0 && points[i].Timestamp.Sub(points[0].Timestamp) >= etaMinElapsed+etaSmoothing {
+			points[i].ProjectedFinish = smoothed[i]
+		}
+	}
+}
+
+// interpolateProjectedFinish интерполирует прогноз между точками, не смешивая его с «нет прогноза»
+func interpolateProjectedFinish(f1, f2, ratio float64) float64 {
+	if f1 == 0 || f2 == 0 {
+		return f1
+	}
+	return f1 + (f2-f1)*ratio
+}
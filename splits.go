@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// --- Structs ---
+
+// Split — пройденный километр (или миля) показываемого фрагмента
+type Split struct {
+	Number   int       // с 1
+	Time     time.Time // момент пересечения границы
+	Duration time.Duration
+}
+
+const (
+	splitsKm = "km"
+	splitsMi = "mi"
+
+	kmPerMile            = 1.609344
+	splitCalloutDuration = 5 * time.Second
+	splitCalloutFade     = 400 * time.Millisecond
+)
+
+// --- Split Detection ---
+
+// splitLength — длина отрезка -splits в км
+func splitLength(unit string) float64 {
+	if unit == splitsMi {
+		return kmPerMile
+	}
+	return 1
+}
+
+// detectSplits находит моменты, когда пройденная от начала points дистанция пересекает
+// очередную границу stepKm; время пересечения интерполируется между соседними точками
+func detectSplits(points []Point, stepKm float64) []Split {
+	if len(points) == 0 {
+		return nil
+	}
+	var splits []Split
+	start := points[0]
+	last := start.Timestamp
+	next := stepKm
+	for i := 1; i < len(points); i++ {
+		p1, p2 := points[i-1], points[i]
+		for p2.Distance-start.Distance >= next {
+			ratio := 0.0
+			if d := p2.Distance - p1.Distance; d > 0 {
+				ratio = (next - (p1.Distance - start.Distance)) / d
+			}
+			t := p1.Timestamp.Add(time.Duration(float64(p2.Timestamp.Sub(p1.Timestamp)) * ratio))
+			splits = append(splits, Split{Number: len(splits) + 1, Time: t, Duration: t.Sub(last)})
+			last = t
+			next += stepKm
+		}
+	}
+	return splits
+}
+
+// splitsBefore — число отрезков, завершённых к моменту t
+func splitsBefore(splits []Split, t time.Time) int {
+	return sort.Search(len(splits), func(i int) bool { return splits[i].Time.After(t) })
+}
+
+// --- Split Rendering ---
+
+// splitIndicator — k-й с конца (с 1) завершённый отрезок для списка в дополнительных строках;
+// пока отрезков меньше, место остаётся пустым
+func splitIndicator(k int, p Point, track *Track, args *Arguments) indicator {
+	n := splitsBefore(track.Splits, p.Timestamp)
+	if k > n {
+		return indicator{}
+	}
+	s := track.Splits[n-k]
+	// до k-го отрезка блок пуст, так что иконка рисуется покадрово
+	return indicator{LiveIcon: drawStopwatchIcon, Value: formatDuration(s.Duration), Unit: fmt.Sprintf(" %s %d", args.Splits, s.Number)}
+}
+
+// drawSplitCallout показывает время только что завершённого отрезка в течение splitCalloutDuration:
+// плашка выезжает снизу и проявляется, затем гаснет
+func drawSplitCallout(dc *gg.Context, splits []Split, currentPoint Point, centerX, y, maxWidth float64, ttf *truetype.Font, args *Arguments) {
+	n := splitsBefore(splits, currentPoint.Timestamp)
+	if n == 0 {
+		return
+	}
+	s := splits[n-1]
+	since := currentPoint.Timestamp.Sub(s.Time)
+	if since > splitCalloutDuration {
+		return
+	}
+
+	alpha := math.Min(1, math.Min(float64(since), float64(splitCalloutDuration-since))/float64(splitCalloutFade))
+	label := fmt.Sprintf("%s %d  %s", args.Splits, s.Number, formatDuration(s.Duration))
+	fontSize := maxWidth / 12
+	pad := fontSize / 2
+	y += (1 - math.Min(1, float64(since)/float64(splitCalloutFade))) * fontSize
+	dc.Push()
+	dc.SetFontFace(newFontFace(ttf, &truetype.Options{Size: fontSize}))
+	w, _ := dc.MeasureString(label)
+	dc.SetColor(color.RGBA{0, 0, 0, uint8(170 * alpha)})
+	dc.DrawRoundedRectangle(centerX-w/2-pad, y, w+2*pad, fontSize+2*pad, pad)
+	dc.Fill()
+	dc.SetColor(withAlpha(args.IndicatorColor, uint8(255*alpha)))
+	dc.DrawStringAnchored(label, centerX, y+pad+fontSize/2, 0.5, 0.35)
+	dc.Pop()
+}
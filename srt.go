@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// --- SRT Export ---
+
+// writeSRT пишет телеметрию субтитрами, по реплике на секунду видео; одинаковые
+// подряд реплики (стоп-кадры, стоянки) склеиваются в одну
+func writeSRT(filePath string, track *Track, args *Arguments, segments []videoSegment, totalFrames int) error {
+	var b strings.Builder
+	duration := float64(totalFrames) / args.Framerate
+	cue, cueStart, prevText := 0, 0.0, ""
+	flush := func(end float64) {
+		if prevText == "" {
+			return
+		}
+		cue++
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", cue, formatSRTTime(cueStart), formatSRTTime(end), prevText)
+	}
+	for second := 0.0; second < duration; second++ {
+		frameNum := min(int(second*args.Framerate), totalFrames-1)
+		seg, offset := frameTrackOffset(track, args, segments, frameNum)
+		text := srtText(track, findPointForTime(offset, seg.StartTime, track.SmoothedPoints), seg.StartTime.Add(time.Duration(offset*float64(time.Second))))
+		if text != prevText {
+			flush(second)
+			cueStart, prevText = second, text
+		}
+	}
+	flush(duration)
+	return os.WriteFile(filePath, []byte(b.String()), 0644)
+}
+
+// srtText — строки реплики в тех же величинах, что показывает оверлей
+func srtText(track *Track, p Point, t time.Time) string {
+	speed := track.SpeedReadout.at(t, p.Speed)
+	slope := track.SlopeReadout.at(t, p.SmoothedSlope)
+	lines := []string{
+		fmt.Sprintf("%.1f km/h · %.1f%%", speed, slope),
+		fmt.Sprintf("%.2f km · %.0f m", p.Distance, p.Ele),
+	}
+	var sensors []string
+	if p.HeartRate > 0 {
+		sensors = append(sensors, fmt.Sprintf("%.0f bpm", p.HeartRate))
+	}
+	if p.Power > 0 {
+		sensors = append(sensors, fmt.Sprintf("%.0f W", p.Power))
+	}
+	if len(sensors) > 0 {
+		lines = append(lines, strings.Join(sensors, " · "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatSRTTime(seconds float64) string {
+	ms := int64(seconds * 1000)
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"image"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	xdraw "golang.org/x/image/draw"
+	"gopkg.in/yaml.v3"
+)
+
+// --- Map Styles ---
+
+const defaultStylesFile = "styles.yaml"
+
+// stylesFile — формат -styles-file: источники тайлов XYZ в дополнение к встроенным
+// и ключи API для стилей по имени, в том числе встроенных
+type stylesFile struct {
+	Styles  []MapStyle        `yaml:"styles"`
+	APIKeys map[string]string `yaml:"api_keys"`
+}
+
+// loadMapStyles добавляет к mapStyles стили из файла path; одноимённые встроенные заменяются.
+// Отсутствие styles.yaml по умолчанию — не ошибка
+func loadMapStyles(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && path == defaultStylesFile {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var f stylesFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	for i, s := range f.Styles {
+		switch {
+		case s.Name == "":
+			return fmt.Errorf("style %d has no name", i+1)
+		case strings.ContainsAny(s.Name, `/\`) || s.Name == "." || s.Name == "..":
+			return fmt.Errorf("style name %q is used as a tile cache directory and must not contain slashes", s.Name)
+		case s.URL == "":
+			return fmt.Errorf("style %s has no url", s.Name)
+		case s.MinZoom < 0 || s.MaxZoom < 0 || (s.MaxZoom > 0 && s.MaxZoom < s.MinZoom):
+			return fmt.Errorf("style %s: bad zoom range %d..%d", s.Name, s.MinZoom, s.MaxZoom)
+		case s.TileSize < 0:
+			return fmt.Errorf("style %s: tile_size must not be negative", s.Name)
+		case s.MaxConnections < 0:
+			return fmt.Errorf("style %s: max_connections must not be negative", s.Name)
+		case slices.Contains(s.Subdomains, ""):
+			return fmt.Errorf("style %s: empty subdomain in subdomains", s.Name)
+		}
+		if err := s.checkSource(); err != nil {
+			return fmt.Errorf("style %s: %w", s.Name, err)
+		}
+		mapStyles[s.Name] = s
+	}
+	for name, key := range f.APIKeys {
+		s, ok := mapStyles[name]
+		if !ok {
+			return fmt.Errorf("api_keys: unknown map style %s", name)
+		}
+		s.APIKey = key
+		mapStyles[name] = s
+	}
+	return nil
+}
+
+// addTileURLStyle регистрирует стиль для -tile-url. Тайлы кэшируются в каталоге с хэшем шаблона,
+// чтобы разные серверы не смешивались
+func addTileURLStyle(url string) (string, error) {
+	if err := checkTileURL(url); err != nil {
+		return "", err
+	}
+	h := fnv.New32a()
+	h.Write([]byte(url))
+	name := fmt.Sprintf("custom-%08x", h.Sum32())
+	mapStyles[name] = MapStyle{Name: name, URL: url}
+	return name, nil
+}
+
+func checkTileURL(url string) error {
+	for _, p := range []string{"{z}", "{x}", "{y}"} {
+		if !strings.Contains(url, p) {
+			return fmt.Errorf("tile URL %s has no %s", url, p)
+		}
+	}
+	return nil
+}
+
+// tileURL — адрес тайла. В шаблон XYZ подставляются {z}, {x}, {y}, {s} (поддомены по кругу) и {r} ("@2x" с -2x, иначе пусто),
+// в шаблонах без {r} для -2x по-старому дописывается @2x перед .png. {apikey} заменяется у всех типов
+func (s MapStyle) tileURL(z, x, y int, args *Arguments) string {
+	url := s.URL
+	if key, err := s.apiKey(); err == nil {
+		url = strings.Replace(url, "{apikey}", key, 1)
+	}
+	switch s.Type {
+	case sourceWMS:
+		return s.wmsURL(url, z, x, y, args.TileSize)
+	case sourceWMTS:
+		return s.wmtsURL(url, z, x, y)
+	}
+	url = strings.Replace(url, "{z}", strconv.Itoa(z), 1)
+	url = strings.Replace(url, "{x}", strconv.Itoa(x), 1)
+	url = strings.Replace(url, "{y}", strconv.Itoa(y), 1)
+	if strings.Contains(url, "{s}") {
+		url = strings.Replace(url, "{s}", s.subdomain(), 1)
+	}
+	if strings.Contains(url, "{r}") {
+		r := ""
+		if args.Is2x {
+			r = "@2x"
+		}
+		return strings.Replace(url, "{r}", r, 1)
+	}
+	if s.appends2x(args) {
+		if strings.Contains(url, "outdoor-v2/256") {
+			url = strings.Replace(url, "outdoor-v2/256", "outdoor-v2", 1)
+		} else {
+			url = strings.Replace(url, ".png", "@2x.png", 1)
+		}
+	}
+	return url
+}
+
+// appends2x — tileURL сам дописывает @2x к адресу тайла: только XYZ без {r} и без tile_size. Сервер
+// с объявленным tile_size отдаёт один размер, его тайлы масштабируются
+func (s MapStyle) appends2x(args *Arguments) bool {
+	return args.Is2x && (s.Type == "" || s.Type == sourceXYZ) && s.TileSize == 0 && !strings.Contains(s.URL, "{r}")
+}
+
+var (
+	defaultSubdomains = []string{"a", "b", "c"}
+	subdomainRotation atomic.Uint32
+)
+
+// subdomain — следующий по кругу поддомен для {s}: серверы, разнесённые по поддоменам, отдают
+// параллельные загрузки быстрее, когда те распределены между шардами
+func (s MapStyle) subdomain() string {
+	subs := s.Subdomains
+	if len(subs) == 0 {
+		subs = defaultSubdomains
+	}
+	return subs[int(subdomainRotation.Add(1)-1)%len(subs)]
+}
+
+// shards — число шардов, между которыми расходятся загрузки тайлов стиля
+func (s MapStyle) shards() int {
+	if s.Type != "" && s.Type != sourceXYZ || !strings.Contains(s.URL, "{s}") {
+		return 1
+	}
+	if len(s.Subdomains) == 0 {
+		return len(defaultSubdomains)
+	}
+	return len(s.Subdomains)
+}
+
+// connections — сколько тайлов стиля можно скачивать одновременно
+func (s MapStyle) connections() int {
+	if s.MaxConnections > 0 {
+		return s.MaxConnections
+	}
+	return tileFetchConcurrency * s.shards()
+}
+
+// apiKey — ключ для {apikey} в шаблоне: из переменной окружения api_key_env, иначе api_key из -styles-file
+func (s MapStyle) apiKey() (string, error) {
+	if !strings.Contains(s.URL, "{apikey}") {
+		return "", nil
+	}
+	if s.APIKeyEnv != "" {
+		if key := os.Getenv(s.APIKeyEnv); key != "" {
+			return key, nil
+		}
+	}
+	if s.APIKey != "" {
+		return s.APIKey, nil
+	}
+	hint := fmt.Sprintf("add \"api_keys: {%s: ...}\" to %s", s.Name, defaultStylesFile)
+	if s.APIKeyEnv != "" {
+		hint = fmt.Sprintf("set %s or %s", s.APIKeyEnv, hint)
+	}
+	return "", fmt.Errorf("map style %s needs an API key: %s", s.Name, hint)
+}
+
+// redact прячет ключ стиля в тексте ошибки: в нём бывает URL тайла, а ошибки попадают в лог
+func (s MapStyle) redact(err error) error {
+	key, _ := s.apiKey()
+	if err == nil || key == "" || !strings.Contains(err.Error(), key) {
+		return err
+	}
+	return redactedError{msg: strings.ReplaceAll(err.Error(), key, "<api key>"), err: err}
+}
+
+// redactedError — ошибка с вырезанным ключом API в тексте; errors.Is по-прежнему видит исходную
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e redactedError) Error() string { return e.msg }
+func (e redactedError) Unwrap() error { return e.err }
+
+// checkTileSize: тайл не того размера допустим, если стиль объявил tile_size (тогда он масштабируется),
+// а без объявления — только без -2x, как раньше
+func (s MapStyle) checkTileSize(img image.Image, args *Arguments) error {
+	b := img.Bounds()
+	if s.TileSize == 0 && args.Is2x && (b.Dx() != args.TileSize || b.Dy() != args.TileSize) {
+		return fmt.Errorf("style %s does not support 2x: tile is %dx%d", s.Name, b.Dx(), b.Dy())
+	}
+	return nil
+}
+
+// fitTileSize приводит тайл стиля с объявленным tile_size к размеру тайлов рендера
+func (s MapStyle) fitTileSize(img image.Image, args *Arguments) image.Image {
+	b := img.Bounds()
+	if s.TileSize == 0 || (b.Dx() == args.TileSize && b.Dy() == args.TileSize) {
+		return img
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, args.TileSize, args.TileSize))
+	xdraw.BiLinear.Scale(dst, dst.Bounds(), img, b, xdraw.Src, nil)
+	return dst
+}
+
+// overzoomTile — тайл глубже max_zoom стиля: увеличенная четверть (восьмая, ...) тайла-предка на max_zoom
+func overzoomTile(style string, z, x, y, maxZoom int, args *Arguments) (image.Image, error) {
+	d := z - maxZoom
+	parent, err := getTileImage(style, maxZoom, x>>d, y>>d, args)
+	if err != nil || parent == placeholderTile(args) {
+		return parent, err
+	}
+	b := parent.Bounds()
+	n := 1 << d
+	ix, iy := x-(x>>d)<<d, y-(y>>d)<<d
+	src := image.Rect(b.Min.X+ix*b.Dx()/n, b.Min.Y+iy*b.Dy()/n, b.Min.X+(ix+1)*b.Dx()/n, b.Min.Y+(iy+1)*b.Dy()/n)
+	if src.Empty() {
+		return placeholderTile(args), nil
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, args.TileSize, args.TileSize))
+	xdraw.BiLinear.Scale(dst, dst.Bounds(), parent, src, xdraw.Src, nil)
+	return dst, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"time"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// --- Sync Strip ---
+
+// drawSyncStrip рисует поверх кадра полосу с часами GPS для подбора -sync-offset: снимите камерой экран
+// GPS-устройства или телефона с секундами, наложите оверлей с -sync-strip и сравните показания в одном кадре.
+// Разница — и есть -sync-offset (положительная, если часы в полосе отстают от снятых)
+func drawSyncStrip(dc *gg.Context, frameNum int, frameTime time.Time, ttf *truetype.Font, args *Arguments) {
+	width := float64(args.VideoWidth)
+	fontSize := min(width/16, 28*args.UIScale)
+	height := fontSize * 1.6
+	label := fmt.Sprintf("GPS %s  video %.2fs  sync %+.2fs",
+		frameTime.In(args.Location).Format("15:04:05.00"), float64(frameNum)/args.Framerate, args.SyncOffset)
+
+	dc.Push()
+	dc.SetColor(color.RGBA{0, 0, 0, 200})
+	dc.DrawRectangle(0, 0, width, height)
+	dc.Fill()
+	dc.SetFontFace(newFontFace(ttf, &truetype.Options{Size: fontSize}))
+	if w, _ := dc.MeasureString(label); w > width*0.95 {
+		k := width * 0.95 / w
+		dc.ScaleAbout(k, k, width/2, height/2)
+	}
+	dc.SetColor(color.White)
+	dc.DrawStringAnchored(label, width/2, height/2, 0.5, 0.35)
+	dc.Pop()
+}
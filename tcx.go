@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"time"
+)
+
+// --- TCX ---
+
+// tcxTrackpoint — точка Garmin Training Center (TCX). Указатели отличают отсутствующее поле от нуля.
+type tcxTrackpoint struct {
+	Time     time.Time `xml:"Time"`
+	Position *struct {
+		Lat float64 `xml:"LatitudeDegrees"`
+		Lon float64 `xml:"LongitudeDegrees"`
+	} `xml:"Position"`
+	Altitude  *float64 `xml:"AltitudeMeters"`
+	Distance  *float64 `xml:"DistanceMeters"` // м от начала
+	HeartRate *float64 `xml:"HeartRateBpm>Value"`
+	Cadence   *float64 `xml:"Cadence"`
+	// ActivityExtension/v2: TPX
+	Speed      *float64 `xml:"Extensions>TPX>Speed"` // м/с
+	Watts      *float64 `xml:"Extensions>TPX>Watts"`
+	RunCadence *float64 `xml:"Extensions>TPX>RunCadence"`
+}
+
+type tcxTrack struct {
+	Points []tcxTrackpoint `xml:"Trackpoint"`
+}
+
+type tcxFile struct {
+	// тренировки: Activities/Activity/Lap/Track, маршруты: Courses/Course/Track
+	Laps []struct {
+		Tracks []tcxTrack `xml:"Track"`
+	} `xml:"Activities>Activity>Lap"`
+	Courses []struct {
+		Tracks []tcxTrack `xml:"Track"`
+	} `xml:"Courses>Course"`
+}
+
+// parseTcx читает TCX (старые выгрузки Garmin Connect, TrainerRoad). Точки без Position
+// помечаются NoFix — как записи FIT с тренажёра, их расставит -virtual-route.
+func parseTcx(filePath string) ([]Point, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var f tcxFile
+	if err := xml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse TCX file: %w", err)
+	}
+
+	var points []Point
+	addTrack := func(t tcxTrack, lap int) {
+		for _, tp := range t.Points {
+			if tp.Time.IsZero() {
+				continue
+			}
+			p := Point{Timestamp: tp.Time, Lap: lap, NoFix: tp.Position == nil}
+			if tp.Position != nil {
+				p.Lat, p.Lon = tp.Position.Lat, tp.Position.Lon
+			}
+			if tp.Altitude != nil {
+				p.Ele = *tp.Altitude
+			}
+			if tp.Distance != nil {
+				p.RecordedDistance = *tp.Distance / 1000
+			}
+			if tp.Speed != nil {
+				p.RecordedSpeed = *tp.Speed * 3.6
+			}
+			if tp.HeartRate != nil {
+				p.HeartRate = *tp.HeartRate
+			}
+			if tp.Watts != nil {
+				p.Power = *tp.Watts
+			}
+			if tp.Cadence != nil {
+				p.Cadence = *tp.Cadence
+			} else if tp.RunCadence != nil { // шагов одной ногой в минуту, как cadence в FIT
+				p.Cadence = *tp.RunCadence
+			}
+			points = append(points, p)
+		}
+	}
+	for i, lap := range f.Laps {
+		for _, t := range lap.Tracks {
+			addTrack(t, i+1)
+		}
+	}
+	for _, course := range f.Courses {
+		for _, t := range course.Tracks {
+			addTrack(t, 0)
+		}
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("no trackpoints found in %s", filePath)
+	}
+	return points, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/fogleman/gg"
+)
+
+// --- Themes ---
+
+// overlayTheme — согласованный набор цветов оверлея (hex). Panel пустой — без подложки под показателями
+type overlayTheme struct {
+	Path, Border, Indicator string
+	BarFill, BarTrack       string
+	Panel                   string
+}
+
+// themes — пресеты -theme; цвета, заданные флагами явно, важнее темы
+var themes = map[string]overlayTheme{
+	"dark": {
+		Path: "#FF3B30", Border: "#202020", Indicator: "#FFFFFF",
+		BarFill: "#4DA3FF", BarTrack: "#404040", Panel: "#000000",
+	},
+	"light": {
+		Path: "#D62828", Border: "#F0F0F0", Indicator: "#202020",
+		BarFill: "#1E88E5", BarTrack: "#C8C8C8", Panel: "#FFFFFF",
+	},
+	"minimal": {
+		Path: "#FFFFFF", Border: "#FFFFFF", Indicator: "#FFFFFF",
+		BarFill: "#FFFFFF", BarTrack: "#606060",
+	},
+	"high-contrast": {
+		Path: "#FFFF00", Border: "#000000", Indicator: "#FFFFFF",
+		BarFill: "#FFFF00", BarTrack: "#000000", Panel: "#000000",
+	},
+}
+
+// applyTheme подставляет цвета темы name в строки флагов colors (имя флага -> значение),
+// кроме флагов из set, заданных в командной строке
+func applyTheme(name string, colors map[string]*string, set map[string]bool) error {
+	t, ok := themes[name]
+	if !ok {
+		names := make([]string, 0, len(themes))
+		for n := range themes {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown theme %q (supported: %s)", name, strings.Join(names, ", "))
+	}
+	for flagName, value := range map[string]string{
+		"path-color":      t.Path,
+		"border-color":    t.Border,
+		"indicator-color": t.Indicator,
+		"bar-color":       t.BarFill,
+		"bar-track-color": t.BarTrack,
+		"panel-color":     t.Panel,
+	} {
+		if !set[flagName] {
+			*colors[flagName] = value
+		}
+	}
+	return nil
+}
+
+// --- Panels ---
+
+// drawPanelBackground рисует подложку -panel-color под показателями: одной плашкой вокруг всего блока по умолчанию,
+// а с -layout-file — под каждым видимым виджетом показателей отдельно. Рисуется до карты, чтобы не заходить на её рамку
+func drawPanelBackground(dc *gg.Context, args *Arguments) {
+	if args.PanelColor == nil {
+		return
+	}
+	pad := float64(args.WidgetSize) * args.PanelPadding
+	dc.SetColor(withAlpha(args.PanelColor, uint8(math.Round(255*args.PanelOpacity))))
+	x0, y0, x1, y1 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, name := range []string{widgetSpeed, widgetAltitude, widgetSlope, widgetDistance, widgetIndicators, widgetProfile} {
+		box := args.Layout.Widgets[name]
+		if box.Hidden || box.H == 0 ||
+			(name == widgetAltitude && (!args.ShowAltitude || args.Activity == "swimming")) ||
+			(name == widgetProfile && !args.ElevationProfile) {
+			continue
+		}
+		bx, by, bw, bh := box.X-pad*box.Scale, box.Y-pad*box.Scale, (box.W+2*pad)*box.Scale, (box.H+2*pad)*box.Scale
+		if args.LayoutFile != "" {
+			dc.DrawRoundedRectangle(bx, by, bw, bh, 2*pad*box.Scale)
+			dc.Fill()
+			continue
+		}
+		x0, y0 = math.Min(x0, bx), math.Min(y0, by)
+		x1, y1 = math.Max(x1, bx+bw), math.Max(y1, by+bh)
+	}
+	if args.LayoutFile == "" && x1 > x0 {
+		dc.DrawRoundedRectangle(x0, y0, x1-x0, y1-y0, 2*pad)
+		dc.Fill()
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"container/list"
+	"image"
+	"sync"
+)
+
+// --- Tile Memory Cache ---
+
+// tileLRU — раскодированные тайлы в памяти с бюджетом в байтах: при превышении выбрасываются
+// давно не использованные, а на промахе тайл заново читается с диска (или масштабируется).
+// Исходные тайлы лежат по пути к файлу, предмасштабированные — по scaledTileKey
+type tileLRU struct {
+	mu     sync.Mutex
+	budget int64 // 0 — без ограничения
+	used   int64
+	order  *list.List // в начале — последние использованные
+	items  map[any]*list.Element
+}
+
+type tileLRUEntry struct {
+	key  any
+	img  image.Image
+	size int64
+}
+
+// scaledTileKey — тайл, предмасштабированный под остаточный масштаб scale (ключ "%.4f")
+type scaledTileKey struct {
+	scale string
+	tile  Tile
+}
+
+func newTileLRU() *tileLRU {
+	return &tileLRU{order: list.New(), items: make(map[any]*list.Element)}
+}
+
+func (c *tileLRU) setBudget(bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.budget = bytes
+	c.evict()
+}
+
+func (c *tileLRU) load(key any) (image.Image, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*tileLRUEntry).img, true
+}
+
+func (c *tileLRU) store(key any, img image.Image) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.used -= el.Value.(*tileLRUEntry).size
+		c.order.Remove(el)
+	}
+	e := &tileLRUEntry{key: key, img: img, size: imageBytes(img)}
+	c.items[key] = c.order.PushFront(e)
+	c.used += e.size
+	c.evict()
+}
+
+func (c *tileLRU) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.items = make(map[any]*list.Element)
+	c.used = 0
+}
+
+// evict выбрасывает старые тайлы до бюджета; последний сохранённый остаётся, даже если он один больше бюджета
+func (c *tileLRU) evict() {
+	for c.budget > 0 && c.used > c.budget && c.order.Len() > 1 {
+		e := c.order.Remove(c.order.Back()).(*tileLRUEntry)
+		delete(c.items, e.key)
+		c.used -= e.size
+	}
+}
+
+// imageBytes — сколько памяти занимают пиксели тайла
+func imageBytes(img image.Image) int64 {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return int64(len(rgba.Pix))
+	}
+	b := img.Bounds()
+	return int64(b.Dx()) * int64(b.Dy()) * 4
+}
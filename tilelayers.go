@@ -0,0 +1,55 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// --- Tile Layers ---
+
+// layeredTileKey — тайл карты с наложенным слоем -overlay-style в кэше тайлов
+type layeredTileKey struct {
+	base, overlay string
+	tile          Tile
+}
+
+// getMapTile — тайл карты для рендера: тайл -style, а с -overlay-style поверх него тайл второго стиля
+// (отмывка рельефа, горизонтали) с прозрачностью -overlay-opacity. Недоступный тайл слоя просто не рисуется
+func getMapTile(z, x, y int, args *Arguments) (image.Image, error) {
+	base, err := getTileImage(args.MapStyle, z, x, y, args)
+	if err != nil || args.OverlayStyle == "" {
+		return base, err
+	}
+	key := layeredTileKey{base: args.MapStyle, overlay: args.OverlayStyle, tile: Tile{X: x, Y: y, Z: z}}
+	if img, ok := tileCache.load(key); ok {
+		return img, nil
+	}
+
+	layer, err := getTileImage(args.OverlayStyle, z, x, y, args)
+	if err != nil {
+		log.Printf("could not get overlay tile: %v", err)
+		return base, nil
+	}
+	if layer == placeholderTile(args) {
+		return base, nil
+	}
+	b := base.Bounds()
+	if layer.Bounds().Size() != b.Size() {
+		scaled := image.NewRGBA(b)
+		xdraw.BiLinear.Scale(scaled, b, layer, layer.Bounds(), xdraw.Src, nil)
+		layer = scaled
+	}
+
+	// тайлы в кэше общие, поэтому слой накладывается на копию
+	out := image.NewRGBA(b)
+	draw.Draw(out, b, base, b.Min, draw.Src)
+	opacity := image.NewUniform(color.Alpha{A: uint8(math.Round(255 * args.OverlayOpacity))})
+	draw.DrawMask(out, b, layer, layer.Bounds().Min, opacity, image.Point{}, draw.Over)
+	tileCache.store(key, out)
+	return out, nil
+}
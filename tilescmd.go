@@ -0,0 +1,197 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Tiles Subcommand ---
+
+// cachedTile — файл тайла в каталоге -tile-cache-dir: стиль/z/x/y.png или y@2x.png
+type cachedTile struct {
+	Path    string
+	Style   string
+	Tile    Tile
+	Size    int64
+	ModTime time.Time // время скачивания: из кэша тайлы только читаются
+}
+
+// runTilesCommand — «tiles stats» и «tiles prune»: обслуживание каталога тайлов, который иначе только растёт
+func runTilesCommand(argv []string) {
+	fset := flag.NewFlagSet("tiles", flag.ExitOnError)
+	dir := fset.String("tile-cache-dir", tileCacheDir, "Directory with downloaded map tiles.")
+	style := fset.String("style", "", "Only this map style (default: all styles).")
+	olderThan := fset.Float64("older-than", 0, "prune: delete tiles downloaded more than this many days ago.")
+	outside := fset.String("outside", "", "prune: delete tiles that do not overlap the box min_lat,min_lon,max_lat,max_lon.")
+	dryRun := fset.Bool("dry-run", false, "prune: only report what would be deleted.")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: %s tiles stats|prune [flags]\n\n", filepath.Base(os.Args[0]))
+		fmt.Fprintln(fset.Output(), "  stats  disk usage of the tile cache per map style")
+		fmt.Fprintln(fset.Output(), "  prune  delete tiles matching -older-than or -outside")
+		fmt.Fprintln(fset.Output())
+		fset.PrintDefaults()
+	}
+	if len(argv) == 0 {
+		fset.Usage()
+		os.Exit(2)
+	}
+	op := argv[0]
+	fset.Parse(argv[1:])
+
+	tiles, err := listCachedTiles(*dir, *style)
+	if err != nil {
+		log.Fatalf("Error reading tile cache: %v", err)
+	}
+	switch op {
+	case "stats":
+		printTileStats(tiles)
+	case "prune":
+		if *olderThan <= 0 && *outside == "" {
+			log.Fatal("tiles prune needs -older-than and/or -outside")
+		}
+		var box *[4]float64
+		if *outside != "" {
+			b, err := parseBoundingBox(*outside)
+			if err != nil {
+				log.Fatalf("Invalid -outside: %v", err)
+			}
+			box = &b
+		}
+		cutoff := time.Now().Add(-time.Duration(*olderThan * float64(24*time.Hour)))
+		pruneTiles(tiles, func(t cachedTile) bool {
+			return (*olderThan > 0 && t.ModTime.Before(cutoff)) || (box != nil && !tileOverlaps(t.Tile, *box))
+		}, *dryRun)
+	default:
+		fset.Usage()
+		os.Exit(2)
+	}
+}
+
+// listCachedTiles обходит каталог тайлов; файлы, не похожие на тайл, пропускаются
+func listCachedTiles(dir, style string) ([]cachedTile, error) {
+	var tiles []cachedTile
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) != 4 || (style != "" && parts[0] != style) {
+			return nil
+		}
+		z, errZ := strconv.Atoi(parts[1])
+		x, errX := strconv.Atoi(parts[2])
+		y, errY := strconv.Atoi(strings.TrimSuffix(strings.TrimSuffix(parts[3], ".png"), "@2x"))
+		if errZ != nil || errX != nil || errY != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		tiles = append(tiles, cachedTile{Path: path, Style: parts[0], Tile: Tile{X: x, Y: y, Z: z}, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s does not exist", dir)
+	}
+	return tiles, err
+}
+
+func printTileStats(tiles []cachedTile) {
+	type styleStats struct {
+		count      int
+		size       int64
+		minZ, maxZ int
+		oldest     time.Time
+	}
+	stats := make(map[string]*styleStats)
+	var total int64
+	for _, t := range tiles {
+		s := stats[t.Style]
+		if s == nil {
+			s = &styleStats{minZ: t.Tile.Z, maxZ: t.Tile.Z, oldest: t.ModTime}
+			stats[t.Style] = s
+		}
+		s.count++
+		s.size += t.Size
+		s.minZ, s.maxZ = min(s.minZ, t.Tile.Z), max(s.maxZ, t.Tile.Z)
+		if t.ModTime.Before(s.oldest) {
+			s.oldest = t.ModTime
+		}
+		total += t.Size
+	}
+	styles := make([]string, 0, len(stats))
+	for name := range stats {
+		styles = append(styles, name)
+	}
+	sort.Strings(styles)
+	for _, name := range styles {
+		s := stats[name]
+		fmt.Printf("%-14s %8d tiles %10.1f MB  z%d-%d  oldest %s\n", name, s.count, float64(s.size)/(1<<20), s.minZ, s.maxZ, s.oldest.Format("2006-01-02"))
+	}
+	fmt.Printf("%-14s %8d tiles %10.1f MB\n", "total", len(tiles), float64(total)/(1<<20))
+}
+
+// pruneTiles удаляет тайлы, для которых match истинно, и опустевшие каталоги z/x под ними
+func pruneTiles(tiles []cachedTile, match func(cachedTile) bool, dryRun bool) {
+	var count int
+	var size int64
+	for _, t := range tiles {
+		if !match(t) {
+			continue
+		}
+		count++
+		size += t.Size
+		if dryRun {
+			continue
+		}
+		if err := os.Remove(t.Path); err != nil {
+			log.Printf("Could not delete %s: %v", t.Path, err)
+			continue
+		}
+		// непустой каталог os.Remove не удалит
+		os.Remove(filepath.Dir(t.Path))
+		os.Remove(filepath.Dir(filepath.Dir(t.Path)))
+	}
+	verb := "Deleted"
+	if dryRun {
+		verb = "Would delete"
+	}
+	fmt.Printf("%s %d of %d tiles, %.1f MB\n", verb, count, len(tiles), float64(size)/(1<<20))
+}
+
+// parseBoundingBox разбирает "min_lat,min_lon,max_lat,max_lon"; углы можно задать в любом порядке
+func parseBoundingBox(s string) ([4]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return [4]float64{}, fmt.Errorf("expected min_lat,min_lon,max_lat,max_lon, got %q", s)
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return [4]float64{}, fmt.Errorf("bad number %q", p)
+		}
+		v[i] = f
+	}
+	return [4]float64{math.Min(v[0], v[2]), math.Min(v[1], v[3]), math.Max(v[0], v[2]), math.Max(v[1], v[3])}, nil
+}
+
+// tileOverlaps — пересекается ли тайл с box {min_lat, min_lon, max_lat, max_lon}
+func tileOverlaps(t Tile, box [4]float64) bool {
+	n := math.Pow(2, float64(t.Z))
+	lon := func(x int) float64 { return float64(x)/n*360 - 180 }
+	lat := func(y int) float64 { return math.Atan(math.Sinh(math.Pi*(1-2*float64(y)/n))) * 180 / math.Pi }
+	// y растёт к югу: северный край тайла — y, южный — y+1
+	return lat(t.Y+1) <= box[2] && lat(t.Y) >= box[0] && lon(t.X) <= box[3] && lon(t.X+1) >= box[1]
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+)
+
+// --- Structs ---
+
+// TimelineSpan — участок видео, на котором время трека идёт с постоянной скоростью.
+// Смещения в секундах от первой точки трека; TrackDur == 0 — стоп-кадр (hold).
+type TimelineSpan struct {
+	VideoStart, VideoDur float64
+	TrackStart, TrackDur float64
+}
+
+// Timeline сопоставляет время видео и время трека. Пустой Timeline — один к одному.
+type Timeline []TimelineSpan
+
+// --- Frame Timeline ---
+
+// buildTimeline строит таймлайн кадров по директивам hold, skip и speedup из файла корректировок
+func buildTimeline(points []Point, specs []TrackAdjustmentSpec) (Timeline, error) {
+	type event struct {
+		start, end float64 // смещения по треку
+		rate       float64 // скорость трека относительно видео; +Inf — вырезать
+		hold       float64
+	}
+
+	var events []event
+	resolver := newPointSpecResolver(points)
+	offset := func(i int) float64 {
+		return points[i].Timestamp.Sub(points[0].Timestamp).Seconds()
+	}
+	for _, spec := range specs {
+		from, err := resolver.resolve(spec.PointSpec)
+		if err != nil {
+			return nil, err
+		}
+		to := from
+		if spec.EndSpec != "" {
+			if to, err = resolver.resolve(spec.EndSpec); err != nil {
+				return nil, err
+			}
+			if to == -1 {
+				to = len(points) - 1
+			}
+		}
+		if from == -1 {
+			if spec.Hold != 0 || spec.EndSpec != "" {
+				log.Printf("Warning: could not find point for spec '%s'", spec.PointSpec)
+			}
+			continue
+		}
+
+		switch {
+		case spec.Hold != 0:
+			events = append(events, event{start: offset(from), end: offset(from), rate: 1, hold: spec.Hold.Seconds()})
+		case spec.Skip:
+			events = append(events, event{start: offset(from), end: offset(to), rate: math.Inf(1)})
+		case spec.Speedup != 0:
+			events = append(events, event{start: offset(from), end: offset(to), rate: spec.Speedup})
+		}
+	}
+	if len(events) == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].start < events[j].start })
+
+	var timeline Timeline
+	video, track := 0.0, 0.0
+	addSpan := func(trackDur, videoDur float64) {
+		if videoDur > 0 || trackDur > 0 {
+			timeline = append(timeline, TimelineSpan{VideoStart: video, VideoDur: videoDur, TrackStart: track, TrackDur: trackDur})
+		}
+		video += videoDur
+		track += trackDur
+	}
+	for _, e := range events {
+		if e.start < track {
+			return nil, fmt.Errorf("overlapping hold/skip/speedup ranges at %.0fs of the track", e.start)
+		}
+		addSpan(e.start-track, e.start-track)
+		if e.hold > 0 {
+			addSpan(0, e.hold)
+		} else if math.IsInf(e.rate, 1) {
+			addSpan(e.end-e.start, 0)
+		} else {
+			addSpan(e.end-e.start, (e.end-e.start)/e.rate)
+		}
+	}
+	// хвост после последней директивы идёт в реальном времени
+	timeline = append(timeline, TimelineSpan{VideoStart: video, VideoDur: math.Inf(1), TrackStart: track, TrackDur: math.Inf(1)})
+	return timeline, nil
+}
+
+// trackOffset переводит смещение в видео в смещение по треку
+func (t Timeline) trackOffset(videoOffset float64) float64 {
+	if len(t) == 0 {
+		return videoOffset
+	}
+	i := sort.Search(len(t), func(i int) bool { return t[i].VideoStart+t[i].VideoDur > videoOffset })
+	if i == len(t) {
+		i = len(t) - 1
+	}
+	s := t[i]
+	if s.TrackDur == 0 {
+		return s.TrackStart
+	}
+	if math.IsInf(s.VideoDur, 1) {
+		return s.TrackStart + (videoOffset - s.VideoStart)
+	}
+	return s.TrackStart + (videoOffset-s.VideoStart)/s.VideoDur*s.TrackDur
+}
+
+// videoOffset переводит смещение по треку в смещение в видео; вырезанные участки
+// схлопываются в точку их начала
+func (t Timeline) videoOffset(trackOffset float64) float64 {
+	if len(t) == 0 {
+		return trackOffset
+	}
+	for i := len(t) - 1; i >= 0; i-- {
+		s := t[i]
+		if s.TrackStart > trackOffset {
+			continue
+		}
+		if s.VideoDur == 0 || s.TrackDur == 0 {
+			return s.VideoStart + s.VideoDur
+		}
+		if math.IsInf(s.TrackDur, 1) {
+			return s.VideoStart + (trackOffset - s.TrackStart)
+		}
+		return s.VideoStart + math.Min(1, (trackOffset-s.TrackStart)/s.TrackDur)*s.VideoDur
+	}
+	return 0
+}
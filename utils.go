@@ -4,9 +4,16 @@ import (
 	"flag"
 	"fmt"
 	"image/color"
+	"log"
 	"math"
 	"os"
+	"path/filepath"
 	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
 )
 
 // --- Structs ---
@@ -37,6 +44,181 @@ type Arguments struct {
 	MapBrightness       float64
 	MapContrast         float64
 	SkipPathSeconds     float64
+	RouteFile           string
+	RouteColor          color.Color
+	RouteDeviationColor color.Color
+	RouteDeviationMax   float64
+	Weather             bool
+	ClimbBanner         bool
+	ShowGear            bool
+	Motorsport          bool
+	IMUFile             string
+	Activity            string
+	LiftColor           color.Color
+	SpeedSmoothing      string
+	EleSmoothing        string
+	SlopeSmoothing      string
+	EleFilter           string
+	EleMaxGrade         float64
+	Segments            string
+	StitchSegments      bool
+	MapMaskFile         string
+	Intro               float64
+	IntroTitle          string
+	Events              string
+	EventMarkers        bool
+	LookAheadZoom       float64
+	OverviewEvery       float64
+	SlopeInterval       float64
+	SlopeHysteresis     float64
+	SpeedInterval       float64
+	SpeedHysteresis     float64
+	HRInterval          float64
+	HRHysteresis        float64
+	MarkerRadius        float64
+	MarkerColor         color.Color
+	MarkerOutlineColor  color.Color
+	MarkerOutlineWidth  float64
+	MarkerPulse         float64
+	BorderStyle         string
+	CheckpointTicks     bool
+	Checkpoints         string
+	Geocode             string
+	WaypointBanners     float64
+	ShowPower           bool
+	ShowHeartRate       bool
+	PowerWindow         float64
+	HRWindow            float64
+	FTP                 float64
+	EndCard             float64
+	CP                  float64
+	WPrime              float64
+	SplitKm             float64
+	CPUProfile          string
+	MemProfile          string
+	AutoTune            bool
+	Deterministic       bool
+	ChunkMinutes        float64
+	FFmpegRestarts      int
+	OutputFormat        string
+	TileCacheDir        string
+	FFmpegPath          string
+	Demo                bool
+	PhotoDir            string
+	PhotoDuration       float64
+	PhotoTransition     float64
+	PhotoClockOffset    float64
+	Chapters            bool
+	ChapterPoints       string
+	SRT                 bool
+	NoVideo             bool
+	ExportGpx           string
+	ExportGpxInterval   float64
+	ExportPoints        string
+	GPMF                bool
+	CompareStyles       string
+	CompareFrames       int
+	Thumbnails          int
+	FallbackFonts       string
+	SpeedGaugeMax       float64
+	PowerGaugeMax       float64
+	AltitudeWidest      float64 // высота фрагмента с самой длинной записью, по ней раскладывается показатель высоты
+	GPSQuality          bool
+	GPSQualityPath      bool
+	ETA                 bool
+	ETAWindow           float64
+	VirtualRoute        string
+	Aspect              string
+	Layout              frameLayout // положение карты и показателей, считается по Aspect
+	UIScale             float64
+	Matte               bool
+	Watch               bool
+	AnnotationFile      string
+	ElevationProfile    bool
+	ShowAltitude        bool
+	AltitudeUnits       string
+	PathColorBy         string
+	PathColorRamp       []color.Color
+	PathColorMin        float64
+	PathColorMax        float64
+	PathAhead           bool
+	WaypointPins        bool
+	SpeedGaugeZones     []gaugeZone
+	SlopeColors         bool
+	SlopeThresholds     []float64
+	ShowAscent          bool
+	ShowElapsed         bool
+	ShowClock           bool
+	Location            *time.Location
+	ElapsedMode         string
+	StopSpeed           float64
+	StopSeconds         float64
+	SpeedStats          bool
+	ShowVAM             bool
+	VAMMinSlope         float64
+	Splits              string
+	SplitsShown         int
+	RiderWeight         float64
+	LayoutFile          string
+	WidgetShape         string
+	WidgetCornerRadius  float64
+	Theme               string
+	BarColor            color.Color
+	BarTrackColor       color.Color
+	Panels              bool
+	PanelColor          color.Color // nil — без подложки
+	PanelOpacity        float64
+	PanelPadding        float64
+	TextEffect          string
+	TextEffectColor     color.Color
+	FontFile            string
+	SourceVideo         string
+	SourceOffset        float64
+	SourceAnchor        string
+	SyncOffset          float64
+	SyncStrip           bool
+	EncodeParallelism   int
+	TileCacheMem        int // МБ
+	TileRetries         int
+	TileRetryDelay      float64 // с, пауза перед первым повтором
+	StylesFile          string
+	TileURL             string
+	OverlayStyle        string
+	OverlayOpacity      float64
+	Attribution         bool
+	Offline             bool
+}
+
+// --- Profiling ---
+
+// startProfiling включает -cpuprofile и возвращает функцию, которая останавливает его
+// и пишет -memprofile; вызывается через defer в main.
+func startProfiling(args *Arguments) func() {
+	if args.CPUProfile != "" {
+		f, err := os.Create(args.CPUProfile)
+		if err != nil {
+			log.Fatalf("Could not create CPU profile: %v", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			log.Fatalf("Could not start CPU profile: %v", err)
+		}
+	}
+	return func() {
+		if args.CPUProfile != "" {
+			pprof.StopCPUProfile()
+		}
+		if args.MemProfile != "" {
+			f, err := os.Create(args.MemProfile)
+			if err != nil {
+				log.Fatalf("Could not create memory profile: %v", err)
+			}
+			defer f.Close()
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				log.Fatalf("Could not write memory profile: %v", err)
+			}
+		}
+	}
 }
 
 // --- Argument Parsing ---
@@ -44,17 +226,29 @@ type Arguments struct {
 func parseArguments() *Arguments {
 	args := &Arguments{}
 	var pathColorStr, borderColorStr, indicatorColorStr string
+	var routeColorStr, routeDeviationColorStr, liftColorStr string
+	var markerColorStr, markerOutlineColorStr string
+	var barColorStr, barTrackColorStr, panelColorStr string
 
-	flag.StringVar(&args.GpxFile, "gpx", "example.gpx", "Path to the GPX file.")
+	flag.StringVar(&args.GpxFile, "gpx", "example.gpx", "Path to the track file (GPX, FIT, TCX, KML or KMZ). Several files of one ride can be given as a comma-separated list or a pattern like ride_*.gpx; they are joined by time.")
+	flag.BoolVar(&args.Demo, "demo", false, "Generate a synthetic track into demo.gpx (overwriting it) and render a short clip from it; -gpx is ignored.")
 	flag.StringVar(&args.OutputFile, "o", "output_go.mp4", "Output video file name.")
+	flag.StringVar(&args.OutputFormat, "format", formatMP4, "Output format: mp4 (H.264 without transparency, see -matte), prores (ProRes 4444 .mov with alpha), qtrle (QuickTime Animation .mov with alpha, lossless, large) or webm (VP9 with alpha) — these need ffmpeg; avi (MJPEG preview without transparency, built in); png (or pngseq) or tiff (numbered PNG or Deflate-compressed TIFF frames with alpha in a directory named after -o, built in).")
 	flag.StringVar(&args.Bitrate, "bitrate", "5M", "Video bitrate (e.g., 5M).")
 	flag.IntVar(&args.Workers, "workers", runtime.NumCPU(), "Number of parallel workers for frame generation.")
+	flag.BoolVar(&args.AutoTune, "auto-tune", false, "Benchmark a few seconds of rendering and encoding with different worker counts and use the fastest instead of -workers.")
+	flag.BoolVar(&args.Deterministic, "deterministic", false, "Produce bit-identical output across runs: strip encoder metadata and timestamps, use bit-exact encoding and print the SHA-256 of the result.")
+	flag.Float64Var(&args.ChunkMinutes, "chunk-minutes", 0, "Render long tracks in chunks of this many minutes of video, keeping only the current chunk's map tiles in memory (0 = prefetch tiles for the whole track upfront).")
+	flag.IntVar(&args.FFmpegRestarts, "ffmpeg-restarts", 0, "If ffmpeg dies mid-render, restart it up to this many times, encode the rest into part files and concatenate them at the end. Output is written as fragmented MP4 with a keyframe every 2 seconds so a partial part stays readable; frames the crashed ffmpeg did not write are sent again.")
+	flag.StringVar(&args.CPUProfile, "cpuprofile", "", "Write a CPU profile to this file.")
+	flag.StringVar(&args.MemProfile, "memprofile", "", "Write a heap profile to this file on exit.")
 	flag.Float64Var(&args.Framerate, "framerate", 23.976, "Video framerate.")
-	flag.StringVar(&args.MapStyle, "style", "thunderforest", "Map style (e.g., default, cyclosm, toner).")
+	flag.StringVar(&args.TileCacheDir, "tile-cache-dir", tileCacheDir, "Directory for downloaded map tiles (relative to the current directory unless absolute).")
+	flag.StringVar(&args.MapStyle, "style", "thunderforest", "Map style (e.g., default, cyclosm, toner) or a style defined in -styles-file.")
 	flag.IntVar(&args.MapZoom, "map-zoom", 15, "Map zoom level. Default 15 is approx 1km diameter for a 400px widget.")
 	flag.IntVar(&args.WidgetSize, "widget-size", 600, "Map widget diameter in pixels.")
-	flag.Float64Var(&args.MapBrightness, "map-brightness", 0, "Map brightness adjustment (-1 to 1), normal 0.")
-	flag.Float64Var(&args.MapContrast, "map-contrast", 1, "Map contrast adjustment (0 to 4), normal 2.")
+	flag.Float64Var(&args.MapBrightness, "map-brightness", 0, "Map brightness shift from -1 (black) to 1 (white); 0 leaves tiles unchanged, -0.3..0.3 is the useful range.")
+	flag.Float64Var(&args.MapContrast, "map-contrast", 1, "Map contrast multiplier from 0 (flat grey) to 4; 1 leaves tiles unchanged, 1.5..2 makes pale styles readable.")
 	flag.Float64Var(&args.SkipPathSeconds, "skip-path-seconds", 0, "Do not draw path for the first X seconds of the track.")
 	pathWidth := flag.Float64("path-width", 10, "Width of the drawn path.")
 	flag.StringVar(&pathColorStr, "path-color", "#FF0000", "Color of the drawn path (hex).")
@@ -64,22 +258,436 @@ func parseArguments() *Arguments {
 	flag.BoolVar(&args.Is2x, "2x", true, "Use 2x tiles.")
 	flag.BoolVar(&args.Debug, "debug", false, "Debug slope calculation.")
 	flag.BoolVar(&args.DynMapScale, "dyn-map-scale", false, "Enable dynamic map scaling based on speed.")
+	flag.Float64Var(&args.LookAheadZoom, "lookahead-zoom", 0, "Zoom in ahead of sharp turns and switchbacks found within the next N seconds (0 to disable). Combines with -dyn-map-scale.")
+	flag.Float64Var(&args.SlopeInterval, "slope-interval", 5, "Update the slope readout every N seconds (0 to update every frame).")
+	flag.Float64Var(&args.SlopeHysteresis, "slope-hysteresis", 0, "Keep the shown slope until it changes by at least this many percent.")
+	flag.Float64Var(&args.SpeedInterval, "speed-interval", 0, "Update the speed readout every N seconds (0 to update every frame).")
+	flag.Float64Var(&args.SpeedHysteresis, "speed-hysteresis", 0, "Keep the shown speed until it changes by at least this many km/h.")
+	flag.Float64Var(&args.HRInterval, "hr-interval", 0, "Update the -heart-rate readout every N seconds (0 to update every frame).")
+	flag.Float64Var(&args.HRHysteresis, "hr-hysteresis", 0, "Keep the shown heart rate until it changes by at least this many bpm.")
+	flag.Float64Var(&args.OverviewEvery, "overview-every", 0, "Every N minutes briefly zoom out to show the whole track, then zoom back in (0 to disable). Single points can also be marked with 'overview' in the track adjustment file.")
+	flag.StringVar(&args.RouteFile, "route", "", "GPX file with a planned route (<rte>) to display faintly under the track.")
+	flag.StringVar(&routeColorStr, "route-color", "#3050FF", "Color of the planned route (hex).")
+	flag.StringVar(&routeDeviationColorStr, "route-deviation-color", "#FFD700", "Color of the path where it deviates from the planned route (hex).")
+	flag.Float64Var(&args.RouteDeviationMax, "route-deviation", 50, "Distance from the planned route (meters) beyond which the path is highlighted.")
+	flag.BoolVar(&args.Weather, "weather", false, "Show historical temperature and wind (from open-meteo.com) for the ride.")
+	flag.BoolVar(&args.ClimbBanner, "climb-banner", false, "Show a banner with remaining length and gradient while on a categorized climb.")
+	flag.BoolVar(&args.Motorsport, "motorsport", false, "Show lean angle and lateral/longitudinal g-force gauges for motorcycle and track-day videos. Computed from GPS, or from -imu sensors where they cover the track.")
+	flag.StringVar(&args.IMUFile, "imu", "", "Accelerometer/gyroscope source for -motorsport: a GoPro MP4 with GPMF telemetry or a FIT file with accelerometer and gyroscope messages. The sensor orientation is found automatically.")
+	flag.StringVar(&args.Activity, "activity", "cycling", "Activity type: cycling, running, swimming or skiing. Running adds stride length, ground contact time and vertical oscillation widgets (FIT running dynamics); swimming shows pace per 100 m and stroke rate and smooths the noisy track; skiing counts runs and vertical drop.")
+	flag.StringVar(&liftColorStr, "lift-color", "#808080", "Color of the path on ski lifts in skiing mode (hex).")
+	flag.BoolVar(&args.CheckpointTicks, "checkpoint-ticks", false, "Mark GPX waypoints lying on the track as ticks on the distance bar.")
+	flag.StringVar(&args.Checkpoints, "checkpoints", "", "Extra distance bar ticks as a comma-separated list of name@km, e.g. 'Feed@42.5,Summit@61'.")
+	flag.BoolVar(&args.ShowPower, "power", false, "Show rolling-average power and running Normalized Power from the track's power meter data.")
+	flag.BoolVar(&args.ShowHeartRate, "heart-rate", false, "Show rolling-average heart rate.")
+	flag.Float64Var(&args.FTP, "ftp", 0, "Functional Threshold Power in watts; enables Normalized Power, Intensity Factor and TSS readouts and colors the -power readout by training zone (0 to disable).")
+	flag.Float64Var(&args.CP, "cp", 0, "Critical Power in watts for the W′ balance gauge (needs -w-prime).")
+	flag.Float64Var(&args.WPrime, "w-prime", 0, "Anaerobic work capacity W′ in joules for the W′ balance gauge (needs -cp).")
+	flag.Float64Var(&args.PowerWindow, "power-window", 3, "Rolling average window for displayed power, seconds (e.g. 3 or 10).")
+	flag.Float64Var(&args.HRWindow, "hr-window", 5, "Rolling average window for displayed heart rate, seconds.")
+	flag.Float64Var(&args.WaypointBanners, "waypoint-banners", 0, "Show a banner with the waypoint name when passing within N meters of a named GPX waypoint (0 to disable).")
+	flag.StringVar(&args.Geocode, "geocode", "", "Show the current road or locality name: 'nominatim' for online reverse geocoding (cached, 1 request per second), or a path to an offline extract with 'lat,lon,name' lines.")
+	flag.StringVar(&args.BorderStyle, "border-style", border3D, "Map border style: 3d, flat, gradient, progress (fills clockwise with ride progress and replaces the distance bar) or none.")
+	flag.Float64Var(&args.MarkerRadius, "marker-radius", 8, "Radius of the current position marker in pixels.")
+	flag.StringVar(&markerColorStr, "marker-color", "#0000ff", "Fill color of the current position marker (hex).")
+	flag.StringVar(&markerOutlineColorStr, "marker-outline-color", "#ffffff", "Outline color of the current position marker (hex).")
+	flag.Float64Var(&args.MarkerOutlineWidth, "marker-outline-width", 2, "Outline width of the current position marker in pixels.")
+	flag.Float64Var(&args.MarkerPulse, "marker-pulse", 0, "Period in seconds of a pulsing ring around the marker (0 to disable).")
+	flag.BoolVar(&args.ShowGear, "gear", false, "Show electronic shifting (Di2/AXS) gear indicator. Requires a FIT file with gear change events.")
+	flag.StringVar(&args.SpeedSmoothing, "speed-smoothing", smoothingWindow, "Speed smoothing: window (moving average), savgol (Savitzky-Golay), exp (exponential) or median.")
+	flag.StringVar(&args.EleFilter, "elevation-filter", eleFilterSlew, "Elevation jump filter: slew (limit change to -elevation-max-grade of the distance travelled), clamp (legacy: drop any change over 3 m between points) or none.")
+	flag.Float64Var(&args.EleMaxGrade, "elevation-max-grade", 35, "Maximum plausible gradient (%) for -elevation-filter=slew.")
+	flag.StringVar(&args.EleSmoothing, "elevation-smoothing", smoothingWindow, "Elevation low-pass filter applied after the jump filter: window (off), savgol, exp or median.")
+	flag.StringVar(&args.SlopeSmoothing, "slope-smoothing", smoothingWindow, "Slope smoothing: window (moving average), savgol, exp or median.")
+	flag.StringVar(&args.MapMaskFile, "map-mask-file", "", "File with map regions to blur or cover, one per line: 'rect lat,lon lat,lon blur' or 'circle lat,lon radius_m color=#RRGGBB'.")
+	flag.Float64Var(&args.Intro, "intro", 0, "Hold the first frame for this many seconds before the track starts moving.")
+	flag.Float64Var(&args.EndCard, "end-card", 0, "Hold the last frame for this many seconds with a ride summary card.")
+	flag.Float64Var(&args.SplitKm, "split-km", 0, "Add per-split speed, heart rate and power averages to the -end-card summary, one row per N km (0 to disable).")
+	flag.StringVar(&args.IntroTitle, "intro-title", "", "Title that fades in over the -intro freeze frame.")
+	flag.StringVar(&args.Events, "events", "", "Comma-separated event types to call out on screen: stop (long stops), brake (hard braking), max-speed.")
+	flag.BoolVar(&args.EventMarkers, "event-markers", false, "Also mark past -events on the map.")
+	flag.StringVar(&args.PhotoDir, "photos", "", "Directory with JPEG photos: each is shown for a moment at its EXIF capture time and pinned on the map.")
+	flag.Float64Var(&args.PhotoDuration, "photo-duration", 4, "How long each -photos photo stays on screen, in seconds of track time.")
+	flag.Float64Var(&args.PhotoTransition, "photo-transition", 0.5, "Fade in/out time for -photos, in seconds.")
+	flag.Float64Var(&args.PhotoClockOffset, "photo-clock-offset", 0, "Seconds to add to photo EXIF times to match the track, if the camera clock was off. Times without a time zone are read as local time.")
+	flag.BoolVar(&args.Chapters, "chapters", false, "Write MP4 chapters at the start, laps (FIT lap messages or returns to the start point) and climbs, and print them as a YouTube chapter list.")
+	flag.StringVar(&args.ChapterPoints, "chapter-points", "", "Extra chapters as name@point, e.g. Summit@12.5km,Cafe@1h20m; points take any -from format. Implies -chapters.")
+	flag.BoolVar(&args.SRT, "srt", false, "Also write speed, slope, distance, elevation, heart rate and power as timed subtitles next to each output video (output.srt).")
+	flag.BoolVar(&args.NoVideo, "no-video", false, "Skip rendering and only write side files such as -srt and the -chapters list.")
+	flag.StringVar(&args.ExportGpx, "export-gpx", "", "Write the cleaned-up track (elevation spikes filtered and smoothed, duplicate positions fixed, -from/-to applied) to this GPX file.")
+	flag.Float64Var(&args.ExportGpxInterval, "export-gpx-interval", 0, "Resample -export-gpx to one point every N seconds (0 keeps the original points).")
+	flag.StringVar(&args.ExportPoints, "export-points", "", "Write per-point computed metrics (speed, avg speed, slope, map scale, zoom, bearing, ...) to this .csv or .json file.")
+	flag.BoolVar(&args.GPMF, "gpmf", false, "Embed a GoPro-compatible GPMF telemetry track (gpmd) into the output MP4.")
+	flag.StringVar(&args.CompareStyles, "compare-styles", "", "Render the same frames in these map styles (comma-separated, or \"all\") side by side into styles.png instead of a video.")
+	flag.IntVar(&args.CompareFrames, "compare-frames", 1, "Number of evenly spaced frames (rows) in the -compare-styles sheet.")
+	flag.IntVar(&args.Thumbnails, "thumbnails", 0, "Render this many evenly spaced frames of the whole video into a contact sheet thumbnails.png instead of a video.")
+	flag.StringVar(&args.FontFile, "font", "", "TrueType font file (.ttf, or .otf with TrueType outlines) for all text instead of the built-in one; falls back to the built-in font if it cannot be loaded.")
+	flag.StringVar(&args.FallbackFonts, "fallback-fonts", "", "Comma-separated TrueType (.ttf) fonts for characters missing from the built-in font, e.g. CJK titles and labels.")
+	flag.Float64Var(&args.SpeedGaugeMax, "speed-gauge-max", 0, "Full scale of the speedometer needle in km/h (0 = track maximum, rounded up).")
+	flag.Float64Var(&args.PowerGaugeMax, "power-gauge-max", 0, "Full scale of the power dial in watts (0 = track maximum, rounded up).")
+	flag.BoolVar(&args.GPSQuality, "gps-quality", false, "Show a signal-quality icon from HDOP and satellite count (GPX) or GPS accuracy (FIT).")
+	flag.BoolVar(&args.GPSQualityPath, "gps-quality-path", false, "Draw a wide translucent band under the path where GPS accuracy was poor.")
+	flag.BoolVar(&args.ETA, "eta", false, "Show projected finish time from the remaining distance and the rolling average speed (stops included).")
+	flag.Float64Var(&args.ETAWindow, "eta-window", 15, "Rolling average window for the -eta projection, minutes.")
+	flag.StringVar(&args.VirtualRoute, "virtual-route", "", "GPX route (or track) to replay an indoor ride on: positions come from the recorded distance or speed, ignoring the ride's own GPS.")
+	flag.StringVar(&args.Aspect, "aspect", "", "Frame layout preset: 9:16 (vertical, for Reels/Shorts) or 1:1 (square). By default the frame fits the widget.")
+	flag.Float64Var(&args.UIScale, "ui-scale", 1, "Scale the whole widget (map, fonts, lines, marker, bars) by this factor, e.g. 2 or 4 for 4K footage. The map keeps its coverage and switches to more detailed tiles.")
+	flag.BoolVar(&args.Matte, "matte", false, "Write the overlay without alpha plus a matching black/white matte (<output>_matte.mp4) for editors that cannot import alpha video. Needs -format mp4.")
+	flag.BoolVar(&args.Watch, "watch", false, "Re-render whenever the track, adjustment, annotation, mask, route or font files change. Renders the first frame unless -to is given, so use -from/-to for a short preview clip.")
+	flag.StringVar(&args.AnnotationFile, "annotations", "", "File with timed on-screen messages, one per line: point (as in the adjustment file, e.g. 12.5km or 3600s), optional duration=8s and color=#RRGGBB, then the text.")
+	flag.BoolVar(&args.ElevationProfile, "elevation-profile", false, "Show the elevation profile of the whole track under the indicators, with the ridden part shaded and a dot at the current position.")
+	flag.BoolVar(&args.ShowAltitude, "altitude", false, "Show the current altitude between speed and slope (not in swimming mode).")
+	flag.StringVar(&args.AltitudeUnits, "altitude-units", "m", "Units of the -altitude and -ascent readouts: m or ft.")
+	flag.StringVar(&args.PathColorBy, "path-color-by", "", "Color the drawn path by a metric instead of -path-color: speed, slope or hr (rolling-average heart rate).")
+	pathColorRampStr := flag.String("path-color-ramp", "#3060FF,#30C050,#FFD000,#FF3020", "Comma-separated colors (hex) for -path-color-by, from the lowest to the highest value.")
+	flag.Float64Var(&args.PathColorMin, "path-color-min", 0, "Metric value at the first -path-color-ramp color (with -path-color-max; both 0 = range of the rendered part, speed from 0).")
+	flag.Float64Var(&args.PathColorMax, "path-color-max", 0, "Metric value at the last -path-color-ramp color.")
+	flag.BoolVar(&args.PathAhead, "path-ahead", false, "Also draw the rest of the track ahead of the current position as a faded dashed line.")
+	flag.BoolVar(&args.WaypointPins, "waypoint-pins", false, "Pin named GPX waypoints (<wpt>: cafés, summits, photo spots) on the map with their names.")
+	speedGaugeZonesStr := flag.String("speed-gauge-zones", "", "Colored ranges on the speedometer scale as max:#RRGGBB in km/h, e.g. '25:#30C050,40:#FFD000,60:#FF3020'.")
+	flag.BoolVar(&args.SlopeColors, "slope-colors", false, "Color the slope readout by gradient: green when flat, yellow, orange and red on steeper climbs, blue on descents.")
+	slopeThresholdsStr := flag.String("slope-thresholds", "3,6,9", "Gradients (%) where -slope-colors switches from green to yellow, orange and red; descents steeper than minus the first one are blue.")
+	flag.BoolVar(&args.ShowAscent, "ascent", false, "Show total elevation gain and loss since the start of the track (changes under 3 m are ignored as noise), in -altitude-units.")
+	flag.BoolVar(&args.SpeedStats, "speed-stats", false, "Show the maximum speed and the average moving speed since the start of the rendered fragment.")
+	flag.BoolVar(&args.ShowVAM, "vam", false, "Show VAM, the climbing rate in vertical meters per hour over the last minute, while the slope is above -vam-min-slope.")
+	flag.Float64Var(&args.VAMMinSlope, "vam-min-slope", 3, "Slope (%) above which the -vam readout appears.")
+	flag.StringVar(&args.Splits, "splits", "", "Show each kilometer (km) or mile (mi) split time as a callout when it is completed, counted from the start of the rendered fragment.")
+	flag.IntVar(&args.SplitsShown, "splits-shown", 3, "Number of recent -splits kept as readouts under the distance bar (0 for the callout only).")
+	flag.Float64Var(&args.RiderWeight, "rider-weight", 0, "Rider weight in kg; shows an estimate of the energy burned since the start of the track in kcal, from the power meter or, without one, a speed and slope model (0 to disable). Cycling and running only.")
+	flag.BoolVar(&args.ShowElapsed, "elapsed", false, "Show elapsed time (H:MM:SS) since the start of the rendered fragment (-from).")
+	flag.StringVar(&args.ElapsedMode, "elapsed-mode", elapsedTotal, "What -elapsed counts: total (wall time) or moving (stops excluded, see -stop-speed and -stop-seconds).")
+	flag.Float64Var(&args.StopSpeed, "stop-speed", 2, "Speed in km/h below which the rider counts as stopped for moving time.")
+	flag.Float64Var(&args.StopSeconds, "stop-seconds", 10, "Minimum length of a stop (or a pause in the recording) in seconds; shorter slowdowns count as moving.")
+	flag.BoolVar(&args.ShowClock, "clock", false, "Show the time of day from the track timestamps, in -timezone.")
+	timezone := flag.String("timezone", "", "Time zone for -clock, -eta, clock-time -from/-to and photo EXIF times without an offset: an IANA name like Europe/Berlin or an offset like +03:00 (default: this computer's zone).")
+	flag.StringVar(&args.LayoutFile, "layout-file", "", "JSON file that rearranges the overlay: optional frame width and height, and per widget (map, speed, altitude, slope, distance, indicators, elevation_profile) an anchor (top-left ... bottom-right, center), offset [x, y] in pixels, size (width in pixels) and visible.")
+	flag.StringVar(&args.WidgetShape, "widget-shape", shapeCircle, "Map widget shape: circle, square or rounded.")
+	flag.Float64Var(&args.WidgetCornerRadius, "widget-corner-radius", 0.12, "Corner radius of the rounded map widget, as a fraction of -widget-size.")
+	flag.StringVar(&args.Theme, "theme", "", "Color preset for the whole overlay: dark, light, minimal or high-contrast. Color flags given explicitly override it.")
+	flag.StringVar(&barColorStr, "bar-color", "#64B4FF", "Color of the filled part of the distance bar (hex).")
+	flag.StringVar(&barTrackColorStr, "bar-track-color", "#505050", "Color of the remaining part of the distance bar (hex).")
+	flag.StringVar(&panelColorStr, "panel-color", "", "Color of a translucent backing plate behind the indicators (hex; empty = none unless -panels).")
+	flag.BoolVar(&args.Panels, "panels", false, "Draw rounded translucent panels behind the indicator row and distance bar, so text stays readable over bright footage (black unless -panel-color or -theme sets a color).")
+	flag.Float64Var(&args.PanelOpacity, "panel-opacity", 0.55, "Opacity of the indicator panels, from 0 to 1.")
+	flag.Float64Var(&args.PanelPadding, "panel-padding", 0.025, "Padding around the indicators inside their panel, as a fraction of -widget-size.")
+	flag.StringVar(&args.TextEffect, "text-effect", "", "Draw indicator text with an outline or a drop shadow so it stays readable over snow or sky: outline or shadow.")
+	textEffectColorStr := flag.String("text-effect-color", "#000000", "Color of the -text-effect outline or shadow (hex).")
+	flag.StringVar(&args.SourceVideo, "source-video", "", "Action-cam video to burn the overlay onto in the same ffmpeg pass; the output is the finished video with the footage's audio.")
+	flag.Float64Var(&args.SourceOffset, "source-offset", 0, "Seconds into -source-video where the first overlay frame belongs (negative: the overlay starts that many seconds after the video).")
+	flag.StringVar(&args.SourceAnchor, "source-anchor", "bottom-left", "Where the overlay sits on -source-video: top-left, top, top-right, left, center, right, bottom-left, bottom or bottom-right.")
+	flag.Float64Var(&args.SyncOffset, "sync-offset", 0, "Seconds (fractional, may be negative) added to the track time of every frame when the camera clock is off from GPS: positive shows data from later in the track.")
+	flag.BoolVar(&args.SyncStrip, "sync-strip", false, "Burn a strip with the GPS clock, video time and -sync-offset into the top of each frame, to find the offset against a filmed clock.")
+	flag.IntVar(&args.EncodeParallelism, "encode-parallelism", 1, "Split the video into this many chunks, encode them with separate ffmpeg processes at once and join them losslessly at the end.")
+	flag.IntVar(&args.TileCacheMem, "tile-cache-mem", 1024, "Memory budget in MB for decoded map tiles; least recently used tiles are dropped and re-read from the tile cache directory when needed again (0 = unlimited).")
+	flag.IntVar(&args.TileRetries, "tile-retries", 3, "Retry a tile download this many times after a timeout, network error, 429 or 5xx before drawing a blank tile in its place.")
+	flag.Float64Var(&args.TileRetryDelay, "tile-retry-delay", 1, "Seconds to wait before the first tile download retry; the wait doubles with each retry, with random jitter.")
+	flag.StringVar(&args.StylesFile, "styles-file", defaultStylesFile, "YAML file with extra map styles (name, url, headers, min_zoom, max_zoom, tile_size, attribution); read if present.")
+	flag.StringVar(&args.TileURL, "tile-url", "", "XYZ tile URL template to use instead of -style, e.g. https://tiles.example.com/{z}/{x}/{y}.png ({r} becomes @2x with -2x).")
+	flag.StringVar(&args.OverlayStyle, "overlay-style", "", "Second map style drawn over -style, e.g. a hillshade or contour layer defined in -styles-file.")
+	flag.Float64Var(&args.OverlayOpacity, "overlay-opacity", 0.5, "Opacity of -overlay-style, 0 to 1.")
+	flag.BoolVar(&args.Attribution, "attribution", true, "Credit the map tile providers in a small label under the map, as their terms of use require. Use -attribution=false only where credit is given elsewhere.")
+	flag.BoolVar(&args.Offline, "offline", false, "Never use the network: map tiles come only from -tile-cache-dir (missing ones are drawn blank), weather and Nominatim answers only from their caches. Makes renders reproducible without internet.")
 	flag.StringVar(&args.TrackAdjustmentFile, "track-adjustment-file", "", "File with track adjustment specifications.")
 
-	flag.StringVar(&args.From, "from", "0s", "Start of the track fragment to render. Can be in seconds (e.g., 500s) or kilometers (e.g., 17.5km).")
-	flag.StringVar(&args.To, "to", "36000s", "End of the track fragment to render. Can be in seconds (e.g., 500s) or kilometers (e.g., 17.5km).")
+	flag.StringVar(&args.From, "from", "0s", "Start of the track fragment to render. Can be in seconds (e.g., 500s), kilometers (e.g., 17.5km), local clock time (e.g., 14:32:05), RFC3339 timestamp, lat,lon (nearest point) or a GPX waypoint name.")
+	flag.StringVar(&args.To, "to", "36000s", "End of the track fragment to render. Can be in seconds (e.g., 500s), kilometers (e.g., 17.5km), local clock time (e.g., 14:32:05), RFC3339 timestamp, lat,lon (nearest point) or a GPX waypoint name.")
+
+	flag.StringVar(&args.Segments, "segments", "", "Several fragments to render instead of -from/-to, e.g. 0s-20m,1h05m-1h30m. Each goes to its own file (output_1.mp4, ...) unless -stitch-segments is set.")
+	flag.BoolVar(&args.StitchSegments, "stitch-segments", false, "Render -segments into a single video with a short fade between them.")
 
 	fmt.Println(os.Args)
 	flag.Parse()
 
+	if args.Demo {
+		// явно заданные -o и -to уважаем, остальное — под короткий ролик из демо-трека
+		set := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		args.GpxFile = demoTrackFile
+		if !set["o"] {
+			args.OutputFile = demoOutputFile
+		}
+		if !set["to"] {
+			args.To = demoDuration
+		}
+	}
+
+	if args.IMUFile != "" && !args.Motorsport {
+		log.Fatal("-imu requires -motorsport")
+	}
+
+	if args.UIScale <= 0 {
+		log.Fatal("-ui-scale must be positive")
+	}
+	// размеры в пикселях с флагов заданы для масштаба 1
+	args.WidgetSize = int(math.Round(float64(args.WidgetSize) * args.UIScale))
+	args.MarkerRadius *= args.UIScale
+	args.MarkerOutlineWidth *= args.UIScale
+
 	// Auto-calculate video size
-	args.VideoWidth = args.WidgetSize + 40
-	args.VideoHeight = args.WidgetSize + 200
+	if err := computeLayout(args); err != nil {
+		log.Fatal(err)
+	}
+
+	switch args.Activity {
+	case "cycling", "running", "swimming", "skiing":
+	default:
+		log.Fatalf("Unknown activity: %s", args.Activity)
+	}
+	switch args.EleFilter {
+	case eleFilterSlew, eleFilterClamp, eleFilterNone:
+	default:
+		log.Fatalf("Unknown elevation filter: %s", args.EleFilter)
+	}
+	if args.MapBrightness < -1 || args.MapBrightness > 1 {
+		log.Fatalf("-map-brightness must be between -1 and 1, got %g", args.MapBrightness)
+	}
+	if args.MapContrast < 0 || args.MapContrast > 4 {
+		log.Fatalf("-map-contrast must be between 0 and 4, got %g", args.MapContrast)
+	}
+	if (args.CP > 0) != (args.WPrime > 0) {
+		log.Fatal("-cp and -w-prime must be set together")
+	}
+	if args.PowerWindow < 0 || args.HRWindow < 0 {
+		log.Fatal("Power and heart rate windows must not be negative")
+	}
+	if args.SlopeInterval < 0 || args.SpeedInterval < 0 || args.HRInterval < 0 || args.SlopeHysteresis < 0 || args.SpeedHysteresis < 0 || args.HRHysteresis < 0 {
+		log.Fatal("Readout intervals and hysteresis must not be negative")
+	}
+	if args.PhotoDuration <= 0 || args.PhotoTransition < 0 || 2*args.PhotoTransition > args.PhotoDuration {
+		log.Fatal("-photo-duration must be positive and at least twice -photo-transition")
+	}
+	switch strings.ToLower(filepath.Ext(args.ExportPoints)) {
+	case "", ".csv", ".json":
+	default:
+		log.Fatalf("-export-points must be a .csv or .json file, got %s", args.ExportPoints)
+	}
+	if args.ExportGpxInterval < 0 {
+		log.Fatal("-export-gpx-interval must not be negative")
+	}
+	if err := loadMapStyles(args.StylesFile); err != nil {
+		log.Fatalf("Error loading -styles-file %s: %v", args.StylesFile, err)
+	}
+	if args.TileURL != "" {
+		var err error
+		if args.MapStyle, err = addTileURLStyle(args.TileURL); err != nil {
+			log.Fatalf("Invalid -tile-url: %v", err)
+		}
+	}
+	if style, ok := mapStyles[args.MapStyle]; !ok {
+		log.Fatalf("Unknown map style: %s", args.MapStyle)
+	} else if _, err := style.apiKey(); err != nil && !args.Offline {
+		log.Fatal(err)
+	}
+	if args.OverlayStyle != "" {
+		if style, ok := mapStyles[args.OverlayStyle]; !ok {
+			log.Fatalf("Unknown -overlay-style: %s", args.OverlayStyle)
+		} else if _, err := style.apiKey(); err != nil && !args.Offline {
+			log.Fatal(err)
+		}
+		if args.OverlayOpacity < 0 || args.OverlayOpacity > 1 {
+			log.Fatal("-overlay-opacity must be between 0 and 1")
+		}
+	}
+	if args.CompareStyles != "" {
+		if _, err := compareStyleList(args.CompareStyles); err != nil {
+			log.Fatal(err)
+		}
+		if args.CompareFrames < 1 {
+			log.Fatal("-compare-frames must be at least 1")
+		}
+	}
+	if args.SpeedGaugeMax < 0 || args.PowerGaugeMax < 0 {
+		log.Fatal("-speed-gauge-max and -power-gauge-max must not be negative")
+	}
+	if args.ETAWindow <= 0 {
+		log.Fatal("-eta-window must be positive")
+	}
+	if args.Thumbnails < 0 {
+		log.Fatal("-thumbnails must not be negative")
+	}
+	if args.ChapterPoints != "" {
+		args.Chapters = true
+	}
+	if args.FFmpegRestarts < 0 {
+		log.Fatal("-ffmpeg-restarts must not be negative")
+	}
+	if args.ChunkMinutes < 0 {
+		log.Fatal("-chunk-minutes must not be negative")
+	}
+	if args.TileCacheMem < 0 {
+		log.Fatal("-tile-cache-mem must not be negative")
+	}
+	if args.TileRetries < 0 || args.TileRetryDelay < 0 {
+		log.Fatal("-tile-retries and -tile-retry-delay must not be negative")
+	}
+	if args.OutputFormat == formatPNGSeq {
+		args.OutputFormat = formatPNG
+	}
+	switch args.OutputFormat {
+	case formatMP4, formatProRes, formatQtrle, formatWebM, formatAVI, formatPNG, formatTIFF:
+	default:
+		log.Fatalf("Unknown output format: %s", args.OutputFormat)
+	}
+	if usesFFmpeg(args.OutputFormat) {
+		var err error
+		if args.FFmpegPath, err = findFFmpeg(); err != nil && !args.RenderFirstFrame && !args.Watch && !args.Debug && !args.NoVideo && args.CompareStyles == "" && args.Thumbnails == 0 {
+			log.Fatal("ffmpeg not found on PATH or next to the program. Install it from https://ffmpeg.org/download.html, or use -format avi, png or tiff to render without ffmpeg.")
+		}
+	}
+	if args.EncodeParallelism < 1 {
+		log.Fatal("-encode-parallelism must be at least 1")
+	}
+	if args.EncodeParallelism > 1 {
+		switch {
+		case !usesFFmpeg(args.OutputFormat):
+			log.Fatal("-encode-parallelism needs an ffmpeg output format (mp4, prores, qtrle or webm)")
+		case args.Matte:
+			log.Fatal("-encode-parallelism cannot be combined with -matte")
+		case args.SourceVideo != "":
+			log.Fatal("-encode-parallelism cannot be combined with -source-video")
+		case args.ChunkMinutes > 0:
+			log.Fatal("-encode-parallelism cannot be combined with -chunk-minutes: chunks are encoded at once, so their tiles would all be needed together")
+		}
+	}
+	if args.SourceVideo != "" {
+		if !usesFFmpeg(args.OutputFormat) {
+			log.Fatal("-source-video needs an ffmpeg output format (mp4, prores, qtrle or webm)")
+		}
+		if args.Matte {
+			log.Fatal("-source-video and -matte cannot be combined: the output is already composited")
+		}
+		if args.Segments != "" {
+			log.Fatal("-source-video cannot be combined with -segments: the overlay is synced to one continuous stretch of footage")
+		}
+		if _, err := os.Stat(args.SourceVideo); err != nil {
+			log.Fatalf("Error opening -source-video: %v", err)
+		}
+		if _, ok := layoutAnchors[args.SourceAnchor]; !ok {
+			log.Fatalf("Unknown -source-anchor: %s", args.SourceAnchor)
+		}
+	}
+	if args.GPMF && args.FFmpegRestarts > 0 {
+		log.Fatal("-gpmf cannot be combined with -ffmpeg-restarts: telemetry is not embedded into fragmented MP4")
+	}
+	if args.OutputFormat != formatMP4 {
+		if args.GPMF {
+			log.Fatal("-gpmf needs -format mp4")
+		}
+		if args.Matte {
+			log.Fatal("-matte needs -format mp4 (the other formats keep transparency themselves)")
+		}
+		// -o по умолчанию — .mp4: меняем расширение, чтобы не получить AVI или MOV с именем .mp4
+		ext := filepath.Ext(args.OutputFile)
+		args.OutputFile = strings.TrimSuffix(args.OutputFile, ext) + outputExtension(args.OutputFormat)
+	}
+	if args.AltitudeUnits != "m" && args.AltitudeUnits != "ft" {
+		log.Fatalf("Unknown altitude units: %s", args.AltitudeUnits)
+	}
+	if args.ElapsedMode != elapsedTotal && args.ElapsedMode != elapsedMoving {
+		log.Fatalf("Unknown -elapsed-mode: %s", args.ElapsedMode)
+	}
+	switch args.Splits {
+	case "", splitsKm, splitsMi:
+	default:
+		log.Fatalf("Unknown -splits unit: %s", args.Splits)
+	}
+	if args.SplitsShown < 0 {
+		log.Fatal("-splits-shown must not be negative")
+	}
+	if args.RiderWeight < 0 {
+		log.Fatal("-rider-weight must not be negative")
+	}
+	if args.RiderWeight > 0 && (args.Activity == "swimming" || args.Activity == "skiing") {
+		log.Fatal("-rider-weight energy estimate supports only cycling and running")
+	}
+	if args.StopSeconds <= 0 {
+		log.Fatal("-stop-seconds must be positive")
+	}
+	switch args.PathColorBy {
+	case "", pathColorBySpeed, pathColorBySlope, pathColorByHR:
+	default:
+		log.Fatalf("Unknown -path-color-by metric: %s", args.PathColorBy)
+	}
+	if args.PathColorMin > args.PathColorMax {
+		log.Fatal("-path-color-min must not be greater than -path-color-max")
+	}
+	var err error
+	if args.PathColorRamp, err = parseColorRamp(*pathColorRampStr); err != nil {
+		log.Fatalf("Error parsing -path-color-ramp: %v", err)
+	}
+	if args.SpeedGaugeZones, err = parseGaugeZones(*speedGaugeZonesStr); err != nil {
+		log.Fatalf("Error parsing -speed-gauge-zones: %v", err)
+	}
+	if args.SlopeThresholds, err = parseSlopeThresholds(*slopeThresholdsStr); err != nil {
+		log.Fatalf("Error parsing -slope-thresholds: %v", err)
+	}
+	if args.Location, err = parseTimezone(*timezone); err != nil {
+		log.Fatalf("Error parsing -timezone: %v", err)
+	}
+	switch args.BorderStyle {
+	case border3D, borderFlat, borderGradient, borderProgress, borderNone:
+	default:
+		log.Fatalf("Unknown border style: %s", args.BorderStyle)
+	}
+	switch args.WidgetShape {
+	case shapeCircle, shapeSquare, shapeRounded:
+	default:
+		log.Fatalf("Unknown widget shape: %s", args.WidgetShape)
+	}
+	switch args.TextEffect {
+	case "", textEffectOutline, textEffectShadow:
+	default:
+		log.Fatalf("Unknown text effect: %s", args.TextEffect)
+	}
+	if args.PanelOpacity < 0 || args.PanelOpacity > 1 {
+		log.Fatal("-panel-opacity must be between 0 and 1")
+	}
+	if args.PanelPadding < 0 {
+		log.Fatal("-panel-padding must not be negative")
+	}
+	if args.WidgetCornerRadius < 0 || args.WidgetCornerRadius > 0.5 {
+		log.Fatal("-widget-corner-radius must be between 0 and 0.5")
+	}
+	for _, m := range []string{args.SpeedSmoothing, args.EleSmoothing, args.SlopeSmoothing} {
+		if !isSmoothingMethod(m) {
+			log.Fatalf("Unknown smoothing method: %s", m)
+		}
+	}
 
-	args.PathWidth = *pathWidth
+	if args.Theme != "" {
+		set := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		colors := map[string]*string{
+			"path-color": &pathColorStr, "border-color": &borderColorStr, "indicator-color": &indicatorColorStr,
+			"bar-color": &barColorStr, "bar-track-color": &barTrackColorStr, "panel-color": &panelColorStr,
+		}
+		if err := applyTheme(args.Theme, colors, set); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	args.PathWidth = *pathWidth * args.UIScale
 	args.PathColor, _ = parseHexColor(pathColorStr)
 	args.BorderColor, _ = parseHexColor(borderColorStr)
 	args.IndicatorColor, _ = parseHexColor(indicatorColorStr)
+	args.RouteColor, _ = parseHexColor(routeColorStr)
+	args.RouteDeviationColor, _ = parseHexColor(routeDeviationColorStr)
+	args.LiftColor, _ = parseHexColor(liftColorStr)
+	args.MarkerColor, _ = parseHexColor(markerColorStr)
+	args.MarkerOutlineColor, _ = parseHexColor(markerOutlineColorStr)
+	if args.TextEffectColor, err = parseHexColor(*textEffectColorStr); err != nil {
+		log.Fatalf("Error parsing -text-effect-color: %v", err)
+	}
+	args.BarColor, _ = parseHexColor(barColorStr)
+	args.BarTrackColor, _ = parseHexColor(barTrackColorStr)
+	if args.Panels && panelColorStr == "" {
+		panelColorStr = "#000000"
+	}
+	if panelColorStr != "" {
+		if args.PanelColor, err = parseHexColor(panelColorStr); err != nil {
+			log.Fatalf("Error parsing -panel-color: %v", err)
+		}
+	}
 
 	if args.Is2x {
 		args.TileSize = 512
@@ -90,6 +698,18 @@ func parseArguments() *Arguments {
 	return args
 }
 
+// parseTimezone разбирает -timezone: имя из базы IANA или смещение вида +03:00; пустая строка — местное время
+func parseTimezone(s string) (*time.Location, error) {
+	if s == "" {
+		return time.Local, nil
+	}
+	if t, err := time.Parse("-07:00", s); err == nil {
+		_, offset := t.Zone()
+		return time.FixedZone(s, offset), nil
+	}
+	return time.LoadLocation(s)
+}
+
 func parseHexColor(s string) (color.Color, error) {
 	var r, g, b uint8
 	_, err := fmt.Sscanf(s, "#%02x%02x%02x", &r, &g, &b)
@@ -99,6 +719,24 @@ func parseHexColor(s string) (color.Color, error) {
 	return color.RGBA{R: r, G: g, B: b, A: 255}, nil
 }
 
+// withAlpha возвращает тот же цвет с заданной непрозрачностью
+func withAlpha(c color.Color, a uint8) color.Color {
+	r, g, b, _ := c.RGBA()
+	return color.NRGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: a}
+}
+
+// formatPace переводит скорость (км/ч) в темп "м:сс" на отрезок perKm километров
+func formatPace(speedKmh, perKm float64) string {
+	if speedKmh < 0.1 {
+		return "--:--"
+	}
+	seconds := int(math.Round(perKm / speedKmh * 3600))
+	if seconds >= 100*60 {
+		return "--:--"
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
 func deg2num(lat, lon float64, zoom int) (float64, float64) {
 	latRad := lat * math.Pi / 180
 	n := math.Pow(2, float64(zoom))
@@ -106,3 +744,13 @@ func deg2num(lat, lon float64, zoom int) (float64, float64) {
 	ytile := (1 - math.Asinh(math.Tan(latRad))/math.Pi) / 2 * n
 	return xtile, ytile
 }
+
+// newProgressBar — progressbar.Default, но в консоли Windows рисуется ASCII-символами:
+// cmd.exe со старой кодовой страницей выводит блоки "█" мусором
+func newProgressBar(max int, description string) *progressbar.ProgressBar {
+	bar := progressbar.Default(int64(max), description)
+	if runtime.GOOS == "windows" {
+		progressbar.OptionSetTheme(progressbar.ThemeASCII)(bar)
+	}
+	return bar
+}
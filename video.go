@@ -2,38 +2,154 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"image"
+	"image/jpeg"
 	"image/png"
+	"io"
 	"log"
+	"math"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/golang/freetype/truetype"
-	"github.com/schollz/progressbar/v3"
+	"golang.org/x/image/tiff"
 )
 
 // --- Structs ---
 
+const (
+	formatMP4      = "mp4"
+	formatProRes   = "prores" // ProRes 4444 в .mov, с альфой
+	formatQtrle    = "qtrle"  // QuickTime Animation в .mov, с альфой и без потерь
+	formatWebM     = "webm"   // VP9 с альфой
+	formatAVI      = "avi"
+	formatPNG      = "png"
+	formatPNGSeq   = "pngseq" // другое имя png
+	formatTIFF     = "tiff"
+	aviJpegQuality = 90
+)
+
+// usesFFmpeg — кодирует ли формат внешний ffmpeg (остальные пишутся встроенными средствами)
+func usesFFmpeg(format string) bool {
+	switch format {
+	case formatMP4, formatProRes, formatQtrle, formatWebM:
+		return true
+	}
+	return false
+}
+
+// outputExtension — расширение файла, которое ждут монтажные программы для формата с альфой; "" — оставить из -o
+func outputExtension(format string) string {
+	switch format {
+	case formatProRes, formatQtrle:
+		return ".mov"
+	case formatWebM:
+		return ".webm"
+	case formatAVI:
+		return ".avi"
+	}
+	return ""
+}
+
 type Frame struct {
 	Number int
 	Data   []byte
 }
 
+// videoSegment — непрерывный кусок трека, рендерящийся подряд идущими кадрами
+type videoSegment struct {
+	StartTime   time.Time
+	Frames      int
+	IntroFrames int // стоп-кадр с заголовком перед началом движения (-intro)
+	OutroFrames int // стоп-кадр с итоговой карточкой в конце (-end-card)
+}
+
+func (s videoSegment) totalFrames() int {
+	return s.IntroFrames + s.Frames + s.OutroFrames
+}
+
 // --- Video Pipeline ---
 
-func generateFrames(frameChan chan<- Frame, track *Track, args *Arguments, totalFrames int, font *truetype.Font, segmentStartTime time.Time) {
+// newVideoSegment считает начало и число кадров для диапазона точек [fromIdx, toIdx)
+func newVideoSegment(track *Track, fromIdx, toIdx int, args *Arguments) videoSegment {
+	if toIdx == 0 {
+		toIdx = len(track.SmoothedPoints)
+	}
+	trackStart := track.SmoothedPoints[0].Timestamp
+	segmentFrom := track.SmoothedPoints[fromIdx].Timestamp.Sub(trackStart).Seconds()
+	segmentTo := track.SmoothedPoints[toIdx-1].Timestamp.Sub(trackStart).Seconds()
+	segmentDuration := track.Timeline.videoOffset(segmentTo) - track.Timeline.videoOffset(segmentFrom)
+	return videoSegment{
+		StartTime: track.SmoothedPoints[fromIdx].Timestamp,
+		Frames:    int(segmentDuration * args.Framerate),
+	}
+}
+
+// segmentOutputFile вставляет номер сегмента перед расширением: out.mp4 -> out_2.mp4
+func segmentOutputFile(outputFile string, n int) string {
+	ext := filepath.Ext(outputFile)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(outputFile, ext), n, ext)
+}
+
+func generateFrames(frameChan chan<- Frame, track *Track, args *Arguments, segments []videoSegment, font *truetype.Font) {
 	var wg sync.WaitGroup
 	tasks := make(chan int, args.Workers*2)
+	totalFrames := 0
+	for _, seg := range segments {
+		totalFrames += seg.totalFrames()
+	}
 
+	// при -chunk-minutes кадры раздаются кусками: следующий кусок начинается, когда предыдущий
+	// отрендерен, и перед ним кэш тайлов заменяется тайлами только для этого куска
+	var pending sync.WaitGroup
+	chunkFrames := totalFrames
+	if args.ChunkMinutes > 0 {
+		chunkFrames = max(1, int(args.ChunkMinutes*60*args.Framerate))
+	}
 	go func() {
-		for i := 0; i < totalFrames; i++ {
-			tasks <- i
+		if args.EncodeParallelism > 1 {
+			// кадры кусков параллельного кодирования раздаются вперемешку, чтобы все ffmpeg работали одновременно
+			chunkLen := encodeChunkLen(totalFrames, args)
+			for step := 0; step < chunkLen; step++ {
+				for from := 0; from < totalFrames; from += chunkLen {
+					if frameNum := from + step; frameNum < min(from+chunkLen, totalFrames) {
+						pending.Add(1)
+						tasks <- frameNum
+					}
+				}
+			}
+			close(tasks)
+			return
+		}
+		for from := 0; from < totalFrames; from += chunkFrames {
+			to := min(from+chunkFrames, totalFrames)
+			if args.ChunkMinutes > 0 {
+				pending.Wait()
+				loadTileWindow(chunkPoints(track, args, segments, from, to), track.TileScales, args)
+			}
+			pending.Add(to - from)
+			for i := from; i < to; i++ {
+				tasks <- i
+			}
 		}
 		close(tasks)
 	}()
 
+	fadeFrames := int(segmentTransition.Seconds() * args.Framerate)
+	var summary []endCardLine
+	if segments[len(segments)-1].OutroFrames > 0 {
+		summary = endCardLines(track, args)
+	}
+
 	for i := 0; i < args.Workers; i++ {
 		wg.Add(1)
 		go func() {
@@ -41,12 +157,36 @@ func generateFrames(frameChan chan<- Frame, track *Track, args *Arguments, total
 			pngBuffer := new(bytes.Buffer)
 
 			for frameNum := range tasks {
-				img := renderFrame(frameNum, totalFrames, track, args, font, segmentStartTime)
+				segIdx, segFrame := locateFrame(segments, frameNum)
+				seg := segments[segIdx]
+				var img image.Image
+				if segFrame < seg.IntroFrames {
+					img = renderFrame(0, seg.Frames, track, args, font, seg.StartTime)
+					drawIntroTitle(img, args.IntroTitle, float64(segFrame)/args.Framerate, font, args)
+				} else if outroFrame := segFrame - seg.IntroFrames - seg.Frames; outroFrame >= 0 {
+					img = renderFrame(seg.Frames-1, seg.Frames, track, args, font, seg.StartTime)
+					drawEndCard(img, summary, float64(outroFrame)/args.Framerate, font, args)
+				} else {
+					img = renderFrame(segFrame-seg.IntroFrames, seg.Frames, track, args, font, seg.StartTime)
+				}
+
+				// на стыках склеенных сегментов оверлей плавно гаснет и появляется снова
+				fade := 1.0
+				if segIdx > 0 && segFrame < fadeFrames {
+					fade = float64(segFrame) / float64(fadeFrames)
+				}
+				if segIdx < len(segments)-1 && seg.totalFrames()-1-segFrame < fadeFrames {
+					fade = math.Min(fade, float64(seg.totalFrames()-1-segFrame)/float64(fadeFrames))
+				}
+				if fade < 1 {
+					fadeImage(img, fade)
+				}
 
 				pngBuffer.Reset()
-				err := png.Encode(pngBuffer, img)
+				err := encodeFrame(pngBuffer, img, args)
 				if err != nil {
 					log.Printf("Failed to encode frame %d: %v", frameNum, err)
+					pending.Done()
 					continue
 				}
 
@@ -54,53 +194,657 @@ func generateFrames(frameChan chan<- Frame, track *Track, args *Arguments, total
 				copy(frameData, pngBuffer.Bytes())
 
 				frameChan <- Frame{Number: frameNum, Data: frameData}
+				pending.Done()
 			}
 		}()
 	}
 	wg.Wait()
 }
 
-func runVideoPipeline(track *Track, args *Arguments, font *truetype.Font) {
-	// --- FFMPEG Setup ---
-	ffmpegCmd := exec.Command("ffmpeg", "-y", "-f", "image2pipe", "-vcodec", "png", "-r", fmt.Sprintf("%f", args.Framerate), "-i", "-", "-c:v", "libx264", "-b:v", args.Bitrate, "-pix_fmt", "yuva420p", "-r", fmt.Sprintf("%f", args.Framerate), args.OutputFile)
-	ffmpegIn, err := ffmpegCmd.StdinPipe()
+// locateFrame находит сегмент склейки, к которому относится кадр, и номер кадра внутри него
+func locateFrame(segments []videoSegment, frameNum int) (segIdx, segFrame int) {
+	segFrame = frameNum
+	for segFrame >= segments[segIdx].totalFrames() {
+		segFrame -= segments[segIdx].totalFrames()
+		segIdx++
+	}
+	return segIdx, segFrame
+}
+
+// frameTrackOffset находит сегмент кадра frameNum склейки и смещение трека от начала сегмента, с.
+// Стоп-кадры показывают первый или последний кадр своего сегмента.
+func frameTrackOffset(track *Track, args *Arguments, segments []videoSegment, frameNum int) (videoSegment, float64) {
+	segIdx, segFrame := locateFrame(segments, frameNum)
+	seg := segments[segIdx]
+	frame := max(min(segFrame-seg.IntroFrames, seg.Frames-1), 0)
+	return seg, frameTimeOffset(frame, track, args, seg.StartTime)
+}
+
+// chunkPoints возвращает непрерывный диапазон SmoothedPoints, показываемых в кадрах [from, to).
+// Кадры проверяются раз в секунду видео; диапазон между крайними точками берётся целиком,
+// так что точки между проверенными кадрами тоже попадают в него.
+func chunkPoints(track *Track, args *Arguments, segments []videoSegment, from, to int) []Point {
+	points := track.SmoothedPoints
+	lo, hi := len(points), 0
+	step := max(1, int(args.Framerate))
+	for frameNum := from; ; frameNum = min(frameNum+step, to-1) {
+		seg, offset := frameTrackOffset(track, args, segments, frameNum)
+		t := seg.StartTime.Add(time.Duration(offset * float64(time.Second)))
+		i := sort.Search(len(points), func(i int) bool { return !points[i].Timestamp.Before(t) })
+		lo, hi = min(lo, i), max(hi, i)
+		if frameNum == to-1 {
+			break
+		}
+	}
+	return points[max(lo-1, 0):min(hi+1, len(points))]
+}
+
+// fadeImage умножает кадр (с предумноженной альфой) на k, делая его полупрозрачным
+func fadeImage(img image.Image, k float64) {
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		return
+	}
+	for i := range rgba.Pix {
+		rgba.Pix[i] = uint8(float64(rgba.Pix[i]) * k)
+	}
+}
+
+// autoTuneWorkers прогоняет по autoTuneDuration видео через весь конвейер — рендер и кодировщик
+// выходного формата — с разным числом воркеров и возвращает самое быстрое. Кадры берутся
+// с середины трека, где карта обычно типичная.
+func autoTuneWorkers(track *Track, args *Arguments, font *truetype.Font) int {
+	mid := track.SmoothedPoints[(track.RenderFromIndex+track.RenderToIndex)/2]
+	segment := videoSegment{StartTime: mid.Timestamp, Frames: int(autoTuneDuration.Seconds() * args.Framerate)}
+
+	candidates := []int{1}
+	for n := 2; n <= 2*runtime.NumCPU(); n *= 2 {
+		candidates = append(candidates, n)
+	}
+	if candidates[len(candidates)-1] != runtime.NumCPU() && runtime.NumCPU() > 1 {
+		candidates = append(candidates, runtime.NumCPU())
+	}
+
+	tuneDir, err := os.MkdirTemp("", "gps_overlay_autotune")
 	if err != nil {
-		log.Fatalf("Failed to get ffmpeg stdin pipe: %v", err)
+		log.Fatalf("Failed to create auto-tune directory: %v", err)
 	}
-	ffmpegCmd.Stderr = os.Stderr
-	if err := ffmpegCmd.Start(); err != nil {
-		log.Fatalf("Failed to start ffmpeg: %v", err)
+	defer os.RemoveAll(tuneDir)
+
+	// прогрев: иначе первый вариант заплатит за чтение тайлов с диска и их декодирование
+	warmArgs := *args
+	warmArgs.Workers = runtime.NumCPU()
+	generateFrames(make(chan Frame, segment.Frames), track, &warmArgs, []videoSegment{segment}, font)
+
+	best, bestFps := args.Workers, 0.0
+	for i, workers := range candidates {
+		tuneArgs := *args
+		tuneArgs.Workers = workers
+		tuneArgs.SourceVideo = "" // кадры с середины трека не совпадают с началом видео с камеры
+		outputFile := filepath.Join(tuneDir, fmt.Sprintf("tune%d", i))
+		if ext := outputExtension(args.OutputFormat); ext != "" {
+			outputFile += ext
+		} else if !isImageSequence(args.OutputFormat) {
+			outputFile += filepath.Ext(args.OutputFile)
+		}
+		start := time.Now()
+		encodeTuneSegment(track, &tuneArgs, font, segment, outputFile)
+		fps := float64(segment.Frames) / time.Since(start).Seconds()
+		log.Printf("Auto-tune: %d workers — %.1f frames/s", workers, fps)
+		if fps > bestFps {
+			best, bestFps = workers, fps
+		}
 	}
+	log.Printf("Auto-tune: using %d workers", best)
+	return best
+}
 
-	// --- Concurrency Setup ---
-	var wg sync.WaitGroup
+// encodeTuneSegment рендерит segment и пишет кадры по порядку в кодировщик выходного формата
+func encodeTuneSegment(track *Track, args *Arguments, font *truetype.Font, segment videoSegment, outputFile string) {
+	encoder := newFrameWriter(args, outputFile, "")
 	frameChan := make(chan Frame, int(args.Framerate)*2)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pending := make(map[int][]byte)
+		next := 0
+		for frame := range frameChan {
+			pending[frame.Number] = frame.Data
+			for data, ok := pending[next]; ok; data, ok = pending[next] {
+				encoder.writeFrame(next, data)
+				delete(pending, next)
+				next++
+			}
+		}
+	}()
+	generateFrames(frameChan, track, args, []videoSegment{segment}, font)
+	close(frameChan)
+	<-done
+	if err := encoder.finish(); err != nil {
+		log.Fatalf("Auto-tune encoder failed: %v", err)
+	}
+}
+
+// findFFmpeg ищет ffmpeg в PATH (на Windows LookPath сам добавляет .exe),
+// а затем рядом с нашим исполняемым файлом — туда ffmpeg.exe обычно и кладут на Windows
+func findFFmpeg() (string, error) {
+	path, err := exec.LookPath("ffmpeg")
+	if err == nil {
+		return path, nil
+	}
+	if exe, exeErr := os.Executable(); exeErr == nil {
+		name := "ffmpeg"
+		if runtime.GOOS == "windows" {
+			name += ".exe"
+		}
+		local := filepath.Join(filepath.Dir(exe), name)
+		if _, statErr := os.Stat(local); statErr == nil {
+			return local, nil
+		}
+	}
+	return "", err
+}
+
+// encodeFrame кодирует кадр для выходного формата: PNG с альфой, TIFF с альфой или JPEG для MJPEG AVI
+func encodeFrame(w io.Writer, img image.Image, args *Arguments) error {
+	switch args.OutputFormat {
+	case formatAVI:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: aviJpegQuality})
+	case formatTIFF:
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+	}
+	return png.Encode(w, img)
+}
+
+// isImageSequence — пишет ли формат кадры отдельными файлами в каталог
+func isImageSequence(format string) bool {
+	return format == formatPNG || format == formatTIFF
+}
+
+// frameWriter принимает закодированные кадры строго по порядку
+type frameWriter interface {
+	writeFrame(frameNum int, data []byte)
+	finish() error
+}
+
+// newFrameWriter открывает вывод для -format; chapters — файл с главами, только для mp4
+func newFrameWriter(args *Arguments, outputFile, chapters string) frameWriter {
+	switch args.OutputFormat {
+	case formatAVI:
+		w, err := newAviWriter(outputFile, args.Framerate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return w
+	case formatPNG, formatTIFF:
+		if err := os.MkdirAll(outputFile, 0755); err != nil {
+			log.Fatalf("Failed to create output directory: %v", err)
+		}
+		return &imageSequenceWriter{dir: outputFile, ext: args.OutputFormat}
+	}
+	if args.Matte {
+		return &mattePairWriter{
+			color: startFFmpeg(args, outputFile, chapters, false),
+			matte: startFFmpeg(args, matteOutputFile(outputFile), chapters, true),
+		}
+	}
+	return startFFmpeg(args, outputFile, chapters, false)
+}
+
+// mattePairWriter пишет одни и те же кадры в два ffmpeg: цвет без прозрачности и ч/б маску из альфы —
+// для монтажных программ, которые не импортируют видео с альфа-каналом
+type mattePairWriter struct {
+	color, matte *ffmpegEncoder
+}
+
+func (w *mattePairWriter) writeFrame(frameNum int, data []byte) {
+	w.color.writeFrame(frameNum, data)
+	w.matte.writeFrame(frameNum, data)
+}
+
+func (w *mattePairWriter) finish() error {
+	colorErr := w.color.finish()
+	if err := w.matte.finish(); err != nil {
+		return fmt.Errorf("matte: %w", err)
+	}
+	return colorErr
+}
+
+// encodeChunkLen — сколько кадров подряд кодирует один ffmpeg при -encode-parallelism; без него — все
+func encodeChunkLen(totalFrames int, args *Arguments) int {
+	parts := max(1, args.EncodeParallelism)
+	return max(1, (totalFrames+parts-1)/parts)
+}
+
+// chunkedWriter кодирует видео несколькими ffmpeg параллельно: каждый пишет свой непрерывный кусок кадров
+// в отдельный файл, а в конце куски склеиваются без перекодирования. Каждый кусок начинается с ключевого
+// кадра, так что склейка не теряет качества. Кадры уходят в ffmpeg из отдельной горутины на кусок,
+// чтобы занятый кодированием процесс не задерживал остальные
+type chunkedWriter struct {
+	args       *Arguments
+	outputFile string
+	chapters   string
+	chunkLen   int
+	chunks     []*ffmpegEncoder
+	queues     []chan Frame
+	wg         sync.WaitGroup
+}
+
+const chunkQueueFrames = 8
+
+func newChunkedWriter(args *Arguments, outputFile, chapters string, totalFrames, chunkLen int) *chunkedWriter {
+	w := &chunkedWriter{args: args, outputFile: outputFile, chapters: chapters, chunkLen: chunkLen}
+	for from := 0; from < totalFrames; from += chunkLen {
+		chunk := startFFmpeg(args, chunkOutputFile(outputFile, len(w.chunks)+1), "", false)
+		queue := make(chan Frame, chunkQueueFrames)
+		w.chunks = append(w.chunks, chunk)
+		w.queues = append(w.queues, queue)
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			for frame := range queue {
+				chunk.writeFrame(frame.Number, frame.Data)
+			}
+		}()
+	}
+	log.Printf("Encoding with %d ffmpeg processes in parallel", len(w.chunks))
+	return w
+}
+
+func (w *chunkedWriter) writeFrame(frameNum int, data []byte) {
+	w.queues[frameNum/w.chunkLen] <- Frame{Number: frameNum, Data: data}
+}
+
+func (w *chunkedWriter) finish() error {
+	for _, queue := range w.queues {
+		close(queue)
+	}
+	w.wg.Wait()
+	var files []string
+	var firstErr error
+	for i, chunk := range w.chunks {
+		if err := chunk.finish(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("chunk %d: %w", i+1, err)
+		}
+		files = append(files, chunk.outputFile)
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return concatParts(w.args.FFmpegPath, files, w.outputFile, w.chapters)
+}
 
-	if track.RenderToIndex == 0 {
-		track.RenderToIndex = len(track.SmoothedPoints)
+// chunkOutputFile — имя файла куска параллельного кодирования: out.mp4 -> out_chunk2.mp4
+func chunkOutputFile(outputFile string, n int) string {
+	ext := filepath.Ext(outputFile)
+	return fmt.Sprintf("%s_chunk%d%s", strings.TrimSuffix(outputFile, ext), n, ext)
+}
+
+// matteOutputFile — имя файла маски рядом с цветным: out.mp4 -> out_matte.mp4
+func matteOutputFile(outputFile string) string {
+	ext := filepath.Ext(outputFile)
+	return strings.TrimSuffix(outputFile, ext) + "_matte" + ext
+}
+
+// imageSequenceWriter складывает кадры в каталог как 000000.png, 000001.png, ... (или .tiff)
+type imageSequenceWriter struct {
+	dir string
+	ext string
+}
+
+func (w *imageSequenceWriter) writeFrame(frameNum int, data []byte) {
+	path := filepath.Join(w.dir, fmt.Sprintf("%06d.%s", frameNum, w.ext))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		log.Fatalf("Failed to write frame %d: %v", frameNum, err)
+	}
+}
+
+func (w *imageSequenceWriter) finish() error {
+	return nil
+}
+
+// ffmpegEncoder — запущенный ffmpeg. Если он падает посреди рендера (нет места, OOM),
+// запускается заново в новый файл-часть, а части в конце склеиваются в outputFile.
+type ffmpegEncoder struct {
+	args       *Arguments
+	outputFile string
+	chapters   string   // файл FFMETADATA с главами; пусто — без глав
+	matte      bool     // писать вместо кадров маску из их альфа-канала
+	parts      []string // файлы частей; пусто, пока ffmpeg ни разу не падал
+	restarts   int
+	partStart  int      // номер первого кадра текущей части; -1 — кадров ещё не было
+	recent     [][]byte // последние отданные кадры — на случай, если их придётся отдать заново
+	recentFrom int      // номер кадра recent[0]
+	cmd        *exec.Cmd
+	in         io.WriteCloser
+}
+
+// ffmpegResendSeconds — сколько секунд последних кадров держится в памяти для повторной отправки после падения ffmpeg.
+// Ключевой кадр при -ffmpeg-restarts ставится каждые ffmpegKeyframeSeconds, и фрагмент MP4 дописывается на
+// каждом, так что в упавшей части теряется не больше интервала между ними и кадров в очереди кодировщика
+const (
+	ffmpegResendSeconds   = 4
+	ffmpegKeyframeSeconds = 2
+)
+
+func startFFmpeg(args *Arguments, outputFile, chapters string, matte bool) *ffmpegEncoder {
+	e := &ffmpegEncoder{args: args, outputFile: outputFile, chapters: chapters, matte: matte, partStart: -1}
+	if err := e.start(outputFile); err != nil {
+		log.Fatal(err)
+	}
+	return e
+}
+
+func (e *ffmpegEncoder) start(file string) error {
+	ffmpegArgs := []string{"-y"}
+	if e.args.SourceVideo != "" && e.args.SourceOffset < 0 {
+		ffmpegArgs = append(ffmpegArgs, "-itsoffset", fmt.Sprintf("%f", -e.args.SourceOffset)) // оверлей вступает позже начала ролика
+	}
+	ffmpegArgs = append(ffmpegArgs, "-f", "image2pipe", "-vcodec", "png", "-r", fmt.Sprintf("%f", e.args.Framerate), "-i", "-")
+	maps := []string{"-map", "0:v"}
+	if e.args.SourceVideo != "" {
+		if e.args.SourceOffset > 0 {
+			ffmpegArgs = append(ffmpegArgs, "-ss", fmt.Sprintf("%f", e.args.SourceOffset))
+		}
+		ffmpegArgs = append(ffmpegArgs, "-i", e.args.SourceVideo, "-filter_complex", sourceOverlayFilter(e.args))
+		maps = []string{"-map", "[out]", "-map", "1:a?", "-shortest"}
+	}
+	if e.chapters != "" && file == e.outputFile {
+		// части после перезапуска идут без глав: их добавит склейка
+		chaptersInput := 1
+		if e.args.SourceVideo != "" {
+			chaptersInput = 2
+		}
+		ffmpegArgs = append(ffmpegArgs, "-f", "ffmetadata", "-i", e.chapters)
+		maps = append(maps, "-map_chapters", fmt.Sprint(chaptersInput))
+	}
+	ffmpegArgs = append(ffmpegArgs, maps...)
+	if e.matte {
+		ffmpegArgs = append(ffmpegArgs, "-vf", "alphaextract")
+	}
+	ffmpegArgs = append(ffmpegArgs, ffmpegCodecArgs(e.args)...)
+	ffmpegArgs = append(ffmpegArgs, "-r", fmt.Sprintf("%f", e.args.Framerate))
+	if e.args.Deterministic {
+		// без версии кодировщика, даты создания и прочих меняющихся от запуска к запуску полей
+		ffmpegArgs = append(ffmpegArgs, "-map_metadata", "-1", "-fflags", "+bitexact", "-flags:v", "+bitexact")
+		if e.args.OutputFormat == formatMP4 {
+			ffmpegArgs = append(ffmpegArgs, "-x264-params", "non-deterministic=0")
+		}
+	}
+	if e.args.FFmpegRestarts > 0 {
+		ffmpegArgs = append(ffmpegArgs, "-g", fmt.Sprint(max(1, int(e.args.Framerate*ffmpegKeyframeSeconds))))
+		if e.args.OutputFormat != formatWebM {
+			// фрагментированный MP4 остаётся читаемым, даже если ffmpeg убит посреди записи
+			ffmpegArgs = append(ffmpegArgs, "-movflags", "+frag_keyframe+empty_moov")
+		}
+	}
+	e.cmd = exec.Command(e.args.FFmpegPath, append(ffmpegArgs, file)...)
+	in, err := e.cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get ffmpeg stdin pipe: %w", err)
+	}
+	e.in = in
+	e.cmd.Stderr = os.Stderr
+	if err := e.cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	return nil
+}
+
+// sourceOverlayFilter накладывает кадры оверлея на -source-video в углу -source-anchor. Видео длится столько же,
+// сколько оверлей: если ролик кончается раньше, держится его последний кадр, а звук обрезается по картинке
+func sourceOverlayFilter(args *Arguments) string {
+	a := layoutAnchors[args.SourceAnchor]
+	return fmt.Sprintf("[1:v]tpad=stop=-1:stop_mode=clone[bg];[bg][0:v]overlay=x=%g*(W-w):y=%g*(H-h):eof_action=pass:shortest=1[out]", a[0], a[1])
+}
+
+// ffmpegCodecArgs — кодек и формат пикселей для -format. libx264 альфу не хранит (yuva420p он молча
+// заменяет на yuv420p), поэтому прозрачный оверлей дают только ProRes 4444, QuickTime Animation и VP9
+func ffmpegCodecArgs(args *Arguments) []string {
+	switch args.OutputFormat {
+	case formatProRes:
+		return []string{"-c:v", "prores_ks", "-profile:v", "4444", "-pix_fmt", "yuva444p10le", "-vendor", "apl0"}
+	case formatQtrle:
+		return []string{"-c:v", "qtrle", "-pix_fmt", "argb"}
+	case formatWebM:
+		// альфа в VP9 пишется только без альтернативных опорных кадров
+		return []string{"-c:v", "libvpx-vp9", "-b:v", args.Bitrate, "-pix_fmt", "yuva420p", "-auto-alt-ref", "0"}
+	}
+	return []string{"-c:v", "libx264", "-b:v", args.Bitrate, "-pix_fmt", "yuv420p"}
+}
+
+// writeFrame отдаёт кадр ffmpeg; при обрыве канала перезапускает его, пока не кончатся -ffmpeg-restarts.
+// Новая часть начинается с первого кадра, которого нет в файле упавшего ffmpeg: принятые им, но не
+// записанные кадры отдаются заново из recent
+func (e *ffmpegEncoder) writeFrame(frameNum int, data []byte) {
+	if e.partStart < 0 {
+		e.partStart, e.recentFrom = frameNum, frameNum
+	}
+	if e.args.FFmpegRestarts > 0 {
+		e.recent = append(e.recent, data)
+		if len(e.recent) > max(1, int(e.args.Framerate*ffmpegResendSeconds)) {
+			e.recent[0] = nil
+			e.recent = e.recent[1:]
+			e.recentFrom++
+		}
+	}
+	for next := frameNum; next <= frameNum; {
+		frame := data
+		if next < frameNum {
+			frame = e.recent[next-e.recentFrom]
+		}
+		_, err := e.in.Write(frame)
+		if err == nil {
+			next++
+			continue
+		}
+		if e.restarts >= e.args.FFmpegRestarts {
+			e.in.Close()
+			e.cmd.Wait()
+			if written, countErr := countFrames(e.args.FFmpegPath, e.currentFile()); countErr == nil {
+				log.Fatalf("Error writing frame %d to ffmpeg (%s holds frames up to %d): %v", next, e.currentFile(), e.partStart+written-1, err)
+			}
+			log.Fatalf("Error writing frame %d to ffmpeg: %v", next, err)
+		}
+		e.restarts++
+		log.Printf("Error writing frame %d to ffmpeg: %v; restarting ffmpeg (%d/%d)", next, err, e.restarts, e.args.FFmpegRestarts)
+		resume, err := e.restart()
+		if err != nil {
+			log.Fatalf("Failed to restart ffmpeg at frame %d: %v", next, err)
+		}
+		if resume < e.recentFrom {
+			log.Fatalf("ffmpeg lost frames %d-%d, which are no longer kept for re-sending", resume, e.recentFrom-1)
+		}
+		if resume < next {
+			log.Printf("Re-sending frames %d-%d that ffmpeg accepted but did not write", resume, next-1)
+		}
+		next = min(resume, frameNum)
+	}
+}
+
+// currentFile — файл, в который пишет текущий ffmpeg
+func (e *ffmpegEncoder) currentFile() string {
+	if len(e.parts) == 0 {
+		return e.outputFile
+	}
+	return e.parts[len(e.parts)-1]
+}
+
+// restart дожидается упавшего ffmpeg, считает кадры в записанной им части и запускает новый в следующий
+// файл-часть. Возвращает номер кадра, с которого надо продолжать
+func (e *ffmpegEncoder) restart() (int, error) {
+	if e.args.SourceVideo != "" {
+		return 0, fmt.Errorf("cannot continue compositing onto -source-video in a new part")
+	}
+	e.in.Close()
+	if err := e.cmd.Wait(); err != nil {
+		log.Printf("ffmpeg exited: %v", err)
 	}
+	dead := e.currentFile()
+	written, err := countFrames(e.args.FFmpegPath, dead)
+	if err != nil {
+		log.Printf("Failed to count frames in %s, encoding it again: %v", dead, err)
+		written = 0
+	}
+	if written == 0 {
+		// упавший ffmpeg не записал ни кадра — часть пустая или нечитаемая, пишем её заново
+		os.Remove(dead)
+		return e.partStart, e.start(dead)
+	}
+	if len(e.parts) == 0 {
+		part := partOutputFile(e.outputFile, 1)
+		if err := os.Rename(e.outputFile, part); err != nil {
+			return 0, err
+		}
+		e.parts = append(e.parts, part)
+	}
+	e.partStart += written
+	part := partOutputFile(e.outputFile, len(e.parts)+1)
+	e.parts = append(e.parts, part)
+	return e.partStart, e.start(part)
+}
+
+// countFrames — сколько кадров видео читается из file. Поток копируется в никуда без декодирования,
+// а число кадров берётся из последней строки -progress
+func countFrames(ffmpegPath, file string) (int, error) {
+	out, err := exec.Command(ffmpegPath, "-v", "error", "-nostats", "-i", file, "-map", "0:v:0", "-c", "copy", "-f", "null", "-progress", "pipe:1", "-").Output()
+	frames := -1
+	for _, line := range strings.Split(string(out), "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "frame="); ok {
+			if n, convErr := strconv.Atoi(v); convErr == nil {
+				frames = n
+			}
+		}
+	}
+	if frames < 0 {
+		if err == nil {
+			err = fmt.Errorf("no frame count in ffmpeg output")
+		}
+		return 0, err
+	}
+	return frames, nil
+}
+
+// finish дожидается ffmpeg и, если были перезапуски, склеивает части в outputFile
+func (e *ffmpegEncoder) finish() error {
+	e.in.Close()
+	if err := e.cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg command failed: %w", err)
+	}
+	if len(e.parts) == 0 {
+		return nil
+	}
+	return concatParts(e.args.FFmpegPath, e.parts, e.outputFile, e.chapters)
+}
+
+// concatParts склеивает файлы parts в outputFile без перекодирования, добавляя главы из chapters, и удаляет части
+func concatParts(ffmpegPath string, parts []string, outputFile, chapters string) error {
+	log.Printf("Concatenating %d parts into %s", len(parts), outputFile)
+	listFile := strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + "_parts.txt"
+	var list strings.Builder
+	for _, part := range parts {
+		abs, err := filepath.Abs(part)
+		if err != nil {
+			return err
+		}
+		// ffmpeg понимает прямые слэши и на Windows, а обратные внутри списка пришлось бы экранировать
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(filepath.ToSlash(abs), "'", `'\''`))
+	}
+	if err := os.WriteFile(listFile, []byte(list.String()), 0644); err != nil {
+		return err
+	}
+	concatArgs := []string{"-y", "-f", "concat", "-safe", "0", "-i", listFile}
+	if chapters != "" {
+		concatArgs = append(concatArgs, "-f", "ffmetadata", "-i", chapters, "-map", "0", "-map_chapters", "1")
+	}
+	concatCmd := exec.Command(ffmpegPath, append(concatArgs, "-c", "copy", outputFile)...)
+	concatCmd.Stderr = os.Stderr
+	if err := concatCmd.Run(); err != nil {
+		return fmt.Errorf("failed to concatenate parts (kept %s): %w", strings.Join(parts, ", "), err)
+	}
+	os.Remove(listFile)
+	for _, part := range parts {
+		os.Remove(part)
+	}
+	return nil
+}
 
-	segmentDuration := track.SmoothedPoints[track.RenderToIndex-1].Timestamp.Sub(track.SmoothedPoints[track.RenderFromIndex].Timestamp)
-	totalFrames := int(segmentDuration.Seconds() * args.Framerate)
-	segmentStartTime := track.SmoothedPoints[track.RenderFromIndex].Timestamp
+// partOutputFile — имя файла-части после перезапуска ffmpeg: out.mp4 -> out_part2.mp4
+func partOutputFile(outputFile string, n int) string {
+	ext := filepath.Ext(outputFile)
+	return fmt.Sprintf("%s_part%d%s", strings.TrimSuffix(outputFile, ext), n, ext)
+}
+
+func runVideoPipeline(track *Track, args *Arguments, font *truetype.Font, segments []videoSegment, outputFile string) {
+	// стоп-кадры в начале и в конце каждого выходного файла
+	segments = append([]videoSegment(nil), segments...)
+	segments[0].IntroFrames = int(args.Intro * args.Framerate)
+	segments[len(segments)-1].OutroFrames = int(args.EndCard * args.Framerate)
+
+	totalFrames := 0
+	for _, seg := range segments {
+		totalFrames += seg.totalFrames()
+	}
+
+	// --- Chapters ---
+	var chaptersFile string
+	if len(track.Chapters) > 0 {
+		marks := chapterMarks(track.Chapters, track, segments, args.Framerate)
+		logChapterList(outputFile, marks)
+		if usesFFmpeg(args.OutputFormat) {
+			chaptersFile = strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + "_chapters.txt"
+			if err := writeChapterMetadata(chaptersFile, marks, float64(totalFrames)/args.Framerate); err != nil {
+				log.Fatalf("Failed to write chapters: %v", err)
+			}
+			defer os.Remove(chaptersFile)
+		}
+	}
+
+	if args.SRT {
+		srtFile := strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + ".srt"
+		if err := writeSRT(srtFile, track, args, segments, totalFrames); err != nil {
+			log.Fatalf("Failed to write subtitles: %v", err)
+		}
+		log.Printf("Subtitles saved to %s", srtFile)
+	}
+	if args.NoVideo {
+		return
+	}
+
+	// --- Output Setup ---
+	chunkLen := encodeChunkLen(totalFrames, args)
+	var encoder frameWriter
+	if args.EncodeParallelism > 1 {
+		encoder = newChunkedWriter(args, outputFile, chaptersFile, totalFrames, chunkLen)
+	} else {
+		encoder = newFrameWriter(args, outputFile, chaptersFile)
+	}
+
+	// --- Concurrency Setup ---
+	var wg sync.WaitGroup
+	frameChan := make(chan Frame, int(args.Framerate)*2)
 
 	// --- Encoder Goroutine (with reordering and timeout) ---
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer ffmpegIn.Close()
 
-		bar := progressbar.Default(int64(totalFrames), "Encoding")
+		bar := newProgressBar(totalFrames, "Encoding")
 		frameBuffer := make(map[int][]byte)
-		nextFrameToWrite := 0
+		// кадры пишутся по порядку внутри каждого куска -encode-parallelism; без него кусок один
+		nextFrameToWrite := make([]int, 0, args.EncodeParallelism)
+		for from := 0; from < totalFrames; from += chunkLen {
+			nextFrameToWrite = append(nextFrameToWrite, from)
+		}
+		written := 0
 		const frameWaitTimeout = 60 * time.Second
 		timeout := time.NewTimer(frameWaitTimeout)
 
-		for nextFrameToWrite < totalFrames {
+		for written < totalFrames {
 			select {
 			case frame, ok := <-frameChan:
 				if !ok {
-					log.Printf("Frame channel closed prematurely. Last written frame: %d", nextFrameToWrite-1)
+					log.Printf("Frame channel closed prematurely. Next frames to write: %v", nextFrameToWrite)
 					return
 				}
 
@@ -110,35 +854,66 @@ func runVideoPipeline(track *Track, args *Arguments, font *truetype.Font) {
 				}
 				timeout.Reset(frameWaitTimeout)
 
-				for {
-					data, found := frameBuffer[nextFrameToWrite]
+				chunk := frame.Number / chunkLen
+				chunkEnd := min((chunk+1)*chunkLen, totalFrames)
+				for nextFrameToWrite[chunk] < chunkEnd {
+					data, found := frameBuffer[nextFrameToWrite[chunk]]
 					if !found {
 						break
 					}
 
-					_, err := ffmpegIn.Write(data)
-					if err != nil {
-						log.Printf("Error writing frame %d to ffmpeg: %v", nextFrameToWrite, err)
-					}
+					encoder.writeFrame(nextFrameToWrite[chunk], data)
 					bar.Add(1)
 
-					delete(frameBuffer, nextFrameToWrite)
-					nextFrameToWrite++
+					delete(frameBuffer, nextFrameToWrite[chunk])
+					nextFrameToWrite[chunk]++
+					written++
 				}
 
 			case <-timeout.C:
-				log.Fatalf("Timeout: Stuck waiting for frame %d for over %v. A worker may have hung.", nextFrameToWrite, frameWaitTimeout)
+				log.Fatalf("Timeout: Stuck waiting for frames %v for over %v. A worker may have hung.", nextFrameToWrite, frameWaitTimeout)
 				return
 			}
 		}
 	}()
 
 	// --- Frame Generation ---
-	generateFrames(frameChan, track, args, totalFrames, font, segmentStartTime)
+	generateFrames(frameChan, track, args, segments, font)
 	close(frameChan)
 
 	wg.Wait()
-	if err := ffmpegCmd.Wait(); err != nil {
-		log.Fatalf("ffmpeg command failed: %v", err)
+	if err := encoder.finish(); err != nil {
+		log.Fatal(err)
+	}
+	if args.GPMF {
+		if err := embedGPMF(outputFile, gpmfSamples(track, args, segments, totalFrames), float64(totalFrames)/args.Framerate); err != nil {
+			log.Fatalf("Failed to embed GPMF telemetry: %v", err)
+		}
+	}
+	fmt.Printf("\nVideo saved to %s\n", outputFile)
+	if args.Matte {
+		fmt.Printf("Matte saved to %s\n", matteOutputFile(outputFile))
+	}
+	if args.Deterministic && !isImageSequence(args.OutputFormat) {
+		logFileHash(outputFile)
+		if args.Matte {
+			logFileHash(matteOutputFile(outputFile))
+		}
+	}
+}
+
+// logFileHash печатает SHA-256 готового файла, чтобы сверять повторные рендеры
+func logFileHash(filePath string) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		log.Printf("Could not hash %s: %v", filePath, err)
+		return
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		log.Printf("Could not hash %s: %v", filePath, err)
+		return
 	}
+	log.Printf("SHA-256 %x  %s", h.Sum(nil), filePath)
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// --- Virtual Ride ---
+
+// virtualLoopGap — если концы маршрута ближе, маршрут считается петлёй и при нехватке
+// длины проезжается по кругу, иначе точки после конца остаются на финише
+const virtualLoopGap = 0.1 // км
+
+// loadVirtualRoute берёт геометрию из <rte>, а если маршрута в файле нет — из трека
+func loadVirtualRoute(filePath string) ([]Point, error) {
+	if route, err := parseGpxRoute(filePath); err == nil {
+		return route, nil
+	}
+	points, err := parseGpx(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(points) < 2 {
+		return nil, fmt.Errorf("no route or track with at least 2 points found in %s", filePath)
+	}
+	return points, nil
+}
+
+// replayOnRoute расставляет точки тренажёрной записи по маршруту route на пройденной по датчику
+// дистанции (поле distance FIT или скорость, проинтегрированная по времени). Свои координаты
+// точек, если они вообще есть, игнорируются; высота берётся из маршрута, если она там указана.
+func replayOnRoute(points []Point, route []Point) error {
+	distances, err := recordedDistances(points)
+	if err != nil {
+		return err
+	}
+
+	routeDist := make([]float64, len(route))
+	routeHasEle := route[0].Ele != 0
+	for i := 1; i < len(route); i++ {
+		routeDist[i] = routeDist[i-1] + haversine(route[i-1], route[i])
+		routeHasEle = routeHasEle || route[i].Ele != 0
+	}
+	length := routeDist[len(route)-1]
+	if length == 0 {
+		return errors.New("virtual route has zero length")
+	}
+	loop := haversine(route[0], route[len(route)-1]) < virtualLoopGap
+	if total := distances[len(distances)-1]; total > length {
+		if loop {
+			log.Printf("Ride (%.2f km) is longer than the virtual route (%.2f km): riding it %.1f times", total, length, total/length)
+		} else {
+			log.Printf("Warning: ride (%.2f km) is longer than the virtual route (%.2f km); the rest stays at the finish", total, length)
+		}
+	}
+
+	for i := range points {
+		d := distances[i]
+		if loop {
+			for d > length {
+				d -= length
+			}
+		}
+		d = min(d, length)
+		k := max(1, sort.SearchFloat64s(routeDist, d))
+		k = min(k, len(route)-1)
+		p1, p2 := route[k-1], route[k]
+		ratio := 0.0
+		if seg := routeDist[k] - routeDist[k-1]; seg > 0 {
+			ratio = (d - routeDist[k-1]) / seg
+		}
+		points[i].Lat = p1.Lat + (p2.Lat-p1.Lat)*ratio
+		points[i].Lon = p1.Lon + (p2.Lon-p1.Lon)*ratio
+		if routeHasEle {
+			points[i].Ele = p1.Ele + (p2.Ele-p1.Ele)*ratio
+		}
+		points[i].NoFix = false
+	}
+	return nil
+}
+
+// recordedDistances — накопленная дистанция каждой точки по датчику, км. Пропуски в поле
+// distance заполняются предыдущим значением; без него дистанция считается по скорости.
+func recordedDistances(points []Point) ([]float64, error) {
+	out := make([]float64, len(points))
+	hasDistance, hasSpeed := false, false
+	for _, p := range points {
+		hasDistance = hasDistance || p.RecordedDistance > 0
+		hasSpeed = hasSpeed || p.RecordedSpeed > 0
+	}
+	switch {
+	case hasDistance:
+		for i, p := range points {
+			out[i] = p.RecordedDistance
+			if i > 0 && out[i] < out[i-1] {
+				out[i] = out[i-1]
+			}
+		}
+	case hasSpeed:
+		for i := 1; i < len(points); i++ {
+			dt := points[i].Timestamp.Sub(points[i-1].Timestamp).Hours()
+			out[i] = out[i-1] + points[i].RecordedSpeed*dt
+		}
+	default:
+		return nil, errors.New("track has no recorded distance or speed to replay on the virtual route")
+	}
+	return out, nil
+}
+
+// dropNoFix убирает точки без координат: без виртуального маршрута их некуда поставить
+func dropNoFix(points []Point) []Point {
+	out := points[:0]
+	for _, p := range points {
+		if !p.NoFix {
+			out = append(out, p)
+		}
+	}
+	return out
+}
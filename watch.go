@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// --- Watch Mode ---
+
+const (
+	watchPollInterval = 500 * time.Millisecond
+	watchSettle       = 300 * time.Millisecond // редактор может сохранять файл в несколько приёмов
+)
+
+// watchedFiles — входные файлы, правка которых меняет картинку: трек, корректировки, маски, маршруты, надписи, раскладка, шрифты, стили карты
+func watchedFiles(args *Arguments) []string {
+	files, _ := trackFilePaths(args.GpxFile)
+	files = append(files, args.TrackAdjustmentFile, args.MapMaskFile, args.RouteFile, args.VirtualRoute, args.AnnotationFile, args.LayoutFile, args.FontFile, args.StylesFile)
+	files = append(files, strings.Split(args.FallbackFonts, ",")...)
+	if args.Geocode != "nominatim" {
+		files = append(files, args.Geocode)
+	}
+	var out []string
+	for _, f := range files {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// fileStamps — время изменения каждого файла; отсутствующий файл даёт нулевое время,
+// так что его появление тоже считается изменением
+func fileStamps(files []string) map[string]time.Time {
+	stamps := make(map[string]time.Time, len(files))
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			stamps[f] = info.ModTime()
+		} else {
+			stamps[f] = time.Time{}
+		}
+	}
+	return stamps
+}
+
+func stampsEqual(a, b map[string]time.Time) bool {
+	for f, t := range a {
+		if !b[f].Equal(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// watchCommandArgs — аргументы запуска без -watch. Без -to рендерится только первый кадр,
+// чтобы сохранение файла не запускало рендер всей поездки
+func watchCommandArgs() []string {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var out []string
+	for _, a := range os.Args[1:] {
+		name, _, _ := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if strings.HasPrefix(a, "-") && name == "watch" {
+			continue
+		}
+		out = append(out, a)
+	}
+	if !set["to"] && !set["render-first-frame"] {
+		out = append(out, "-render-first-frame")
+	}
+	return out
+}
+
+// watchAndRender перезапускает программу с теми же флагами при каждом изменении входных файлов.
+// Рендер идёт в отдельном процессе: ошибка в файле корректировок не обрывает наблюдение.
+func watchAndRender(args *Arguments) {
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Could not locate the program for -watch: %v", err)
+	}
+	files := watchedFiles(args)
+	cmdArgs := watchCommandArgs()
+	log.Printf("Watching %s; press Ctrl+C to stop", strings.Join(files, ", "))
+
+	for {
+		stamps := fileStamps(files)
+		cmd := exec.Command(exe, cmdArgs...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Printf("Render failed: %v", err)
+		} else {
+			log.Printf("Render finished at %s, waiting for changes...", time.Now().Format("15:04:05"))
+		}
+
+		// файл, изменённый во время рендера, тоже вызывает перерендер: снимок сделан до запуска
+		for stampsEqual(stamps, fileStamps(files)) {
+			time.Sleep(watchPollInterval)
+		}
+		time.Sleep(watchSettle)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fogleman/gg"
+)
+
+// --- Structs ---
+
+type WeatherSample struct {
+	Time          time.Time
+	Temperature   float64 // °C
+	WindSpeed     float64 // km/h
+	WindDirection float64 // откуда дует, градусы
+}
+
+type openMeteoResponse struct {
+	Hourly struct {
+		Time          []string   `json:"time"`
+		Temperature   []*float64 `json:"temperature_2m"`
+		WindSpeed     []*float64 `json:"wind_speed_10m"`
+		WindDirection []*float64 `json:"wind_direction_10m"`
+	} `json:"hourly"`
+}
+
+// --- Historical Weather ---
+
+// fetchTrackWeather запрашивает почасовую погоду вдоль трека: для каждого часа поездки
+// берётся ближайшая по времени точка, и погода запрашивается для её окрестности (~10 км).
+// offline — брать погоду только из кэша
+func fetchTrackWeather(points []Point, offline bool) ([]WeatherSample, error) {
+	if len(points) == 0 {
+		return nil, nil
+	}
+	startHour := points[0].Timestamp.UTC().Truncate(time.Hour)
+	endHour := points[len(points)-1].Timestamp.UTC().Truncate(time.Hour).Add(time.Hour)
+
+	series := make(map[string][]WeatherSample)
+	var samples []WeatherSample
+	pointIdx := 0
+	for hour := startHour; !hour.After(endHour); hour = hour.Add(time.Hour) {
+		for pointIdx < len(points)-1 && points[pointIdx].Timestamp.Before(hour) {
+			pointIdx++
+		}
+		p := points[pointIdx]
+		lat := math.Round(p.Lat*10) / 10
+		lon := math.Round(p.Lon*10) / 10
+		key := fmt.Sprintf("%.1f_%.1f", lat, lon)
+
+		if _, ok := series[key]; !ok {
+			s, err := fetchOpenMeteoArchive(lat, lon, startHour, endHour, offline)
+			if err != nil {
+				return nil, err
+			}
+			series[key] = s
+		}
+		for _, s := range series[key] {
+			if s.Time.Equal(hour) {
+				samples = append(samples, s)
+				break
+			}
+		}
+	}
+	return samples, nil
+}
+
+func fetchOpenMeteoArchive(lat, lon float64, from, to time.Time, offline bool) ([]WeatherSample, error) {
+	cachePath := filepath.Join(weatherCacheDir, fmt.Sprintf("%.1f_%.1f_%s_%s.json", lat, lon, from.Format("20060102"), to.Format("20060102")))
+	body, err := os.ReadFile(cachePath)
+	if err != nil && offline {
+		return nil, fmt.Errorf("weather for %.1f,%.1f is not in %s and -offline forbids downloading it", lat, lon, weatherCacheDir)
+	}
+	if err != nil {
+		url := fmt.Sprintf("https://archive-api.open-meteo.com/v1/archive?latitude=%.1f&longitude=%.1f&start_date=%s&end_date=%s&hourly=temperature_2m,wind_speed_10m,wind_direction_10m&timezone=GMT&wind_speed_unit=kmh",
+			lat, lon, from.Format("2006-01-02"), to.Format("2006-01-02"))
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("User-Agent", "GpsOverlayVideoGo/0.1")
+
+		client := &http.Client{
+			Timeout: 10 * time.Second,
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch weather %s: %w", url, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch weather %s: status %d", url, resp.StatusCode)
+		}
+
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		os.MkdirAll(weatherCacheDir, 0755)
+		os.WriteFile(cachePath, body, 0644)
+	}
+
+	var data openMeteoResponse
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("failed to decode weather response: %w", err)
+	}
+
+	h := data.Hourly
+	var samples []WeatherSample
+	for i, ts := range h.Time {
+		t, err := time.Parse("2006-01-02T15:04", ts)
+		if err != nil {
+			continue
+		}
+		if i >= len(h.Temperature) || i >= len(h.WindSpeed) || i >= len(h.WindDirection) {
+			break
+		}
+		if h.Temperature[i] == nil || h.WindSpeed[i] == nil || h.WindDirection[i] == nil {
+			continue // архив ещё не заполнен
+		}
+		samples = append(samples, WeatherSample{Time: t, Temperature: *h.Temperature[i], WindSpeed: *h.WindSpeed[i], WindDirection: *h.WindDirection[i]})
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no weather data for %.1f,%.1f between %s and %s", lat, lon, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	}
+	return samples, nil
+}
+
+// applyWeather интерполирует почасовые данные по времени каждой точки
+func applyWeather(points []Point, samples []WeatherSample) {
+	if len(samples) == 0 {
+		return
+	}
+	j := 0
+	for i := range points {
+		t := points[i].Timestamp
+		for j < len(samples)-2 && !samples[j+1].Time.After(t) {
+			j++
+		}
+		s1 := samples[j]
+		s2 := s1
+		if j+1 < len(samples) {
+			s2 = samples[j+1]
+		}
+		ratio := 0.0
+		if span := s2.Time.Sub(s1.Time).Seconds(); span > 0 {
+			ratio = math.Max(0, math.Min(1, t.Sub(s1.Time).Seconds()/span))
+		}
+		points[i].Temperature = s1.Temperature + (s2.Temperature-s1.Temperature)*ratio
+		points[i].WindSpeed = s1.WindSpeed + (s2.WindSpeed-s1.WindSpeed)*ratio
+		points[i].WindDirection = interpolateBearing(gg.Radians(s1.WindDirection), gg.Radians(s2.WindDirection), ratio)
+	}
+	log.Printf("Weather: %d hourly samples applied", len(samples))
+}
@@ -0,0 +1,746 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// --- Structs ---
+
+// indicator — блок "иконка + значение + единица" в дополнительных строках под полосой дистанции.
+// Icon не зависит от значения и рисуется один раз в iconLayer; то, что меняется от кадра к кадру
+// (стрелка шкалы, иконка, видимая не всегда), рисует LiveIcon
+type indicator struct {
+	Icon     func(dc *gg.Context, x, y, size, lineWidth float64)
+	LiveIcon func(dc *gg.Context, x, y, size, lineWidth float64)
+	Value    string
+	Unit     string
+	Color    color.Color // nil — args.IndicatorColor
+}
+
+const indicatorsPerRow = 3
+
+// --- Extra Indicators ---
+
+const (
+	elapsedTotal  = "total"
+	elapsedMoving = "moving"
+)
+
+// extraIndicatorNames возвращает включённые дополнительные индикаторы в порядке отрисовки
+func extraIndicatorNames(args *Arguments) []string {
+	var names []string
+	if args.Weather {
+		names = append(names, "temperature", "wind")
+	}
+	if args.ShowGear {
+		names = append(names, "gear")
+	}
+	if args.ShowPower {
+		names = append(names, "power", "normalized_power")
+	}
+	if args.FTP > 0 {
+		if !args.ShowPower {
+			names = append(names, "normalized_power")
+		}
+		names = append(names, "intensity_factor", "tss")
+	}
+	if args.CP > 0 && args.WPrime > 0 {
+		names = append(names, "w_prime_balance")
+	}
+	if args.ShowHeartRate {
+		names = append(names, "heart_rate")
+	}
+	if args.ETA {
+		names = append(names, "eta")
+	}
+	if args.SpeedStats {
+		names = append(names, "max_speed", "avg_speed")
+	}
+	if args.ShowVAM {
+		names = append(names, "vam")
+	}
+	if args.RiderWeight > 0 {
+		names = append(names, "calories")
+	}
+	if args.Splits != "" {
+		// последние отрезки, начиная с самого свежего
+		for k := 1; k <= args.SplitsShown; k++ {
+			names = append(names, fmt.Sprintf("split_%d", k))
+		}
+	}
+	if args.ShowElapsed {
+		names = append(names, "elapsed")
+	}
+	if args.ShowClock {
+		names = append(names, "clock")
+	}
+	if args.ShowAscent {
+		names = append(names, "ascent", "descent")
+	}
+	if args.Activity == "running" {
+		names = append(names, "step_length", "ground_contact", "vertical_oscillation")
+	}
+	if args.Activity == "swimming" {
+		names = append(names, "stroke_rate")
+	}
+	if args.Activity == "skiing" {
+		names = append(names, "runs", "run_drop", "total_drop")
+	}
+	if args.Motorsport {
+		names = append(names, "lean_angle", "lateral_g", "longitudinal_g")
+	}
+	return names
+}
+
+// --- Gauge Ranges ---
+
+// niceGaugeMax округляет максимум шкалы вверх до половины старшего разряда: 34 -> 35, 87 -> 90, 412 -> 450
+func niceGaugeMax(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	step := math.Pow(10, math.Floor(math.Log10(v))) / 2
+	return math.Ceil(v/step) * step
+}
+
+// setGaugeRanges подбирает шкалы спидометра и мощности под максимум показываемого диапазона трека,
+// если они не заданы флагами, и находит в нём самую длинную запись высоты
+func setGaugeRanges(track *Track, args *Arguments) {
+	maxSpeed, maxPower := 0.0, 0.0
+	widest := ""
+	for _, p := range track.SmoothedPoints[track.RenderFromIndex:track.RenderToIndex] {
+		maxSpeed = math.Max(maxSpeed, p.Speed)
+		maxPower = math.Max(maxPower, p.AvgPower)
+		if v, _ := altitudeText(p.Ele, args); len(v) > len(widest) {
+			widest, args.AltitudeWidest = v, p.Ele
+		}
+	}
+	if args.SpeedGaugeMax == 0 {
+		args.SpeedGaugeMax = niceGaugeMax(maxSpeed)
+	}
+	if args.PowerGaugeMax == 0 {
+		args.PowerGaugeMax = niceGaugeMax(maxPower)
+	}
+}
+
+// speedGaugeTicks — число делений шкалы спидометра
+const speedGaugeTicks = 5
+
+// gaugeZone — цветной участок шкалы от предыдущей границы (или нуля) до Max
+type gaugeZone struct {
+	Max   float64
+	Color color.Color
+}
+
+// parseGaugeZones разбирает список участков шкалы вида "25:#30C050,40:#FFD000,60:#FF3020"
+// (верхняя граница и цвет), границы по возрастанию
+func parseGaugeZones(spec string) ([]gaugeZone, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	var zones []gaugeZone
+	for _, part := range strings.Split(spec, ",") {
+		bound, hex, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("bad zone %q, expected max:#RRGGBB", part)
+		}
+		v, err := strconv.ParseFloat(bound, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad zone bound %q: %w", bound, err)
+		}
+		c, err := parseHexColor(hex)
+		if err != nil {
+			return nil, fmt.Errorf("bad zone color %q: %w", hex, err)
+		}
+		if len(zones) > 0 && v <= zones[len(zones)-1].Max {
+			return nil, fmt.Errorf("zone bounds must increase, got %g after %g", v, zones[len(zones)-1].Max)
+		}
+		zones = append(zones, gaugeZone{Max: v, Color: c})
+	}
+	return zones, nil
+}
+
+// --- Power Zones ---
+
+// powerZoneBounds — верхние границы зон мощности 1–6 по Коггану в долях FTP; выше последней — зона 7
+var powerZoneBounds = []float64{0.55, 0.75, 0.90, 1.05, 1.20, 1.50}
+
+// цвета зон как на головных устройствах: восстановление серое, анаэробная и выше — красная и фиолетовая
+var powerZoneColors = []color.Color{
+	color.RGBA{150, 150, 150, 255},
+	color.RGBA{50, 130, 240, 255},
+	color.RGBA{40, 180, 60, 255},
+	color.RGBA{240, 200, 0, 255},
+	color.RGBA{250, 120, 0, 255},
+	color.RGBA{220, 40, 30, 255},
+	color.RGBA{160, 60, 200, 255},
+}
+
+// powerZone — номер зоны (1–7) для мощности power при данном FTP
+func powerZone(power, ftp float64) int {
+	for i, bound := range powerZoneBounds {
+		if power <= bound*ftp {
+			return i + 1
+		}
+	}
+	return len(powerZoneBounds) + 1
+}
+
+// --- Slope Colors ---
+
+// slopeColors — цвета уклона от ровного к самому крутому подъёму; спуски круче первого порога — slopeDescentColor
+var slopeColors = []color.Color{
+	color.RGBA{40, 180, 60, 255},
+	color.RGBA{240, 200, 0, 255},
+	color.RGBA{250, 120, 0, 255},
+	color.RGBA{220, 40, 30, 255},
+}
+
+var slopeDescentColor = color.RGBA{50, 130, 240, 255}
+
+// parseSlopeThresholds разбирает -slope-thresholds: len(slopeColors)-1 возрастающих порогов в процентах
+func parseSlopeThresholds(spec string) ([]float64, error) {
+	var thresholds []float64
+	for _, part := range strings.Split(spec, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad threshold %q: %w", part, err)
+		}
+		if v <= 0 || (len(thresholds) > 0 && v <= thresholds[len(thresholds)-1]) {
+			return nil, fmt.Errorf("thresholds must be positive and increasing, got %q", spec)
+		}
+		thresholds = append(thresholds, v)
+	}
+	if len(thresholds) != len(slopeColors)-1 {
+		return nil, fmt.Errorf("need %d thresholds, got %d", len(slopeColors)-1, len(thresholds))
+	}
+	return thresholds, nil
+}
+
+// slopeColor — цвет показаний уклона slope (%) по порогам thresholds
+func slopeColor(slope float64, thresholds []float64) color.Color {
+	if slope <= -thresholds[0] {
+		return slopeDescentColor
+	}
+	for i, t := range thresholds {
+		if slope < t {
+			return slopeColors[i]
+		}
+	}
+	return slopeColors[len(slopeColors)-1]
+}
+
+func extraIndicatorRows(args *Arguments) int {
+	n := len(extraIndicatorNames(args))
+	return (n + indicatorsPerRow - 1) / indicatorsPerRow
+}
+
+// шрифт дополнительных строк мельче основного ряда, чтобы три блока помещались по ширине
+const extraIndicatorFontScale = 0.7
+
+func extraRowHeight(widgetWidth float64) float64 {
+	return widgetWidth / 8.0 * extraIndicatorFontScale * 1.4
+}
+
+func buildIndicator(name string, p Point, track *Track, args *Arguments) indicator {
+	if suffix, ok := strings.CutPrefix(name, "split_"); ok {
+		k, _ := strconv.Atoi(suffix)
+		return splitIndicator(k, p, track, args)
+	}
+	switch name {
+	case "temperature":
+		return indicator{Icon: drawThermometerIcon, Value: fmt.Sprintf("%.0f", p.Temperature), Unit: " °C"}
+	case "wind":
+		// стрелка показывает, куда дует ветер, относительно направления движения (вверх — попутный)
+		relative := p.WindDirection + math.Pi - p.Bearing
+		return indicator{
+			LiveIcon: func(dc *gg.Context, x, y, size, lineWidth float64) {
+				drawWindIcon(dc, x, y, size, lineWidth, relative)
+			},
+			Value: fmt.Sprintf("%.0f", p.WindSpeed),
+			Unit:  " km/h",
+		}
+	case "gear":
+		if p.FrontGear == 0 || p.RearGear == 0 {
+			return indicator{Icon: drawGearIcon, Value: "--"}
+		}
+		return indicator{Icon: drawGearIcon, Value: fmt.Sprintf("%d×%d", p.FrontGear, p.RearGear), Unit: fmt.Sprintf(" %d-%d", p.FrontGearNum, p.RearGearNum)}
+	case "power":
+		ind := indicator{
+			Icon: func(dc *gg.Context, x, y, size, lineWidth float64) {
+				drawGaugeDial(dc, x, y, size, lineWidth, args.PowerGaugeMax, nil)
+			},
+			LiveIcon: func(dc *gg.Context, x, y, size, lineWidth float64) {
+				drawGaugeNeedle(dc, x, y, size, lineWidth, p.AvgPower, args.PowerGaugeMax)
+			},
+			Value: fmt.Sprintf("%.0f", p.AvgPower),
+			Unit:  " W",
+		}
+		if args.FTP > 0 {
+			zone := powerZone(p.AvgPower, args.FTP)
+			ind.Color = powerZoneColors[zone-1]
+			ind.Unit = fmt.Sprintf(" W Z%d", zone)
+		}
+		return ind
+	case "normalized_power":
+		return indicator{Icon: drawPowerIcon, Value: fmt.Sprintf("%.0f", p.NormalizedPower), Unit: " W NP"}
+	case "intensity_factor":
+		return indicator{Icon: drawPowerIcon, Value: fmt.Sprintf("%.2f", p.IntensityFactor), Unit: " IF"}
+	case "tss":
+		return indicator{Icon: drawPowerIcon, Value: fmt.Sprintf("%.0f", p.TSS), Unit: " TSS"}
+	case "w_prime_balance":
+		// полоса запаса: зелёная, жёлтая ниже половины, красная ниже четверти
+		fraction := p.WPrimeBalance / args.WPrime
+		c := color.Color(color.RGBA{40, 180, 60, 255})
+		if fraction < 0.25 {
+			c = color.RGBA{220, 40, 30, 255}
+		} else if fraction < 0.5 {
+			c = color.RGBA{230, 170, 0, 255}
+		}
+		return indicator{
+			LiveIcon: func(dc *gg.Context, x, y, size, lineWidth float64) {
+				drawBalanceBarIcon(dc, x, y, size, lineWidth, fraction, c)
+			},
+			Value: fmt.Sprintf("%.1f", p.WPrimeBalance/1000),
+			Unit:  " kJ W′",
+		}
+	case "eta":
+		if p.ProjectedFinish == 0 {
+			return indicator{Icon: drawClockIcon, Value: "--:--", Unit: " ETA"}
+		}
+		finish := time.UnixMilli(int64(p.ProjectedFinish * 1000)).In(args.Location)
+		return indicator{Icon: drawClockIcon, Value: finish.Format("15:04"), Unit: " ETA"}
+	case "max_speed", "avg_speed":
+		v, unit := p.MaxSpeed, " max"
+		if name == "avg_speed" {
+			v, unit = p.SessionAvgSpeed, " avg"
+		}
+		return indicator{
+			Icon: func(dc *gg.Context, x, y, size, lineWidth float64) {
+				drawGaugeDial(dc, x, y, size, lineWidth, args.SpeedGaugeMax, nil)
+			},
+			LiveIcon: func(dc *gg.Context, x, y, size, lineWidth float64) {
+				drawGaugeNeedle(dc, x, y, size, lineWidth, v, args.SpeedGaugeMax)
+			},
+			Value: fmt.Sprintf("%.1f", v),
+			Unit:  unit,
+		}
+	case "vam":
+		// место под VAM держим всегда, а показываем только на подъёме, чтобы строки не прыгали;
+		// поэтому и иконка у него живая
+		if p.SmoothedSlope < args.VAMMinSlope {
+			return indicator{}
+		}
+		return indicator{LiveIcon: drawAscentIcon, Value: fmt.Sprintf("%.0f", math.Max(0, p.VAM)), Unit: " m/h"}
+	case "calories":
+		return indicator{Icon: drawFlameIcon, Value: fmt.Sprintf("%.0f", p.Calories), Unit: " kcal"}
+	case "elapsed":
+		// от начала показываемого фрагмента (-from), а не от начала записи
+		start := track.SmoothedPoints[track.RenderFromIndex]
+		elapsed := p.Timestamp.Sub(start.Timestamp).Seconds()
+		if args.ElapsedMode == elapsedMoving {
+			elapsed = p.MovingTime - start.MovingTime
+		}
+		s := max(0, int(elapsed))
+		return indicator{Icon: drawStopwatchIcon, Value: fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)}
+	case "clock":
+		return indicator{Icon: drawClockIcon, Value: p.Timestamp.In(args.Location).Format("15:04:05")}
+	case "ascent", "descent":
+		v, icon := p.Ascent, drawAscentIcon
+		if name == "descent" {
+			v, icon = p.Descent, drawDescentIcon
+		}
+		unit := " m"
+		if args.AltitudeUnits == "ft" {
+			v, unit = v/0.3048, " ft"
+		}
+		return indicator{Icon: icon, Value: fmt.Sprintf("%.0f", v), Unit: unit}
+	case "heart_rate":
+		return indicator{Icon: drawHeartIcon, Value: fmt.Sprintf("%.0f", track.HRReadout.at(p.Timestamp, p.AvgHeartRate)), Unit: " bpm"}
+	case "stroke_rate":
+		return indicator{Icon: drawStrokeIcon, Value: fmt.Sprintf("%.0f", p.Cadence), Unit: " spm"}
+	case "runs":
+		return indicator{Icon: drawSkiIcon, Value: fmt.Sprintf("%d", p.RunNumber), Unit: " runs"}
+	case "run_drop":
+		return indicator{Icon: drawDescentIcon, Value: fmt.Sprintf("%.0f", p.RunDrop), Unit: " m"}
+	case "total_drop":
+		return indicator{Icon: drawDescentIcon, Value: fmt.Sprintf("%.0f", p.TotalDrop), Unit: " m total"}
+	case "lean_angle", "lateral_g", "longitudinal_g":
+		return motionIndicator(name, track.Motion, p.Timestamp)
+	case "step_length":
+		return indicator{Icon: drawStrideIcon, Value: fmt.Sprintf("%.2f", p.StepLength), Unit: " m"}
+	case "ground_contact":
+		return indicator{Icon: drawGroundContactIcon, Value: fmt.Sprintf("%.0f", p.GroundContactTime), Unit: " ms"}
+	case "vertical_oscillation":
+		return indicator{Icon: drawVerticalOscillationIcon, Value: fmt.Sprintf("%.1f", p.VerticalOscillation), Unit: " cm"}
+	}
+	return indicator{Value: "?"}
+}
+
+// extraIndicatorSlot — левый край и базовая линия i-го блока дополнительных строк
+func extraIndicatorSlot(i int, x, topY, widgetWidth float64) (float64, float64) {
+	return x + float64(i%indicatorsPerRow)*widgetWidth/indicatorsPerRow, topY + float64(i/indicatorsPerRow)*extraRowHeight(widgetWidth)
+}
+
+// drawExtraIndicatorIcons рисует неизменные иконки (Icon) блоков drawExtraIndicators — для iconLayer
+func drawExtraIndicatorIcons(dc *gg.Context, indicators []indicator, x, topY, widgetWidth float64, args *Arguments) {
+	valueFontSize := widgetWidth / 8.0 * extraIndicatorFontScale
+	iconSize := valueFontSize * 0.8
+	dc.SetColor(args.IndicatorColor)
+	for i, ind := range indicators {
+		if ind.Icon != nil {
+			blockX, rowY := extraIndicatorSlot(i, x, topY, widgetWidth)
+			ind.Icon(dc, blockX+iconSize/2, rowY-valueFontSize*0.35, iconSize, widgetWidth/150.0)
+		}
+	}
+}
+
+// drawExtraIndicators раскладывает индикаторы по indicatorsPerRow в строке, начиная с базовой линии topY.
+// Иконки Icon сюда не входят, их рисует drawExtraIndicatorIcons
+func drawExtraIndicators(dc *gg.Context, indicators []indicator, x, topY, widgetWidth float64, ttf *truetype.Font, args *Arguments) {
+	blockWidth := widgetWidth / indicatorsPerRow
+	valueFontSize := widgetWidth / 8.0 * extraIndicatorFontScale
+	iconSize := valueFontSize * 0.8
+	iconLineWidth := widgetWidth / 150.0
+
+	valueFace := newFontFace(ttf, &truetype.Options{Size: valueFontSize})
+	unitFace := newFontFace(ttf, &truetype.Options{Size: valueFontSize / 2})
+
+	for i, ind := range indicators {
+		blockX, rowY := extraIndicatorSlot(i, x, topY, widgetWidth)
+
+		c := ind.Color
+		if c == nil {
+			c = args.IndicatorColor
+		}
+		dc.SetColor(args.IndicatorColor)
+		if ind.LiveIcon != nil {
+			ind.LiveIcon(dc, blockX+iconSize/2, rowY-valueFontSize*0.35, iconSize, iconLineWidth)
+		}
+
+		startX := blockX + iconSize*1.3
+		dc.SetFontFace(valueFace)
+		valueWidth, _ := dc.MeasureString(ind.Value)
+		dc.SetFontFace(unitFace)
+		unitWidth, _ := dc.MeasureString(ind.Unit)
+
+		// не влезающий в блок текст ужимаем, чтобы не наезжал на соседа
+		dc.Push()
+		if available := blockX + blockWidth*0.95 - startX; valueWidth+unitWidth > available {
+			k := available / (valueWidth + unitWidth)
+			dc.ScaleAbout(k, k, startX, rowY)
+		}
+		dc.SetFontFace(valueFace)
+		drawIndicatorText(dc, ind.Value, startX, rowY, 0, 0, c, args)
+		dc.SetFontFace(unitFace)
+		drawIndicatorText(dc, ind.Unit, startX+valueWidth, rowY, 0, 0, c, args)
+		dc.Pop()
+	}
+	dc.SetColor(args.IndicatorColor)
+}
+
+// --- Icons ---
+
+func drawThermometerIcon(dc *gg.Context, x, y, size, lineWidth float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.SetLineWidth(lineWidth)
+	bulbR := size / 6
+	dc.DrawCircle(0, size/2-bulbR, bulbR)
+	dc.Stroke()
+	dc.DrawLine(-bulbR/2, size/2-2*bulbR, -bulbR/2, -size/2)
+	dc.DrawLine(bulbR/2, size/2-2*bulbR, bulbR/2, -size/2)
+	dc.Stroke()
+	dc.Pop()
+}
+
+// drawWindIcon рисует стрелку, повёрнутую на angle (0 — вверх)
+func drawWindIcon(dc *gg.Context, x, y, size, lineWidth, angle float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.Rotate(angle)
+	dc.SetLineWidth(lineWidth)
+	dc.MoveTo(0, size/2)
+	dc.LineTo(0, -size/2)
+	dc.Stroke()
+	dc.MoveTo(-size/4, -size/4)
+	dc.LineTo(0, -size/2)
+	dc.LineTo(size/4, -size/4)
+	dc.Stroke()
+	dc.Pop()
+}
+
+// drawGearIcon рисует звезду с зубьями
+func drawGearIcon(dc *gg.Context, x, y, size, lineWidth float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.SetLineWidth(lineWidth)
+	r := size / 2
+	dc.DrawCircle(0, 0, r*0.7)
+	dc.Stroke()
+	dc.DrawCircle(0, 0, r*0.2)
+	dc.Stroke()
+	for i := 0; i < 8; i++ {
+		a := float64(i) * math.Pi / 4
+		dc.DrawLine(math.Cos(a)*r*0.7, math.Sin(a)*r*0.7, math.Cos(a)*r, math.Sin(a)*r)
+	}
+	dc.Stroke()
+	dc.Pop()
+}
+
+// drawStrideIcon рисует шаг: отрезок с засечками на концах
+func drawStrideIcon(dc *gg.Context, x, y, size, lineWidth float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.SetLineWidth(lineWidth)
+	dc.DrawLine(-size/2, 0, size/2, 0)
+	dc.DrawLine(-size/2, -size/4, -size/2, size/4)
+	dc.DrawLine(size/2, -size/4, size/2, size/4)
+	dc.Stroke()
+	dc.Pop()
+}
+
+// drawGroundContactIcon рисует стопу на линии земли
+func drawGroundContactIcon(dc *gg.Context, x, y, size, lineWidth float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.SetLineWidth(lineWidth)
+	dc.DrawLine(-size/2, size/3, size/2, size/3)
+	dc.Stroke()
+	dc.DrawEllipse(0, size/3-size/8, size/4, size/8)
+	dc.Fill()
+	dc.Pop()
+}
+
+// drawVerticalOscillationIcon рисует синусоиду
+func drawVerticalOscillationIcon(dc *gg.Context, x, y, size, lineWidth float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.SetLineWidth(lineWidth)
+	for i := 0; i <= 20; i++ {
+		px := -size/2 + size*float64(i)/20
+		py := -math.Sin(float64(i)/20*2*math.Pi) * size / 4
+		dc.LineTo(px, py)
+	}
+	dc.Stroke()
+	dc.Pop()
+}
+
+// drawStrokeIcon рисует волны
+func drawStrokeIcon(dc *gg.Context, x, y, size, lineWidth float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.SetLineWidth(lineWidth)
+	for _, dy := range []float64{-size / 4, size / 4} {
+		dc.NewSubPath()
+		for i := 0; i <= 20; i++ {
+			px := -size/2 + size*float64(i)/20
+			py := dy - math.Sin(float64(i)/20*4*math.Pi)*size/10
+			dc.LineTo(px, py)
+		}
+		dc.Stroke()
+	}
+	dc.Pop()
+}
+
+// drawSkiIcon рисует две наклонные лыжи
+func drawSkiIcon(dc *gg.Context, x, y, size, lineWidth float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.Rotate(gg.Radians(-20))
+	dc.SetLineWidth(lineWidth)
+	for _, dy := range []float64{-size / 6, size / 6} {
+		dc.MoveTo(-size/2, dy)
+		dc.LineTo(size/2, dy)
+		dc.QuadraticTo(size/2+size/8, dy, size/2+size/8, dy-size/8)
+		dc.Stroke()
+	}
+	dc.Pop()
+}
+
+// drawAscentIcon рисует стрелку вверх по склону
+func drawAscentIcon(dc *gg.Context, x, y, size, lineWidth float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.SetLineWidth(lineWidth)
+	dc.MoveTo(-size/2, size/2)
+	dc.LineTo(size/2, -size/2)
+	dc.Stroke()
+	dc.MoveTo(size/2-size/3, -size/2)
+	dc.LineTo(size/2, -size/2)
+	dc.LineTo(size/2, -size/2+size/3)
+	dc.Stroke()
+	dc.Pop()
+}
+
+// drawDescentIcon рисует стрелку вниз под склоном
+func drawDescentIcon(dc *gg.Context, x, y, size, lineWidth float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.SetLineWidth(lineWidth)
+	dc.MoveTo(-size/2, -size/2)
+	dc.LineTo(size/2, size/2)
+	dc.Stroke()
+	dc.MoveTo(size/2-size/3, size/2)
+	dc.LineTo(size/2, size/2)
+	dc.LineTo(size/2, size/2-size/3)
+	dc.Stroke()
+	dc.Pop()
+}
+
+// drawLeanIcon рисует горизонт и наклонённую на lean (градусы, вправо положительный) стойку
+func drawLeanIcon(dc *gg.Context, x, y, size, lineWidth, lean float64) {
+	dc.Push()
+	dc.Translate(x, y+size/4)
+	dc.SetLineWidth(lineWidth)
+	r := size / 2
+	dc.DrawArc(0, 0, r, math.Pi, 2*math.Pi)
+	dc.ClosePath()
+	dc.Stroke()
+	a := gg.Radians(math.Max(-leanGaugeMax, math.Min(leanGaugeMax, lean)) * 90 / leanGaugeMax)
+	dc.SetLineWidth(lineWidth * 2)
+	dc.DrawLine(0, 0, r*math.Sin(a), -r*math.Cos(a))
+	dc.Stroke()
+	dc.Pop()
+}
+
+// drawGForceIcon рисует круг перегрузок с точкой в (lat, -lon), край круга — gForceGaugeMax
+func drawGForceIcon(dc *gg.Context, x, y, size, lineWidth, lat, lon float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.SetLineWidth(lineWidth)
+	r := size / 2
+	dc.DrawCircle(0, 0, r)
+	dc.Stroke()
+	dc.SetLineWidth(lineWidth / 2)
+	dc.DrawLine(-r, 0, r, 0)
+	dc.DrawLine(0, -r, 0, r)
+	dc.Stroke()
+	dx, dy := lat/gForceGaugeMax*r, -lon/gForceGaugeMax*r
+	if d := math.Hypot(dx, dy); d > r {
+		dx, dy = dx*r/d, dy*r/d
+	}
+	dc.DrawCircle(dx, dy, size/7)
+	dc.Fill()
+	dc.Pop()
+}
+
+// drawPowerIcon рисует молнию
+func drawPowerIcon(dc *gg.Context, x, y, size, lineWidth float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.MoveTo(size/8, -size/2)
+	dc.LineTo(-size/4, size/12)
+	dc.LineTo(0, size/12)
+	dc.LineTo(-size/8, size/2)
+	dc.LineTo(size/4, -size/12)
+	dc.LineTo(0, -size/12)
+	dc.ClosePath()
+	dc.Fill()
+	dc.Pop()
+}
+
+// drawClockIcon рисует циферблат со стрелками
+func drawClockIcon(dc *gg.Context, x, y, size, lineWidth float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.SetLineWidth(lineWidth)
+	dc.DrawCircle(0, 0, size/2)
+	dc.MoveTo(0, -size/3)
+	dc.LineTo(0, 0)
+	dc.LineTo(size/4, size/8)
+	dc.Stroke()
+	dc.Pop()
+}
+
+// drawStopwatchIcon рисует секундомер: циферблат с кнопкой сверху
+func drawStopwatchIcon(dc *gg.Context, x, y, size, lineWidth float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.SetLineWidth(lineWidth)
+	dc.DrawCircle(0, size/12, size*5/12)
+	dc.MoveTo(0, -size/2)
+	dc.LineTo(0, -size/3)
+	dc.MoveTo(0, size/12)
+	dc.LineTo(size/5, -size/8)
+	dc.Stroke()
+	dc.Pop()
+}
+
+// drawFlameIcon рисует язычок пламени
+func drawFlameIcon(dc *gg.Context, x, y, size, lineWidth float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.SetLineWidth(lineWidth)
+	dc.MoveTo(0, -size/2)
+	dc.CubicTo(size/2, -size/8, size/2.5, size/2, 0, size/2)
+	dc.CubicTo(-size/2.5, size/2, -size/2, 0, -size/6, -size/6)
+	dc.CubicTo(-size/10, size/10, size/12, 0, 0, -size/2)
+	dc.Stroke()
+	dc.Pop()
+}
+
+// drawHeartIcon рисует сердце из двух дуг и угла
+func drawHeartIcon(dc *gg.Context, x, y, size, lineWidth float64) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.SetLineWidth(lineWidth)
+	r := size / 4
+	dc.DrawArc(-r, -r/2, r, math.Pi*0.75, math.Pi*2)
+	dc.DrawArc(r, -r/2, r, math.Pi, math.Pi*2.25)
+	dc.LineTo(0, size/2)
+	dc.ClosePath()
+	dc.Stroke()
+	dc.Pop()
+}
+
+// drawBalanceBarIcon рисует вертикальную батарейку, заполненную на fraction
+func drawBalanceBarIcon(dc *gg.Context, x, y, size, lineWidth, fraction float64, fill color.Color) {
+	dc.Push()
+	dc.Translate(x, y)
+	dc.SetLineWidth(lineWidth)
+	w, h := size/2, size
+	dc.DrawRectangle(-w/2, -h/2, w, h)
+	dc.Stroke()
+	dc.DrawRectangle(-w/6, -h/2-lineWidth*2, w/3, lineWidth*2)
+	dc.Fill()
+	inner := (h - 2*lineWidth) * math.Max(0, math.Min(1, fraction))
+	dc.SetColor(fill)
+	dc.DrawRectangle(-w/2+lineWidth, h/2-lineWidth-inner, w-2*lineWidth, inner)
+	dc.Fill()
+	dc.Pop()
+}
+
+// --- Text Effects ---
+
+const (
+	textEffectOutline = "outline"
+	textEffectShadow  = "shadow"
+)
+
+// drawIndicatorText рисует текст показателя цветом c. С -text-effect под ним сначала та же строка цветом
+// -text-effect-color: сдвинутая во все стороны (контур) или вниз вправо (тень), чтобы белый текст читался на снегу и небе
+func drawIndicatorText(dc *gg.Context, s string, x, y, ax, ay float64, c color.Color, args *Arguments) {
+	d := math.Max(1, dc.FontHeight()/16)
+	switch args.TextEffect {
+	case textEffectOutline:
+		dc.SetColor(args.TextEffectColor)
+		for i := 0; i < 8; i++ {
+			a := float64(i) * math.Pi / 4
+			dc.DrawStringAnchored(s, x+d*math.Cos(a), y+d*math.Sin(a), ax, ay)
+		}
+	case textEffectShadow:
+		dc.SetColor(withAlpha(args.TextEffectColor, 160))
+		dc.DrawStringAnchored(s, x+1.5*d, y+1.5*d, ax, ay)
+	}
+	dc.SetColor(c)
+	dc.DrawStringAnchored(s, x, y, ax, ay)
+}
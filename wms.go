@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// --- WMS / WMTS Sources ---
+
+// типы источника тайлов (type в -styles-file)
+const (
+	sourceXYZ  = "xyz"
+	sourceWMS  = "wms"
+	sourceWMTS = "wmts"
+)
+
+const webMercatorHalfWorld = math.Pi * 6378137 // м, половина ширины мира в EPSG:3857
+
+// checkSource проверяет поля стиля, нужные его типу источника
+func (s MapStyle) checkSource() error {
+	switch s.Type {
+	case "", sourceXYZ:
+		return checkTileURL(s.URL)
+	case sourceWMS:
+		if s.Layers == "" {
+			return fmt.Errorf("a wms source needs layers")
+		}
+	case sourceWMTS:
+		if strings.Contains(s.URL, "{TileMatrix}") {
+			for _, p := range []string{"{TileRow}", "{TileCol}"} {
+				if !strings.Contains(s.URL, p) {
+					return fmt.Errorf("WMTS URL template %s has no %s", s.URL, p)
+				}
+			}
+		} else if s.Layers == "" {
+			return fmt.Errorf("a wmts source needs layers (or a RESTful URL template with {TileMatrix}, {TileRow}, {TileCol})")
+		}
+	default:
+		return fmt.Errorf("unknown source type %q (supported: xyz, wms, wmts)", s.Type)
+	}
+	return nil
+}
+
+// wmsURL — запрос GetMap на квадрат тайла в EPSG:3857 размером в тайл рендера: с -2x сервер
+// сам рисует карту в двойном разрешении
+func (s MapStyle) wmsURL(base string, z, x, y, size int) string {
+	tileSpan := 2 * webMercatorHalfWorld / math.Pow(2, float64(z))
+	minX := -webMercatorHalfWorld + float64(x)*tileSpan
+	maxY := webMercatorHalfWorld - float64(y)*tileSpan
+	bbox := strings.Join([]string{
+		strconv.FormatFloat(minX, 'f', 3, 64), strconv.FormatFloat(maxY-tileSpan, 'f', 3, 64),
+		strconv.FormatFloat(minX+tileSpan, 'f', 3, 64), strconv.FormatFloat(maxY, 'f', 3, 64),
+	}, ",")
+
+	version := s.Version
+	if version == "" {
+		version = "1.3.0"
+	}
+	q := map[string]string{
+		"SERVICE": "WMS", "REQUEST": "GetMap", "VERSION": version,
+		"LAYERS": s.Layers, "STYLES": s.LayerStyle, "FORMAT": s.imageFormat(),
+		"BBOX": bbox, "WIDTH": strconv.Itoa(size), "HEIGHT": strconv.Itoa(size),
+	}
+	// до 1.3.0 система координат называлась SRS; порядок осей у EPSG:3857 в обеих версиях x,y
+	if version < "1.3" {
+		q["SRS"] = "EPSG:3857"
+	} else {
+		q["CRS"] = "EPSG:3857"
+	}
+	return withQuery(base, q)
+}
+
+// wmtsURL — GetTile для набора матриц в веб-меркаторе, где матрица z — это зум z. RESTful-шаблон
+// с {TileMatrix}, {TileRow}, {TileCol} заполняется напрямую, иначе строится запрос KVP
+func (s MapStyle) wmtsURL(base string, z, x, y int) string {
+	matrix := s.TileMatrixPrefix + strconv.Itoa(z)
+	if strings.Contains(base, "{TileMatrix}") {
+		u := strings.Replace(base, "{TileMatrix}", matrix, 1)
+		u = strings.Replace(u, "{TileRow}", strconv.Itoa(y), 1)
+		return strings.Replace(u, "{TileCol}", strconv.Itoa(x), 1)
+	}
+	matrixSet := s.TileMatrixSet
+	if matrixSet == "" {
+		matrixSet = "GoogleMapsCompatible"
+	}
+	style := s.LayerStyle
+	if style == "" {
+		style = "default"
+	}
+	version := s.Version
+	if version == "" {
+		version = "1.0.0"
+	}
+	return withQuery(base, map[string]string{
+		"SERVICE": "WMTS", "REQUEST": "GetTile", "VERSION": version,
+		"LAYER": s.Layers, "STYLE": style, "FORMAT": s.imageFormat(),
+		"TILEMATRIXSET": matrixSet, "TILEMATRIX": matrix,
+		"TILEROW": strconv.Itoa(y), "TILECOL": strconv.Itoa(x),
+	})
+}
+
+func (s MapStyle) imageFormat() string {
+	if s.Format != "" {
+		return s.Format
+	}
+	return "image/png"
+}
+
+// withQuery дописывает параметры к URL, сохраняя уже имеющиеся в нём (например, ключ)
+func withQuery(base string, params map[string]string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return base
+	}
+	q := u.Query()
+	for k, v := range params {
+		q.Set(k, v)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}